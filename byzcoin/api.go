@@ -211,6 +211,28 @@ func (c *Client) AddTransactionAndWait(tx ClientTransaction, wait int) (*AddTxRe
 	return reply, nil
 }
 
+// SimulateTransaction asks a node to run the transaction on its latest state
+// and returns the state changes it would create, without adding the
+// transaction to the ledger. If the transaction would be refused, the reason
+// is returned as an error together with the response.
+func (c *Client) SimulateTransaction(tx ClientTransaction) (*SimulateTransactionResponse, error) {
+	reply := &SimulateTransactionResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+		Transaction: tx,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %v", err)
+	}
+
+	if reply.Error != "" {
+		return reply, xerrors.New(reply.Error)
+	}
+
+	return reply, nil
+}
+
 // GetProof returns a proof for the key stored in the skipchain starting from
 // the genesis block. The proof can prove the existence or the absence of the
 // key. Note that the integrity of the proof is verified.
@@ -349,6 +371,118 @@ func (c *Client) getProofRaw(key []byte, from, include *skipchain.SkipBlock) (*G
 	return reply, nil
 }
 
+// GetMultiProof returns a single proof for all the keys stored in the
+// skipchain starting from the genesis block. It can prove the existence or
+// the absence of each key and it is smaller than the proofs of the keys
+// because they share the nodes of the trie and the forward links. Note that
+// the integrity of the proof is verified. Use MultiProof.VerifyMany to get
+// the proof of each key.
+func (c *Client) GetMultiProof(keys [][]byte) (*GetMultiProofResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	rep, err := c.GetMultiProofFrom(keys, c.Genesis)
+	return rep, cothority.ErrorOrNil(err, "request failed")
+}
+
+// GetMultiProofFrom returns a single proof for all the keys stored in the
+// skipchain starting from the block given in parameter. The same caution as
+// for GetProofFrom applies.
+func (c *Client) GetMultiProofFrom(keys [][]byte, from *skipchain.SkipBlock) (*GetMultiProofResponse, error) {
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		gpr, ok := msg.(*GetMultiProofResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if _, err := gpr.Proof.VerifyManyFromBlock(from, keys); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+		return nil
+	}
+
+	req := &GetMultiProof{
+		Version: CurrentVersion,
+		Keys:    keys,
+		ID:      from.Hash,
+	}
+
+	reply := &GetMultiProofResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	if c.Latest == nil || c.Latest.Index < reply.Proof.Latest.Index {
+		c.Latest = &reply.Proof.Latest
+	}
+
+	return reply, nil
+}
+
+// GetRangeProof returns a single proof of all the keys whose hash starts with
+// the binary prefix, starting from the genesis block. It proves that there are
+// no other keys under the prefix. Note that the integrity of the proof is
+// verified. Use RangeProof.VerifyFromBlock to get the keys and their bodies.
+func (c *Client) GetRangeProof(prefix []bool) (*GetRangeProofResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	rep, err := c.GetRangeProofFrom(prefix, c.Genesis)
+	return rep, cothority.ErrorOrNil(err, "request failed")
+}
+
+// GetRangeProofFrom returns a single proof of all the keys whose hash starts
+// with the binary prefix, starting from the block given in parameter. The
+// same caution as for GetProofFrom applies.
+func (c *Client) GetRangeProofFrom(prefix []bool, from *skipchain.SkipBlock) (*GetRangeProofResponse, error) {
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		gpr, ok := msg.(*GetRangeProofResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if _, _, err := gpr.Proof.VerifyFromBlock(from, prefix); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+		return nil
+	}
+
+	req := &GetRangeProof{
+		Version: CurrentVersion,
+		Prefix:  prefix,
+		ID:      from.Hash,
+	}
+
+	reply := &GetRangeProofResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	if c.Latest == nil || c.Latest.Index < reply.Proof.Latest.Index {
+		c.Latest = &reply.Proof.Latest
+	}
+
+	return reply, nil
+}
+
 // GetDeferredData makes a request to retrieve the deferred instruction data
 // and return the reply if the proof can be verified.
 func (c *Client) GetDeferredData(instrID InstanceID) (*DeferredData, error) {
@@ -408,6 +542,26 @@ func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.A
 	return ret, nil
 }
 
+// ExplainAuthorization returns the steps of the evaluation of the rule of the
+// action in the given darc, when it is signed by the given identities. The
+// attributes, like "attr:block:after=10", are assumed to be satisfied.
+func (c *Client) ExplainAuthorization(dID darc.ID, action darc.Action, ids []darc.Identity,
+	attrs ...string) ([]darc.ExplainStep, error) {
+	reply := &ExplainAuthorizationResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &ExplainAuthorization{
+		Version:    CurrentVersion,
+		ByzCoinID:  c.ID,
+		DarcID:     dID,
+		Action:     action,
+		Identities: ids,
+		Attributes: attrs,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply.Steps, nil
+}
+
 // GetGenDarc uses the GetProof method to fetch the latest version of the
 // Genesis Darc from ByzCoin and parses it.
 func (c *Client) GetGenDarc() (*darc.Darc, error) {
@@ -569,6 +723,76 @@ func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) erro
 	}
 }
 
+// StreamFilteredTransactions is like StreamTransactions, but the node only
+// sends the transactions and state changes matching the filter, together
+// with the ID of their block. Blocks without matching transactions are
+// skipped. As the blocks are not sent, their integrity cannot be verified:
+// a proof should be requested for the important state changes.
+func (c *Client) StreamFilteredTransactions(filter StreamingFilter,
+	handler func(StreamingResponse, error)) error {
+	req := StreamingRequest{
+		ID:     c.ID,
+		Filter: &filter,
+	}
+	n := int(rand.Int31n(int32(len(c.Roster.List))))
+	if c.options != nil {
+		if c.options.DontShuffle {
+			n = c.options.StartNode
+		}
+	}
+
+	conn, err := c.Stream(c.Roster.List[n], &req)
+	if err != nil {
+		handler(StreamingResponse{}, err)
+		return xerrors.Errorf("stream error: %v", err)
+	}
+	for {
+		resp := StreamingResponse{}
+		if err := conn.ReadMessage(&resp); err != nil {
+			handler(StreamingResponse{}, err)
+			return nil
+		}
+		handler(resp, nil)
+	}
+}
+
+// StreamEvents is a blocking call where it calls the handler every time the
+// node sends events emitted in a new block. Only the events with one of the
+// given names and of one of the given instances are sent. An empty slice
+// means no filtering. The streaming stops if the client or the service
+// stops.
+//
+// It contacts any random node by default. A specific node can be chosen by
+// using `c.UseNode`.
+func (c *Client) StreamEvents(names []string, iIDs []InstanceID,
+	handler func(StreamEventsResponse, error)) error {
+	req := StreamEventsRequest{
+		ID:          c.ID,
+		Names:       names,
+		InstanceIDs: iIDs,
+	}
+	n := int(rand.Int31n(int32(len(c.Roster.List))))
+	if c.options != nil {
+		if c.options.DontShuffle {
+			n = c.options.StartNode
+		}
+	}
+
+	conn, err := c.Stream(c.Roster.List[n], &req)
+	if err != nil {
+		handler(StreamEventsResponse{}, err)
+		return xerrors.Errorf("stream error: %v", err)
+	}
+	for {
+		resp := StreamEventsResponse{}
+		if err := conn.ReadMessage(&resp); err != nil {
+			handler(StreamEventsResponse{}, err)
+			return nil
+		}
+		handler(resp, nil)
+	}
+}
+
 func (c *Client) signerCounterDecoder(buf []byte, data interface{}) error {
 	err := protobuf.Decode(buf, data)
 	if err != nil {
@@ -670,6 +894,27 @@ func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, le
 	return
 }
 
+// GetSnapshot returns the manifest of the latest snapshot of the global state
+// that one of the nodes holds.
+func (c *Client) GetSnapshot() (*GetSnapshotResponse, error) {
+	reply := &GetSnapshotResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &GetSnapshot{ByzCoinID: c.ID},
+		reply, c.options)
+	return reply, cothority.ErrorOrNil(err, "request failed")
+}
+
+// GetSnapshotChunk asks the node si for the chunk with the given hash. The
+// chunk is returned encoded, and must be verified with
+// SnapshotManifest.verifyChunk before being used.
+func (c *Client) GetSnapshotChunk(si *network.ServerIdentity, hash []byte) ([]byte, error) {
+	reply := &GetSnapshotChunkResponse{}
+	err := c.SendProtobuf(si, &GetSnapshotChunk{ByzCoinID: c.ID, Hash: hash}, reply)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply.Chunk, nil
+}
+
 // ResolveInstanceID resolves the instance ID using the given darc ID and name.
 // The name must be already set by calling the naming contract.
 func (c *Client) ResolveInstanceID(darcID darc.ID, name string) (InstanceID, error) {
@@ -757,6 +1002,16 @@ func Debug(url string, byzcoinID *skipchain.SkipBlockID) (*DebugResponse, error)
 	return reply, cothority.ErrorOrNil(err, "request failed")
 }
 
+// GetMempool returns the transactions of the given chain waiting in the
+// mempool of the conode at url. As for Debug, this only works on loopback.
+func GetMempool(url string, byzcoinID skipchain.SkipBlockID) (*GetMempoolResponse, error) {
+	reply := &GetMempoolResponse{}
+	request := &GetMempoolRequest{ByzCoinID: byzcoinID}
+	si := &network.ServerIdentity{URL: url}
+	err := onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, request, reply)
+	return reply, cothority.ErrorOrNil(err, "request failed")
+}
+
 // DebugRemove deletes an existing byzcoin-instance from the conode.
 func DebugRemove(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) error {
 	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(), byzcoinID)
@@ -789,6 +1044,9 @@ func DefaultGenesisMsg(v Version, r *onet.Roster, rules []string, ids ...darc.Id
 		log.Error(err)
 		return nil, xerrors.Errorf("adding rule: %v", err)
 	}
+	if err := rs.AddRule("invoke:"+ContractConfigID+"."+cmdContractVersion, ownerExpr); err != nil {
+		return nil, xerrors.Errorf("adding rule: %v", err)
+	}
 	if err := rs.AddRule("spawn:"+ContractDarcID, ownerExpr); err != nil {
 		return nil, xerrors.Errorf("adding rule: %v", err)
 	}
@@ -165,6 +165,115 @@ func TestClient_GetProof(t *testing.T) {
 	require.Equal(t, 1, len(p.Proof.Links))
 }
 
+func TestClient_GetMultiProof(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(t, servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	d := msg.GenesisDarc
+
+	c, csr, err := NewLedger(msg, false)
+	require.NoError(t, err)
+
+	var keys [][]byte
+	for i := 1; i <= 5; i++ {
+		tx, err := createOneClientTxWithCounter(d.GetBaseID(), dummyContract,
+			[]byte{byte(i)}, signer, uint64(i))
+		require.NoError(t, err)
+		_, err = c.AddTransactionAndWait(tx, 10)
+		require.NoError(t, err)
+		keys = append(keys, tx.Instructions[0].Hash())
+	}
+	missing := NewInstanceID([]byte("missing"))
+	keys = append(keys, missing[:])
+
+	rep, err := c.GetMultiProof(keys)
+	require.NoError(t, err)
+	proofs, err := rep.Proof.VerifyMany(csr.Skipblock.SkipChainID(), keys)
+	require.NoError(t, err)
+	require.Len(t, proofs, len(keys))
+	for i, key := range keys[:5] {
+		require.True(t, proofs[i].InclusionProof.Match(key))
+		v, cid, _, err := proofs[i].Get(key)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(i + 1)}, v)
+		require.Equal(t, dummyContract, cid)
+	}
+	require.False(t, proofs[5].InclusionProof.Match(missing[:]))
+	require.NoError(t, proofs[0].VerifyMany(csr.Skipblock.SkipChainID(), proofs[1:]))
+	other := proofs[1]
+	other.Latest.Data = csr.Skipblock.Data
+	require.Error(t, proofs[0].VerifyMany(csr.Skipblock.SkipChainID(), []Proof{other}))
+
+	// The proof must match the keys.
+	_, err = rep.Proof.VerifyMany(csr.Skipblock.SkipChainID(), keys[:1])
+	require.Error(t, err)
+	// A proof for another block is refused.
+	rep.Proof.Latest.Data = csr.Skipblock.Data
+	_, err = rep.Proof.VerifyMany(csr.Skipblock.SkipChainID(), keys)
+	require.Error(t, err)
+
+	_, err = c.GetMultiProof(nil)
+	require.Error(t, err)
+}
+
+func TestClient_GetRangeProof(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(t, servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	d := msg.GenesisDarc
+
+	c, csr, err := NewLedger(msg, false)
+	require.NoError(t, err)
+
+	values := make(map[string][]byte)
+	for i := 1; i <= 5; i++ {
+		tx, err := createOneClientTxWithCounter(d.GetBaseID(), dummyContract,
+			[]byte{byte(i)}, signer, uint64(i))
+		require.NoError(t, err)
+		_, err = c.AddTransactionAndWait(tx, 10)
+		require.NoError(t, err)
+		values[string(tx.Instructions[0].Hash())] = []byte{byte(i)}
+	}
+
+	// The two halves of the trie hold all the instances.
+	found := make(map[string][]byte)
+	for _, prefix := range [][]bool{{true}, {false}} {
+		rep, err := c.GetRangeProof(prefix)
+		require.NoError(t, err)
+		keys, bodies, err := rep.Proof.Verify(csr.Skipblock.SkipChainID(), prefix)
+		require.NoError(t, err)
+		require.Equal(t, len(keys), len(bodies))
+		for i, key := range keys {
+			found[string(key)] = bodies[i].Value
+		}
+
+		// The proof is only valid for its prefix and its block.
+		_, _, err = rep.Proof.Verify(csr.Skipblock.SkipChainID(), []bool{!prefix[0]})
+		require.Error(t, err)
+		rep.Proof.Latest.Data = csr.Skipblock.Data
+		_, _, err = rep.Proof.Verify(csr.Skipblock.SkipChainID(), prefix)
+		require.Error(t, err)
+	}
+	for key, value := range values {
+		require.Equal(t, value, found[key])
+	}
+
+	_, err = c.GetRangeProof(make([]bool, 257))
+	require.Error(t, err)
+}
+
 func TestClient_GetProofCorrupted(t *testing.T) {
 	l := onet.NewTCPTest(cothority.Suite)
 	servers, roster, _ := l.GenTree(1, true)
@@ -365,17 +474,19 @@ func newTestService(c *onet.Context) (onet.Service, error) {
 	s := &Service{
 		ServiceProcessor:       onet.NewServiceProcessor(c),
 		contracts:              newContractRegistry(),
-		txBuffer:               newTxBuffer(),
+		mempool:                newMempool(),
 		storage:                &bcStorage{},
 		darcToSc:               make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:       newStateChangeCache(),
 		stateChangeStorage:     newStateChangeStorage(c),
+		snapshots:              newSnapshotStorage(c),
 		heartbeatsTimeout:      make(chan string, 1),
 		closeLeaderMonitorChan: make(chan bool, 1),
 		heartbeats:             newHeartbeats(),
 		viewChangeMan:          newViewChangeManager(),
 		streamingMan:           streamingManager{},
 		closed:                 true,
+		metrics:                newServiceMetrics(),
 	}
 
 	cs := &corruptedService{Service: s}
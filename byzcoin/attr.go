@@ -0,0 +1,284 @@
+package byzcoin
+
+import (
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"golang.org/x/xerrors"
+)
+
+// The attributes of the standard library of interpreters, available to every
+// contract from VersionAttrInterpreters on. Their values are URL queries, as
+// in "attr:block:after=10&before=20".
+const (
+	// AttrBlock restricts the index of the block: the current index must be
+	// strictly between "after" and "before", which are both optional.
+	AttrBlock = "block"
+	// AttrTime restricts the timestamp of the block: it must be at or after
+	// "after" and strictly before "before", which are RFC3339 times like
+	// "2020-01-06T14:00:00+01:00" and are both optional.
+	AttrTime = "time"
+	// AttrTimeInterval restricts the time of day of the block timestamp: it
+	// must be at or after "after" and strictly before "before", given like
+	// "09:00" or "9am". The window wraps around midnight if "after" is later
+	// than "before". The optional "days" lists the allowed days, like
+	// "mon,tue", and the optional "tz" is the UTC offset of the times, like
+	// "+02:00". Named time zones are not supported, because all the nodes
+	// must agree on the offset. In both time attributes, a "+" is kept as is
+	// and doesn't need to be escaped.
+	AttrTimeInterval = "time_interval"
+	// AttrInstance requires that the instance "id", in hexadecimal, exists.
+	// If "contract" is given, the instance must be of this contract.
+	AttrInstance = "instance"
+)
+
+// MakeAttrInterpreterFn creates the interpreter of an attribute for the
+// verification of the instruction inst.
+type MakeAttrInterpreterFn func(rst ReadOnlyStateTrie, inst Instruction) func(string) error
+
+var attrRegistry = struct {
+	sync.Mutex
+	fns map[string]MakeAttrInterpreterFn
+}{fns: make(map[string]MakeAttrInterpreterFn)}
+
+func init() {
+	for name, fn := range map[string]MakeAttrInterpreterFn{
+		AttrTime:         makeAttrTime,
+		AttrTimeInterval: makeAttrTimeInterval,
+		AttrInstance:     makeAttrInstance,
+	} {
+		if err := RegisterGlobalAttrInterpreter(name, fn); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterGlobalAttrInterpreter adds an attribute interpreter to the ones
+// used to verify the instructions of every contract. Like the contracts, it
+// should be called during module initialization, and all the nodes must
+// register the same interpreters.
+func RegisterGlobalAttrInterpreter(name string, f MakeAttrInterpreterFn) error {
+	if name == AttrBlock {
+		return xerrors.Errorf("attribute %s is reserved", name)
+	}
+	attrRegistry.Lock()
+	defer attrRegistry.Unlock()
+	if _, ok := attrRegistry.fns[name]; ok {
+		return xerrors.Errorf("attribute %s is already registered", name)
+	}
+	attrRegistry.fns[name] = f
+	return nil
+}
+
+// MakeAttrInterpreters returns the interpreters of the registered attributes
+// for the verification of the instruction. Before VersionAttrInterpreters,
+// only the block attribute is supported.
+func MakeAttrInterpreters(rst ReadOnlyStateTrie, inst Instruction) darc.AttrInterpreters {
+	attrs := darc.AttrInterpreters{AttrBlock: makeAttrBlock(rst, inst)}
+	if rst.GetVersion() < VersionAttrInterpreters {
+		return attrs
+	}
+	attrRegistry.Lock()
+	defer attrRegistry.Unlock()
+	for name, fn := range attrRegistry.fns {
+		attrs[name] = fn(rst, inst)
+	}
+	return attrs
+}
+
+func makeAttrBlock(rst ReadOnlyStateTrie, inst Instruction) func(string) error {
+	return func(attr string) error {
+		vals, err := url.ParseQuery(attr)
+		if err != nil {
+			return xerrors.Errorf("parsing query: %v", err)
+		}
+		beforeStr := vals.Get("before")
+		afterStr := vals.Get("after")
+
+		var before, after int
+
+		if len(beforeStr) == 0 {
+			// Set before to something higher than the current
+			// index so that it always passes.
+			before = rst.GetIndex() + 1
+		} else {
+			var err error
+			before, err = strconv.Atoi(beforeStr)
+			if err != nil {
+				return xerrors.Errorf("atoi: %v", err)
+			}
+		}
+
+		if len(afterStr) == 0 {
+			after = -1
+		} else {
+			var err error
+			after, err = strconv.Atoi(afterStr)
+			if err != nil {
+				return xerrors.Errorf("atoi: %v", err)
+			}
+		}
+
+		if after < rst.GetIndex() && rst.GetIndex() < before {
+			return nil
+		}
+		return xerrors.Errorf("the current block index is %d which does not fit in the interval (%d, %d)", rst.GetIndex(), after, before)
+	}
+}
+
+// blockTime returns the timestamp of the block of the instruction, which is
+// only known when the state trie is given by the service.
+func blockTime(rst ReadOnlyStateTrie) (time.Time, error) {
+	tr, ok := rst.(TimeReader)
+	if !ok {
+		return time.Time{}, xerrors.New("the block timestamp is not available")
+	}
+	return time.Unix(0, tr.GetCurrentBlockTimestamp()), nil
+}
+
+// parseAttrTimeQuery parses the query of an attribute that holds times, whose
+// UTC offsets like "+02:00" keep their literal "+" instead of becoming a space.
+func parseAttrTimeQuery(attr string) (url.Values, error) {
+	return url.ParseQuery(strings.Replace(attr, "+", "%2B", -1))
+}
+
+func makeAttrTime(rst ReadOnlyStateTrie, inst Instruction) func(string) error {
+	return func(attr string) error {
+		vals, err := parseAttrTimeQuery(attr)
+		if err != nil {
+			return xerrors.Errorf("parsing query: %v", err)
+		}
+		now, err := blockTime(rst)
+		if err != nil {
+			return err
+		}
+		if s := vals.Get("after"); s != "" {
+			after, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return xerrors.Errorf("parsing after: %v", err)
+			}
+			if now.Before(after) {
+				return xerrors.Errorf("the block time %v is before %v", now.UTC(), after)
+			}
+		}
+		if s := vals.Get("before"); s != "" {
+			before, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return xerrors.Errorf("parsing before: %v", err)
+			}
+			if !now.Before(before) {
+				return xerrors.Errorf("the block time %v is not before %v", now.UTC(), before)
+			}
+		}
+		return nil
+	}
+}
+
+var timeOfDayLayouts = []string{"15:04", "3pm", "3:04pm", "3PM", "3:04PM"}
+
+// parseTimeOfDay returns the duration since midnight of a time of day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	for _, layout := range timeOfDayLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+		}
+	}
+	return 0, xerrors.Errorf("invalid time of day %s", s)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func makeAttrTimeInterval(rst ReadOnlyStateTrie, inst Instruction) func(string) error {
+	return func(attr string) error {
+		vals, err := parseAttrTimeQuery(attr)
+		if err != nil {
+			return xerrors.Errorf("parsing query: %v", err)
+		}
+		now, err := blockTime(rst)
+		if err != nil {
+			return err
+		}
+
+		loc := time.UTC
+		if tz := vals.Get("tz"); tz != "" {
+			offset, err := time.Parse("-07:00", tz)
+			if err != nil {
+				return xerrors.Errorf("parsing tz: %v", err)
+			}
+			_, secs := offset.Zone()
+			loc = time.FixedZone(tz, secs)
+		}
+		now = now.In(loc)
+
+		if days := vals.Get("days"); days != "" {
+			allowed := false
+			for _, day := range strings.Split(days, ",") {
+				wd, ok := weekdays[strings.ToLower(day)]
+				if !ok {
+					return xerrors.Errorf("invalid day %s", day)
+				}
+				if wd == now.Weekday() {
+					allowed = true
+				}
+			}
+			if !allowed {
+				return xerrors.Errorf("%v is not one of the days %s", now.Weekday(), days)
+			}
+		}
+
+		y, m, d := now.Date()
+		tod := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, loc))
+		after, before := time.Duration(0), 24*time.Hour
+		if s := vals.Get("after"); s != "" {
+			if after, err = parseTimeOfDay(s); err != nil {
+				return err
+			}
+		}
+		if s := vals.Get("before"); s != "" {
+			if before, err = parseTimeOfDay(s); err != nil {
+				return err
+			}
+		}
+		inWindow := after <= tod && tod < before
+		if after > before {
+			inWindow = after <= tod || tod < before
+		}
+		if !inWindow {
+			return xerrors.Errorf("the block time %s is not in the interval [%s, %s)",
+				now.Format("15:04"), vals.Get("after"), vals.Get("before"))
+		}
+		return nil
+	}
+}
+
+func makeAttrInstance(rst ReadOnlyStateTrie, inst Instruction) func(string) error {
+	return func(attr string) error {
+		vals, err := url.ParseQuery(attr)
+		if err != nil {
+			return xerrors.Errorf("parsing query: %v", err)
+		}
+		id, err := hex.DecodeString(vals.Get("id"))
+		if err != nil || len(id) != len(InstanceID{}) {
+			return xerrors.New("id must be an instance ID in hexadecimal")
+		}
+		_, _, cid, _, err := rst.GetValues(id)
+		if err != nil {
+			return cothority.ErrorOrNil(err, "getting instance")
+		}
+		if c := vals.Get("contract"); c != "" && c != cid {
+			return xerrors.Errorf("instance is of contract %s instead of %s", cid, c)
+		}
+		return nil
+	}
+}
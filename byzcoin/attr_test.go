@@ -0,0 +1,91 @@
+package byzcoin
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// attrState returns a state trie whose block timestamp is the given time.
+func attrState(rost *ROSTSimul, now time.Time) ReadOnlyStateTrie {
+	return globalState{rost, nil, &currentBlockInfo{now.UnixNano()}}
+}
+
+func TestAttr_Registry(t *testing.T) {
+	require.Error(t, RegisterGlobalAttrInterpreter(AttrBlock, makeAttrInstance))
+	require.Error(t, RegisterGlobalAttrInterpreter(AttrTime, makeAttrTime))
+
+	rost := NewROSTSimul()
+	attrs := MakeAttrInterpreters(rost, Instruction{})
+	require.Contains(t, attrs, AttrBlock)
+	require.Contains(t, attrs, AttrTimeInterval)
+
+	// Only the block attribute is available before the standard library.
+	rost.Version = VersionTxValidity
+	attrs = MakeAttrInterpreters(rost, Instruction{})
+	require.Equal(t, 1, len(attrs))
+	require.Contains(t, attrs, AttrBlock)
+}
+
+func TestAttr_Block(t *testing.T) {
+	// The index of a ROSTSimul is -1.
+	attr := makeAttrBlock(NewROSTSimul(), Instruction{})
+	require.NoError(t, attr("after=-2&before=0"))
+	require.Error(t, attr("after=-1"))
+	require.Error(t, attr("before=-1"))
+	require.Error(t, attr("before=x"))
+}
+
+func TestAttr_Time(t *testing.T) {
+	now := time.Date(2020, 1, 6, 12, 0, 0, 0, time.UTC)
+	attr := makeAttrTime(attrState(NewROSTSimul(), now), Instruction{})
+	require.NoError(t, attr("after=2020-01-06T12:00:00Z"))
+	require.NoError(t, attr("after=2020-01-01T00:00:00Z&before=2020-02-01T00:00:00Z"))
+	require.NoError(t, attr("before=2020-01-06T14:00:00%2B01:59"))
+	require.NoError(t, attr("before=2020-01-06T14:00:00+01:59"))
+	require.NoError(t, attr("after=2020-01-06T13:00:00+01:00&before=2020-01-06T14:00:00+01:01"))
+	require.Error(t, attr("before=2020-01-06T13:00:00+01:00"))
+	require.Error(t, attr("before=2020-01-06T12:00:00Z"))
+	require.Error(t, attr("after=2020-01-07T00:00:00Z"))
+	require.Error(t, attr("after=yesterday"))
+
+	// Without a block timestamp, the time is unknown.
+	attr = makeAttrTime(NewROSTSimul(), Instruction{})
+	require.Error(t, attr("after=2020-01-01T00:00:00Z"))
+}
+
+func TestAttr_TimeInterval(t *testing.T) {
+	// This is a Monday.
+	now := time.Date(2020, 1, 6, 16, 30, 0, 0, time.UTC)
+	attr := makeAttrTimeInterval(attrState(NewROSTSimul(), now), Instruction{})
+	require.NoError(t, attr("after=9am&before=5pm"))
+	require.NoError(t, attr("after=09:00&before=17:00&days=mon,tue,wed,thu,fri"))
+	require.NoError(t, attr("after=16:30"))
+	require.NoError(t, attr("after=22:00&before=17:00"))
+	require.NoError(t, attr("after=9am&before=5pm&tz=-05:00&days=mon"))
+	require.Error(t, attr("after=9am&before=5pm&tz=%2B02:00"))
+	require.Error(t, attr("after=9am&before=5pm&tz=+02:00"))
+	require.NoError(t, attr("after=6pm&before=7pm&tz=+02:00"))
+	require.NoError(t, attr("after=6pm&before=7pm&tz=%2B02:00"))
+	require.Error(t, attr("before=4:30pm"))
+	require.Error(t, attr("after=22:00&before=6:00"))
+	require.Error(t, attr("days=sat,sun"))
+	require.Error(t, attr("days=monday"))
+	require.Error(t, attr("after=25:00"))
+	require.Error(t, attr("tz=Europe/Zurich"))
+}
+
+func TestAttr_Instance(t *testing.T) {
+	rost := NewROSTSimul()
+	id, err := rost.CreateCoin("test", 10)
+	require.NoError(t, err)
+	attr := makeAttrInstance(rost, Instruction{})
+	idStr := hex.EncodeToString(id.Slice())
+	require.NoError(t, attr("id="+idStr))
+	require.NoError(t, attr("id="+idStr+"&contract=coin"))
+	require.Error(t, attr("id="+idStr+"&contract=value"))
+	require.Error(t, attr("id="+hex.EncodeToString(make([]byte, 32))))
+	require.Error(t, attr("id=1234"))
+}
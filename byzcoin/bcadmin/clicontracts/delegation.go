@@ -0,0 +1,242 @@
+package clicontracts
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/urfave/cli"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// maxIndex is the biggest block index, like math.MaxInt of Go 1.17.
+const maxIndex = int(^uint(0) >> 1)
+
+// DelegationSpawn spawns a delegation of an action of a darc to another
+// identity, until a block index or a time.
+func DelegationSpawn(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	action := c.String("action")
+	if action == "" {
+		return xerrors.New("--action flag is required")
+	}
+	delegate := c.String("delegate")
+	if delegate == "" {
+		return xerrors.New("--delegate flag is required")
+	}
+	if _, err := darc.ParseIdentity(delegate); err != nil {
+		return xerrors.Errorf("failed to parse the delegate: %v", err)
+	}
+
+	args := byzcoin.Arguments{
+		{Name: "action", Value: []byte(action)},
+		{Name: "delegate", Value: []byte(delegate)},
+	}
+	if c.IsSet("expiry_index") {
+		if c.Uint64("expiry_index") > uint64(maxIndex) {
+			return xerrors.Errorf("--expiry_index must not be bigger than %d", maxIndex)
+		}
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, c.Uint64("expiry_index"))
+		args = append(args, byzcoin.Argument{Name: "expiry_index", Value: buf})
+	}
+	if c.IsSet("expiry_time") {
+		expiry, err := time.Parse(time.RFC3339, c.String("expiry_time"))
+		if err != nil {
+			return xerrors.Errorf("failed to parse the expiry time: %v", err)
+		}
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(expiry.UnixNano()))
+		args = append(args, byzcoin.Argument{Name: "expiry_timestamp", Value: buf})
+	}
+	if len(args) != 3 {
+		return xerrors.New("exactly one of --expiry_index and --expiry_time is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	dstr := c.String("darc")
+	if dstr == "" {
+		dstr = cfg.AdminDarc.GetIdentityString()
+	}
+	d, err := lib.GetDarcByString(cl, dstr)
+	if err != nil {
+		return err
+	}
+
+	signer, err := delegationSigner(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	counters, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return xerrors.Errorf("couldn't get the signer counters: %v", err)
+	}
+
+	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(d.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: byzcoin.ContractDelegationID,
+			Args:       args,
+		},
+		SignerCounter: []uint64{counters.Counters[0] + 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ctx.FillSignersAndSignWith(*signer)
+	if err != nil {
+		return err
+	}
+
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
+	}
+
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	if err != nil {
+		return err
+	}
+
+	instID := ctx.Instructions[0].DeriveID("").Slice()
+	log.Infof("Spawned a new delegation contract. Its instance id is:\n%x", instID)
+	log.Infof("The delegate presents it with the instructions of the action, "+
+		"like with bcadmin darc add --delegation %x.", instID)
+
+	return lib.WaitPropagation(c, cl)
+}
+
+// DelegationInvokeRevoke revokes a delegation before it expires.
+func DelegationInvokeRevoke(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	instID := c.String("instid")
+	if instID == "" {
+		return xerrors.New("--instid flag is required")
+	}
+	instIDBuf, err := hex.DecodeString(instID)
+	if err != nil {
+		return xerrors.New("failed to decode the instid string")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	signer, err := delegationSigner(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	counters, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return xerrors.Errorf("couldn't get the signer counters: %v", err)
+	}
+
+	ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(instIDBuf),
+		Invoke: &byzcoin.Invoke{
+			ContractID: byzcoin.ContractDelegationID,
+			Command:    "revoke",
+		},
+		SignerCounter: []uint64{counters.Counters[0] + 1},
+	})
+	if err != nil {
+		return err
+	}
+	err = ctx.FillSignersAndSignWith(*signer)
+	if err != nil {
+		return err
+	}
+
+	if lib.FindRecursivefBool("export", c) {
+		return lib.ExportTransaction(ctx)
+	}
+
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Delegation revoked! (instance ID is %x)", instIDBuf)
+
+	return lib.WaitPropagation(c, cl)
+}
+
+// DelegationGet checks the proof and displays a delegation contract.
+func DelegationGet(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	instID := c.String("instid")
+	if instID == "" {
+		return xerrors.New("--instid flag is required")
+	}
+	instIDBuf, err := hex.DecodeString(instID)
+	if err != nil {
+		return xerrors.New("failed to decode the instID string" + instID)
+	}
+
+	pr, err := cl.GetProofFromLatest(instIDBuf)
+	if err != nil {
+		return xerrors.Errorf("couldn't get proof: %v", err)
+	}
+	proof := pr.Proof
+
+	match := proof.InclusionProof.Match(instIDBuf)
+	if !match {
+		return xerrors.New("proof does not match")
+	}
+
+	_, resultBuf, cid, _, err := proof.KeyValue()
+	if err != nil {
+		return xerrors.Errorf("couldn't get value out of proof: %v", err)
+	}
+	if cid != byzcoin.ContractDelegationID {
+		return xerrors.Errorf("instance is a %s, not a delegation", cid)
+	}
+
+	var del byzcoin.Delegation
+	err = protobuf.Decode(resultBuf, &del)
+	if err != nil {
+		return xerrors.Errorf("couldn't decode the delegation: %v", err)
+	}
+
+	log.Infof("- Darc: %x\n- Action: %s\n- Delegate: %s\n- Expiry: %s\n- Revoked: %t",
+		del.DarcID, del.Action, del.Delegate.String(), &del.Expiry, del.Revoked)
+
+	return nil
+}
+
+func delegationSigner(c *cli.Context, cfg lib.Config) (*darc.Signer, error) {
+	sstr := c.String("sign")
+	if sstr == "" {
+		return lib.LoadKey(cfg.AdminIdentity)
+	}
+	return lib.LoadKeyFromString(sstr)
+}
@@ -0,0 +1,78 @@
+# This method should be called from the byzcoin/bcadmin/test.sh script
+
+testContractDelegation() {
+    run testDelegationSpawn
+    run testDelegationInvokeRevoke
+}
+
+testDelegationSpawn() {
+    # In this test we delegate the spawn:darc rule to another key and check
+    # that it can be used by presenting the delegation, or once the
+    # delegation is added to the rule.
+    runCoBG 1 2 3
+    runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+    eval $SED
+    [ -z "$BC" ] && exit 1
+
+    testOK runBA darc add -out_id ./darc_id.txt -out_key ./darc_key.txt -unrestricted
+    ID=`cat ./darc_id.txt`
+    KEY=`cat ./darc_key.txt`
+    testOK runBA key -save ./key.txt
+    KEY2=`cat ./key.txt`
+    testOK runBA darc rule -rule "spawn:darc" --identity "$KEY" --darc "$ID" --sign "$KEY"
+
+    # An expiry is required
+    testFail runBA contract delegation spawn --action "spawn:darc" --delegate "$KEY2" --darc "$ID" --sign "$KEY"
+    # The delegate cannot delegate a right it doesn't have
+    testFail runBA contract delegation spawn --action "spawn:darc" --delegate "$KEY2" \
+        --expiry_time "2100-01-01T00:00:00Z" --darc "$ID" --sign "$KEY2"
+
+    OUTRES=`runBA0 contract delegation spawn --action "spawn:darc" --delegate "$KEY2" \
+        --expiry_time "2100-01-01T00:00:00Z" --darc "$ID" --sign "$KEY"`
+    matchOK "$OUTRES" "^Spawned a new delegation contract. Its instance id is:
+[0-9a-f]{64}"
+    DEL_ID=$( echo "$OUTRES" | grep -A 1 "instance id" | sed -n 2p )
+    matchOK "$DEL_ID" ^[0-9a-f]{64}$
+
+    OUTRES=`runBA0 contract delegation get --instid "$DEL_ID"`
+    testGrep "Delegate: $KEY2" echo "$OUTRES"
+    testGrep "Revoked: false" echo "$OUTRES"
+
+    testFail runBA darc add -darc "$ID" -sign "$KEY2"
+    testOK runBA darc add -darc "$ID" -sign "$KEY2" -delegation "$DEL_ID"
+    testFail runBA darc add -darc "$ID" -sign "$KEY2" -delegation "$ID"
+    testOK runBA darc rule -replace -rule "spawn:darc" --identity "$KEY | delegation:$DEL_ID" --darc "$ID" --sign "$KEY"
+    testOK runBA darc add -darc "$ID" -sign "$KEY2"
+
+    testFail runBA contract delegation spawn --action "spawn:darc" --delegate "$KEY2" \
+        --expiry_index 9223372036854775808 --darc "$ID" --sign "$KEY"
+}
+
+testDelegationInvokeRevoke() {
+    # In this test we revoke a delegation, which can only be done by the
+    # identities of the delegated rule.
+    runCoBG 1 2 3
+    runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+    eval $SED
+    [ -z "$BC" ] && exit 1
+
+    testOK runBA darc add -out_id ./darc_id.txt -out_key ./darc_key.txt -unrestricted
+    ID=`cat ./darc_id.txt`
+    KEY=`cat ./darc_key.txt`
+    testOK runBA key -save ./key.txt
+    KEY2=`cat ./key.txt`
+    testOK runBA darc rule -rule "spawn:darc" --identity "$KEY" --darc "$ID" --sign "$KEY"
+
+    OUTRES=`runBA0 contract delegation spawn --action "spawn:darc" --delegate "$KEY2" \
+        --expiry_time "2100-01-01T00:00:00Z" --darc "$ID" --sign "$KEY"`
+    DEL_ID=$( echo "$OUTRES" | grep -A 1 "instance id" | sed -n 2p )
+    matchOK "$DEL_ID" ^[0-9a-f]{64}$
+    testOK runBA darc add -darc "$ID" -sign "$KEY2" -delegation "$DEL_ID"
+
+    testFail runBA contract delegation invoke revoke --instid "$DEL_ID" --sign "$KEY2"
+    testOK runBA contract delegation invoke revoke --instid "$DEL_ID" --sign "$KEY"
+    testFail runBA contract delegation invoke revoke --instid "$DEL_ID" --sign "$KEY"
+    testGrep "Revoked: true" runBA0 contract delegation get --instid "$DEL_ID"
+
+    testFail runBA darc add -darc "$ID" -sign "$KEY2" -delegation "$DEL_ID"
+}
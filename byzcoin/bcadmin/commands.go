@@ -67,7 +67,7 @@ var cmds = cli.Commands{
                                       [--darc <darc id>] 
                                       [--sign <pub key>]     
                              }
-   CONTRACT   {value,deferred,config}`),
+   CONTRACT   {value,deferred,config,name,delegation}`),
 		Subcommands: cli.Commands{
 			{
 				Name:  "value",
@@ -449,6 +449,90 @@ var cmds = cli.Commands{
 					},
 				},
 			},
+			{
+				Name:  "delegation",
+				Usage: "Manipulate a delegation contract",
+				Subcommands: cli.Commands{
+					{
+						Name:   "spawn",
+						Usage:  "delegate an action of a DARC to another identity until a block or a time",
+						Action: clicontracts.DelegationSpawn,
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "bc",
+								EnvVar: "BC",
+								Usage:  "the ByzCoin config to use (required)",
+							},
+							cli.StringFlag{
+								Name:  "action",
+								Usage: "the action to delegate, like invoke:value.update (required)",
+							},
+							cli.StringFlag{
+								Name:  "delegate",
+								Usage: "the identity receiving the right (required)",
+							},
+							cli.Uint64Flag{
+								Name:  "expiry_index",
+								Usage: "the last block index in which the delegation can be used",
+							},
+							cli.StringFlag{
+								Name:  "expiry_time",
+								Usage: "the time after which the delegation cannot be used, in RFC3339 format",
+							},
+							cli.StringFlag{
+								Name:  "darc",
+								Usage: "DARC holding the delegated action (default is the admin DARC)",
+							},
+							cli.StringFlag{
+								Name:  "sign",
+								Usage: "public key of the signing entity (default is the admin public key)",
+							},
+						},
+					},
+					{
+						Name:  "invoke",
+						Usage: "invoke a delegation contract",
+						Subcommands: cli.Commands{
+							{
+								Name:   "revoke",
+								Usage:  "revoke the delegation before it expires",
+								Action: clicontracts.DelegationInvokeRevoke,
+								Flags: []cli.Flag{
+									cli.StringFlag{
+										Name:   "bc",
+										EnvVar: "BC",
+										Usage:  "the ByzCoin config to use (required)",
+									},
+									cli.StringFlag{
+										Name:  "instid, i",
+										Usage: "the instance ID of the delegation contract",
+									},
+									cli.StringFlag{
+										Name:  "sign",
+										Usage: "public key of the signing entity (default is the admin public key)",
+									},
+								},
+							},
+						},
+					},
+					{
+						Name:   "get",
+						Usage:  "if the proof matches, displays the given delegation instance",
+						Action: clicontracts.DelegationGet,
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "bc",
+								EnvVar: "BC",
+								Usage:  "the ByzCoin config to use (required)",
+							},
+							cli.StringFlag{
+								Name:  "instid, i",
+								Usage: "the instance id (required)",
+							},
+						},
+					},
+				},
+			},
 		},
 	},
 
@@ -492,6 +576,34 @@ var cmds = cli.Commands{
 					},
 				},
 			},
+			{
+				Name:   "explain",
+				Usage:  "Explain the evaluation of a rule of a DARC for the given signers",
+				Action: darcExplain,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+					cli.StringFlag{
+						Name:  "darc",
+						Usage: "the DARC of the rule (admin darc by default)",
+					},
+					cli.StringFlag{
+						Name:  "rule",
+						Usage: "the action of the rule to explain (required)",
+					},
+					cli.StringSliceFlag{
+						Name:  "identity, id",
+						Usage: "an identity that signs, multiple use of this param is allowed",
+					},
+					cli.StringSliceFlag{
+						Name:  "attr",
+						Usage: "an attribute, like attr:block:after=10, that is assumed to be satisfied, multiple use of this param is allowed",
+					},
+				},
+			},
 			{
 				Name:   "cdesc",
 				Usage:  "Edit the description of a DARC",
@@ -534,6 +646,10 @@ var cmds = cli.Commands{
 						Name:  "darc",
 						Usage: "DARC with the right to create a new DARC (default is the admin DARC)",
 					},
+					cli.StringSliceFlag{
+						Name:  "delegation",
+						Usage: "instance ID of a delegation of spawn:darc to the signer, multiple use of this param is allowed",
+					},
 					cli.StringSliceFlag{
 						Name:  "identity, id",
 						Usage: "an identity, multiple use of this param is allowed. If empty it will create a new identity. Each provided identity is checked by the evaluation parser.",
@@ -577,6 +693,10 @@ var cmds = cli.Commands{
 						Name:  "minimum, M",
 						Usage: "if this flag is set, the rule is computed to be \"M out of N\" identities. Otherwise it uses ANDs",
 					},
+					cli.UintFlag{
+						Name:  "threshold, T",
+						Usage: "if this flag is set, the rule is a threshold expression of \"T out of N\" identities, which is shorter than the one of --minimum but needs conodes that support it",
+					},
 				},
 			},
 			{
@@ -609,6 +729,10 @@ var cmds = cli.Commands{
 						Name:  "minimum, M",
 						Usage: "if this flag is set, the rule is computed to be \"M out of N\" identities. Otherwise it uses ANDs",
 					},
+					cli.UintFlag{
+						Name:  "threshold, T",
+						Usage: "if this flag is set, the rule is a threshold expression of \"T out of N\" identities, which is shorter than the one of --minimum but needs conodes that support it",
+					},
 					cli.BoolFlag{
 						Name:  "replace",
 						Usage: "if this rule already exists, replace it with this new one",
@@ -874,6 +998,20 @@ var cmds = cli.Commands{
 				Name:  "print",
 				Usage: "print the private and public key",
 			},
+			cli.StringFlag{
+				Name:  "type",
+				Usage: "type of the key: ed25519, secp256k1 or webauthn",
+				Value: "ed25519",
+			},
+			cli.StringFlag{
+				Name: "import",
+				Usage: "hex of an existing secp256k1 private key, or of the DER-encoded " +
+					"public key of a webauthn credential",
+			},
+			cli.StringFlag{
+				Name:  "rpid",
+				Usage: "ID of the relying party of a webauthn credential, like example.com",
+			},
 		},
 	},
 
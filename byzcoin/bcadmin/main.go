@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
@@ -18,6 +20,7 @@ import (
 
 	"golang.org/x/xerrors"
 
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/qantik/qrgo"
 	"github.com/urfave/cli"
 	"go.dedis.ch/cothority/v3"
@@ -843,13 +846,66 @@ func key(c *cli.Context) error {
 		if err != nil {
 			return xerrors.Errorf("couldn't load signer: %v", err)
 		}
-		log.Infof("Private: %s\nPublic: %s", sig.Ed25519.Secret, sig.Ed25519.Point)
+		switch {
+		case sig.Ed25519 != nil:
+			log.Infof("Private: %s\nPublic: %s", sig.Ed25519.Secret, sig.Ed25519.Point)
+		case sig.Secp256k1 != nil:
+			log.Infof("Private: %x\nAddress: %s", sig.Secp256k1.Secret, sig.Secp256k1.Address.Hex())
+		default:
+			return xerrors.Errorf("cannot print a signer of type %d", sig.Type())
+		}
 		return nil
 	}
-	newSigner := darc.NewSignerEd25519(nil, nil)
-	err := lib.SaveKey(newSigner)
-	if err != nil {
-		return err
+
+	var id darc.Identity
+	switch c.String("type") {
+	case "ed25519":
+		if c.String("import") != "" {
+			return xerrors.New("cannot import an ed25519 key")
+		}
+		newSigner := darc.NewSignerEd25519(nil, nil)
+		err := lib.SaveKey(newSigner)
+		if err != nil {
+			return err
+		}
+		id = newSigner.Identity()
+	case "secp256k1":
+		var private *ecdsa.PrivateKey
+		if imp := c.String("import"); imp != "" {
+			var err error
+			private, err = crypto.HexToECDSA(strings.TrimPrefix(imp, "0x"))
+			if err != nil {
+				return xerrors.Errorf("parsing private key: %v", err)
+			}
+		}
+		newSigner, err := darc.NewSignerSecp256k1(private)
+		if err != nil {
+			return err
+		}
+		err = lib.SaveKey(newSigner)
+		if err != nil {
+			return err
+		}
+		id = newSigner.Identity()
+	case "webauthn":
+		// The private key stays in the authenticator, so there is nothing
+		// to save.
+		public, err := hex.DecodeString(c.String("import"))
+		if err != nil || len(public) == 0 {
+			return xerrors.New("--import must be the public key of the credential in hexadecimal")
+		}
+		if _, err := x509.ParsePKIXPublicKey(public); err != nil {
+			return xerrors.Errorf("parsing public key: %v", err)
+		}
+		if c.String("rpid") == "" {
+			return xerrors.New("--rpid must be the ID of the relying party of the credential")
+		}
+		id = darc.NewIdentityWebAuthn(c.String("rpid"), public)
+		if _, err := darc.ParseIdentity(id.String()); err != nil {
+			return xerrors.Errorf("invalid relying party ID: %v", err)
+		}
+	default:
+		return xerrors.Errorf("unknown key type %s", c.String("type"))
 	}
 
 	var fo io.Writer
@@ -870,7 +926,7 @@ func key(c *cli.Context) error {
 			}
 		}()
 	}
-	_, err = fmt.Fprintln(fo, newSigner.Identity().String())
+	_, err := fmt.Fprintln(fo, id.String())
 	return err
 }
 
@@ -899,6 +955,60 @@ func darcShow(c *cli.Context) error {
 	return err
 }
 
+// darcExplain prints the evaluation of a rule of a darc, with the parts of
+// the expression and of the delegated darcs that are satisfied or not.
+func darcExplain(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+	action := c.String("rule")
+	if action == "" {
+		return xerrors.New("--rule flag is required")
+	}
+
+	cfg, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return err
+	}
+
+	dstr := c.String("darc")
+	if dstr == "" {
+		dstr = cfg.AdminDarc.GetIdentityString()
+	}
+	d, err := lib.GetDarcByString(cl, dstr)
+	if err != nil {
+		return err
+	}
+
+	var ids []darc.Identity
+	for _, s := range c.StringSlice("identity") {
+		id, err := darc.ParseIdentity(s)
+		if err != nil {
+			return xerrors.Errorf("failed to parse identity %s: %v", s, err)
+		}
+		ids = append(ids, id)
+	}
+
+	steps, err := cl.ExplainAuthorization(d.GetBaseID(), darc.Action(action), ids,
+		c.StringSlice("attr")...)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		result := "satisfied"
+		if !step.Satisfied {
+			result = "NOT satisfied"
+		}
+		line := fmt.Sprintf("%s%s: %s", strings.Repeat("  ", step.Depth), step.Expr, result)
+		if step.Reason != "" {
+			line += " (" + step.Reason + ")"
+		}
+		fmt.Fprintln(c.App.Writer, line)
+	}
+	return nil
+}
+
 // "cDesc" stands for Change Description. This function allows one to edit the
 // description of a darc.
 func darcCdesc(c *cli.Context) error {
@@ -1388,6 +1498,15 @@ func darcAdd(c *cli.Context) error {
 
 	instID := byzcoin.NewInstanceID(dSpawn.GetBaseID())
 
+	var delegations []byzcoin.InstanceID
+	for _, del := range c.StringSlice("delegation") {
+		buf, err := hex.DecodeString(del)
+		if err != nil || len(buf) != len(byzcoin.InstanceID{}) {
+			return xerrors.Errorf("--delegation must be the instance ID of a delegation, got %s", del)
+		}
+		delegations = append(delegations, byzcoin.NewInstanceID(buf))
+	}
+
 	counters, err := cl.GetSignerCounters(signer.Identity().String())
 
 	spawn := byzcoin.Spawn{
@@ -1404,6 +1523,7 @@ func darcAdd(c *cli.Context) error {
 		InstanceID:    instID,
 		Spawn:         &spawn,
 		SignerCounter: []uint64{counters.Counters[0] + 1},
+		Delegations:   delegations,
 	})
 	if err != nil {
 		return err
@@ -1500,13 +1620,9 @@ func darcRule(c *cli.Context) error {
 		}
 	}
 
-	var groupExpr expression.Expr
-	min := c.Uint("minimum")
-	if min == 0 {
-		groupExpr = expression.InitAndExpr(identities...)
-	} else {
-		andGroups := lib.CombinationAnds(identities, int(min))
-		groupExpr = expression.InitOrExpr(andGroups...)
+	groupExpr, err := getGroupExpr(c, identities)
+	if err != nil {
+		return err
 	}
 
 	d2 := d.Copy()
@@ -1576,6 +1692,38 @@ func darcRule(c *cli.Context) error {
 	return lib.WaitPropagation(c, cl)
 }
 
+// getGroupExpr returns the expression of the rule given by the identities and
+// the --minimum or --threshold flags.
+func getGroupExpr(c *cli.Context, identities []string) (expression.Expr, error) {
+	min := c.Uint("minimum")
+	threshold := c.Uint("threshold")
+	switch {
+	case min != 0 && threshold != 0:
+		return nil, xerrors.New("--minimum and --threshold cannot be used together")
+	case threshold != 0:
+		if int(threshold) > len(identities) {
+			return nil, xerrors.New("--threshold is bigger than the number of identities")
+		}
+		items := make([]string, len(identities))
+		for i, id := range identities {
+			switch {
+			case strings.ContainsAny(id, " \t\n"):
+				items[i] = "(" + id + ")"
+			case strings.HasPrefix(id, "proxy:") || strings.HasPrefix(id, "attr:"):
+				// Their data would include the separator.
+				items[i] = id + " "
+			default:
+				items[i] = id
+			}
+		}
+		return expression.InitThresholdExpr(int(threshold), items...), nil
+	case min != 0:
+		andGroups := lib.CombinationAnds(identities, int(min))
+		return expression.InitOrExpr(andGroups...), nil
+	}
+	return expression.InitAndExpr(identities...), nil
+}
+
 // print a rule based on the identities and the minimum given.
 func darcPrintRule(c *cli.Context) error {
 
@@ -1597,13 +1745,9 @@ func darcPrintRule(c *cli.Context) error {
 		}
 	}
 
-	var groupExpr expression.Expr
-	min := c.Uint("minimum")
-	if min == 0 {
-		groupExpr = expression.InitAndExpr(identities...)
-	} else {
-		andGroups := lib.CombinationAnds(identities, int(min))
-		groupExpr = expression.InitOrExpr(andGroups...)
+	groupExpr, err := getGroupExpr(c, identities)
+	if err != nil {
+		return err
 	}
 
 	log.Infof("%s\n", groupExpr)
@@ -22,6 +22,7 @@ export BC_WAIT=true
 . "../clicontracts/deferred_test.sh"
 . "../clicontracts/value_test.sh"
 . "../clicontracts/name_test.sh"
+. "../clicontracts/delegation_test.sh"
 
 main(){
     startTest
@@ -43,6 +44,7 @@ main(){
     run testRuleDarc
     run testAddDarcFromOtherOne
     run testAddDarcWithOwner
+    run testKeyTypes
     run testExpression
     run testLinkPermission
     run testQR
@@ -53,6 +55,7 @@ main(){
     run testContractDeferred
     run testContractConfig
     run testContractName
+    run testContractDelegation
     stopTest
 }
 
@@ -384,6 +387,12 @@ testDarcAddRuleMinimum(){
   testOK runBA darc rule -rule test:contract --darc "$ID" -sign "$KEY" -id 'darc:A & ed25519:aef' -id darc:B -id darc:C -id darc:D --minimum 2 -replace
   testFGrep "test:contract - \"((darc:A & ed25519:aef) & (darc:B)) | ((darc:A & ed25519:aef) & (darc:C)) | ((darc:A & ed25519:aef) & (darc:D)) | ((darc:B) & (darc:C)) | ((darc:B) & (darc:D)) | ((darc:C) & (darc:D))\"" runBA0 darc show --darc "$ID"
 
+  # with a threshold
+  testOK runBA darc rule -rule test:contract --darc "$ID" -sign "$KEY" -id 'darc:A & ed25519:aef' -id darc:B -id darc:C --threshold 2 -replace
+  testFGrep "test:contract - \"[(darc:A & ed25519:aef), darc:B, darc:C]/2\"" runBA0 darc show --darc "$ID"
+  testFail runBA darc rule -rule test:contract --darc "$ID" -sign "$KEY" -id darc:A -id darc:B --threshold 3 -replace
+  testFail runBA darc rule -rule test:contract --darc "$ID" -sign "$KEY" -id darc:A -id darc:B --threshold 1 --minimum 1 -replace
+
   # with some wrong identities
   testFail runBA darc rule -rule test:contract --darc "$ID" -sign "$KEY" -id 'xdarc:A & ed25519:aef' -id darc:B --minimum 2 -replace
   testFail runBA darc rule -rule test:contract --darc "$ID" -sign "$KEY" -id 'xdarc:A & ed25519:aef' -id darc:B -replace
@@ -451,6 +460,30 @@ testAddDarcWithOwner(){
   testGrep "$KEY" runBA0 darc show -darc "$ID"
 }
 
+testKeyTypes(){
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+
+  testOK runBA key --type secp256k1 --save ./key.txt
+  KEY=`cat ./key.txt`
+  testGrep "Address: 0x" runBA0 key --print "config/key-$KEY.cfg"
+  testOK runBA darc add -id "$KEY" -out_id "darc_id.txt"
+  ID=`cat ./darc_id.txt`
+  testGrep "$KEY" runBA0 darc show -darc "$ID"
+
+  testGrep "secp256k1:2c7536e3605d9c16a7a3d7b1898e529396a65c23" runBA0 key --type secp256k1 \
+    --import 4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318
+  testGrep "webauthn:example.com:3059" runBA0 key --type webauthn --rpid example.com --import \
+    3059301306072a8648ce3d020106082a8648ce3d0301070342000416ab4f9f2a8915978575052810aea366001807886f7b17e8ba3a990933bd8bcc17493e5735e9b603f83de807b9fe6b2c964c45b8018e6cc83e188017f2d4eb97
+  testFail runBA key --type webauthn
+  testFail runBA key --type webauthn --import \
+    3059301306072a8648ce3d020106082a8648ce3d0301070342000416ab4f9f2a8915978575052810aea366001807886f7b17e8ba3a990933bd8bcc17493e5735e9b603f83de807b9fe6b2c964c45b8018e6cc83e188017f2d4eb97
+  testFail runBA key --type ed25519 --import 00
+  testFail runBA key --type rsa
+}
+
 testExpression(){
   runCoBG 1 2 3
   runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
@@ -471,6 +504,10 @@ testExpression(){
   testOK runBA darc rule -replace -rule spawn:darc -identity "$KEY & $KEY2" -darc "$ID" -sign "$KEY"
   testFail runBA darc add -darc "$ID" -sign "$KEY"
   testFail runBA darc add -darc "$ID" -sign "$KEY2"
+
+  testFGrep "$KEY2: NOT satisfied (not one of the signers)" runBA0 darc explain -darc "$ID" -rule spawn:darc -id "$KEY"
+  testFGrep "$KEY & $KEY2: satisfied" runBA0 darc explain -darc "$ID" -rule spawn:darc -id "$KEY" -id "$KEY2"
+  testFail runBA darc explain -darc "$ID" -rule spawn:value -id "$KEY"
 }
 
 runBA(){
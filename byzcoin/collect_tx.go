@@ -24,15 +24,18 @@ type CollectTxProtocol struct {
 	*onet.TreeNodeInstance
 	TxsChan           chan []ClientTransaction
 	CommonVersionChan chan Version
-	SkipchainID       skipchain.SkipBlockID
-	LatestID          skipchain.SkipBlockID
-	MaxNumTxs         int
-	requestChan       chan structCollectTxRequest
-	responseChan      chan structCollectTxResponse
-	getTxs            getTxsCallback
-	Finish            chan bool
-	closing           chan bool
-	version           int
+	// ContractVersionsChan receives the contract versions supported by a
+	// threshold of the conodes.
+	ContractVersionsChan chan []ContractVersion
+	SkipchainID          skipchain.SkipBlockID
+	LatestID             skipchain.SkipBlockID
+	MaxNumTxs            int
+	requestChan          chan structCollectTxRequest
+	responseChan         chan structCollectTxResponse
+	getTxs               getTxsCallback
+	Finish               chan bool
+	closing              chan bool
+	version              int
 }
 
 // CollectTxRequest is the request message that asks the receiver to send their
@@ -47,8 +50,9 @@ type CollectTxRequest struct {
 // CollectTxResponse is the response message that contains all the pending
 // transactions on the node.
 type CollectTxResponse struct {
-	Txs            []ClientTransaction
-	ByzcoinVersion Version
+	Txs              []ClientTransaction
+	ByzcoinVersion   Version
+	ContractVersions []ContractVersion `protobuf:"opt"`
 }
 
 type structCollectTxRequest struct {
@@ -69,13 +73,14 @@ func NewCollectTxProtocol(getTxs getTxsCallback) func(*onet.TreeNodeInstance) (o
 			// If we do not buffer this channel then the protocol
 			// might be blocked from stopping when the receiver
 			// stops reading from this channel.
-			TxsChan:           make(chan []ClientTransaction, len(node.List())),
-			CommonVersionChan: make(chan Version, len(node.List())),
-			MaxNumTxs:         defaultMaxNumTxs,
-			getTxs:            getTxs,
-			Finish:            make(chan bool),
-			closing:           make(chan bool),
-			version:           1,
+			TxsChan:              make(chan []ClientTransaction, len(node.List())),
+			CommonVersionChan:    make(chan Version, len(node.List())),
+			ContractVersionsChan: make(chan []ContractVersion, 1),
+			MaxNumTxs:            defaultMaxNumTxs,
+			getTxs:               getTxs,
+			Finish:               make(chan bool),
+			closing:              make(chan bool),
+			version:              1,
 		}
 		if err := node.RegisterChannels(&c.requestChan, &c.responseChan); err != nil {
 			return c, xerrors.Errorf("registering channels: %v", err)
@@ -140,8 +145,9 @@ func (p *CollectTxProtocol) Dispatch() error {
 
 	// send the result of the callback to the root
 	resp := &CollectTxResponse{
-		Txs:            p.getTxs(req.ServerIdentity, p.Roster(), req.SkipchainID, req.LatestID, maxOut),
-		ByzcoinVersion: p.getByzcoinVersion(),
+		Txs:              p.getTxs(req.ServerIdentity, p.Roster(), req.SkipchainID, req.LatestID, maxOut),
+		ByzcoinVersion:   p.getByzcoinVersion(),
+		ContractVersions: p.getContractVersions(),
 	}
 	log.Lvlf3("%s sends back %d transactions and version %d",
 		p.ServerIdentity(), len(resp.Txs), p.getByzcoinVersion())
@@ -162,6 +168,8 @@ func (p *CollectTxProtocol) Dispatch() error {
 
 		leaderVersion := p.getByzcoinVersion()
 		vb.add(p.ServerIdentity(), leaderVersion)
+		cvb := newContractVersionBuffer(len(p.Children()) + 1)
+		cvb.add(p.ServerIdentity(), p.getContractVersions())
 
 		finish := false
 
@@ -169,6 +177,7 @@ func (p *CollectTxProtocol) Dispatch() error {
 			select {
 			case resp := <-p.responseChan:
 				vb.add(resp.ServerIdentity, resp.ByzcoinVersion)
+				cvb.add(resp.ServerIdentity, resp.ContractVersions)
 
 				// If more than the limit is sent, we simply drop all of them
 				// as the conode is not behaving correctly.
@@ -185,6 +194,7 @@ func (p *CollectTxProtocol) Dispatch() error {
 		if vb.hasThresholdFor(leaderVersion) {
 			p.CommonVersionChan <- leaderVersion
 		}
+		p.ContractVersionsChan <- cvb.supported()
 	}
 	return nil
 }
@@ -204,6 +214,15 @@ func (p *CollectTxProtocol) getByzcoinVersion() Version {
 	return srv.(*Service).GetProtocolVersion()
 }
 
+func (p *CollectTxProtocol) getContractVersions() []ContractVersion {
+	srv := p.Host().Service(ServiceName)
+	if srv == nil {
+		panic("Byzcoin should always be available as a service for this protocol")
+	}
+
+	return srv.(*Service).contracts.supportedVersions()
+}
+
 type versionBuffer struct {
 	versions   map[Version]int
 	identities map[network.ServerIdentityID]bool
@@ -107,3 +107,23 @@ func TestCollectTx_VersionBuffer(t *testing.T) {
 	require.False(t, vb.hasThresholdFor(2))
 	require.True(t, vb.hasThresholdFor(1))
 }
+
+// Check that the contract versions need the support of a threshold.
+func TestCollectTx_ContractVersionBuffer(t *testing.T) {
+	cvb := newContractVersionBuffer(4)
+	v1 := ContractVersion{ContractID: "a", Version: 1}
+	v2 := ContractVersion{ContractID: "b", Version: 2}
+	ids := []*network.ServerIdentity{newSI(), newSI(), newSI(), newSI()}
+
+	cvb.add(ids[0], []ContractVersion{v1, v2})
+	cvb.add(ids[1], []ContractVersion{v1})
+	// A conode cannot count twice.
+	cvb.add(ids[1], []ContractVersion{v2})
+	cvb.add(ids[1], []ContractVersion{v2})
+	require.Empty(t, cvb.supported())
+
+	cvb.add(ids[2], []ContractVersion{v1, v2})
+	require.Equal(t, []ContractVersion{v1}, cvb.supported())
+	cvb.add(ids[3], []ContractVersion{v2})
+	require.Equal(t, []ContractVersion{v1, v2}, cvb.supported())
+}
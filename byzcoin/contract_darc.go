@@ -2,9 +2,11 @@ package byzcoin
 
 import (
 	"bytes"
+	"strings"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"golang.org/x/xerrors"
 )
 
@@ -45,6 +47,17 @@ func (c *contractSecureDarc) SetRegistry(r ReadOnlyContractRegistry) {
 	c.contracts = r
 }
 
+// VerifyInstruction verifies the spawning of a delegation against the rule of
+// the delegated action, so that everybody holding a right can delegate it.
+func (c *contractSecureDarc) VerifyInstruction(rst ReadOnlyStateTrie, inst Instruction, ctxHash []byte) error {
+	if inst.GetType() == SpawnType && inst.Spawn.ContractID == ContractDelegationID &&
+		rst.GetVersion() >= VersionDelegation {
+		return verifyDelegationInstruction(rst, inst, ctxHash,
+			darc.Action(inst.Spawn.Args.Search("action")))
+	}
+	return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+}
+
 // VerifyDeferredInstruction does the same as the standard VerifyInstruction
 // method in the diferrence that it does not take into account the counters. We
 // need the Darc contract to opt in for deferred transaction because it is used
@@ -66,6 +79,9 @@ func (c *contractSecureDarc) Spawn(rst ReadOnlyStateTrie, inst Instruction, coin
 		if d.Version != 0 {
 			return nil, nil, xerrors.New("DARC version must start at 0")
 		}
+		if err := verifyDarcRules(rst, d); err != nil {
+			return nil, nil, xerrors.Errorf("verifying rules: %v", err)
+		}
 
 		id := d.GetBaseID()
 
@@ -136,6 +152,9 @@ func (c *contractSecureDarc) Invoke(rst ReadOnlyStateTrie, inst Instruction, coi
 		if err := newD.SanityCheck(oldD); err != nil {
 			return nil, nil, xerrors.Errorf("sanity check: %v", err)
 		}
+		if err := verifyDarcRules(rst, newD); err != nil {
+			return nil, nil, xerrors.Errorf("verifying rules: %v", err)
+		}
 		// use the subset rule if it's not a genesis Darc
 		_, _, _, genesisDarcID, err := GetValueContract(rst, NewInstanceID(nil).Slice())
 		if err != nil {
@@ -168,6 +187,9 @@ func (c *contractSecureDarc) Invoke(rst ReadOnlyStateTrie, inst Instruction, coi
 		if err := newD.SanityCheck(oldD); err != nil {
 			return nil, nil, xerrors.Errorf("sanity check: %v", err)
 		}
+		if err := verifyDarcRules(rst, newD); err != nil {
+			return nil, nil, xerrors.Errorf("verifying rules: %v", err)
+		}
 		return []StateChange{
 			NewStateChange(Update, inst.InstanceID, ContractDarcID, darcBuf, darcID),
 		}, coins, nil
@@ -176,6 +198,51 @@ func (c *contractSecureDarc) Invoke(rst ReadOnlyStateTrie, inst Instruction, coi
 	}
 }
 
+// verifyDarcRules rejects the rules of the darc that the version of the
+// chain doesn't support, as the older nodes cannot evaluate them.
+func verifyDarcRules(rst ReadOnlyStateTrie, d *darc.Darc) error {
+	for _, rule := range d.Rules.List {
+		if err := verifyExprVersion(rst.GetVersion(), rule.Expr); err != nil {
+			return xerrors.Errorf("rule %s: %v", rule.Action, err)
+		}
+	}
+	return nil
+}
+
+// verifyExprVersion returns an error if the expression holds a term that the
+// version doesn't support.
+func verifyExprVersion(version Version, expr expression.Expr) error {
+	if version < VersionThreshold && expression.HasThreshold(expr) {
+		return xerrors.Errorf("threshold expressions need version %d",
+			VersionThreshold)
+	}
+	if version < VersionWalletIdentities &&
+		hasIdentityType(expr, "secp256k1", "webauthn") {
+		return xerrors.Errorf("wallet identities need version %d",
+			VersionWalletIdentities)
+	}
+	if version < VersionDelegation && hasIdentityType(expr, "delegation") {
+		return xerrors.Errorf("delegation identities need version %d",
+			VersionDelegation)
+	}
+	return nil
+}
+
+// hasIdentityType returns true if one of the ids of the expression has one of
+// the types. An expression that cannot be parsed has none.
+func hasIdentityType(expr expression.Expr, types ...string) bool {
+	found := false
+	expression.Explain(expr, func(id string) bool {
+		for _, typ := range types {
+			if strings.HasPrefix(id, typ+":") {
+				found = true
+			}
+		}
+		return false
+	})
+	return found
+}
+
 func isChangingEvolveUnrestricted(oldD *darc.Darc, newD *darc.Darc) bool {
 	oldExpr := oldD.Rules.Get(darc.Action("invoke:" + ContractDarcID + "." + cmdDarcEvolveUnrestriction))
 	newExpr := newD.Rules.Get(darc.Action("invoke:" + ContractDarcID + "." + cmdDarcEvolveUnrestriction))
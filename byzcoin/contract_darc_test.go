@@ -6,6 +6,7 @@ import (
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
@@ -247,3 +248,60 @@ func TestSecureDarc(t *testing.T) {
 
 	require.NoError(t, local.WaitDone(5*genesisMsg.BlockInterval))
 }
+
+// TestSecureDarc_Threshold checks that the darcs with threshold expressions
+// are only accepted once the chain runs VersionThreshold.
+func TestSecureDarc_Threshold(t *testing.T) {
+	ids := []darc.Identity{darc.NewSignerEd25519(nil, nil).Identity(),
+		darc.NewSignerEd25519(nil, nil).Identity()}
+	d := darc.NewDarc(darc.InitRules(ids[:1], ids[:1]), []byte("threshold"))
+	require.NoError(t, d.Rules.AddRule("spawn:value",
+		expression.InitThresholdExpr(1, ids[0].String(), ids[1].String())))
+	darcBuf, err := d.ToProto()
+	require.NoError(t, err)
+	inst := Instruction{
+		InstanceID: NewInstanceID(nil),
+		Spawn: &Spawn{
+			ContractID: ContractDarcID,
+			Args:       Arguments{{Name: "darc", Value: darcBuf}},
+		},
+	}
+
+	rost := NewROSTSimul()
+	rost.Version = VersionEvents
+	_, _, err = (&contractSecureDarc{}).Spawn(rost, inst, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "threshold expressions")
+
+	rost.Version = VersionThreshold
+	scs, _, err := (&contractSecureDarc{}).Spawn(rost, inst, nil)
+	require.NoError(t, err)
+	require.Len(t, scs, 1)
+}
+
+// TestSecureDarc_ExprVersion checks that the terms of the expressions are
+// only accepted from the version that introduced them.
+func TestSecureDarc_ExprVersion(t *testing.T) {
+	secp, err := darc.NewSignerSecp256k1(nil)
+	require.NoError(t, err)
+	webAuthn := darc.NewIdentityWebAuthn("example.com", []byte{4, 1, 2})
+	ed := darc.NewSignerEd25519(nil, nil).Identity()
+
+	for _, test := range []struct {
+		expr    expression.Expr
+		version Version
+	}{
+		{expression.Expr(ed.String()), VersionInstructionHash},
+		{expression.InitThresholdExpr(1, ed.String(), "darc:aa"), VersionThreshold},
+		{expression.InitOrExpr(ed.String(), secp.Identity().String()),
+			VersionWalletIdentities},
+		{expression.Expr(webAuthn.String()), VersionWalletIdentities},
+		{expression.InitAndExpr(ed.String(), "delegation:aa"), VersionDelegation},
+	} {
+		err := verifyExprVersion(test.version-1, test.expr)
+		if test.version > VersionInstructionHash {
+			require.Error(t, err, test.expr)
+		}
+		require.NoError(t, verifyExprVersion(test.version, test.expr), test.expr)
+	}
+}
@@ -0,0 +1,251 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ContractDelegationID is the ID of the delegation contract. A delegation
+// grants the right to perform an action of a darc to another identity, until
+// a given block index or timestamp. The delegate presents the delegation in
+// the Delegations of its instruction, which is then accepted while the
+// delegation is valid, without changing the rule of the action. The identity
+// delegation:<instance id> can also be added to the rule, for example to use
+// the delegation in another expression.
+//
+// A delegation is spawned from the darc with the following arguments:
+//   - action: the delegated action, like "invoke:value.update"
+//   - delegate: the identity receiving the right, like "ed25519:..."
+//   - expiry_index or expiry_timestamp: the last block in which the
+//     delegation can be used, as a little-endian uint64. The timestamp is in
+//     nanoseconds since the Unix epoch.
+//
+// Spawning a delegation, revoking it with the "revoke" command and deleting
+// it is authorized by the rule of the delegated action, instead of the rules
+// of the delegation contract: everybody holding a right can delegate it. A
+// delegation cannot be used to manage the delegations.
+const ContractDelegationID = "delegation"
+
+const cmdDelegationRevoke = "revoke"
+
+// maxIndex is the biggest block index, like math.MaxInt of Go 1.17.
+const maxIndex = int(^uint(0) >> 1)
+
+type contractDelegation struct {
+	BasicContract
+	Delegation
+}
+
+func contractDelegationFromBytes(in []byte) (Contract, error) {
+	c := &contractDelegation{}
+	err := protobuf.DecodeWithConstructors(in, &c.Delegation, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+	return c, nil
+}
+
+// verifyDelegationInstruction verifies the instruction against the rule of
+// the delegated action instead of the action of the instruction.
+func verifyDelegationInstruction(rst ReadOnlyStateTrie, inst Instruction, ctxHash []byte, action darc.Action) error {
+	if action == "" {
+		return xerrors.New("missing delegated action")
+	}
+	err := inst.VerifyWithOption(rst, ctxHash, &VerificationOptions{
+		EvalAttr: MakeAttrInterpreters(rst, inst),
+		action:   action,
+	})
+	return cothority.ErrorOrNil(err, "verifying delegation")
+}
+
+// VerifyInstruction verifies the revocation and the deletion of the
+// delegation against the rule of the delegated action.
+func (c *contractDelegation) VerifyInstruction(rst ReadOnlyStateTrie, inst Instruction, ctxHash []byte) error {
+	return verifyDelegationInstruction(rst, inst, ctxHash, c.Action)
+}
+
+func (c *contractDelegation) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
+	if rst.GetVersion() < VersionDelegation {
+		return nil, nil, xerrors.Errorf("delegations are only supported from version %d on",
+			VersionDelegation)
+	}
+
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	d, err := rst.LoadDarc(darcID)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading darc: %v", err)
+	}
+
+	del := Delegation{
+		DarcID: d.GetBaseID(),
+		Action: darc.Action(inst.Spawn.Args.Search("action")),
+	}
+	if !d.Rules.Contains(del.Action) {
+		return nil, nil, xerrors.Errorf("action '%v' does not exist", del.Action)
+	}
+	del.Delegate, err = darc.ParseIdentity(string(inst.Spawn.Args.Search("delegate")))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("parsing delegate: %v", err)
+	}
+	if buf := inst.Spawn.Args.Search("expiry_index"); buf != nil {
+		if len(buf) != 8 {
+			return nil, nil, xerrors.New("expiry_index must be 8 bytes long")
+		}
+		index := binary.LittleEndian.Uint64(buf)
+		if index > uint64(maxIndex) {
+			return nil, nil, xerrors.Errorf("expiry_index must not be bigger than %d", maxIndex)
+		}
+		del.Expiry.Index = int(index)
+	}
+	if buf := inst.Spawn.Args.Search("expiry_timestamp"); buf != nil {
+		if len(buf) != 8 {
+			return nil, nil, xerrors.New("expiry_timestamp must be 8 bytes long")
+		}
+		timestamp := binary.LittleEndian.Uint64(buf)
+		if timestamp > math.MaxInt64 {
+			return nil, nil, xerrors.Errorf("expiry_timestamp must not be bigger than %d",
+				int64(math.MaxInt64))
+		}
+		del.Expiry.Timestamp = int64(timestamp)
+	}
+	if err := del.Expiry.verify(); err != nil {
+		return nil, nil, xerrors.Errorf("invalid expiry: %v", err)
+	}
+
+	buf, err := protobuf.Encode(&del)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("encoding: %v", err)
+	}
+	return []StateChange{
+		NewStateChange(Create, inst.DeriveID(""), ContractDelegationID, buf, darcID),
+	}, coins, nil
+}
+
+func (c *contractDelegation) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
+	if inst.Invoke.Command != cmdDelegationRevoke {
+		return nil, nil, xerrors.Errorf("unknown command: %s", inst.Invoke.Command)
+	}
+	if c.Revoked {
+		return nil, nil, xerrors.New("the delegation is already revoked")
+	}
+
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	c.Revoked = true
+	buf, err := protobuf.Encode(&c.Delegation)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("encoding: %v", err)
+	}
+	return []StateChange{
+		NewStateChange(Update, inst.InstanceID, ContractDelegationID, buf, darcID),
+	}, coins, nil
+}
+
+// verify returns an error if the delegation cannot be used for the action of
+// the darc in the block following the state.
+func (del Delegation) verify(rst ReadOnlyStateTrie, darcID darc.ID, action darc.Action) error {
+	if del.Revoked {
+		return xerrors.New("the delegation has been revoked")
+	}
+	if !del.DarcID.Equal(darcID) || del.Action != action {
+		return xerrors.Errorf("the delegation is for action '%v' of darc %x",
+			del.Action, del.DarcID)
+	}
+	var timestamp int64
+	if tr, ok := rst.(TimeReader); ok {
+		timestamp = tr.GetCurrentBlockTimestamp()
+	} else if del.Expiry.Timestamp != 0 {
+		return xerrors.New("the block timestamp is not available")
+	}
+	if del.Expiry.before(rst.GetIndex()+1, timestamp) {
+		return xerrors.Errorf("the delegation expired after %s", &del.Expiry)
+	}
+	return nil
+}
+
+// delegationDarcGetter wraps getDarc so that it also resolves the delegation
+// identities given for the action of the darc, as described in darc.GetDarc.
+func delegationDarcGetter(rst ReadOnlyStateTrie, darcID darc.ID, action darc.Action,
+	getDarc darc.GetDarc) darc.GetDarc {
+	return func(s string, latest bool) *darc.Darc {
+		if !strings.HasPrefix(s, "delegation:") {
+			return getDarc(s, latest)
+		}
+		if rst.GetVersion() < VersionDelegation {
+			return nil
+		}
+		id, err := hex.DecodeString(strings.TrimPrefix(s, "delegation:"))
+		if err != nil {
+			return nil
+		}
+		del, err := loadDelegation(rst, id)
+		if err != nil {
+			return nil
+		}
+		if err := del.verify(rst, darcID, action); err != nil {
+			log.Lvlf2("cannot use %s: %v", s, err)
+			return nil
+		}
+		// The darc of the delegation is only used to evaluate the
+		// delegate, as its sign rule.
+		ids := []darc.Identity{del.Delegate}
+		return darc.NewDarc(darc.InitRules(ids, ids), []byte(s))
+	}
+}
+
+// loadDelegation returns the delegation of the instance.
+func loadDelegation(rst ReadOnlyStateTrie, id []byte) (*Delegation, error) {
+	buf, _, cid, _, err := rst.GetValues(id)
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	if cid != ContractDelegationID {
+		return nil, xerrors.Errorf("instance is a %s, not a delegation", cid)
+	}
+	var del Delegation
+	err = protobuf.DecodeWithConstructors(buf, &del, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding: %v", err)
+	}
+	return &del, nil
+}
+
+// presentedDelegates returns the delegates of the delegations presented with
+// the instruction, or an error if one of them cannot be used for the action
+// of the darc.
+func presentedDelegates(rst ReadOnlyStateTrie, instr Instruction, darcID darc.ID,
+	action darc.Action) ([]darc.Identity, error) {
+	if len(instr.Delegations) == 0 {
+		return nil, nil
+	}
+	if rst.GetVersion() < VersionDelegation {
+		return nil, xerrors.Errorf("delegations are only supported from version %d on",
+			VersionDelegation)
+	}
+	delegates := make([]darc.Identity, len(instr.Delegations))
+	for i, id := range instr.Delegations {
+		del, err := loadDelegation(rst, id.Slice())
+		if err != nil {
+			return nil, xerrors.Errorf("delegation %x: %v", id[:], err)
+		}
+		if err := del.verify(rst, darcID, action); err != nil {
+			return nil, xerrors.Errorf("delegation %x: %v", id[:], err)
+		}
+		delegates[i] = del.Delegate
+	}
+	return delegates, nil
+}
@@ -0,0 +1,249 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+const testDelegatedAction = darc.Action("invoke:value.update")
+
+type delegationTest struct {
+	t        *testing.T
+	trie     *trie.Trie
+	sst      *stagingStateTrie
+	owner    darc.Signer
+	delegate darc.Signer
+	darc     *darc.Darc
+	valueID  InstanceID
+}
+
+// newDelegationTest creates a state at the given version and block index,
+// with a darc of the owner and a value instance guarded by the darc.
+func newDelegationTest(t *testing.T, version Version, index int) *delegationTest {
+	memTrie, err := trie.NewTrie(trie.NewMemDB(), []byte("nonce"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(version))
+	require.NoError(t, memTrie.SetMetadata([]byte(trieVersionKey), buf))
+
+	dt := &delegationTest{
+		t:        t,
+		trie:     memTrie,
+		sst:      &stagingStateTrie{StagingTrie: *memTrie.MakeStagingTrie()},
+		owner:    darc.NewSignerEd25519(nil, nil),
+		delegate: darc.NewSignerEd25519(nil, nil),
+		valueID:  NewInstanceID([]byte("value")),
+	}
+	dt.setIndex(index)
+	ids := []darc.Identity{dt.owner.Identity()}
+	dt.darc = darc.NewDarc(darc.InitRules(ids, ids), []byte("delegating darc"))
+	require.NoError(t, dt.darc.Rules.AddRule(testDelegatedAction, dt.darc.Rules.GetSignExpr()))
+	require.NoError(t, dt.darc.Rules.AddRule("delete:value", dt.darc.Rules.GetSignExpr()))
+	require.NoError(t, dt.darc.Rules.AddRule("spawn:"+ContractDelegationID, dt.darc.Rules.GetSignExpr()))
+
+	configBuf, err := protobuf.Encode(&ChainConfig{DarcContractIDs: []string{ContractDarcID}})
+	require.NoError(t, err)
+	darcBuf, err := dt.darc.ToProto()
+	require.NoError(t, err)
+	require.NoError(t, dt.sst.StoreAll([]StateChange{
+		NewStateChange(Create, NewInstanceID(nil), ContractConfigID, configBuf, nil),
+		NewStateChange(Create, NewInstanceID(dt.darc.GetBaseID()), ContractDarcID,
+			darcBuf, dt.darc.GetBaseID()),
+		NewStateChange(Create, dt.valueID, "value", []byte("value"), dt.darc.GetBaseID()),
+	}))
+	return dt
+}
+
+// setIndex sets the index of the latest block.
+func (dt *delegationTest) setIndex(index int) {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(index))
+	require.NoError(dt.t, dt.trie.SetMetadata([]byte(trieIndexKey), buf))
+}
+
+// spawn returns the instruction spawning a delegation to the delegate.
+func (dt *delegationTest) spawn(action darc.Action, expiry Argument) Instruction {
+	return Instruction{
+		InstanceID: NewInstanceID(dt.darc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: ContractDelegationID,
+			Args: Arguments{
+				{Name: "action", Value: []byte(action)},
+				{Name: "delegate", Value: []byte(dt.delegate.Identity().String())},
+				expiry,
+			},
+		},
+	}
+}
+
+// apply runs the instruction on the state with the given contract.
+func (dt *delegationTest) apply(c Contract, instr Instruction) error {
+	var scs []StateChange
+	var err error
+	switch instr.GetType() {
+	case SpawnType:
+		scs, _, err = c.Spawn(dt.sst, instr, nil)
+	case InvokeType:
+		scs, _, err = c.Invoke(dt.sst, instr, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return dt.sst.StoreAll(scs)
+}
+
+// evolve adds the delegation to the rule of the delegated action.
+func (dt *delegationTest) evolve(delegationID InstanceID) {
+	d := dt.darc.Copy()
+	require.NoError(dt.t, d.EvolveFrom(dt.darc))
+	expr := dt.owner.Identity().String() + " | delegation:" + hex.EncodeToString(delegationID.Slice())
+	require.NoError(dt.t, d.Rules.UpdateRule(testDelegatedAction, []byte(expr)))
+	buf, err := d.ToProto()
+	require.NoError(dt.t, err)
+	require.NoError(dt.t, dt.sst.StoreAll([]StateChange{
+		NewStateChange(Update, NewInstanceID(dt.darc.GetBaseID()), ContractDarcID,
+			buf, dt.darc.GetBaseID()),
+	}))
+}
+
+// verify signs the instruction by the signer and verifies it at the given time.
+func (dt *delegationTest) verify(instr Instruction, now time.Time, signer darc.Signer) error {
+	instr.SignerCounter = []uint64{1}
+	ctx := NewClientTransaction(CurrentVersion, instr)
+	require.NoError(dt.t, ctx.FillSignersAndSignWith(signer))
+	gs := globalState{dt.sst, nil, &currentBlockInfo{now.UnixNano()}}
+
+	var c Contract = &contractSecureDarc{}
+	if instr.Spawn == nil {
+		buf, _, cid, _, err := dt.sst.GetValues(instr.InstanceID.Slice())
+		require.NoError(dt.t, err)
+		c = &BasicContract{}
+		if cid == ContractDelegationID {
+			c, err = contractDelegationFromBytes(buf)
+			require.NoError(dt.t, err)
+		}
+	}
+	return c.VerifyInstruction(gs, ctx.Instructions[0], ctx.Hash())
+}
+
+func leUint64(i uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, i)
+	return buf
+}
+
+func TestDelegation_Spawn(t *testing.T) {
+	dt := newDelegationTest(t, CurrentVersion, 3)
+	c, err := contractDelegationFromBytes(nil)
+	require.NoError(t, err)
+
+	expiry := Argument{Name: "expiry_index", Value: leUint64(5)}
+	require.Error(t, dt.apply(c, dt.spawn("invoke:value.delete", expiry)))
+	require.Error(t, dt.apply(c, dt.spawn(testDelegatedAction,
+		Argument{Name: "expiry_index", Value: []byte{5}})))
+	require.Error(t, dt.apply(c, dt.spawn(testDelegatedAction, Argument{})))
+	require.Error(t, dt.apply(c, dt.spawn(testDelegatedAction,
+		Argument{Name: "expiry_index", Value: leUint64(uint64(maxIndex) + 1)})))
+	require.Error(t, dt.apply(c, dt.spawn(testDelegatedAction,
+		Argument{Name: "expiry_timestamp", Value: leUint64(1 << 63)})))
+
+	instr := dt.spawn(testDelegatedAction, expiry)
+	require.NoError(t, dt.apply(c, instr))
+	buf, _, cid, darcID, err := dt.sst.GetValues(instr.DeriveID("").Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractDelegationID, cid)
+	require.Equal(t, dt.darc.GetBaseID(), darcID)
+	var del Delegation
+	require.NoError(t, protobuf.Decode(buf, &del))
+	require.Equal(t, testDelegatedAction, del.Action)
+	delegateID := dt.delegate.Identity()
+	require.True(t, del.Delegate.Equal(&delegateID))
+	require.Equal(t, 5, del.Expiry.Index)
+
+	// The contract is not available before its version.
+	dt = newDelegationTest(t, VersionWalletIdentities, 3)
+	require.Error(t, dt.apply(c, dt.spawn(testDelegatedAction, expiry)))
+}
+
+func TestDelegation_Verify(t *testing.T) {
+	now := time.Now()
+	dt := newDelegationTest(t, CurrentVersion, 3)
+	c, err := contractDelegationFromBytes(nil)
+	require.NoError(t, err)
+
+	spawnIndex := dt.spawn(testDelegatedAction, Argument{Name: "expiry_index", Value: leUint64(4)})
+	require.NoError(t, dt.apply(c, spawnIndex))
+	spawnTime := dt.spawn(testDelegatedAction, Argument{Name: "expiry_timestamp",
+		Value: leUint64(uint64(now.Add(time.Hour).UnixNano()))})
+	require.NoError(t, dt.apply(c, spawnTime))
+
+	update := Instruction{
+		InstanceID: dt.valueID,
+		Invoke:     &Invoke{ContractID: "value", Command: "update"},
+	}
+	require.Error(t, dt.verify(update, now, dt.delegate))
+
+	// The delegate presents the delegation, without changing the rule.
+	update.Delegations = []InstanceID{spawnIndex.DeriveID("")}
+	require.NoError(t, dt.verify(update, now, dt.delegate))
+	require.NoError(t, dt.verify(update, now, dt.owner))
+	require.Error(t, dt.verify(update, now, darc.NewSignerEd25519(nil, nil)))
+	// A delegation is only valid for its action and darc.
+	deleteValue := Instruction{
+		InstanceID:  dt.valueID,
+		Delete:      &Delete{ContractID: "value"},
+		Delegations: update.Delegations,
+	}
+	require.Error(t, dt.verify(deleteValue, now, dt.delegate))
+	deleteValue.Delegations = nil
+	require.NoError(t, dt.verify(deleteValue, now, dt.owner))
+	update.Delegations = []InstanceID{dt.valueID}
+	require.Error(t, dt.verify(update, now, dt.delegate))
+	update.Delegations = nil
+
+	// The delegations can also be added to the rule.
+	dt.evolve(spawnIndex.DeriveID(""))
+	require.NoError(t, dt.verify(update, now, dt.owner))
+	require.NoError(t, dt.verify(update, now, dt.delegate))
+
+	// The next block has index 5, which is after the expiry.
+	dt.setIndex(4)
+	require.Error(t, dt.verify(update, now, dt.delegate))
+	require.NoError(t, dt.verify(update, now, dt.owner))
+	update.Delegations = []InstanceID{spawnIndex.DeriveID("")}
+	require.Error(t, dt.verify(update, now, dt.delegate))
+	update.Delegations = []InstanceID{spawnTime.DeriveID("")}
+	require.NoError(t, dt.verify(update, now, dt.delegate))
+	update.Delegations = nil
+
+	dt.evolve(spawnTime.DeriveID(""))
+	require.NoError(t, dt.verify(update, now, dt.delegate))
+	require.Error(t, dt.verify(update, now.Add(2*time.Hour), dt.delegate))
+
+	// The delegate can neither revoke its delegation, nor spawn a new one.
+	revoke := Instruction{
+		InstanceID: spawnTime.DeriveID(""),
+		Invoke:     &Invoke{ContractID: ContractDelegationID, Command: cmdDelegationRevoke},
+	}
+	require.Error(t, dt.verify(revoke, now, dt.delegate))
+	require.Error(t, dt.verify(spawnIndex, now, dt.delegate))
+	revoke.Delegations = []InstanceID{spawnTime.DeriveID("")}
+	require.Error(t, dt.verify(revoke, now, dt.delegate))
+	revoke.Delegations = nil
+	require.NoError(t, dt.verify(revoke, now, dt.owner))
+
+	buf, _, _, _, err := dt.sst.GetValues(spawnTime.DeriveID("").Slice())
+	require.NoError(t, err)
+	c, err = contractDelegationFromBytes(buf)
+	require.NoError(t, err)
+	require.NoError(t, dt.apply(c, revoke))
+	require.Error(t, dt.verify(update, now, dt.delegate))
+	require.Error(t, dt.apply(c, revoke))
+}
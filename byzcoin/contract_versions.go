@@ -0,0 +1,412 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoinx"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// A contract can have multiple versions in the same binary. The version 0 is
+// the one registered with RegisterGlobalContract and it is active until an
+// instruction of the config contract switches to another one:
+//
+//   - invoke:config.contract_version with the arguments
+//     - contract_id  the ID of the contract
+//     - version      the new version, as a little-endian uint32
+//     - block_index  the index of the block where the version becomes
+//       active, as a little-endian uint64
+//
+// The leader only puts this instruction in a block once a threshold of the
+// conodes reported that they support the new version, in the same way as for
+// the upgrades of the ByzCoin version. The switch is stored in the
+// configuration of the chain and it is applied at the beginning of the first
+// block whose index is at least block_index: the migration function of the
+// new version, if any, rewrites all the existing instances of the contract,
+// then the following instructions are executed by the new version.
+
+// cmdContractVersion is the command of the config contract that schedules
+// the switch of the version of a contract.
+const cmdContractVersion = "contract_version"
+
+// MigrationFn rewrites the value of an existing instance when a new version
+// of its contract becomes active.
+type MigrationFn func(rst ReadOnlyStateTrie, id InstanceID, value []byte) ([]byte, error)
+
+type versionedContract struct {
+	fn      ContractFn
+	migrate MigrationFn
+}
+
+// ContractVersion is a version of a contract that a conode supports.
+type ContractVersion struct {
+	ContractID string
+	Version    uint32
+}
+
+// RegisterGlobalContractVersion stores a new version of a contract in the
+// global registry. The version 0 is the one registered with
+// RegisterGlobalContract so the version must be bigger. The migration
+// function is optional and rewrites the existing instances when the version
+// becomes active. This should be called during module initialization, like
+// RegisterGlobalContract.
+func RegisterGlobalContractVersion(contractID string, version uint32, f ContractFn, migrate MigrationFn) error {
+	err := globalContractRegistry.registerVersion(contractID, version, f, migrate, false)
+	return cothority.ErrorOrNil(err, "registration failed")
+}
+
+func (cr *contractRegistry) registerVersion(contractID string, version uint32,
+	f ContractFn, migrate MigrationFn, ignoreLock bool) error {
+	if version == 0 {
+		return xerrors.New("version 0 is registered with RegisterGlobalContract")
+	}
+
+	cr.Lock()
+	defer cr.Unlock()
+	if cr.locked && !ignoreLock {
+		return xerrors.New("contract registry is locked")
+	}
+	versions, ok := cr.versions[contractID]
+	if !ok {
+		versions = make(map[uint32]versionedContract)
+		cr.versions[contractID] = versions
+	}
+	if _, exists := versions[version]; exists {
+		return xerrors.New("contract version already registered")
+	}
+	versions[version] = versionedContract{fn: f, migrate: migrate}
+	return nil
+}
+
+// searchVersion looks up the given version of a contract.
+func (cr *contractRegistry) searchVersion(contractID string, version uint32) (versionedContract, bool) {
+	if version == 0 {
+		fn, ok := cr.Search(contractID)
+		return versionedContract{fn: fn}, ok
+	}
+	cr.Lock()
+	defer cr.Unlock()
+	vc, ok := cr.versions[contractID][version]
+	return vc, ok
+}
+
+func (cr *contractRegistry) hasVersions(contractID string) bool {
+	cr.Lock()
+	defer cr.Unlock()
+	return len(cr.versions[contractID]) > 0
+}
+
+// supportedVersions returns the versions above 0 of all contracts.
+func (cr *contractRegistry) supportedVersions() []ContractVersion {
+	cr.Lock()
+	defer cr.Unlock()
+	var cvs []ContractVersion
+	for id, versions := range cr.versions {
+		for v := range versions {
+			cvs = append(cvs, ContractVersion{ContractID: id, Version: v})
+		}
+	}
+	sortContractVersions(cvs)
+	return cvs
+}
+
+// withState returns a view of the registry giving the active version of
+// the contracts in the given state.
+func (cr *contractRegistry) withState(rst ReadOnlyStateTrie) ReadOnlyContractRegistry {
+	return stateContractRegistry{cr: cr, rst: rst}
+}
+
+type stateContractRegistry struct {
+	cr  *contractRegistry
+	rst ReadOnlyStateTrie
+}
+
+// Search implements ReadOnlyContractRegistry. It returns the constructor of
+// the active version of the contract.
+func (r stateContractRegistry) Search(contractID string) (ContractFn, bool) {
+	if !r.cr.hasVersions(contractID) {
+		return r.cr.Search(contractID)
+	}
+	config, err := r.rst.LoadConfig()
+	if err != nil {
+		return r.cr.Search(contractID)
+	}
+	vc, ok := r.cr.searchVersion(contractID, config.activeContractVersion(contractID))
+	return vc.fn, ok
+}
+
+func (r stateContractRegistry) searchVersion(contractID string, version uint32) (versionedContract, bool) {
+	return r.cr.searchVersion(contractID, version)
+}
+
+// activeContractVersion returns the version of the contract that is used to
+// execute the instructions.
+func (c ChainConfig) activeContractVersion(contractID string) uint32 {
+	var version uint32
+	for _, sw := range c.ContractVersions {
+		if sw.Applied && sw.ContractID == contractID {
+			version = sw.Version
+		}
+	}
+	return version
+}
+
+func sortContractVersions(cvs []ContractVersion) {
+	sort.Slice(cvs, func(i, j int) bool {
+		if cvs[i].ContractID != cvs[j].ContractID {
+			return cvs[i].ContractID < cvs[j].ContractID
+		}
+		return cvs[i].Version < cvs[j].Version
+	})
+}
+
+// contractVersionSwitch returns the switch requested by the instruction, or
+// false if it is not a contract_version instruction.
+func contractVersionSwitch(inst Instruction) (ContractVersionSwitch, bool, error) {
+	if inst.Invoke == nil || inst.Invoke.ContractID != ContractConfigID ||
+		inst.Invoke.Command != cmdContractVersion {
+		return ContractVersionSwitch{}, false, nil
+	}
+	sw := ContractVersionSwitch{ContractID: string(inst.Invoke.Args.Search("contract_id"))}
+	if sw.ContractID == "" {
+		return sw, true, xerrors.New("missing contract_id")
+	}
+	versionBuf := inst.Invoke.Args.Search("version")
+	if len(versionBuf) != 4 {
+		return sw, true, xerrors.New("version must be 4 bytes")
+	}
+	sw.Version = binary.LittleEndian.Uint32(versionBuf)
+	indexBuf := inst.Invoke.Args.Search("block_index")
+	if len(indexBuf) != 8 {
+		return sw, true, xerrors.New("block_index must be 8 bytes")
+	}
+	sw.BlockIndex = int(binary.LittleEndian.Uint64(indexBuf))
+	return sw, true, nil
+}
+
+// invokeContractVersion schedules the switch of the version of a contract.
+func (c *contractConfig) invokeContractVersion(rst ReadOnlyStateTrie, inst Instruction, darcID darc.ID) (StateChanges, error) {
+	sw, _, err := contractVersionSwitch(inst)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid arguments: %v", err)
+	}
+	if sw.BlockIndex <= rst.GetIndex()+1 {
+		return nil, xerrors.New("block index must be after the current block")
+	}
+	reg, ok := c.contracts.(interface {
+		searchVersion(string, uint32) (versionedContract, bool)
+	})
+	if !ok {
+		return nil, xerrors.New("contracts registry is missing due to bad initialization")
+	}
+	if _, ok := reg.searchVersion(sw.ContractID, sw.Version); !ok {
+		return nil, xerrors.Errorf("version %d of contract %s is not supported",
+			sw.Version, sw.ContractID)
+	}
+
+	config, err := rst.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	for _, other := range config.ContractVersions {
+		if other.ContractID == sw.ContractID && !other.Applied {
+			return nil, xerrors.Errorf("a switch of contract %s is already scheduled",
+				sw.ContractID)
+		}
+	}
+	if config.activeContractVersion(sw.ContractID) == sw.Version {
+		return nil, xerrors.Errorf("version %d is already active", sw.Version)
+	}
+	config.ContractVersions = append(config.ContractVersions, sw)
+	configBuf, err := protobuf.Encode(config)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding config: %v", err)
+	}
+	return StateChanges{
+		NewStateChange(Update, ConfigInstanceID, ContractConfigID, configBuf, darcID),
+	}, nil
+}
+
+// SetRegistry keeps the reference of the contract registry.
+func (c *contractConfig) SetRegistry(r ReadOnlyContractRegistry) {
+	c.contracts = r
+}
+
+// applyContractSwitches applies the switches of contract versions that are
+// due at the block following the state of sst. The existing instances are
+// migrated and the switches are marked as applied in the configuration. A
+// switch whose migration fails is dropped, so that the contract keeps its
+// version. The state changes are stored in sst and returned.
+func (s *Service) applyContractSwitches(sst *stagingStateTrie) (StateChanges, error) {
+	config, err := sst.LoadConfig()
+	if err != nil {
+		// The genesis block doesn't have a configuration yet.
+		return nil, nil
+	}
+	index := sst.GetIndex() + 1
+	var scs StateChanges
+	var switches []ContractVersionSwitch
+	changed := false
+	for _, sw := range config.ContractVersions {
+		if sw.Applied || sw.BlockIndex > index {
+			switches = append(switches, sw)
+			continue
+		}
+		changed = true
+		migrated, err := s.migrateContract(sst, sw)
+		if err != nil {
+			log.Warnf("%s: dropping switch of contract %s to version %d: %v",
+				s.ServerIdentity(), sw.ContractID, sw.Version, err)
+			continue
+		}
+		if err := sst.StoreAll(migrated); err != nil {
+			return nil, xerrors.Errorf("storing migration: %v", err)
+		}
+		scs = append(scs, migrated...)
+		sw.Applied = true
+		switches = append(switches, sw)
+		log.Lvlf2("%s: contract %s switched to version %d at block %d",
+			s.ServerIdentity(), sw.ContractID, sw.Version, index)
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	config.ContractVersions = switches
+	configBuf, err := protobuf.Encode(config)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding config: %v", err)
+	}
+	_, ver, _, darcID, err := sst.GetValues(ConfigInstanceID.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading config: %v", err)
+	}
+	sc := NewStateChange(Update, ConfigInstanceID, ContractConfigID, configBuf, darcID)
+	sc.Version = ver + 1
+	if err := sst.StoreAll(StateChanges{sc}); err != nil {
+		return nil, xerrors.Errorf("storing config: %v", err)
+	}
+	return append(scs, sc), nil
+}
+
+// migrateContract returns the state changes rewriting the instances of the
+// contract with the migration function of the new version.
+func (s *Service) migrateContract(sst *stagingStateTrie, sw ContractVersionSwitch) (StateChanges, error) {
+	vc, ok := s.contracts.searchVersion(sw.ContractID, sw.Version)
+	if !ok {
+		return nil, xerrors.New("version not supported")
+	}
+	if vc.migrate == nil {
+		return nil, nil
+	}
+
+	type instance struct {
+		id   InstanceID
+		body StateChangeBody
+	}
+	var instances []instance
+	err := sst.ForEach(func(k, v []byte) error {
+		// The decoded body shares the memory of v, which is only valid
+		// during the iteration.
+		body, err := decodeStateChangeBody(append([]byte{}, v...))
+		if err == nil && body.ContractID == sw.ContractID {
+			instances = append(instances, instance{NewInstanceID(k), body})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading instances: %v", err)
+	}
+
+	var scs StateChanges
+	for _, inst := range instances {
+		value, err := vc.migrate(sst, inst.id, inst.body.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("migrating %x: %v", inst.id[:], err)
+		}
+		sc := NewStateChange(Update, inst.id, sw.ContractID, value, inst.body.DarcID)
+		sc.Version = inst.body.Version + 1
+		scs = append(scs, sc)
+	}
+	return scs, nil
+}
+
+// contractVersionBuffer counts the conodes supporting each contract version.
+type contractVersionBuffer struct {
+	counts     map[ContractVersion]int
+	identities map[network.ServerIdentityID]bool
+	threshold  int
+}
+
+func newContractVersionBuffer(n int) contractVersionBuffer {
+	return contractVersionBuffer{
+		counts:     make(map[ContractVersion]int),
+		identities: make(map[network.ServerIdentityID]bool),
+		threshold:  byzcoinx.Threshold(n),
+	}
+}
+
+func (cvb contractVersionBuffer) add(si *network.ServerIdentity, cvs []ContractVersion) {
+	if cvb.identities[si.ID] {
+		return
+	}
+	cvb.identities[si.ID] = true
+	for _, cv := range cvs {
+		cvb.counts[cv]++
+	}
+}
+
+// supported returns the versions supported by a threshold of conodes.
+func (cvb contractVersionBuffer) supported() []ContractVersion {
+	var cvs []ContractVersion
+	for cv, n := range cvb.counts {
+		if n >= cvb.threshold {
+			cvs = append(cvs, cv)
+		}
+	}
+	sortContractVersions(cvs)
+	return cvs
+}
+
+// holdContractVersionTxs returns the transactions that can go in the next
+// block. A transaction switching to a contract version that is not supported
+// by a threshold of the conodes goes back in the mempool, so that it is tried
+// again for the next blocks until it expires. A version that the leader
+// itself doesn't know is not held: the contract refuses it.
+func (s *defaultTxProcessor) holdContractVersionTxs(txs []ClientTransaction,
+	supported []ContractVersion) []ClientTransaction {
+	var out []ClientTransaction
+txLoop:
+	for _, tx := range txs {
+		for _, inst := range tx.Instructions {
+			sw, ok, err := contractVersionSwitch(inst)
+			if !ok || err != nil || sw.Version == 0 {
+				// Invalid instructions are refused by the contract.
+				continue
+			}
+			if _, known := s.contracts.searchVersion(sw.ContractID, sw.Version); !known {
+				continue
+			}
+			found := false
+			for _, cv := range supported {
+				if cv.ContractID == sw.ContractID && cv.Version == sw.Version {
+					found = true
+				}
+			}
+			if !found {
+				log.Lvlf2("%s: holding switch of contract %s to version %d until "+
+					"enough conodes support it", s.ServerIdentity(), sw.ContractID, sw.Version)
+				s.mempool.restore(string(s.scID), tx)
+				continue txLoop
+			}
+		}
+		out = append(out, tx)
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+func contractVersionInstr(s *ser, contractID string, version uint32, index int, counter uint64) Instruction {
+	versionBuf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(versionBuf, version)
+	indexBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(indexBuf, uint64(index))
+	return Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    cmdContractVersion,
+			Args: Arguments{
+				{Name: "contract_id", Value: []byte(contractID)},
+				{Name: "version", Value: versionBuf},
+				{Name: "block_index", Value: indexBuf},
+			},
+		},
+		SignerIdentities: []darc.Identity{s.signer.Identity()},
+		SignerCounter:    []uint64{counter},
+	}
+}
+
+// dummyContractV1Func is the version 1 of the dummy contract, which prefixes
+// the values of the instances it spawns.
+func dummyContractV1Func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+	if inst.GetType() == SpawnType {
+		_, _, _, darcID, err := cdb.GetValues(inst.InstanceID.Slice())
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{
+			NewStateChange(Create, NewInstanceID(inst.Hash()), inst.Spawn.ContractID,
+				append([]byte("v1:"), inst.Spawn.Args[0].Value...), darcID),
+		}, nil, nil
+	}
+	return dummyContractFunc(cdb, inst, c)
+}
+
+func TestService_ContractVersion(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+	// The default genesis darc lets the owners switch the versions.
+	require.True(t, s.darc.Rules.Contains("invoke:"+ContractConfigID+"."+cmdContractVersion))
+
+	migrate := func(rst ReadOnlyStateTrie, id InstanceID, value []byte) ([]byte, error) {
+		return append([]byte("v1:"), value...), nil
+	}
+	for _, srv := range s.services {
+		require.NoError(t, srv.contracts.registerVersion(dummyContract, 1,
+			adaptor(dummyContractV1Func), migrate, true))
+	}
+
+	index := func() int {
+		st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+		require.NoError(t, err)
+		return st.GetIndex()
+	}
+
+	// The block must be in the future.
+	resp, _ := s.sendInstructions(t, 10,
+		contractVersionInstr(s, dummyContract, 1, index()+1, 2))
+	require.Contains(t, resp.Error, "block index must be after the current block")
+	// The version must be supported.
+	resp, _ = s.sendInstructions(t, 10,
+		contractVersionInstr(s, dummyContract, 2, index()+3, 2))
+	require.Contains(t, resp.Error, "version 2 of contract dummy is not supported")
+
+	// The switch happens two blocks after the instruction.
+	switchIndex := index() + 3
+	resp, _ = s.sendInstructions(t, 10,
+		contractVersionInstr(s, dummyContract, 1, switchIndex, 2))
+	require.Empty(t, resp.Error)
+	config, err := s.service().LoadConfig(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, []ContractVersionSwitch{{
+		ContractID: dummyContract, Version: 1, BlockIndex: switchIndex,
+	}}, config.ContractVersions)
+
+	// The old version is still used before the switch.
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract,
+		[]byte("before"), s.signer, 3)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	before := NewInstanceID(tx.Instructions[0].Hash())
+
+	tx, err = createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract,
+		[]byte("after"), s.signer, 4)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	after := NewInstanceID(tx.Instructions[0].Hash())
+
+	require.Equal(t, switchIndex, index())
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	for id, expected := range map[InstanceID]string{
+		NewInstanceID(s.tx.Instructions[0].Hash()): "v1:anyvalue",
+		before: "v1:before",
+		after:  "v1:after",
+	} {
+		value, version, _, _, err := st.GetValues(id.Slice())
+		require.NoError(t, err)
+		require.Equal(t, expected, string(value))
+		if id != after {
+			require.Equal(t, uint64(1), version)
+		}
+	}
+	config, err = s.service().LoadConfig(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	require.True(t, config.ContractVersions[0].Applied)
+	require.Equal(t, uint32(1), config.activeContractVersion(dummyContract))
+
+	// update_config cannot change the versions.
+	ctx, _ := createConfigTxWithCounter(t, testInterval, *s.roster, defaultMaxBlockSize, s, 5)
+	resp, _ = s.sendInstructions(t, 10, ctx.Instructions[0])
+	require.Contains(t, resp.Error, "contract versions can only be changed with contract_version")
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
-	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -97,6 +96,9 @@ type ContractFn func(in []byte) (Contract, error)
 // can be added for the global call.
 type contractRegistry struct {
 	registry map[string]ContractFn
+	// versions holds the other versions of the contracts, the version 0
+	// being the one in registry.
+	versions map[string]map[uint32]versionedContract
 	locked   bool
 	sync.Mutex
 }
@@ -148,6 +150,12 @@ func (cr *contractRegistry) clone() *contractRegistry {
 	for key, value := range cr.registry {
 		clone.registry[key] = value
 	}
+	for key, versions := range cr.versions {
+		clone.versions[key] = make(map[uint32]versionedContract)
+		for v, vc := range versions {
+			clone.versions[key][v] = vc
+		}
+	}
 	cr.Unlock()
 
 	return clone
@@ -156,6 +164,7 @@ func (cr *contractRegistry) clone() *contractRegistry {
 func newContractRegistry() *contractRegistry {
 	return &contractRegistry{
 		registry: make(map[string]ContractFn),
+		versions: make(map[string]map[uint32]versionedContract),
 		locked:   false,
 	}
 }
@@ -236,48 +245,10 @@ func (b BasicContract) VerifyDeferredInstruction(rst ReadOnlyStateTrie, inst Ins
 	return notImpl("VerifyDeferredInstruction")
 }
 
-// MakeAttrInterpreters provides one default attribute verification which check
-// whether the transaction is sent after a certain block index and before
-// another block index.
+// MakeAttrInterpreters provides the registered attribute interpreters, see
+// the package-level MakeAttrInterpreters.
 func (b BasicContract) MakeAttrInterpreters(rst ReadOnlyStateTrie, inst Instruction) darc.AttrInterpreters {
-	cb := func(attr string) error {
-		vals, err := url.ParseQuery(attr)
-		if err != nil {
-			return xerrors.Errorf("parsing query: %v", err)
-		}
-		beforeStr := vals.Get("before")
-		afterStr := vals.Get("after")
-
-		var before, after int
-
-		if len(beforeStr) == 0 {
-			// Set before to something higher than the current
-			// index so that it always passes.
-			before = rst.GetIndex() + 1
-		} else {
-			var err error
-			before, err = strconv.Atoi(beforeStr)
-			if err != nil {
-				return xerrors.Errorf("atoi: %v")
-			}
-		}
-
-		if len(afterStr) == 0 {
-			after = -1
-		} else {
-			var err error
-			after, err = strconv.Atoi(afterStr)
-			if err != nil {
-				return xerrors.Errorf("atoi: %v", err)
-			}
-		}
-
-		if after < rst.GetIndex() && rst.GetIndex() < before {
-			return nil
-		}
-		return xerrors.Errorf("the current block index is %d which does not fit in the interval (%d, %d)", rst.GetIndex(), after, before)
-	}
-	return darc.AttrInterpreters{"block": cb}
+	return MakeAttrInterpreters(rst, inst)
 }
 
 // Spawn is not implmented in a BasicContract. Types which embed BasicContract
@@ -324,6 +295,7 @@ var ConfigInstanceID = InstanceID{}
 type contractConfig struct {
 	BasicContract
 	ChainConfig
+	contracts ReadOnlyContractRegistry
 }
 
 var _ Contract = (*contractConfig)(nil)
@@ -471,10 +443,16 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 // Invoke offers the following functions:
 //   - Invoke:update_config
 //   - Invoke:view_change
+//   - Invoke:contract_version
 //
 // Invoke:update_config should have the following input argument:
 //   - config ChainConfig
 //
+// Invoke:contract_version should have the following input arguments:
+//   - contract_id string
+//   - version uint32, little-endian
+//   - block_index uint64, little-endian
+//
 // Invoke:view_change sould have the following input arguments:
 //   - newview viewchange.NewViewReq
 //   - multisig []byte
@@ -510,6 +488,10 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 		if err = newConfig.sanityCheck(oldConfig); err != nil {
 			return nil, nil, xerrors.Errorf("sanity check: %v", err)
 		}
+		// The contract versions can only be changed by contract_version.
+		if !sameContractVersions(newConfig.ContractVersions, oldConfig.ContractVersions) {
+			return nil, nil, xerrors.New("contract versions can only be changed with " + cmdContractVersion)
+		}
 		var val []byte
 		val, _, _, _, err = rst.GetValues(darcID)
 		if err != nil {
@@ -574,11 +556,26 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 
 		sc, err := updateRosterScs(rst, darcID, req.Roster)
 		return sc, coins, cothority.ErrorOrNil(err, "roster scs")
+	case cmdContractVersion:
+		sc, err := c.invokeContractVersion(rst, inst, darcID)
+		return sc, coins, cothority.ErrorOrNil(err, "contract version")
 	default:
 		return nil, nil, xerrors.New("invalid invoke command: " + inst.Invoke.Command)
 	}
 }
 
+func sameContractVersions(a, b []ContractVersionSwitch) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func updateRosterScs(rst ReadOnlyStateTrie, darcID darc.ID, newRoster onet.Roster) (StateChanges, error) {
 	config, err := rst.LoadConfig()
 	if err != nil {
@@ -3,6 +3,9 @@ package contracts
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"net/url"
+	"strconv"
 
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/darc"
@@ -14,6 +17,11 @@ import (
 // ContractCoinID denotes a contract that can store and transfer coins.
 const ContractCoinID = "coin"
 
+// AttrCoinBalance restricts the balance of a coin instance: the coin instance
+// "id", in hexadecimal, must hold at least "min" coins, as in
+// "attr:coin_balance:id=abcd...&min=100".
+const AttrCoinBalance = "coin_balance"
+
 // CoinName is a well-known InstanceID that identifies coins as belonging
 // to this contract.
 var CoinName = iid("byzCoin")
@@ -220,6 +228,38 @@ func (c *contractCoin) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 	return
 }
 
+func makeAttrCoinBalance(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction) func(string) error {
+	return func(attr string) error {
+		vals, err := url.ParseQuery(attr)
+		if err != nil {
+			return xerrors.Errorf("parsing query: %v", err)
+		}
+		id, err := hex.DecodeString(vals.Get("id"))
+		if err != nil || len(id) != len(byzcoin.InstanceID{}) {
+			return xerrors.New("id must be an instance ID in hexadecimal")
+		}
+		min, err := strconv.ParseUint(vals.Get("min"), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing min: %v", err)
+		}
+		buf, _, cid, _, err := rst.GetValues(id)
+		if err != nil {
+			return xerrors.Errorf("getting coin instance: %v", err)
+		}
+		if cid != ContractCoinID {
+			return xerrors.Errorf("instance is of contract %s instead of %s", cid, ContractCoinID)
+		}
+		var coin byzcoin.Coin
+		if err := protobuf.Decode(buf, &coin); err != nil {
+			return xerrors.Errorf("decoding coin: %v", err)
+		}
+		if coin.Value < min {
+			return xerrors.Errorf("the balance %d is smaller than %d", coin.Value, min)
+		}
+		return nil
+	}
+}
+
 // iid uses sha256(in) in order to manufacture an InstanceID from in
 // thereby handling the case where len(in) != 32.
 //
@@ -3,6 +3,7 @@ package contracts
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"testing"
 
@@ -343,3 +344,22 @@ func (ct cvTest) getContract(i byzcoin.InstanceID) byzcoin.Contract {
 	}
 	return c
 }
+
+func TestCoin_AttrBalance(t *testing.T) {
+	rost := byzcoin.NewROSTSimul()
+	id, err := rost.CreateCoin("test", 10)
+	require.NoError(t, err)
+	value, err := rost.CreateRandomInstance(ContractValueID, &byzcoin.Coin{}, nil)
+	require.NoError(t, err)
+
+	attr := makeAttrCoinBalance(rost, byzcoin.Instruction{})
+	idStr := hex.EncodeToString(id.Slice())
+	require.NoError(t, attr("id="+idStr+"&min=10"))
+	require.NoError(t, attr("id="+idStr+"&min=0"))
+	require.Error(t, attr("id="+idStr+"&min=11"))
+	require.Error(t, attr("id="+idStr))
+	require.Error(t, attr("id="+hex.EncodeToString(value.Slice())+"&min=0"))
+	require.Error(t, attr("id="+hex.EncodeToString(make([]byte, 32))+"&min=0"))
+
+	require.Contains(t, byzcoin.MakeAttrInterpreters(rost, byzcoin.Instruction{}), AttrCoinBalance)
+}
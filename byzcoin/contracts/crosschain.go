@@ -0,0 +1,334 @@
+package contracts
+
+import (
+	"crypto/sha256"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The crosschain contract lets a chain act on facts proven on another,
+// foreign, ByzCoin chain. An instance holds the genesis ID of the foreign
+// chain and its latest trusted block, with the roster that signs the forward
+// links from there. The following methods are available:
+//  - update takes the blocks of the foreign chain in the argument "blocks",
+//    encoded as a CrossChainBlocks. The first block must be the trusted block,
+//    with its forward links, and every following block must be reached by a
+//    forward link of the previous one. The last block becomes trusted.
+//  - verify takes a byzcoin.Proof of the foreign chain in the argument
+//    "proof", which must start from the trusted block. The key of the proof
+//    must be given in the argument "key" and be present on the foreign
+//    chain. Its value is then stored in a crosschain_value instance, whose ID
+//    is given by CrossChainValueID, and the latest block of the proof
+//    becomes trusted if it is newer.
+// Other contracts can read the crosschain_value instances with
+// ReadCrossChainValue.
+
+// ContractCrossChainID denotes a contract verifying proofs of a foreign
+// chain.
+var ContractCrossChainID = "crosschain"
+
+// ContractCrossChainValueID denotes the instances holding the values verified
+// by a crosschain instance. They can only be changed by verifying a proof.
+var ContractCrossChainValueID = "crosschain_value"
+
+// CrossChain is the value of a crosschain instance.
+type CrossChain struct {
+	// GenesisID is the ID of the genesis block of the foreign chain.
+	GenesisID skipchain.SkipBlockID
+	// BlockID is the ID of the latest trusted block.
+	BlockID skipchain.SkipBlockID
+	// Index is the index of the latest trusted block.
+	Index int
+	// Roster is the roster of the latest trusted block.
+	Roster *onet.Roster
+	// SignatureScheme is the signature scheme of the latest trusted block.
+	SignatureScheme uint32
+}
+
+// CrossChainBlocks holds the blocks given to the update command.
+type CrossChainBlocks struct {
+	Blocks []*skipchain.SkipBlock
+}
+
+// CrossChainValue is the value of a crosschain_value instance.
+type CrossChainValue struct {
+	// Key is the key of the instance on the foreign chain.
+	Key []byte
+	// Value is the value of the instance on the foreign chain.
+	Value []byte
+	// ContractID is the contract of the instance on the foreign chain.
+	ContractID string
+	// DarcID is the darc of the instance on the foreign chain.
+	DarcID darc.ID
+	// BlockIndex is the index of the block of the proof.
+	BlockIndex int
+}
+
+// CrossChainValueID returns the ID of the instance holding the value of the
+// given key of the foreign chain, verified by the crosschain instance with the
+// given ID.
+func CrossChainValueID(id byzcoin.InstanceID, key []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractCrossChainValueID))
+	h.Write(id.Slice())
+	h.Write(key)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// ReadCrossChainValue returns the value of the given key of the foreign chain,
+// verified by the crosschain instance with the given ID.
+func ReadCrossChainValue(rst byzcoin.ReadOnlyStateTrie, id byzcoin.InstanceID, key []byte) (*CrossChainValue, error) {
+	buf, _, cid, _, err := rst.GetValues(CrossChainValueID(id, key).Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading value: %v", err)
+	}
+	if cid != ContractCrossChainValueID {
+		return nil, xerrors.Errorf("wrong contract: %s", cid)
+	}
+	var v CrossChainValue
+	err = protobuf.Decode(buf, &v)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding value: %v", err)
+	}
+	return &v, nil
+}
+
+// ContractCrossChain verifies proofs of a foreign chain.
+type ContractCrossChain struct {
+	byzcoin.BasicContract
+	CrossChain
+}
+
+func contractCrossChainFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractCrossChain{}
+	err := protobuf.DecodeWithConstructors(in, &c.CrossChain, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// Spawn implements the byzcoin.Contract interface. It registers the foreign
+// chain given by its genesis ID in "genesis_id" and its trusted roster in
+// "roster", which is the protobuf encoding of an onet.Roster.
+func (c ContractCrossChain) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	var ca byzcoin.InstanceID
+	if rst.GetVersion() >= byzcoin.VersionPreID {
+		ca, err = inst.DeriveIDArg("", "preID")
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't get deriveID: %v", err)
+		}
+	} else {
+		ca = inst.DeriveID("")
+	}
+
+	genesisID := inst.Spawn.Args.Search("genesis_id")
+	if len(genesisID) != 32 {
+		return nil, nil, xerrors.New("genesis_id must be 32 bytes")
+	}
+	var roster onet.Roster
+	err = protobuf.DecodeWithConstructors(inst.Spawn.Args.Search("roster"),
+		&roster, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding roster: %v", err)
+	}
+	if len(roster.List) == 0 {
+		return nil, nil, xerrors.New("empty roster")
+	}
+
+	buf, err := protobuf.Encode(&CrossChain{
+		GenesisID: genesisID,
+		BlockID:   genesisID,
+		Roster:    &roster,
+	})
+	if err != nil {
+		return nil, nil, xerrors.Errorf("encoding instance: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, ca, ContractCrossChainID, buf, darcID),
+	}
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface
+func (c ContractCrossChain) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	cc := c.CrossChain
+	switch inst.Invoke.Command {
+	case "update":
+		var blocks CrossChainBlocks
+		err = protobuf.DecodeWithConstructors(inst.Invoke.Args.Search("blocks"),
+			&blocks, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, nil, xerrors.Errorf("decoding blocks: %v", err)
+		}
+		err = cc.update(blocks.Blocks)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("updating: %v", err)
+		}
+	case "verify":
+		var v *CrossChainValue
+		v, err = cc.verify(inst.Invoke.Args.Search("proof"), inst.Invoke.Args.Search("key"))
+		if err != nil {
+			return nil, nil, xerrors.Errorf("verifying proof: %v", err)
+		}
+		var buf []byte
+		buf, err = protobuf.Encode(v)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("encoding value: %v", err)
+		}
+		id := CrossChainValueID(inst.InstanceID, v.Key)
+		action := byzcoin.Create
+		if old, _, _, _, err := rst.GetValues(id.Slice()); err == nil && old != nil {
+			action = byzcoin.Update
+		}
+		sc = append(sc, byzcoin.NewStateChange(action, id,
+			ContractCrossChainValueID, buf, darcID))
+	default:
+		return nil, nil, xerrors.New("crosschain contract can only update and verify")
+	}
+
+	buf, err := protobuf.Encode(&cc)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("encoding instance: %v", err)
+	}
+	sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+		ContractCrossChainID, buf, darcID))
+	return
+}
+
+// update verifies the forward links from the trusted block to the last of the
+// blocks, and trusts the last one.
+func (cc *CrossChain) update(blocks []*skipchain.SkipBlock) error {
+	if len(blocks) < 2 {
+		return xerrors.New("need the trusted block and at least one new block")
+	}
+	prev := blocks[0]
+	if prev.SkipBlockFix == nil || !prev.CalculateHash().Equal(cc.BlockID) {
+		return xerrors.New("blocks don't start from the trusted block")
+	}
+	// The roster comes from the instance because the hash of the trusted
+	// block has been verified when it was trusted. The signature scheme
+	// comes from the block, as the hash covers it and a spawned instance
+	// doesn't know the scheme of the genesis block.
+	roster := cc.Roster
+	scheme := prev.SignatureScheme
+	for _, sb := range blocks[1:] {
+		if sb.SkipBlockFix == nil || sb.Roster == nil {
+			return xerrors.New("missing block header")
+		}
+		if !sb.Hash.Equal(sb.CalculateHash()) {
+			return xerrors.Errorf("wrong hash of block %d", sb.Index)
+		}
+		if !sb.SkipChainID().Equal(cc.GenesisID) {
+			return xerrors.Errorf("block %d is from another chain", sb.Index)
+		}
+		var link *skipchain.ForwardLink
+		for _, fl := range prev.ForwardLink {
+			if fl != nil && fl.To.Equal(sb.Hash) {
+				link = fl
+			}
+		}
+		if link == nil || !link.From.Equal(prev.CalculateHash()) {
+			return xerrors.Errorf("missing forward link to block %d", sb.Index)
+		}
+		publics := roster.ServicePublics(skipchain.ServiceName)
+		err := link.VerifyWithScheme(pairing.NewSuiteBn256(), publics, scheme)
+		if err != nil {
+			return xerrors.Errorf("invalid forward link to block %d: %v", sb.Index, err)
+		}
+		prev = sb
+		roster = sb.Roster
+		scheme = sb.SignatureScheme
+	}
+	cc.trust(prev)
+	return nil
+}
+
+// verify verifies that the proof starts from the trusted block and that the
+// key is present, and returns its value. The latest block of the proof is
+// trusted if it is newer than the trusted block.
+func (cc *CrossChain) verify(proofBuf []byte, key []byte) (*CrossChainValue, error) {
+	if len(key) == 0 {
+		return nil, xerrors.New("missing key")
+	}
+	var p byzcoin.Proof
+	err := protobuf.DecodeWithConstructors(proofBuf, &p, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding proof: %v", err)
+	}
+	if p.Latest.SkipBlockFix == nil || !p.Latest.SkipChainID().Equal(cc.GenesisID) {
+		return nil, xerrors.New("proof is from another chain")
+	}
+	trusted := &skipchain.SkipBlock{
+		SkipBlockFix: &skipchain.SkipBlockFix{Roster: cc.Roster},
+		Hash:         cc.BlockID,
+	}
+	err = p.VerifyFromBlock(trusted)
+	if err != nil {
+		return nil, cothority.WrapError(err)
+	}
+	if !p.InclusionProof.Match(key) {
+		return nil, xerrors.New("key is not present on the foreign chain")
+	}
+	value, contractID, darcID, err := p.Get(key)
+	if err != nil {
+		return nil, xerrors.Errorf("reading proof: %v", err)
+	}
+	if p.Latest.Index > cc.Index {
+		cc.trust(&p.Latest)
+	}
+	return &CrossChainValue{
+		Key:        key,
+		Value:      value,
+		ContractID: contractID,
+		DarcID:     darcID,
+		BlockIndex: p.Latest.Index,
+	}, nil
+}
+
+func (cc *CrossChain) trust(sb *skipchain.SkipBlock) {
+	cc.BlockID = sb.CalculateHash()
+	cc.Index = sb.Index
+	cc.Roster = sb.Roster
+	cc.SignatureScheme = sb.SignatureScheme
+}
+
+// contractCrossChainValue holds a value verified by a crosschain instance. It
+// doesn't accept any instruction, as only the crosschain instance can change
+// it.
+type contractCrossChainValue struct {
+	byzcoin.BasicContract
+}
+
+func contractCrossChainValueFromBytes(in []byte) (byzcoin.Contract, error) {
+	return contractCrossChainValue{}, nil
+}
+
+// Delete implements the byzcoin.Contract interface
+func (c contractCrossChainValue) Delete(byzcoin.ReadOnlyStateTrie, byzcoin.Instruction, []byzcoin.Coin) ([]byzcoin.StateChange, []byzcoin.Coin, error) {
+	return nil, nil, xerrors.New("crosschain values can't be removed")
+}
@@ -0,0 +1,235 @@
+package contracts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+)
+
+// ccChain is a ledger with a signer keeping track of its counter.
+type ccChain struct {
+	t       *testing.T
+	cl      *byzcoin.Client
+	darcID  darc.ID
+	signer  darc.Signer
+	counter uint64
+}
+
+func newCCChain(t *testing.T, roster *onet.Roster, rules ...string) *ccChain {
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		rules, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = time.Second
+	cl, _, err := byzcoin.NewLedger(msg, false)
+	require.NoError(t, err)
+	return &ccChain{t: t, cl: cl, darcID: msg.GenesisDarc.GetBaseID(), signer: signer}
+}
+
+func (c *ccChain) send(inst byzcoin.Instruction) (byzcoin.ClientTransaction, error) {
+	c.counter++
+	inst.SignerCounter = []uint64{c.counter}
+	ctx, err := c.cl.CreateTransaction(inst)
+	require.NoError(c.t, err)
+	require.NoError(c.t, ctx.FillSignersAndSignWith(c.signer))
+	_, err = c.cl.AddTransactionAndWait(ctx, 10)
+	if err != nil {
+		// The counter is only used by accepted transactions.
+		c.counter--
+	}
+	return ctx, err
+}
+
+func (c *ccChain) invoke(id byzcoin.InstanceID, cmd string, args ...byzcoin.Argument) error {
+	_, err := c.send(byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractCrossChainID,
+			Command:    cmd,
+			Args:       args,
+		},
+	})
+	return err
+}
+
+func (c *ccChain) proof(key []byte) *byzcoin.Proof {
+	rep, err := c.cl.GetProofFromLatest(key)
+	require.NoError(c.t, err)
+	return &rep.Proof
+}
+
+func TestCrossChain(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	foreign := newCCChain(t, roster, "spawn:value", "invoke:value.update")
+	home := newCCChain(t, roster, "spawn:crosschain",
+		"invoke:crosschain.update", "invoke:crosschain.verify")
+
+	ctx, err := foreign.send(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(foreign.darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractValueID,
+			Args:       byzcoin.Arguments{{Name: "value", Value: []byte("1234")}},
+		},
+	})
+	require.NoError(t, err)
+	key := ctx.Instructions[0].DeriveID("").Slice()
+
+	rosterBuf, err := protobuf.Encode(roster)
+	require.NoError(t, err)
+	ctx, err = home.send(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(home.darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractCrossChainID,
+			Args: byzcoin.Arguments{
+				{Name: "genesis_id", Value: foreign.cl.ID},
+				{Name: "roster", Value: rosterBuf},
+			},
+		},
+	})
+	require.NoError(t, err)
+	ccID := ctx.Instructions[0].DeriveID("")
+
+	trusted := func() CrossChain {
+		var cc CrossChain
+		require.NoError(t, home.proof(ccID.Slice()).VerifyAndDecode(cothority.Suite,
+			ContractCrossChainID, &cc))
+		return cc
+	}
+	verify := func(p *byzcoin.Proof, key []byte) error {
+		buf, err := protobuf.Encode(p)
+		require.NoError(t, err)
+		return home.invoke(ccID, "verify",
+			byzcoin.Argument{Name: "proof", Value: buf},
+			byzcoin.Argument{Name: "key", Value: key})
+	}
+	readValue := func() CrossChainValue {
+		var v CrossChainValue
+		require.NoError(t, home.proof(CrossChainValueID(ccID, key).Slice()).
+			VerifyAndDecode(cothority.Suite, ContractCrossChainValueID, &v))
+		return v
+	}
+
+	// The proof from the genesis block is accepted and its latest block
+	// becomes trusted.
+	rep, err := foreign.cl.GetProof(key)
+	require.NoError(t, err)
+	require.NoError(t, verify(&rep.Proof, key))
+	v := readValue()
+	require.Equal(t, []byte("1234"), v.Value)
+	require.Equal(t, ContractValueID, v.ContractID)
+	cc := trusted()
+	require.Equal(t, rep.Proof.Latest.Index, cc.Index)
+	require.Equal(t, rep.Proof.Latest.Hash, cc.BlockID)
+
+	// A proof of a missing key or of another chain is refused.
+	require.Error(t, verify(&rep.Proof, []byte("missing")))
+	require.Error(t, verify(home.proof(ccID.Slice()), ccID.Slice()))
+
+	_, err = foreign.send(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(key),
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractValueID,
+			Command:    "update",
+			Args:       byzcoin.Arguments{{Name: "value", Value: []byte("5678")}},
+		},
+	})
+	require.NoError(t, err)
+
+	// The forward links from the trusted block are submitted, so that the
+	// next proof only needs to start from the new latest block.
+	sc := skipchain.NewClient()
+	update, err := sc.GetUpdateChain(roster, cc.BlockID)
+	require.NoError(t, err)
+	require.True(t, len(update.Update) > 1)
+	latest := update.Update[len(update.Update)-1]
+	blocks, err := protobuf.Encode(&CrossChainBlocks{Blocks: update.Update})
+	require.NoError(t, err)
+	require.NoError(t, home.invoke(ccID, "update",
+		byzcoin.Argument{Name: "blocks", Value: blocks}))
+	cc = trusted()
+	require.Equal(t, latest.Hash, cc.BlockID)
+	require.Equal(t, latest.Index, cc.Index)
+
+	// The blocks must start from the trusted block.
+	require.Error(t, home.invoke(ccID, "update",
+		byzcoin.Argument{Name: "blocks", Value: blocks}))
+
+	rep, err = foreign.cl.GetProofFrom(key, latest)
+	require.NoError(t, err)
+	require.NoError(t, verify(&rep.Proof, key))
+	v = readValue()
+	require.Equal(t, []byte("5678"), v.Value)
+	require.Equal(t, rep.Proof.Latest.Index, v.BlockIndex)
+
+	// A proof starting from the genesis block is not accepted anymore.
+	rep, err = foreign.cl.GetProof(key)
+	require.NoError(t, err)
+	require.Error(t, verify(&rep.Proof, key))
+
+	local.WaitDone(time.Second)
+}
+
+// TestCrossChain_UpdateFromGenesis makes sure that the forward links of the
+// genesis block are accepted right after the spawn, whatever the signature
+// scheme of the foreign chain.
+func TestCrossChain_UpdateFromGenesis(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	foreign := newCCChain(t, roster, "spawn:value")
+	home := newCCChain(t, roster, "spawn:crosschain", "invoke:crosschain.update")
+
+	_, err := foreign.send(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(foreign.darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractValueID,
+			Args:       byzcoin.Arguments{{Name: "value", Value: []byte("1234")}},
+		},
+	})
+	require.NoError(t, err)
+
+	rosterBuf, err := protobuf.Encode(roster)
+	require.NoError(t, err)
+	ctx, err := home.send(byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(home.darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractCrossChainID,
+			Args: byzcoin.Arguments{
+				{Name: "genesis_id", Value: foreign.cl.ID},
+				{Name: "roster", Value: rosterBuf},
+			},
+		},
+	})
+	require.NoError(t, err)
+	ccID := ctx.Instructions[0].DeriveID("")
+
+	update, err := skipchain.NewClient().GetUpdateChain(roster, foreign.cl.ID)
+	require.NoError(t, err)
+	require.True(t, len(update.Update) > 1)
+	require.Equal(t, uint32(skipchain.BdnSignatureSchemeIndex),
+		update.Update[0].SignatureScheme)
+	blocks, err := protobuf.Encode(&CrossChainBlocks{Blocks: update.Update})
+	require.NoError(t, err)
+	require.NoError(t, home.invoke(ccID, "update",
+		byzcoin.Argument{Name: "blocks", Value: blocks}))
+
+	var cc CrossChain
+	require.NoError(t, home.proof(ccID.Slice()).VerifyAndDecode(cothority.Suite,
+		ContractCrossChainID, &cc))
+	latest := update.Update[len(update.Update)-1]
+	require.Equal(t, latest.Hash, cc.BlockID)
+	require.Equal(t, latest.Index, cc.Index)
+
+	local.WaitDone(time.Second)
+}
@@ -18,4 +18,24 @@ func init() {
 	if err != nil {
 		log.ErrFatal(err)
 	}
+	err = byzcoin.RegisterGlobalContract(ContractWasmID, contractWasmFromBytes)
+	if err != nil {
+		log.ErrFatal(err)
+	}
+	err = byzcoin.RegisterGlobalContract(ContractWasmDataID, contractWasmDataFromBytes)
+	if err != nil {
+		log.ErrFatal(err)
+	}
+	err = byzcoin.RegisterGlobalContract(ContractCrossChainID, contractCrossChainFromBytes)
+	if err != nil {
+		log.ErrFatal(err)
+	}
+	err = byzcoin.RegisterGlobalContract(ContractCrossChainValueID, contractCrossChainValueFromBytes)
+	if err != nil {
+		log.ErrFatal(err)
+	}
+	err = byzcoin.RegisterGlobalAttrInterpreter(AttrCoinBalance, makeAttrCoinBalance)
+	if err != nil {
+		log.ErrFatal(err)
+	}
 }
@@ -0,0 +1,539 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"sort"
+
+	lru "github.com/hashicorp/golang-lru"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/wasm"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The wasm contract stores a WebAssembly module in an instance and runs it
+// when the instance is spawned, invoked or deleted. This allows to deploy new
+// business logic without having to recompile the conodes.
+//
+// The module can export the functions "spawn", "invoke" and "delete", which
+// take no arguments and return an i32. Any other value than 0 makes the
+// instruction fail. Only "invoke" is mandatory. The module can import the
+// following functions from "env", the pointers and lengths being i32 in the
+// linear memory of the module:
+//
+//   arg(name, name_len, buf, buf_len) -> i32
+//     copies the value of the argument of the instruction with the given name
+//     in buf and returns its length, or -1 if it is missing
+//   command(buf, buf_len) -> i32
+//     copies the command of an invoke in buf and returns its length
+//   instance_id(buf)
+//     copies the 32 bytes of the ID of the wasm instance in buf
+//   data_id(key, key_len, buf)
+//     copies the 32 bytes of the ID of the data instance of the key in buf
+//   state_get(id, buf, buf_len) -> i32
+//     copies the value of the instance with the 32 bytes ID in buf and
+//     returns its length, or -1 if it doesn't exist
+//   data_set(key, key_len, value, value_len)
+//     emits a state change that creates or updates the data instance of the
+//     key with the value
+//   data_remove(key, key_len) -> i32
+//     emits a state change that removes the data instance of the key and
+//     returns 0, or -1 if it doesn't exist
+//   log(msg, msg_len)
+//     logs a message on the conode, for debugging
+//
+// When a buffer is too small, only its length is copied but the full length
+// is returned. The module can only change the data instances it owns, which
+// are the ones with a wasm_data contract and an ID derived from the ID of the
+// wasm instance and a key. The reads return the global state as it was before
+// the instruction. The keys of the data instances are listed in an index, so
+// that the data instances left by the delete function are removed with the
+// wasm instance.
+//
+// The execution is deterministic: the module has no access to the time or to
+// randomness and the floating point instructions are refused. Every executed
+// instruction costs gas and the execution fails when WasmGasLimit is reached.
+
+// ContractWasmID denotes a contract holding a WebAssembly module.
+var ContractWasmID = "wasm"
+
+// ContractWasmDataID denotes the instances holding the data of a WebAssembly
+// module. They can only be changed by the module.
+var ContractWasmDataID = "wasm_data"
+
+// WasmGasLimit is the gas available for an instruction on a wasm instance.
+var WasmGasLimit uint64 = 10000000
+
+// wasmModuleCacheSize is the number of parsed modules kept in memory, so
+// that a module isn't parsed again for every instruction.
+const wasmModuleCacheSize = 64
+
+// wasmModules caches the parsed modules by the hash of their code. A parsed
+// module is only read by the interpreter, so it can be shared.
+var wasmModules = func() *lru.Cache {
+	c, err := lru.New(wasmModuleCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// WasmDataID returns the ID of the data instance of the given key for the
+// wasm instance with the given ID.
+func WasmDataID(id byzcoin.InstanceID, key []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractWasmDataID))
+	h.Write(id.Slice())
+	h.Write(key)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// wasmIndexID returns the ID of the index of the data instances of the wasm
+// instance with the given ID.
+func wasmIndexID(id byzcoin.InstanceID) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte("wasm_index"))
+	h.Write(id.Slice())
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// wasmDataIndex lists the keys of the data instances of a wasm instance.
+type wasmDataIndex struct {
+	Keys [][]byte
+}
+
+// ContractWasm runs the WebAssembly module stored in its instance.
+type ContractWasm struct {
+	byzcoin.BasicContract
+	module []byte
+}
+
+func contractWasmFromBytes(in []byte) (byzcoin.Contract, error) {
+	return &ContractWasm{module: in}, nil
+}
+
+// Spawn implements the byzcoin.Contract interface. It creates a new instance
+// with the module given in the "module" argument and runs its spawn function.
+func (c ContractWasm) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	var ca byzcoin.InstanceID
+	if rst.GetVersion() >= byzcoin.VersionPreID {
+		ca, err = inst.DeriveIDArg("", "preID")
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't get deriveID: %v", err)
+		}
+	} else {
+		ca = inst.DeriveID("")
+	}
+
+	module := inst.Spawn.Args.Search("module")
+	mod, err := parseWasmModule(module)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid module: %v", err)
+	}
+	r := newWasmRun(rst, inst, ca, darcID)
+	if err = r.run(mod, "spawn"); err != nil {
+		return nil, nil, xerrors.Errorf("running spawn: %v", err)
+	}
+	scs, err := r.stateChanges()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("running spawn: %v", err)
+	}
+	sc = append([]byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, ca, ContractWasmID, module, darcID),
+	}, scs...)
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface. It runs the invoke
+// function of the module, which can read the command with "command".
+func (c ContractWasm) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	mod, err := parseWasmModule(c.module)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid module: %v", err)
+	}
+	if _, ok := mod.Export("invoke"); !ok {
+		return nil, nil, xerrors.New("module doesn't support invoke")
+	}
+	r := newWasmRun(rst, inst, inst.InstanceID, darcID)
+	if err = r.run(mod, "invoke"); err != nil {
+		return nil, nil, xerrors.Errorf("running invoke: %v", err)
+	}
+	sc, err = r.stateChanges()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("running invoke: %v", err)
+	}
+	return
+}
+
+// Delete implements the byzcoin.Contract interface. It runs the delete
+// function of the module and removes the instance with all its data
+// instances.
+func (c ContractWasm) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	mod, err := parseWasmModule(c.module)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid module: %v", err)
+	}
+	r := newWasmRun(rst, inst, inst.InstanceID, darcID)
+	if err = r.run(mod, "delete"); err != nil {
+		return nil, nil, xerrors.Errorf("running delete: %v", err)
+	}
+	sc, err = r.removeData()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("removing data: %v", err)
+	}
+	sc = append(sc, byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID,
+		ContractWasmID, nil, darcID))
+	return
+}
+
+// VerifyDeferredInstruction implements the byzcoin.Contract interface
+func (c ContractWasm) VerifyDeferredInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	return inst.VerifyWithOption(rst, ctxHash, &byzcoin.VerificationOptions{IgnoreCounters: true})
+}
+
+// contractWasmData holds the data of a module. It doesn't accept any
+// instruction, as only the module can change it.
+type contractWasmData struct {
+	byzcoin.BasicContract
+}
+
+func contractWasmDataFromBytes(in []byte) (byzcoin.Contract, error) {
+	return contractWasmData{}, nil
+}
+
+// Delete implements the byzcoin.Contract interface
+func (c contractWasmData) Delete(byzcoin.ReadOnlyStateTrie, byzcoin.Instruction, []byzcoin.Coin) ([]byzcoin.StateChange, []byzcoin.Coin, error) {
+	return nil, nil, xerrors.New("wasm data can only be removed by its module")
+}
+
+// parseWasmModule returns the parsed module, from the cache if it has
+// already been parsed.
+func parseWasmModule(code []byte) (*wasm.Module, error) {
+	h := sha256.Sum256(code)
+	if mod, ok := wasmModules.Get(h); ok {
+		return mod.(*wasm.Module), nil
+	}
+	mod, err := wasm.Parse(code)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"spawn", "invoke", "delete"} {
+		ft, ok := mod.Export(name)
+		if ok && !ft.Equal(wasm.FuncType{Results: []wasm.ValueType{wasm.I32}}) {
+			return nil, xerrors.Errorf("%s must take no arguments and return an i32", name)
+		}
+	}
+	wasmModules.Add(h, mod)
+	return mod, nil
+}
+
+// wasmRun holds the context of the execution of a module for an instruction.
+type wasmRun struct {
+	rst     byzcoin.ReadOnlyStateTrie
+	inst    byzcoin.Instruction
+	id      byzcoin.InstanceID
+	darcID  darc.ID
+	scs     []byzcoin.StateChange
+	written map[byzcoin.InstanceID]bool
+	// keys holds the keys of the data instances. It is loaded from the
+	// index when the set of keys is first changed.
+	keys        map[string]bool
+	indexed     bool
+	keysChanged bool
+}
+
+func newWasmRun(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction,
+	id byzcoin.InstanceID, darcID darc.ID) *wasmRun {
+	return &wasmRun{
+		rst:     rst,
+		inst:    inst,
+		id:      id,
+		darcID:  darcID,
+		written: make(map[byzcoin.InstanceID]bool),
+	}
+}
+
+// run runs the function of the module with the given name, if it is
+// exported, and records the state changes it emitted.
+func (r *wasmRun) run(mod *wasm.Module, name string) error {
+	if _, ok := mod.Export(name); !ok {
+		return nil
+	}
+
+	config := wasm.DefaultConfig()
+	config.Gas = WasmGasLimit
+	vm, err := wasm.Instantiate(mod, r.imports(), config)
+	if err != nil {
+		return xerrors.Errorf("instantiating module: %v", err)
+	}
+	res, err := vm.Call(name)
+	if err != nil {
+		return xerrors.Errorf("after %d gas: %v", vm.GasUsed(), err)
+	}
+	if code := int32(res[0]); code != 0 {
+		return xerrors.Errorf("module returned error code %d", code)
+	}
+	return nil
+}
+
+// loadKeys reads the keys of the data instances from the index, if they are
+// not loaded yet.
+func (r *wasmRun) loadKeys() error {
+	if r.keys != nil {
+		return nil
+	}
+	r.keys = make(map[string]bool)
+	buf, _, _, _, err := r.rst.GetValues(wasmIndexID(r.id).Slice())
+	if err != nil || buf == nil {
+		return nil
+	}
+	var index wasmDataIndex
+	if err := protobuf.Decode(buf, &index); err != nil {
+		return xerrors.Errorf("decoding index: %v", err)
+	}
+	r.indexed = true
+	for _, k := range index.Keys {
+		r.keys[string(k)] = true
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the data instances in a deterministic
+// order.
+func (r *wasmRun) sortedKeys() [][]byte {
+	var keys []string
+	for k := range r.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([][]byte, len(keys))
+	for i, k := range keys {
+		out[i] = []byte(k)
+	}
+	return out
+}
+
+// stateChanges returns the state changes emitted by the module, followed by
+// the update of the index if the keys of the data instances changed.
+func (r *wasmRun) stateChanges() ([]byzcoin.StateChange, error) {
+	if !r.keysChanged {
+		return r.scs, nil
+	}
+	id := wasmIndexID(r.id)
+	if len(r.keys) == 0 {
+		if !r.indexed {
+			return r.scs, nil
+		}
+		return append(r.scs, byzcoin.NewStateChange(byzcoin.Remove, id,
+			ContractWasmDataID, nil, r.darcID)), nil
+	}
+	buf, err := protobuf.Encode(&wasmDataIndex{Keys: r.sortedKeys()})
+	if err != nil {
+		return nil, xerrors.Errorf("encoding index: %v", err)
+	}
+	action := byzcoin.Create
+	if r.indexed {
+		action = byzcoin.Update
+	}
+	return append(r.scs, byzcoin.NewStateChange(action, id,
+		ContractWasmDataID, buf, r.darcID)), nil
+}
+
+// removeData returns the state changes emitted by the module, followed by
+// the removal of the remaining data instances and of the index.
+func (r *wasmRun) removeData() ([]byzcoin.StateChange, error) {
+	if err := r.loadKeys(); err != nil {
+		return nil, err
+	}
+	scs := r.scs
+	for _, k := range r.sortedKeys() {
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Remove,
+			WasmDataID(r.id, k), ContractWasmDataID, nil, r.darcID))
+	}
+	if r.indexed {
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Remove,
+			wasmIndexID(r.id), ContractWasmDataID, nil, r.darcID))
+	}
+	return scs, nil
+}
+
+func (r *wasmRun) args() byzcoin.Arguments {
+	switch r.inst.GetType() {
+	case byzcoin.SpawnType:
+		return r.inst.Spawn.Args
+	case byzcoin.InvokeType:
+		return r.inst.Invoke.Args
+	case byzcoin.DeleteType:
+		return r.inst.Delete.Args
+	}
+	return nil
+}
+
+// exists returns true if the instance exists, taking into account the state
+// changes already emitted.
+func (r *wasmRun) exists(id byzcoin.InstanceID) bool {
+	if w, ok := r.written[id]; ok {
+		return w
+	}
+	v, _, _, _, err := r.rst.GetValues(id.Slice())
+	return err == nil && v != nil
+}
+
+// copyOut writes as much of data as fits in the buffer and returns the
+// length of data.
+func copyOut(vm *wasm.VM, data []byte, ptr, length uint32) ([]uint64, error) {
+	if uint32(len(data)) < length {
+		length = uint32(len(data))
+	}
+	if err := vm.UseGas(uint64(length) * wasm.GasPerByte); err != nil {
+		return nil, err
+	}
+	if err := vm.Write(ptr, data[:length]); err != nil {
+		return nil, err
+	}
+	return []uint64{uint64(uint32(len(data)))}, nil
+}
+
+// read reads a buffer of the module, paying for its size.
+func read(vm *wasm.VM, ptr, length uint64) ([]byte, error) {
+	if err := vm.UseGas(length * wasm.GasPerByte); err != nil {
+		return nil, err
+	}
+	return vm.Read(uint32(ptr), uint32(length))
+}
+
+func (r *wasmRun) imports() wasm.Imports {
+	i32 := wasm.I32
+	fn := func(params, results int, call func(vm *wasm.VM, args []uint64) ([]uint64, error)) wasm.HostFunc {
+		ft := wasm.FuncType{}
+		for i := 0; i < params; i++ {
+			ft.Params = append(ft.Params, i32)
+		}
+		for i := 0; i < results; i++ {
+			ft.Results = append(ft.Results, i32)
+		}
+		return wasm.HostFunc{Type: ft, Call: call}
+	}
+	minusOne := []uint64{uint64(0xffffffff)}
+
+	return wasm.Imports{"env": {
+		"arg": fn(4, 1, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			name, err := read(vm, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			for _, arg := range r.args() {
+				if arg.Name == string(name) {
+					return copyOut(vm, arg.Value, uint32(args[2]), uint32(args[3]))
+				}
+			}
+			return minusOne, nil
+		}),
+		"command": fn(2, 1, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			var cmd string
+			if r.inst.Invoke != nil {
+				cmd = r.inst.Invoke.Command
+			}
+			return copyOut(vm, []byte(cmd), uint32(args[0]), uint32(args[1]))
+		}),
+		"instance_id": fn(1, 0, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			return nil, vm.Write(uint32(args[0]), r.id.Slice())
+		}),
+		"data_id": fn(3, 0, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			key, err := read(vm, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			return nil, vm.Write(uint32(args[2]), WasmDataID(r.id, key).Slice())
+		}),
+		"state_get": fn(3, 1, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			id, err := read(vm, args[0], 32)
+			if err != nil {
+				return nil, err
+			}
+			v, _, _, _, err := r.rst.GetValues(id)
+			if err != nil || v == nil {
+				return minusOne, nil
+			}
+			return copyOut(vm, v, uint32(args[1]), uint32(args[2]))
+		}),
+		"data_set": fn(4, 0, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			key, err := read(vm, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			value, err := read(vm, args[2], args[3])
+			if err != nil {
+				return nil, err
+			}
+			id := WasmDataID(r.id, key)
+			action := byzcoin.Create
+			if r.exists(id) {
+				action = byzcoin.Update
+			} else {
+				if err := r.loadKeys(); err != nil {
+					return nil, err
+				}
+				r.keys[string(key)] = true
+				r.keysChanged = true
+			}
+			r.scs = append(r.scs, byzcoin.NewStateChange(action, id,
+				ContractWasmDataID, value, r.darcID))
+			r.written[id] = true
+			return nil, nil
+		}),
+		"data_remove": fn(2, 1, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			key, err := read(vm, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			id := WasmDataID(r.id, key)
+			if !r.exists(id) {
+				return minusOne, nil
+			}
+			if err := r.loadKeys(); err != nil {
+				return nil, err
+			}
+			delete(r.keys, string(key))
+			r.keysChanged = true
+			r.scs = append(r.scs, byzcoin.NewStateChange(byzcoin.Remove, id,
+				ContractWasmDataID, nil, r.darcID))
+			r.written[id] = false
+			return []uint64{0}, nil
+		}),
+		"log": fn(2, 0, func(vm *wasm.VM, args []uint64) ([]uint64, error) {
+			msg, err := read(vm, args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			log.Lvlf3("wasm %x: %s", r.id.Slice(), msg)
+			return nil, nil
+		}),
+	}}
+}
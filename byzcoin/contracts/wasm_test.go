@@ -0,0 +1,224 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/protobuf"
+)
+
+// wasmSection encodes a section of a module whose items are all shorter than
+// 128 bytes.
+func wasmSection(id byte, items ...[]byte) []byte {
+	content := []byte{byte(len(items))}
+	for _, item := range items {
+		content = append(content, item...)
+	}
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+func wasmImport(name string, typ byte) []byte {
+	return append(append([]byte{3, 'e', 'n', 'v', byte(len(name))}, name...), 0, typ)
+}
+
+func wasmExport(name string, idx byte) []byte {
+	return append(append([]byte{byte(len(name))}, name...), 0, idx)
+}
+
+func wasmBody(code ...byte) []byte {
+	// One local of type i32.
+	return append([]byte{byte(len(code) + 3), 1, 1, 0x7f}, code...)
+}
+
+// counterModule stores the "value" argument under the key "v" when it is
+// spawned or invoked, and removes it when it is deleted. The invoke fails if
+// there is no value yet.
+func counterModule() []byte {
+	i32 := byte(0x7f)
+	// Stores the value argument, or returns 1 if it is missing.
+	store := []byte{
+		0x41, 8, 0x41, 5, 0x41, 0xc0, 0, 0x41, 32, 0x10, 0, // arg("value", 64, 32)
+		0x22, 0, 0x41, 0, 0x48, 0x04, 0x40, 0x41, 1, 0x0f, 0x0b, // if < 0 return 1
+		0x41, 0, 0x41, 1, 0x41, 0xc0, 0, 0x20, 0, 0x10, 1, // data_set("v", 64, len)
+		0x41, 0, 0x0b,
+	}
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, wasmSection(1,
+		[]byte{0x60, 4, i32, i32, i32, i32, 1, i32},
+		[]byte{0x60, 4, i32, i32, i32, i32, 0},
+		[]byte{0x60, 3, i32, i32, i32, 0},
+		[]byte{0x60, 3, i32, i32, i32, 1, i32},
+		[]byte{0x60, 2, i32, i32, 1, i32},
+		[]byte{0x60, 0, 1, i32},
+	)...)
+	module = append(module, wasmSection(2,
+		wasmImport("arg", 0), wasmImport("data_set", 1),
+		wasmImport("data_id", 2), wasmImport("state_get", 3),
+		wasmImport("data_remove", 4),
+	)...)
+	module = append(module, wasmSection(3, []byte{5}, []byte{5}, []byte{5})...)
+	module = append(module, wasmSection(5, []byte{0, 1})...)
+	module = append(module, wasmSection(7, wasmExport("spawn", 5),
+		wasmExport("invoke", 6), wasmExport("delete", 7))...)
+	module = append(module, wasmSection(10,
+		wasmBody(store...),
+		wasmBody(append([]byte{
+			0x41, 0, 0x41, 1, 0x41, 0xc8, 1, 0x10, 2, // data_id("v", 200)
+			0x41, 0xc8, 1, 0x41, 0xac, 2, 0x41, 32, 0x10, 3, // state_get(200, 300, 32)
+			0x41, 0, 0x48, 0x04, 0x40, 0x41, 2, 0x0f, 0x0b, // if < 0 return 2
+		}, store...)...),
+		wasmBody(0x41, 0, 0x41, 1, 0x10, 4, 0x1a, 0x41, 0, 0x0b),
+	)...)
+	data := append([]byte{0, 0x41, 0, 0x0b, 13}, "v\x00\x00\x00\x00\x00\x00\x00value"...)
+	return append(module, wasmSection(11, data)...)
+}
+
+func TestWasm_Lifecycle(t *testing.T) {
+	ct := newCT(t, "spawn:wasm", "invoke:wasm.update", "delete:wasm")
+	module := counterModule()
+
+	inst := byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(gdarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractWasmID,
+			Args: byzcoin.Arguments{
+				{Name: "module", Value: module},
+				{Name: "value", Value: []byte("first")},
+			},
+		},
+	}
+	c, err := contractWasmFromBytes(nil)
+	require.NoError(t, err)
+	sc, _, err := c.Spawn(ct, inst, nil)
+	require.NoError(t, err)
+	ca := inst.DeriveID("")
+	dataID := WasmDataID(ca, []byte("v"))
+	indexID := wasmIndexID(ca)
+	index, err := protobuf.Encode(&wasmDataIndex{Keys: [][]byte{[]byte("v")}})
+	require.NoError(t, err)
+	require.Equal(t, []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, ca, ContractWasmID, module, gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Create, dataID, ContractWasmDataID,
+			[]byte("first"), gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Create, indexID, ContractWasmDataID,
+			index, gdarc.GetBaseID()),
+	}, sc)
+	ct.Store(ca, module, ContractWasmID, gdarc.GetBaseID())
+	ct.Store(dataID, []byte("first"), ContractWasmDataID, gdarc.GetBaseID())
+	ct.Store(indexID, index, ContractWasmDataID, gdarc.GetBaseID())
+
+	inst = byzcoin.Instruction{
+		InstanceID: ca,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    "update",
+			Args:       byzcoin.Arguments{{Name: "value", Value: []byte("second")}},
+		},
+	}
+	c, err = contractWasmFromBytes(module)
+	require.NoError(t, err)
+	sc, _, err = c.Invoke(ct, inst, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, dataID, ContractWasmDataID,
+			[]byte("second"), gdarc.GetBaseID()),
+	}, sc)
+
+	// The module refuses an update without value.
+	inst.Invoke.Args = nil
+	_, _, err = c.Invoke(ct, inst, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error code 1")
+
+	inst = byzcoin.Instruction{
+		InstanceID: ca,
+		Delete:     &byzcoin.Delete{ContractID: ContractWasmID},
+	}
+	sc, _, err = c.Delete(ct, inst, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Remove, dataID, ContractWasmDataID, nil,
+			gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Remove, indexID, ContractWasmDataID, nil,
+			gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Remove, ca, ContractWasmID, nil,
+			gdarc.GetBaseID()),
+	}, sc)
+
+	// The data instances left by the module are removed with it.
+	otherID := WasmDataID(ca, []byte("w"))
+	index, err = protobuf.Encode(&wasmDataIndex{Keys: [][]byte{[]byte("v"), []byte("w")}})
+	require.NoError(t, err)
+	ct.Store(otherID, []byte("other"), ContractWasmDataID, gdarc.GetBaseID())
+	ct.Store(indexID, index, ContractWasmDataID, gdarc.GetBaseID())
+	sc, _, err = c.Delete(ct, inst, nil)
+	require.NoError(t, err)
+	require.Equal(t, []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Remove, dataID, ContractWasmDataID, nil,
+			gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Remove, otherID, ContractWasmDataID, nil,
+			gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Remove, indexID, ContractWasmDataID, nil,
+			gdarc.GetBaseID()),
+		byzcoin.NewStateChange(byzcoin.Remove, ca, ContractWasmID, nil,
+			gdarc.GetBaseID()),
+	}, sc)
+
+	// The data cannot be changed directly.
+	_, _, err = contractWasmData{}.Delete(ct, inst, nil)
+	require.Error(t, err)
+}
+
+func TestWasm_Errors(t *testing.T) {
+	ct := newCT(t, "spawn:wasm")
+	spawn := func(module []byte) error {
+		inst := byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(gdarc.GetBaseID()),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractWasmID,
+				Args:       byzcoin.Arguments{{Name: "module", Value: module}},
+			},
+		}
+		_, _, err := ContractWasm{}.Spawn(ct, inst, nil)
+		return err
+	}
+
+	require.Error(t, spawn([]byte("not a module")))
+
+	// A module is only parsed once.
+	mod, err := parseWasmModule(counterModule())
+	require.NoError(t, err)
+	mod2, err := parseWasmModule(counterModule())
+	require.NoError(t, err)
+	require.True(t, mod == mod2)
+
+	// The data instance doesn't exist yet, so the invoke fails.
+	ct.Store(byzcoin.NewInstanceID([]byte("wasm")), counterModule(),
+		ContractWasmID, gdarc.GetBaseID())
+	inst := byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID([]byte("wasm")),
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    "update",
+		},
+	}
+	_, _, err = ContractWasm{module: counterModule()}.Invoke(ct, inst, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error code 2")
+
+	// An endless loop runs out of gas.
+	loop := []byte("\x00asm\x01\x00\x00\x00")
+	loop = append(loop, wasmSection(1, []byte{0x60, 0, 1, 0x7f})...)
+	loop = append(loop, wasmSection(3, []byte{0})...)
+	loop = append(loop, wasmSection(7, wasmExport("spawn", 0))...)
+	loop = append(loop, wasmSection(10, wasmBody(0x03, 0x40, 0x0c, 0, 0x0b, 0x41, 0, 0x0b))...)
+	gas := WasmGasLimit
+	defer func() {
+		WasmGasLimit = gas
+	}()
+	WasmGasLimit = 1000
+	err = spawn(loop)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "out of gas")
+}
@@ -0,0 +1,100 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// NewEvent returns a state change that emits an event about the instance
+// iID. A contract returns it together with its other state changes. It
+// doesn't modify the global state, but the event is stored in the block if
+// the transaction is accepted.
+func NewEvent(iID InstanceID, name string, attrs ...Argument) (StateChange, error) {
+	buf, err := protobuf.Encode(&Event{
+		InstanceID: iID,
+		Name:       name,
+		Attributes: attrs,
+	})
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("encoding event: %v", err)
+	}
+	return NewStateChange(EmitEvent, iID, "", buf, darc.ID{}), nil
+}
+
+// decodeEvent returns the event of a state change created by NewEvent.
+func decodeEvent(sc StateChange) (Event, error) {
+	var ev Event
+	if sc.StateAction != EmitEvent {
+		return ev, xerrors.New("not an event")
+	}
+	if err := protobuf.Decode(sc.Value, &ev); err != nil {
+		return ev, xerrors.Errorf("decoding event: %v", err)
+	}
+	if !bytes.Equal(ev.InstanceID[:], sc.InstanceID) {
+		return ev, xerrors.New("instance ID of the event doesn't match")
+	}
+	return ev, nil
+}
+
+// Events returns the events emitted by the state changes, in order.
+func (scs StateChanges) Events() ([]Event, error) {
+	var evs []Event
+	for _, sc := range scs {
+		if sc.StateAction != EmitEvent {
+			continue
+		}
+		ev, err := decodeEvent(sc)
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, ev)
+	}
+	return evs, nil
+}
+
+// eventsHash returns the sha256 of all events, or nil if there are no
+// events, so that blocks without events have an empty DataHeader.EventsHash.
+func eventsHash(evs []Event) []byte {
+	if len(evs) == 0 {
+		return nil
+	}
+	h := sha256.New()
+	for _, ev := range evs {
+		buf, err := protobuf.Encode(&ev)
+		if err != nil {
+			log.Lvl2("Couldn't marshal event")
+		}
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+// matches returns true if the event passes the filter of the request.
+func (req *StreamEventsRequest) matches(ev Event) bool {
+	if len(req.Names) > 0 {
+		found := false
+		for _, name := range req.Names {
+			if name == ev.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(req.InstanceIDs) > 0 {
+		for _, id := range req.InstanceIDs {
+			if id.Equal(ev.InstanceID) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
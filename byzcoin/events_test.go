@@ -0,0 +1,183 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestEvents(t *testing.T) {
+	iID := genID()
+	sc, err := NewEvent(iID, "hello", Argument{Name: "who", Value: []byte("world")})
+	require.NoError(t, err)
+	require.Equal(t, EmitEvent, sc.StateAction)
+	ev, err := decodeEvent(sc)
+	require.NoError(t, err)
+	require.Equal(t, "hello", ev.Name)
+	require.Equal(t, []byte("world"), ev.Attributes.Search("who"))
+
+	// An event must be about the instance of its state change.
+	wrong := sc
+	wrong.InstanceID = genID().Slice()
+	_, err = decodeEvent(wrong)
+	require.Error(t, err)
+
+	scs := StateChanges{
+		NewStateChange(Create, iID, "dummy", nil, nil),
+		sc,
+	}
+	evs, err := scs.Events()
+	require.NoError(t, err)
+	require.Equal(t, []Event{ev}, evs)
+	require.Nil(t, eventsHash(nil))
+	require.NotNil(t, eventsHash(evs))
+
+	require.True(t, (&StreamEventsRequest{}).matches(ev))
+	require.True(t, (&StreamEventsRequest{Names: []string{"a", "hello"}}).matches(ev))
+	require.False(t, (&StreamEventsRequest{Names: []string{"a"}}).matches(ev))
+	require.True(t, (&StreamEventsRequest{InstanceIDs: []InstanceID{iID}}).matches(ev))
+	require.False(t, (&StreamEventsRequest{InstanceIDs: []InstanceID{genID()}}).matches(ev))
+	require.False(t, (&StreamEventsRequest{Names: []string{"hello"},
+		InstanceIDs: []InstanceID{genID()}}).matches(ev))
+}
+
+func TestService_StreamEvents(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// The emitter contract creates an instance and emits two events.
+	emitter := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		iID := inst.DeriveID("")
+		created, err := NewEvent(iID, "created", inst.Spawn.Args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		other, err := NewEvent(iID, "other")
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{
+			NewStateChange(Create, iID, emitterContract, nil, nil),
+			created,
+			other,
+		}, c, nil
+	}
+	for _, srv := range s.services {
+		require.NoError(t, srv.testRegisterContract(emitterContract, adaptorNoVerify(emitter)))
+	}
+
+	events, stop, err := s.service().StreamEvents(&StreamEventsRequest{
+		ID:    s.genesis.SkipChainID(),
+		Names: []string{"created"},
+	})
+	require.NoError(t, err)
+	defer close(stop)
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), emitterContract, s.value, s.signer)
+	require.NoError(t, err)
+	s.sendTx(t, tx)
+
+	var resp *StreamEventsResponse
+	select {
+	case resp = <-events:
+	case <-time.After(10 * testInterval):
+		t.Fatal("didn't get the events")
+	}
+	iID := tx.Instructions[0].DeriveID("")
+	require.Equal(t, 1, len(resp.Events))
+	require.Equal(t, "created", resp.Events[0].Name)
+	require.Equal(t, iID, resp.Events[0].InstanceID)
+	require.Equal(t, s.value, resp.Events[0].Attributes.Search("data"))
+
+	// Both events are in the block, and the state changes are stored
+	// without them.
+	sb := s.service().db().GetByID(resp.BlockID)
+	require.NotNil(t, sb)
+	var body DataBody
+	require.NoError(t, protobuf.Decode(sb.Payload, &body))
+	require.Equal(t, 2, len(body.Events))
+	header, err := decodeBlockHeader(sb)
+	require.NoError(t, err)
+	require.Equal(t, eventsHash(body.Events), header.EventsHash)
+
+	vers, err := s.service().GetAllInstanceVersion(&GetAllInstanceVersion{
+		SkipChainID: s.genesis.SkipChainID(),
+		InstanceID:  iID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(vers.StateChanges))
+}
+
+// An event between the state changes of an instance must not take one of its
+// versions.
+func TestService_EventsInstanceVersions(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	emitter := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		iID := inst.DeriveID("")
+		ev, err := NewEvent(iID, "created")
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{
+			NewStateChange(Create, iID, emitterContract, []byte("a"), nil),
+			ev,
+			NewStateChange(Update, iID, emitterContract, []byte("b"), nil),
+		}, c, nil
+	}
+	for _, srv := range s.services {
+		require.NoError(t, srv.testRegisterContract(emitterContract, adaptorNoVerify(emitter)))
+	}
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), emitterContract, s.value, s.signer)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	iID := tx.Instructions[0].DeriveID("")
+	s.waitProof(t, iID)
+
+	vers, err := s.service().GetAllInstanceVersion(&GetAllInstanceVersion{
+		SkipChainID: s.genesis.SkipChainID(),
+		InstanceID:  iID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(vers.StateChanges))
+	for i, sc := range vers.StateChanges {
+		require.Equal(t, uint64(i), sc.StateChange.Version)
+	}
+	st, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	_, ver, _, _, err := st.GetValues(iID.Slice())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), ver)
+}
+
+func TestService_EventsVersion(t *testing.T) {
+	s := newSerWithVersion(t, 1, testInterval, 4, disableViewChange, VersionTxValidity)
+	defer s.local.CloseAll()
+
+	emitter := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		ev, err := NewEvent(inst.DeriveID(""), "created")
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{ev}, c, nil
+	}
+	for _, srv := range s.services {
+		require.NoError(t, srv.testRegisterContract(emitterContract, adaptorNoVerify(emitter)))
+	}
+
+	// Nodes before VersionEvents don't know about events, so they must be
+	// refused.
+	tx, err := createOneClientTx(s.darc.GetBaseID(), emitterContract, s.value, s.signer)
+	require.NoError(t, err)
+	resp, err := s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Error, "emitted an event before version")
+}
@@ -0,0 +1,204 @@
+package byzcoin
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// The HTTP servers of the metrics and of the REST gateway are shared by all
+// the ByzCoin services of the process, as several conodes can run in the same
+// process, like in the tests. A server is opened on the first registration to
+// its address and closed once the last service is unregistered.
+const (
+	// httpReadTimeout is the time to read a request, including its body.
+	httpReadTimeout = 30 * time.Second
+	// httpWriteTimeout is the time to answer a request. It is longer than
+	// the read timeout, as adding a transaction can wait for its inclusion.
+	httpWriteTimeout = 2 * time.Minute
+	// httpIdleTimeout is the time an idle connection is kept open.
+	httpIdleTimeout = 2 * time.Minute
+	// httpMaxBodySize is the maximal size of the body of a request. A
+	// transaction cannot be bigger than a block.
+	httpMaxBodySize = defaultMaxBlockSize
+)
+
+// httpServer is an HTTP server shared by the services of the process.
+type httpServer struct {
+	server *http.Server
+	addr   net.Addr
+	// metrics and rest are the services whose metrics and REST gateway
+	// are served, in the order of their registration.
+	metrics []*Service
+	rest    []*Service
+	// origins are the origins allowed to call the REST gateway from a
+	// browser, merged from the configurations of all the services.
+	origins []string
+}
+
+var httpServers = struct {
+	sync.Mutex
+	servers map[string]*httpServer
+}{servers: make(map[string]*httpServer)}
+
+// registerHTTP serves the metrics and the REST gateway of the service on the
+// addresses of the node configuration.
+func (s *Service) registerHTTP() error {
+	nc := getNodeConfig()
+	httpServers.Lock()
+	defer httpServers.Unlock()
+	if nc.MetricsAddress != "" {
+		hs, err := openHTTPServer(nc.MetricsAddress)
+		if err != nil {
+			return xerrors.Errorf("serving metrics: %v", err)
+		}
+		hs.metrics = append(hs.metrics, s)
+	}
+	if nc.RESTAddress != "" {
+		hs, err := openHTTPServer(nc.RESTAddress)
+		if err != nil {
+			s.unregisterHTTPLocked()
+			return xerrors.Errorf("serving REST gateway: %v", err)
+		}
+		hs.rest = append(hs.rest, s)
+		hs.origins = mergeOrigins(hs.origins, nc.RESTAllowedOrigins)
+	}
+	return nil
+}
+
+// unregisterHTTP stops serving the metrics and the REST gateway of the
+// service, and closes the servers that serve no service anymore.
+func (s *Service) unregisterHTTP() {
+	httpServers.Lock()
+	defer httpServers.Unlock()
+	s.unregisterHTTPLocked()
+}
+
+func (s *Service) unregisterHTTPLocked() {
+	remove := func(list []*Service) []*Service {
+		for i, srv := range list {
+			if srv == s {
+				return append(list[:i:i], list[i+1:]...)
+			}
+		}
+		return list
+	}
+	for addr, hs := range httpServers.servers {
+		hs.metrics = remove(hs.metrics)
+		hs.rest = remove(hs.rest)
+		if len(hs.metrics) > 0 || len(hs.rest) > 0 {
+			continue
+		}
+		if err := hs.server.Close(); err != nil {
+			log.Warn("couldn't close the HTTP server:", err)
+		}
+		delete(httpServers.servers, addr)
+	}
+}
+
+// openHTTPServer returns the server of addr, and opens it if there is none
+// yet. It must be called with httpServers locked.
+func openHTTPServer(addr string) (*httpServer, error) {
+	if hs, ok := httpServers.servers[addr]; ok {
+		return hs, nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, xerrors.Errorf("listening: %v", err)
+	}
+	hs := &httpServer{addr: l.Addr()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", hs.serveMetrics)
+	mux.HandleFunc(restPrefix, hs.serveREST)
+	hs.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  httpReadTimeout,
+		WriteTimeout: httpWriteTimeout,
+		IdleTimeout:  httpIdleTimeout,
+	}
+	go func() {
+		if err := hs.server.Serve(l); err != http.ErrServerClosed {
+			log.Error("HTTP server stopped:", err)
+		}
+	}()
+	httpServers.servers[addr] = hs
+	log.Lvl1("Serving ByzCoin over HTTP on", l.Addr())
+	return hs, nil
+}
+
+func (hs *httpServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	httpServers.Lock()
+	services := append([]*Service{}, hs.metrics...)
+	httpServers.Unlock()
+	if len(services) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	metricsHandler(services).ServeHTTP(w, r)
+}
+
+func (hs *httpServer) serveREST(w http.ResponseWriter, r *http.Request) {
+	httpServers.Lock()
+	services := append([]*Service{}, hs.rest...)
+	origins := hs.origins
+	httpServers.Unlock()
+	if len(services) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" && allowedOrigin(origins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	// The chain is served by the first service that knows it.
+	srv := services[0]
+	if bcID, ok := restChainID(r.URL.Path); ok {
+		for _, s := range services {
+			if s.hasStateTrie(bcID) {
+				srv = s
+				break
+			}
+		}
+	}
+	srv.RESTHandler().ServeHTTP(w, r)
+}
+
+// allowedOrigin returns true if the origin is in the list, or if the list
+// holds "*".
+func allowedOrigin(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeOrigins returns the origins with the ones of other that are not in
+// the list yet.
+func mergeOrigins(origins, other []string) []string {
+	out := append([]string{}, origins...)
+otherLoop:
+	for _, o := range other {
+		for _, known := range out {
+			if strings.EqualFold(o, known) {
+				continue otherLoop
+			}
+		}
+		out = append(out, o)
+	}
+	return out
+}
@@ -0,0 +1,338 @@
+// Package lightclient implements a client that follows a ByzCoin chain
+// without storing its blocks. It only keeps the headers of the blocks it has
+// verified, starting from a trusted genesis block, in a local bbolt file.
+//
+// The headers are verified by following the forward links of the chain, so
+// the roster changes are verified too. A proof is verified against the
+// block it starts from, which only requires the forward links created since
+// the last trusted block, and the latest block of the proof becomes trusted
+// in turn.
+package lightclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var (
+	// bucketBlocks maps the IDs of the trusted blocks to their header.
+	bucketBlocks = []byte("blocks")
+	// bucketIndexes maps the indexes of the trusted blocks to their ID.
+	bucketIndexes = []byte("indexes")
+	// bucketMeta holds the genesis and the latest block IDs.
+	bucketMeta = []byte("meta")
+
+	keyGenesis = []byte("genesis")
+	keyLatest  = []byte("latest")
+)
+
+var suite = pairing.NewSuiteBn256()
+
+// ErrUnknownBlock is returned when a proof doesn't start from a trusted
+// block.
+var ErrUnknownBlock = xerrors.New("proof doesn't start from a trusted block")
+
+// Client is a light client of a ByzCoin chain.
+type Client struct {
+	db        *bbolt.DB
+	skipchain *skipchain.Client
+	genesisID skipchain.SkipBlockID
+	latest    *skipchain.SkipBlock
+	sync.Mutex
+}
+
+// Open opens the header store in the file at path, creating it if needed.
+// The genesis block is trusted when the store is created; if it exists
+// already, it must be for the same chain and the client starts again from
+// the latest block verified before.
+func Open(path string, genesis *skipchain.SkipBlock) (*Client, error) {
+	if genesis == nil || genesis.Index != 0 {
+		return nil, xerrors.New("need a genesis block")
+	}
+	if !genesis.Hash.Equal(genesis.CalculateHash()) {
+		return nil, xerrors.New("wrong hash of the genesis block")
+	}
+
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("opening db: %v", err)
+	}
+	c := &Client{
+		db:        db,
+		skipchain: skipchain.NewClient(),
+		genesisID: genesis.Hash,
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketBlocks, bucketIndexes, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return xerrors.Errorf("creating bucket: %v", err)
+			}
+		}
+		meta := tx.Bucket(bucketMeta)
+		stored := meta.Get(keyGenesis)
+		if stored == nil {
+			if err := meta.Put(keyGenesis, genesis.Hash); err != nil {
+				return xerrors.Errorf("storing genesis: %v", err)
+			}
+			return storeBlock(tx, genesis)
+		}
+		if !bytes.Equal(stored, genesis.Hash) {
+			return xerrors.New("the store holds another chain")
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.View(func(tx *bbolt.Tx) error {
+			var err error
+			c.latest, err = getBlock(tx, tx.Bucket(bucketMeta).Get(keyLatest))
+			return err
+		})
+	}
+	if err != nil {
+		db.Close()
+		return nil, xerrors.Errorf("loading store: %v", err)
+	}
+	return c, nil
+}
+
+// Close closes the header store.
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// GenesisID returns the ID of the chain followed by the client.
+func (c *Client) GenesisID() skipchain.SkipBlockID {
+	return c.genesisID
+}
+
+// Latest returns the header of the latest trusted block.
+func (c *Client) Latest() *skipchain.SkipBlock {
+	c.Lock()
+	defer c.Unlock()
+	return c.latest.Copy()
+}
+
+// GetBlock returns the header of the trusted block with the given ID, or nil
+// if the block is not trusted.
+func (c *Client) GetBlock(id skipchain.SkipBlockID) (sb *skipchain.SkipBlock, err error) {
+	err = c.db.View(func(tx *bbolt.Tx) error {
+		sb, err = getBlock(tx, id)
+		return err
+	})
+	return
+}
+
+// GetBlockByIndex returns the header of the trusted block with the given
+// index, or nil if the block is not trusted.
+func (c *Client) GetBlockByIndex(index int) (sb *skipchain.SkipBlock, err error) {
+	err = c.db.View(func(tx *bbolt.Tx) error {
+		id := tx.Bucket(bucketIndexes).Get(indexKey(index))
+		if id == nil {
+			return nil
+		}
+		sb, err = getBlock(tx, id)
+		return err
+	})
+	return
+}
+
+// Update asks the roster of the latest trusted block for the blocks that
+// follow it and trusts them once their forward links are verified. It
+// returns the number of new trusted blocks.
+func (c *Client) Update() (int, error) {
+	latest := c.Latest()
+	reply, err := c.skipchain.GetUpdateChain(latest.Roster, latest.Hash)
+	if err != nil {
+		return 0, xerrors.Errorf("getting update chain: %v", err)
+	}
+
+	blocks, err := verifyUpdate(latest, reply.Update)
+	if err != nil {
+		return 0, xerrors.Errorf("verifying update chain: %v", err)
+	}
+	for _, sb := range blocks {
+		if err := c.trust(sb); err != nil {
+			return 0, xerrors.Errorf("storing block: %v", err)
+		}
+	}
+	log.Lvlf2("light client of %x trusts block %d", c.genesisID, c.Latest().Index)
+	return len(blocks), nil
+}
+
+// verifyUpdate verifies that the blocks follow the trusted block with valid
+// forward links and returns the ones after it.
+func verifyUpdate(trusted *skipchain.SkipBlock, update []*skipchain.SkipBlock) ([]*skipchain.SkipBlock, error) {
+	if len(update) == 0 || !update[0].Hash.Equal(trusted.Hash) {
+		return nil, xerrors.New("update doesn't start from the trusted block")
+	}
+	prev := update[0]
+	// The roster comes from the trusted header because the hash doesn't
+	// cover the forward links.
+	roster := trusted.Roster
+	scheme := trusted.SignatureScheme
+	for _, sb := range update[1:] {
+		if !sb.Hash.Equal(sb.CalculateHash()) {
+			return nil, xerrors.Errorf("wrong hash of block %d", sb.Index)
+		}
+		if sb.Index <= prev.Index {
+			return nil, xerrors.Errorf("block %d doesn't follow block %d", sb.Index, prev.Index)
+		}
+		var link *skipchain.ForwardLink
+		for _, fl := range prev.ForwardLink {
+			if fl.To.Equal(sb.Hash) {
+				link = fl
+			}
+		}
+		if link == nil || !link.From.Equal(prev.Hash) {
+			return nil, xerrors.Errorf("missing forward link to block %d", sb.Index)
+		}
+		publics := roster.ServicePublics(skipchain.ServiceName)
+		if err := link.VerifyWithScheme(suite, publics, scheme); err != nil {
+			return nil, xerrors.Errorf("invalid forward link to block %d: %v", sb.Index, err)
+		}
+		prev = sb
+		roster = sb.Roster
+		scheme = sb.SignatureScheme
+	}
+	return update[1:], nil
+}
+
+// Verify verifies that the proof is valid for the state of its latest block.
+// It doesn't know which key the caller asked for: the caller must check that
+// p.InclusionProof is about that key, with Exists or Match. The proof must
+// start from a trusted block, so that only the forward links created since
+// then are needed; proofs from the genesis block are always accepted. The
+// latest block of the proof becomes trusted if it is newer than the latest
+// trusted one.
+func (c *Client) Verify(p *byzcoin.Proof) error {
+	if len(p.Links) == 0 {
+		return xerrors.Errorf("verifying proof: %w", byzcoin.ErrorMissingForwardLinks)
+	}
+	from, err := c.GetBlock(p.Links[0].To)
+	if err != nil {
+		return xerrors.Errorf("reading block: %v", err)
+	}
+	if from == nil {
+		return ErrUnknownBlock
+	}
+	if err := p.VerifyFromBlock(from); err != nil {
+		return xerrors.Errorf("verifying proof: %w", err)
+	}
+
+	latest := p.Latest.Copy()
+	if !latest.Hash.Equal(latest.CalculateHash()) {
+		return xerrors.New("wrong hash of the latest block")
+	}
+	if latest.Index > c.Latest().Index {
+		// The links of the proof end at this block, so it is trusted.
+		if err := c.trust(latest); err != nil {
+			return xerrors.Errorf("storing block: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetProof asks the roster of the latest trusted block for a proof of the
+// key, starting from that block, and verifies it. The proof is checked to be
+// about the key, but it can be an absence proof.
+func (c *Client) GetProof(key []byte) (*byzcoin.Proof, error) {
+	latest := c.Latest()
+	cl := byzcoin.NewClient(c.genesisID, *latest.Roster)
+	reply, err := cl.GetProofFrom(key, latest)
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	if err := c.Verify(&reply.Proof); err != nil {
+		return nil, xerrors.Errorf("verifying proof: %w", err)
+	}
+	if _, err := reply.Proof.InclusionProof.Exists(key); err != nil {
+		return nil, xerrors.Errorf("proof is not about the key: %v", err)
+	}
+	return &reply.Proof, nil
+}
+
+// trust stores the header of a verified block and makes it the latest block
+// if it is newer.
+func (c *Client) trust(sb *skipchain.SkipBlock) error {
+	c.Lock()
+	defer c.Unlock()
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		if err := storeBlock(tx, sb); err != nil {
+			return err
+		}
+		if sb.Index > c.latest.Index {
+			return tx.Bucket(bucketMeta).Put(keyLatest, sb.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if sb.Index > c.latest.Index {
+		c.latest = header(sb)
+	}
+	return nil
+}
+
+// header returns a copy of the block without the payload and the forward
+// links, which are not covered by its hash.
+func header(sb *skipchain.SkipBlock) *skipchain.SkipBlock {
+	h := sb.Copy()
+	h.Payload = nil
+	h.ForwardLink = nil
+	return h
+}
+
+func storeBlock(tx *bbolt.Tx, sb *skipchain.SkipBlock) error {
+	buf, err := network.Marshal(header(sb))
+	if err != nil {
+		return xerrors.Errorf("encoding block: %v", err)
+	}
+	if err := tx.Bucket(bucketBlocks).Put(sb.Hash, buf); err != nil {
+		return err
+	}
+	if tx.Bucket(bucketMeta).Get(keyLatest) == nil {
+		if err := tx.Bucket(bucketMeta).Put(keyLatest, sb.Hash); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(bucketIndexes).Put(indexKey(sb.Index), sb.Hash)
+}
+
+func getBlock(tx *bbolt.Tx, id []byte) (*skipchain.SkipBlock, error) {
+	if id == nil {
+		return nil, nil
+	}
+	val := tx.Bucket(bucketBlocks).Get(id)
+	if val == nil {
+		return nil, nil
+	}
+	// The value is only valid during the transaction.
+	buf := append([]byte{}, val...)
+	_, msg, err := network.Unmarshal(buf, suite)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding block: %v", err)
+	}
+	sb, ok := msg.(*skipchain.SkipBlock)
+	if !ok {
+		return nil, xerrors.New("stored value is not a block")
+	}
+	// Only the headers are stored, the decoding gives an empty payload.
+	sb.Payload = nil
+	return sb, nil
+}
+
+func indexKey(index int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(index))
+	return key
+}
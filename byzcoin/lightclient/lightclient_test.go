@@ -0,0 +1,100 @@
+package lightclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestClient(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + contracts.ContractValueID}, signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	cl, csr, err := byzcoin.NewLedger(msg, false)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "lightclient")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "headers.db")
+
+	lc, err := Open(path, csr.Skipblock)
+	require.NoError(t, err)
+	require.Equal(t, 0, lc.Latest().Index)
+
+	var ids []byzcoin.InstanceID
+	spawn := func(counter uint64) {
+		ctx, err := cl.CreateTransaction(byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(msg.GenesisDarc.GetBaseID()),
+			Spawn: &byzcoin.Spawn{
+				ContractID: contracts.ContractValueID,
+				Args:       byzcoin.Arguments{{Name: "value", Value: []byte{byte(counter)}}},
+			},
+			SignerCounter: []uint64{counter},
+		})
+		require.NoError(t, err)
+		require.NoError(t, ctx.FillSignersAndSignWith(signer))
+		_, err = cl.AddTransactionAndWait(ctx, 10)
+		require.NoError(t, err)
+		ids = append(ids, ctx.Instructions[0].DeriveID(""))
+	}
+	spawn(1)
+	spawn(2)
+
+	n, err := lc.Update()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, 2, lc.Latest().Index)
+	sb, err := lc.GetBlockByIndex(1)
+	require.NoError(t, err)
+	require.NotNil(t, sb)
+	require.Nil(t, sb.Payload)
+
+	// The proof only holds the links since the latest trusted block.
+	spawn(3)
+	p, err := lc.GetProof(ids[2].Slice())
+	require.NoError(t, err)
+	require.Len(t, p.Links, 2)
+	require.True(t, p.InclusionProof.Match(ids[2].Slice()))
+	require.Equal(t, 3, lc.Latest().Index)
+
+	// The trusted blocks survive a restart.
+	require.NoError(t, lc.Close())
+	lc, err = Open(path, csr.Skipblock)
+	require.NoError(t, err)
+	require.Equal(t, 3, lc.Latest().Index)
+	n, err = lc.Update()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// A proof from the genesis block is accepted but a proof from a block
+	// that is not trusted is refused.
+	rep, err := cl.GetProof(ids[0].Slice())
+	require.NoError(t, err)
+	require.NoError(t, lc.Verify(&rep.Proof))
+	rep.Proof.Links[0].To = ids[0].Slice()
+	require.Equal(t, ErrUnknownBlock, lc.Verify(&rep.Proof))
+
+	// The store only holds one chain.
+	require.NoError(t, lc.Close())
+	other := csr.Skipblock.Copy()
+	other.Data = []byte("other")
+	other.Hash = other.CalculateHash()
+	_, err = Open(path, other)
+	require.Error(t, err)
+}
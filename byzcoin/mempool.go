@@ -0,0 +1,489 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultMaxBufferSize is the maximum number of transactions the mempool
+// holds for one chain.
+const defaultMaxBufferSize = 1000
+
+// maxMempoolCopies is the maximum number of copies of a transaction with
+// different signatures that the mempool holds.
+const maxMempoolCopies = 2
+
+// defaultMempoolTTL is how long a transaction stays in the mempool before it
+// is dropped, if it never became ready to be included in a block.
+const defaultMempoolTTL = 10 * time.Minute
+
+// FeeFunc returns the fee a transaction pays to be included in a block.
+// ByzCoin itself doesn't charge for transactions, so the default fee is 0 and
+// the mempool is first-come first-served. A node that wants to give priority
+// to some transactions can set its own function with Service.SetFeeFunc.
+type FeeFunc func(tx ClientTransaction) uint64
+
+// signerRange holds the first and the last counter a transaction uses for one
+// signer. An instruction increments the counter of its signers, so the
+// following instructions of the same transaction need the next counter.
+type signerRange struct {
+	id          string
+	first, last uint64
+}
+
+// mempoolKey identifies a transaction in the mempool. It covers the
+// signatures, so that a copy of a transaction with invalid signatures doesn't
+// prevent the valid one from being added.
+func mempoolKey(tx ClientTransaction) string {
+	h := sha256.New()
+	h.Write(tx.Hash())
+	h.Write(tx.Instructions.HashWithSignatures())
+	return string(h.Sum(nil))
+}
+
+// verifySignatures returns an error if one of the signatures of tx doesn't
+// verify against its identity. It doesn't need the global state, so it
+// cannot tell whether the signers are allowed to send the transaction.
+func verifySignatures(tx ClientTransaction) error {
+	msg := tx.Hash()
+	for i, instr := range tx.Instructions {
+		if len(instr.SignerIdentities) != len(instr.Signatures) {
+			return xerrors.Errorf("instruction %d: length of identities does "+
+				"not match the length of signatures", i)
+		}
+		for j, id := range instr.SignerIdentities {
+			if err := id.Verify(msg, instr.Signatures[j]); err != nil {
+				return xerrors.Errorf("instruction %d, signature %d: %v", i, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mempoolEntry is one transaction waiting in the mempool.
+type mempoolEntry struct {
+	tx ClientTransaction
+	// hash is the key of the entry, given by mempoolKey.
+	hash string
+	// txHash is the hash of the transaction, shared by all its copies.
+	txHash   string
+	received time.Time
+	fee      uint64
+	size     int
+	// seq is the order of arrival, used to break ties between entries with
+	// the same priority.
+	seq     uint64
+	signers []signerRange
+}
+
+// before returns true if e has a higher priority than other: it pays a
+// higher fee per byte, or has the same fee per byte and arrived earlier.
+func (e *mempoolEntry) before(other *mempoolEntry) bool {
+	// Compare e.fee / e.size with other.fee / other.size without losing
+	// precision.
+	hi1, lo1 := bits.Mul64(e.fee, uint64(other.size))
+	hi2, lo2 := bits.Mul64(other.fee, uint64(e.size))
+	if hi1 != hi2 {
+		return hi1 > hi2
+	}
+	if lo1 != lo2 {
+		return lo1 > lo2
+	}
+	return e.seq < other.seq
+}
+
+func newMempoolEntry(tx ClientTransaction, fee uint64, seq uint64, now time.Time) *mempoolEntry {
+	e := &mempoolEntry{
+		tx:       tx,
+		hash:     mempoolKey(tx),
+		txHash:   string(tx.Hash()),
+		received: now,
+		fee:      fee,
+		size:     txSize(TxResult{ClientTransaction: tx}),
+		seq:      seq,
+	}
+	index := make(map[string]int)
+	for _, instr := range tx.Instructions {
+		for i, id := range instr.SignerIdentities {
+			if i >= len(instr.SignerCounter) {
+				break
+			}
+			idStr := id.String()
+			ctr := instr.SignerCounter[i]
+			if pos, ok := index[idStr]; ok {
+				e.signers[pos].last = ctr
+				continue
+			}
+			index[idStr] = len(e.signers)
+			e.signers = append(e.signers, signerRange{idStr, ctr, ctr})
+		}
+	}
+	return e
+}
+
+// chainPool holds the pending transactions of one chain.
+type chainPool struct {
+	entries map[string]*mempoolEntry
+	seq     uint64
+	// restored holds when the restored transactions were first restored,
+	// so that they still expire.
+	restored map[string]time.Time
+}
+
+// sorted returns all entries, the highest priority first.
+func (p *chainPool) sorted() []*mempoolEntry {
+	out := make([]*mempoolEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].before(out[j])
+	})
+	return out
+}
+
+// mempool is a thread-safe data structure that stores the client
+// transactions of all chains until they are included in a block. It drops
+// duplicate transactions, but keeps up to maxMempoolCopies copies of a
+// transaction with other signatures, as it cannot know which ones are valid
+// without the global state. It keeps at most
+// maxSize transactions per chain and forgets transactions after ttl.
+// Transactions are handed out with the
+// highest fee first, but always in the order of the counters of their
+// signers, so that a transaction using a future counter doesn't end up in a
+// block where it would fail.
+type mempool struct {
+	sync.Mutex
+	pools   map[string]*chainPool
+	maxSize int
+	ttl     time.Duration
+	fee     FeeFunc
+	// now is replaced in the tests.
+	now func() time.Time
+}
+
+func newMempool() *mempool {
+	return &mempool{
+		pools:   make(map[string]*chainPool),
+		maxSize: defaultMaxBufferSize,
+		ttl:     defaultMempoolTTL,
+		fee:     func(ClientTransaction) uint64 { return 0 },
+		now:     time.Now,
+	}
+}
+
+func (m *mempool) setFeeFunc(f FeeFunc) {
+	m.Lock()
+	defer m.Unlock()
+	m.fee = f
+}
+
+// feeOf returns the fee of tx, as the mempool ranks it.
+func (m *mempool) feeOf(tx ClientTransaction) uint64 {
+	m.Lock()
+	f := m.fee
+	m.Unlock()
+	return f(tx)
+}
+
+// purge removes the expired entries of the pool. The caller must hold the
+// lock.
+func (m *mempool) purge(p *chainPool) {
+	limit := m.now().Add(-m.ttl)
+	for h, e := range p.entries {
+		if e.received.Before(limit) {
+			delete(p.entries, h)
+		}
+	}
+	for h, received := range p.restored {
+		if received.Before(limit) {
+			delete(p.restored, h)
+		}
+	}
+}
+
+// add puts newTx in the mempool of the chain given by key. A transaction that
+// is already in the mempool is ignored. If the mempool already holds
+// maxMempoolCopies copies of the transaction, or if it is full, the
+// signatures of newTx must verify, so that copies with junk signatures cannot
+// push out other transactions. Then newTx replaces a copy whose signatures
+// don't verify, or else it is refused. If the mempool is full, the newTx
+// replaces the entry with the lowest priority, but only if it has a
+// strictly higher priority. Else it is refused: with equal priorities we
+// cannot drop earlier transactions because an attacker could send multiple
+// ones to replace legit transactions.
+func (m *mempool) add(key string, newTx ClientTransaction) error {
+	m.Lock()
+	defer m.Unlock()
+
+	p, ok := m.pools[key]
+	if !ok {
+		p = &chainPool{entries: make(map[string]*mempoolEntry)}
+		m.pools[key] = p
+	}
+	m.purge(p)
+
+	p.seq++
+	e := newMempoolEntry(newTx, m.fee(newTx), p.seq, m.now())
+	if _, ok := p.entries[e.hash]; ok {
+		return nil
+	}
+
+	var copies []*mempoolEntry
+	for _, other := range p.entries {
+		if other.txHash == e.txHash {
+			copies = append(copies, other)
+		}
+	}
+	if len(copies) >= maxMempoolCopies || len(p.entries) >= m.maxSize {
+		if err := verifySignatures(newTx); err != nil {
+			return xerrors.Errorf("mempool is full for this transaction and "+
+				"its signatures don't verify: %v", err)
+		}
+	}
+	if len(copies) >= maxMempoolCopies {
+		var invalid *mempoolEntry
+		for _, c := range copies {
+			if verifySignatures(c.tx) != nil {
+				invalid = c
+				break
+			}
+		}
+		if invalid == nil {
+			return xerrors.New("too many copies of this transaction")
+		}
+		delete(p.entries, invalid.hash)
+	}
+
+	if len(p.entries) >= m.maxSize {
+		var lowest *mempoolEntry
+		for _, other := range p.entries {
+			if lowest == nil || lowest.before(other) {
+				lowest = other
+			}
+		}
+		if lowest == nil || !e.before(lowest) {
+			return xerrors.New("mempool is full")
+		}
+		delete(p.entries, lowest.hash)
+	}
+	p.entries[e.hash] = e
+	return nil
+}
+
+// restore puts back a transaction that has been taken from the mempool of
+// the chain given by key but has to wait for a later block. Unlike add, it
+// doesn't apply the limits on the copies and on the size, as the transaction
+// had already been accepted, and it ignores a transaction that is already in
+// the mempool. A transaction that is restored again and again expires like
+// the others, counting from the first time it was restored.
+func (m *mempool) restore(key string, tx ClientTransaction) {
+	m.Lock()
+	defer m.Unlock()
+
+	p, ok := m.pools[key]
+	if !ok {
+		p = &chainPool{entries: make(map[string]*mempoolEntry)}
+		m.pools[key] = p
+	}
+	if p.restored == nil {
+		p.restored = make(map[string]time.Time)
+	}
+	m.purge(p)
+
+	p.seq++
+	e := newMempoolEntry(tx, m.fee(tx), p.seq, m.now())
+	if _, ok := p.entries[e.hash]; ok {
+		return
+	}
+	if received, ok := p.restored[e.hash]; ok {
+		e.received = received
+	} else {
+		p.restored[e.hash] = e.received
+	}
+	p.entries[e.hash] = e
+}
+
+// poolState is what the mempool needs to know about the global state to
+// decide which transactions can go in the next block.
+type poolState struct {
+	// counter returns the current counter of a signer.
+	counter func(id string) (uint64, error)
+	// index and timestamp of the next block.
+	index     int
+	timestamp int64
+}
+
+// take removes up to max transactions from the mempool of the chain given by
+// key and returns them, the highest priority first. If max is negative, all
+// ready transactions are returned. A transaction is ready if, for each of its
+// signers, its first counter follows the counter in the global state or the
+// last counter of a transaction taken before, and if the next block is
+// inside its validity window. Transactions with counters that have already
+// been used are returned too, so that the block refuses them and their
+// senders learn about it. Expired transactions are dropped, while
+// transactions with future counters or whose window didn't start yet stay in
+// the mempool. So once a copy of a transaction is taken, the other copies
+// wait: they are removed by removeIncluded when the block includes the copy,
+// or taken for the next block if the copy is refused.
+func (m *mempool) take(key string, max int, st poolState) []ClientTransaction {
+	m.Lock()
+	defer m.Unlock()
+
+	ret := []ClientTransaction{}
+	p, ok := m.pools[key]
+	if !ok {
+		return ret
+	}
+	m.purge(p)
+
+	// stateNext is the next counter following the global state, next is the
+	// next counter following the transactions already taken.
+	stateNext := make(map[string]uint64)
+	next := make(map[string]uint64)
+	getNext := func(id string) (uint64, bool) {
+		if n, ok := next[id]; ok {
+			return n, true
+		}
+		c, err := st.counter(id)
+		if err != nil {
+			// Let the block creation decide about this transaction.
+			return 0, false
+		}
+		stateNext[id] = c + 1
+		next[id] = c + 1
+		return c + 1, true
+	}
+
+	const (
+		ready = iota
+		stale
+		expired
+		future
+	)
+	status := func(e *mempoolEntry) int {
+		if e.tx.expired(st.index, st.timestamp) {
+			return expired
+		}
+		if e.tx.NotBefore != nil && e.tx.NotBefore.after(st.index, st.timestamp) {
+			return future
+		}
+		for _, sr := range e.signers {
+			n, ok := getNext(sr.id)
+			if !ok {
+				continue
+			}
+			if sr.first < stateNext[sr.id] {
+				return stale
+			}
+			if sr.first != n {
+				return future
+			}
+		}
+		return ready
+	}
+
+	entries := p.sorted()
+	// Taking a transaction can make other transactions of the same signers
+	// ready, so we go through the entries until nothing changes.
+	for progress := true; progress; {
+		progress = false
+		for _, e := range entries {
+			if max >= 0 && len(ret) >= max {
+				break
+			}
+			if _, ok := p.entries[e.hash]; !ok {
+				continue
+			}
+			switch status(e) {
+			case expired:
+				delete(p.entries, e.hash)
+			case stale:
+				ret = append(ret, e.tx)
+				delete(p.entries, e.hash)
+			case ready:
+				ret = append(ret, e.tx)
+				delete(p.entries, e.hash)
+				for _, sr := range e.signers {
+					if _, ok := next[sr.id]; ok {
+						next[sr.id] = sr.last + 1
+					}
+				}
+				progress = true
+			}
+		}
+	}
+
+	if len(p.entries) == 0 {
+		delete(m.pools, key)
+	}
+	return ret
+}
+
+// removeIncluded removes the transactions of the chain given by key that the
+// block accepted, with all their copies, as their counters are used now. The
+// copies of the refused transactions stay, as one of them might be valid.
+func (m *mempool) removeIncluded(key string, txs TxResults) {
+	m.Lock()
+	defer m.Unlock()
+
+	p, ok := m.pools[key]
+	if !ok {
+		return
+	}
+	included := make(map[string]bool)
+	for _, tx := range txs {
+		if tx.Accepted {
+			included[string(tx.ClientTransaction.Hash())] = true
+		}
+	}
+	for h, e := range p.entries {
+		if included[e.txHash] {
+			delete(p.entries, h)
+		}
+	}
+	if len(p.entries) == 0 {
+		delete(m.pools, key)
+	}
+}
+
+// entries returns a copy of the pending transactions of the chain given by
+// key, the highest priority first.
+func (m *mempool) entries(key string) []MempoolEntry {
+	m.Lock()
+	defer m.Unlock()
+
+	p, ok := m.pools[key]
+	if !ok {
+		return nil
+	}
+	m.purge(p)
+	var out []MempoolEntry
+	for _, e := range p.sorted() {
+		out = append(out, MempoolEntry{
+			Transaction: e.tx,
+			Received:    e.received.UnixNano(),
+			Fee:         e.fee,
+			Size:        e.size,
+		})
+	}
+	return out
+}
+
+// sizes returns the number of pending transactions of each chain.
+func (m *mempool) sizes() map[string]int {
+	m.Lock()
+	defer m.Unlock()
+
+	out := make(map[string]int)
+	for key, p := range m.pools {
+		m.purge(p)
+		out[key] = len(p.entries)
+	}
+	return out
+}
@@ -0,0 +1,338 @@
+package byzcoin
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+// newMempoolTx returns a transaction with a unique instruction and, if
+// signer is given, the counter for the signer.
+func newMempoolTx(i int, signer *darc.Signer, counter uint64) ClientTransaction {
+	instr := Instruction{
+		InstanceID: NewInstanceID([]byte{byte(i), byte(i >> 8)}),
+		Invoke:     &Invoke{ContractID: "mempool"},
+	}
+	if signer != nil {
+		instr.SignerIdentities = []darc.Identity{signer.Identity()}
+		instr.SignerCounter = []uint64{counter}
+	}
+	return ClientTransaction{Instructions: Instructions{instr}}
+}
+
+var noCounters = poolState{
+	counter: func(string) (uint64, error) {
+		return 0, nil
+	},
+}
+
+func TestMempool_Add(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+	key2 := "abcd"
+
+	for i := 0; i < defaultMaxBufferSize*2; i++ {
+		m.add(key, newMempoolTx(i, nil, 0))
+		m.add(key2, newMempoolTx(i, nil, 0))
+	}
+
+	require.Equal(t, defaultMaxBufferSize, len(m.pools[key].entries))
+	require.Equal(t, defaultMaxBufferSize, len(m.pools[key2].entries))
+	// The first transactions are kept.
+	require.Equal(t, newMempoolTx(0, nil, 0), m.entries(key)[0].Transaction)
+	require.Error(t, m.add(key, newMempoolTx(defaultMaxBufferSize*2, nil, 0)))
+
+	// Duplicates are ignored.
+	m = newMempool()
+	require.NoError(t, m.add(key, newMempoolTx(1, nil, 0)))
+	require.NoError(t, m.add(key, newMempoolTx(1, nil, 0)))
+	require.Equal(t, 1, len(m.entries(key)))
+}
+
+func TestMempool_Restore(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+
+	for i := 0; i < defaultMaxBufferSize; i++ {
+		require.NoError(t, m.add(key, newMempoolTx(i, nil, 0)))
+	}
+	txs := m.take(key, 1, noCounters)
+	require.Equal(t, 1, len(txs))
+	require.NoError(t, m.add(key, newMempoolTx(defaultMaxBufferSize, nil, 0)))
+
+	// A transaction taken out goes back even if the mempool is full, and
+	// only once.
+	m.restore(key, txs[0])
+	m.restore(key, txs[0])
+	require.Equal(t, defaultMaxBufferSize+1, len(m.entries(key)))
+
+	m.restore("other", txs[0])
+	require.Equal(t, 1, len(m.entries("other")))
+
+	// A transaction restored after every block still expires.
+	m = newMempool()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	tx := newMempoolTx(1, nil, 0)
+	m.restore(key, tx)
+	for i := 0; i < 3; i++ {
+		now = now.Add(defaultMempoolTTL/2 + time.Second)
+		txs = m.take(key, -1, noCounters)
+		if i >= 1 {
+			require.Empty(t, txs)
+			continue
+		}
+		require.Equal(t, []ClientTransaction{tx}, txs)
+		m.restore(key, tx)
+	}
+}
+
+func TestMempool_Take(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+
+	for i := 0; i < 100; i++ {
+		m.add(key, newMempoolTx(i, nil, 0))
+	}
+
+	txs := m.take(key, 12, noCounters)
+	require.Equal(t, 12, len(txs))
+	require.Equal(t, 88, len(m.pools[key].entries))
+	// First come, first served.
+	for i, tx := range txs {
+		require.Equal(t, newMempoolTx(i, nil, 0), tx)
+	}
+
+	txs = m.take(key, 100, noCounters)
+	require.Equal(t, 88, len(txs))
+	_, ok := m.pools[key]
+	require.False(t, ok)
+
+	txs = m.take(key, 100, noCounters)
+	require.Equal(t, 0, len(txs))
+}
+
+func TestMempool_TakeDisabled(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+
+	for i := 0; i < 10; i++ {
+		m.add(key, newMempoolTx(i, nil, 0))
+	}
+
+	txs := m.take(key, -1, noCounters)
+	require.Equal(t, 10, len(txs))
+	_, ok := m.pools[key]
+	require.False(t, ok)
+}
+
+func TestMempool_Fee(t *testing.T) {
+	m := newMempool()
+	m.maxSize = 3
+	key := "abc"
+	// The fee is the first byte of the instance ID.
+	m.setFeeFunc(func(tx ClientTransaction) uint64 {
+		return uint64(tx.Instructions[0].InstanceID[0])
+	})
+
+	require.NoError(t, m.add(key, newMempoolTx(1, nil, 0)))
+	require.NoError(t, m.add(key, newMempoolTx(3, nil, 0)))
+	require.NoError(t, m.add(key, newMempoolTx(2, nil, 0)))
+	// Full, and not better than the lowest one.
+	require.Error(t, m.add(key, newMempoolTx(1+256, nil, 0)))
+	// Full, but replaces the lowest one.
+	require.NoError(t, m.add(key, newMempoolTx(4, nil, 0)))
+
+	txs := m.take(key, -1, noCounters)
+	require.Equal(t, 3, len(txs))
+	require.Equal(t, newMempoolTx(4, nil, 0), txs[0])
+	require.Equal(t, newMempoolTx(3, nil, 0), txs[1])
+	require.Equal(t, newMempoolTx(2, nil, 0), txs[2])
+}
+
+func TestMempool_Counters(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+	signer := darc.NewSignerEd25519(nil, nil)
+	counters := map[string]uint64{signer.Identity().String(): 2}
+	counter := poolState{
+		counter: func(id string) (uint64, error) {
+			return counters[id], nil
+		},
+	}
+
+	// Arrive out of order, with one stale and one future transaction.
+	require.NoError(t, m.add(key, newMempoolTx(5, &signer, 5)))
+	require.NoError(t, m.add(key, newMempoolTx(4, &signer, 4)))
+	require.NoError(t, m.add(key, newMempoolTx(2, &signer, 2)))
+	require.NoError(t, m.add(key, newMempoolTx(3, &signer, 3)))
+	require.NoError(t, m.add(key, newMempoolTx(7, &signer, 7)))
+	// Another transaction with the same counter as the one before.
+	require.NoError(t, m.add(key, newMempoolTx(8, &signer, 3)))
+	require.NoError(t, m.add(key, newMempoolTx(9, nil, 0)))
+
+	// The stale transaction is taken so that the block refuses it.
+	txs := m.take(key, -1, counter)
+	require.Equal(t, 5, len(txs))
+	require.Equal(t, newMempoolTx(2, &signer, 2), txs[0])
+	require.Equal(t, newMempoolTx(3, &signer, 3), txs[1])
+	require.Equal(t, newMempoolTx(9, nil, 0), txs[2])
+	require.Equal(t, newMempoolTx(4, &signer, 4), txs[3])
+	require.Equal(t, newMempoolTx(5, &signer, 5), txs[4])
+
+	// The transaction with counter 7 waits for counter 6, and the second
+	// transaction with counter 3 is still valid if the first one fails.
+	require.Equal(t, 2, len(m.entries(key)))
+	require.Equal(t, 1, len(m.take(key, -1, counter)))
+	counters[signer.Identity().String()] = 5
+	require.Empty(t, m.take(key, -1, counter))
+	require.NoError(t, m.add(key, newMempoolTx(6, &signer, 6)))
+	txs = m.take(key, -1, counter)
+	require.Equal(t, 2, len(txs))
+	require.Equal(t, newMempoolTx(6, &signer, 6), txs[0])
+	require.Equal(t, newMempoolTx(7, &signer, 7), txs[1])
+}
+
+func TestMempool_Signatures(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+	signer := darc.NewSignerEd25519(nil, nil)
+	counters := map[string]uint64{signer.Identity().String(): 0}
+	counter := poolState{
+		counter: func(id string) (uint64, error) {
+			return counters[id], nil
+		},
+	}
+
+	// A copy with an invalid signature arrives first, it doesn't prevent
+	// the valid transaction from being added.
+	forged := newMempoolTx(1, &signer, 1)
+	forged.Instructions[0].Signatures = [][]byte{[]byte("invalid")}
+	valid := newMempoolTx(1, &signer, 1)
+	valid.Instructions[0].Signatures = [][]byte{[]byte("valid")}
+	require.NoError(t, m.add(key, forged))
+	require.NoError(t, m.add(key, valid))
+	require.NoError(t, m.add(key, valid))
+	require.Equal(t, 2, len(m.entries(key)))
+
+	// Only one copy goes in a block. If it is refused, the other copy is
+	// taken for the next block.
+	require.Equal(t, []ClientTransaction{forged}, m.take(key, -1, counter))
+	require.Equal(t, []ClientTransaction{valid}, m.take(key, -1, counter))
+
+	// Once a copy is included, the other one is removed.
+	require.NoError(t, m.add(key, forged))
+	require.NoError(t, m.add(key, valid))
+	require.Equal(t, []ClientTransaction{forged}, m.take(key, -1, counter))
+	m.removeIncluded(key, TxResults{{ClientTransaction: forged, Accepted: false}})
+	require.Equal(t, 1, len(m.entries(key)))
+	require.NoError(t, m.add(key, forged))
+	require.Equal(t, []ClientTransaction{valid}, m.take(key, -1, counter))
+	m.removeIncluded(key, TxResults{{ClientTransaction: valid, Accepted: true}})
+	require.Empty(t, m.entries(key))
+}
+
+func TestMempool_Copies(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+	signer := darc.NewSignerEd25519(nil, nil)
+	valid := newMempoolTx(1, &signer, 1)
+	valid.Instructions.SetVersion(CurrentVersion)
+	require.NoError(t, valid.SignWith(signer))
+
+	// Copies with junk signatures cannot fill the mempool.
+	for i := 0; i < defaultMaxBufferSize; i++ {
+		forged := valid
+		forged.Instructions = append(Instructions{}, valid.Instructions...)
+		forged.Instructions[0].Signatures = [][]byte{{byte(i), byte(i >> 8)}}
+		if i < maxMempoolCopies {
+			require.NoError(t, m.add(key, forged))
+		} else {
+			require.Error(t, m.add(key, forged))
+		}
+	}
+	require.Equal(t, maxMempoolCopies, len(m.entries(key)))
+	require.NoError(t, m.add(key, newMempoolTx(2, nil, 0)))
+
+	// The valid copy replaces one with junk signatures.
+	require.NoError(t, m.add(key, valid))
+	entries := m.entries(key)
+	require.Equal(t, maxMempoolCopies+1, len(entries))
+	found := false
+	for _, e := range entries {
+		if bytes.Equal(e.Transaction.Instructions.HashWithSignatures(),
+			valid.Instructions.HashWithSignatures()) {
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestMempool_TTL(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	require.NoError(t, m.add(key, newMempoolTx(1, nil, 0)))
+	now = now.Add(defaultMempoolTTL / 2)
+	require.NoError(t, m.add(key, newMempoolTx(2, nil, 0)))
+	require.Equal(t, 2, len(m.entries(key)))
+
+	now = now.Add(defaultMempoolTTL/2 + time.Second)
+	txs := m.take(key, -1, noCounters)
+	require.Equal(t, 1, len(txs))
+	require.Equal(t, newMempoolTx(2, nil, 0), txs[0])
+}
+
+func TestMempool_Window(t *testing.T) {
+	m := newMempool()
+	key := "abc"
+
+	tx := newMempoolTx(1, nil, 0)
+	tx.NotAfter = &TxBound{Index: 5}
+	require.NoError(t, m.add(key, tx))
+	tx = newMempoolTx(2, nil, 0)
+	tx.NotBefore = &TxBound{Index: 7}
+	require.NoError(t, m.add(key, tx))
+
+	st := noCounters
+	st.index = 6
+	require.Empty(t, m.take(key, -1, st))
+	// The expired transaction has been dropped.
+	require.Equal(t, 1, len(m.entries(key)))
+	st.index = 7
+	txs := m.take(key, -1, st)
+	require.Equal(t, 1, len(txs))
+	require.Equal(t, tx, txs[0])
+}
+
+func TestService_GetMempool(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// A transaction with a future counter must stay in the mempool.
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 2)
+	require.NoError(t, err)
+	s.sendTx(t, tx)
+	time.Sleep(2 * testInterval)
+
+	resp, err := s.service().GetMempool(&GetMempoolRequest{ByzCoinID: s.genesis.SkipChainID()})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Entries))
+	require.Equal(t, tx.Instructions.Hash(), resp.Entries[0].Transaction.Instructions.Hash())
+	require.NotZero(t, resp.Entries[0].Size)
+
+	// Once the missing counter arrives, both transactions get included.
+	tx1, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx1, 10)
+	s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+
+	resp, err = s.service().GetMempool(&GetMempoolRequest{ByzCoinID: s.genesis.SkipChainID()})
+	require.NoError(t, err)
+	require.Empty(t, resp.Entries)
+}
@@ -24,7 +24,7 @@ func init() {
 type Version int
 
 // CurrentVersion is what we're running now
-const CurrentVersion Version = VersionSpawnerCoins
+const CurrentVersion Version = VersionDelegation
 
 const (
 	// VersionInstructionHash is the first version and indicates that a new,
@@ -41,4 +41,22 @@ const (
 	// VersionSpawnerCoins indicates a fixed spawner contract that will treat
 	// the coins correctly
 	VersionSpawnerCoins = 6
+	// VersionTxValidity adds the NotBefore and NotAfter validity window to
+	// the transactions
+	VersionTxValidity = 7
+	// VersionEvents lets the contracts emit events, which are stored in the
+	// blocks together with their hash in the header
+	VersionEvents = 8
+	// VersionThreshold accepts the threshold expressions "[a, b, c]/m" in
+	// the rules of the darcs
+	VersionThreshold = 9
+	// VersionAttrInterpreters makes the standard attribute interpreters
+	// available to every contract
+	VersionAttrInterpreters = 10
+	// VersionWalletIdentities accepts the secp256k1 and webauthn identities
+	// as signers of the instructions
+	VersionWalletIdentities = 11
+	// VersionDelegation adds the delegation contract and the delegation
+	// identities in the darc expressions
+	VersionDelegation = 12
 )
@@ -0,0 +1,315 @@
+package byzcoin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// trieCountTTL is how long the number of entries of a state trie is cached,
+// as counting them reads the whole trie.
+const trieCountTTL = time.Minute
+
+// The classes of the errors that make a transaction being rejected.
+const (
+	// errClassWindow is for transactions outside of their validity window.
+	errClassWindow = "window"
+	// errClassContract is for instructions refused by their contract,
+	// including failed authorizations.
+	errClassContract = "contract"
+	// errClassCounter is for signer counters that cannot be updated.
+	errClassCounter = "counter"
+	// errClassState is for state changes that don't match the global state.
+	errClassState = "state"
+	// errClassEvent is for invalid events.
+	errClassEvent = "event"
+	// errClassStorage is for state changes that cannot be stored.
+	errClassStorage = "storage"
+	// errClassUnknown is for transactions whose error has not been seen by
+	// this node.
+	errClassUnknown = "unknown"
+)
+
+// histogram counts observations in cumulative buckets, as expected by
+// Prometheus.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name,
+			strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// serviceMetrics holds the metrics that are updated by the service. The
+// metrics that describe the current state, like the size of the mempool, are
+// read when they are requested.
+type serviceMetrics struct {
+	sync.Mutex
+	blockLatency *histogram
+	blockTxs     *histogram
+	rejected     map[string]uint64
+	viewChanges  uint64
+	catchUps     uint64
+	// trieCounts caches the number of entries of the state tries.
+	trieCounts map[string]trieCount
+}
+
+type trieCount struct {
+	nodes int
+	time  time.Time
+}
+
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{
+		blockLatency: newHistogram(0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60),
+		blockTxs:     newHistogram(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000),
+		rejected:     make(map[string]uint64),
+		trieCounts:   make(map[string]trieCount),
+	}
+}
+
+func (m *serviceMetrics) observeBlockCreation(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.blockLatency.observe(d.Seconds())
+}
+
+// observeBlock updates the metrics with the transactions of a new block. The
+// class of the error of a rejected transaction is given by class.
+func (m *serviceMetrics) observeBlock(txs TxResults, class func(tx TxResult) string) {
+	m.Lock()
+	defer m.Unlock()
+	m.blockTxs.observe(float64(len(txs)))
+	for _, tx := range txs {
+		if !tx.Accepted {
+			m.rejected[class(tx)]++
+			continue
+		}
+		for _, instr := range tx.ClientTransaction.Instructions {
+			if instr.Invoke != nil && instr.Invoke.ContractID == ContractConfigID &&
+				instr.Invoke.Command == "view_change" {
+				m.viewChanges++
+			}
+		}
+	}
+}
+
+func (m *serviceMetrics) incCatchUps() {
+	m.Lock()
+	defer m.Unlock()
+	m.catchUps++
+}
+
+func (m *serviceMetrics) write(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	m.blockLatency.write(w, "byzcoin_block_creation_seconds",
+		"Time to create a new block and have it stored by the roster.")
+	m.blockTxs.write(w, "byzcoin_block_transactions",
+		"Number of transactions, accepted or not, per block.")
+
+	fmt.Fprintf(w, "# HELP byzcoin_transactions_rejected_total Number of "+
+		"rejected transactions in the blocks, by class of error.\n"+
+		"# TYPE byzcoin_transactions_rejected_total counter\n")
+	var classes []string
+	for class := range m.rejected {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "byzcoin_transactions_rejected_total{class=\"%s\"} %d\n",
+			class, m.rejected[class])
+	}
+
+	fmt.Fprintf(w, "# HELP byzcoin_view_changes_total Number of view-changes "+
+		"in the blocks.\n# TYPE byzcoin_view_changes_total counter\n"+
+		"byzcoin_view_changes_total %d\n", m.viewChanges)
+	fmt.Fprintf(w, "# HELP byzcoin_catch_ups_total Number of times this node "+
+		"had to catch up.\n# TYPE byzcoin_catch_ups_total counter\n"+
+		"byzcoin_catch_ups_total %d\n", m.catchUps)
+}
+
+// addError stores the given error using the hash with signatures of the
+// given transaction as the key, together with the class of the error.
+func (s *Service) addError(tx ClientTransaction, class string, err error) {
+	key := tx.Instructions.HashWithSignatures()
+	s.txErrorBuf.add(key, err.Error())
+	s.txErrorClassBuf.add(key, class)
+}
+
+// errorClass returns the class of the error of a rejected transaction.
+func (s *Service) errorClass(tx TxResult) string {
+	class, ok := s.txErrorClassBuf.get(tx.ClientTransaction.Instructions.HashWithSignatures())
+	if !ok {
+		return errClassUnknown
+	}
+	return class
+}
+
+// writeMetrics writes all metrics in the text format of Prometheus.
+func (s *Service) writeMetrics(w io.Writer) {
+	s.metrics.write(w)
+
+	fmt.Fprintf(w, "# HELP byzcoin_mempool_transactions Number of "+
+		"transactions waiting in the mempool.\n"+
+		"# TYPE byzcoin_mempool_transactions gauge\n")
+	sizes := s.mempool.sizes()
+	var keys []string
+	for key := range sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "byzcoin_mempool_transactions{chain=\"%x\"} %d\n",
+			key, sizes[key])
+	}
+
+	fmt.Fprintf(w, "# HELP byzcoin_state_trie_nodes Number of entries in "+
+		"the database of the state trie.\n"+
+		"# TYPE byzcoin_state_trie_nodes gauge\n")
+	s.stateTriesLock.Lock()
+	var ids []string
+	for idStr := range s.stateTries {
+		ids = append(ids, idStr)
+	}
+	s.stateTriesLock.Unlock()
+	sort.Strings(ids)
+	for _, idStr := range ids {
+		nodes, err := s.countTrie(idStr)
+		if err != nil {
+			log.Error("couldn't read the size of the trie:", err)
+			continue
+		}
+		fmt.Fprintf(w, "byzcoin_state_trie_nodes{chain=\"%s\"} %d\n", idStr, nodes)
+	}
+
+	fmt.Fprintf(w, "# HELP byzcoin_state_change_storage_bytes Size of the "+
+		"stored state changes.\n# TYPE byzcoin_state_change_storage_bytes gauge\n"+
+		"byzcoin_state_change_storage_bytes %d\n", s.stateChangeStorage.getSize())
+}
+
+// countTrie returns the number of entries of the state trie of a chain. It is
+// only counted again once the cached number is older than trieCountTTL.
+func (s *Service) countTrie(idStr string) (int, error) {
+	s.metrics.Lock()
+	c, ok := s.metrics.trieCounts[idStr]
+	s.metrics.Unlock()
+	if ok && time.Since(c.time) < trieCountTTL {
+		return c.nodes, nil
+	}
+	nodes, err := s.trieStore.count(idStr)
+	if err != nil {
+		return 0, err
+	}
+	s.metrics.Lock()
+	s.metrics.trieCounts[idStr] = trieCount{nodes: nodes, time: time.Now()}
+	s.metrics.Unlock()
+	return nodes, nil
+}
+
+// MetricsHandler returns a handler serving the metrics of the service in the
+// text format of Prometheus.
+func (s *Service) MetricsHandler() http.Handler {
+	return metricsHandler([]*Service{s})
+}
+
+// metricsHandler returns a handler serving the metrics of the services. If
+// there are several, their samples are told apart by a node label.
+func metricsHandler(services []*Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if len(services) == 1 {
+			services[0].writeMetrics(&buf)
+		} else {
+			writeNodesMetrics(&buf, services)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+// writeNodesMetrics writes the metrics of several services with a node label
+// holding the address of their conode. The samples are grouped by metric, as
+// required by the text format of Prometheus.
+func writeNodesMetrics(w io.Writer, services []*Service) {
+	var names []string
+	headers := make(map[string][]string)
+	samples := make(map[string][]string)
+	for _, s := range services {
+		var buf bytes.Buffer
+		s.writeMetrics(&buf)
+		label := fmt.Sprintf("node=\"%s\"", s.ServerIdentity().Address)
+		var name string
+		var first bool
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "# HELP "):
+				name = strings.Fields(line)[2]
+				_, ok := headers[name]
+				first = !ok
+				if first {
+					names = append(names, name)
+				}
+				fallthrough
+			case strings.HasPrefix(line, "#"):
+				if first {
+					headers[name] = append(headers[name], line)
+				}
+			default:
+				samples[name] = append(samples[name], addMetricLabel(line, label))
+			}
+		}
+	}
+	for _, name := range names {
+		for _, line := range append(headers[name], samples[name]...) {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// addMetricLabel adds the label to the sample line.
+func addMetricLabel(line, label string) string {
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return line
+	}
+	if i := strings.IndexByte(line[:end], '{'); i >= 0 {
+		return line[:i+1] + label + "," + line[i+1:]
+	}
+	return line[:end] + "{" + label + "}" + line[end:]
+}
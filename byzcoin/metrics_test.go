@@ -0,0 +1,96 @@
+package byzcoin
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(1, 5)
+	h.observe(0.5)
+	h.observe(3)
+	h.observe(10)
+
+	var buf bytes.Buffer
+	h.write(&buf, "test", "A test.")
+	require.Equal(t, "# HELP test A test.\n# TYPE test histogram\n"+
+		"test_bucket{le=\"1\"} 1\n"+
+		"test_bucket{le=\"5\"} 2\n"+
+		"test_bucket{le=\"+Inf\"} 3\n"+
+		"test_sum 13.5\n"+
+		"test_count 3\n", buf.String())
+}
+
+func TestService_Metrics(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	_, _, resp, err, _ := sendTransaction(t, s, 0, invalidContract, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.Error)
+	// Waits for the leader to update its trie.
+	time.Sleep(testInterval)
+
+	srv := httptest.NewServer(s.service().MetricsHandler())
+	defer srv.Close()
+	r, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(t, err)
+	metrics := string(body)
+
+	require.Contains(t, metrics, "byzcoin_block_creation_seconds_count ")
+	require.Contains(t, metrics, "byzcoin_block_transactions_count ")
+	require.Contains(t, metrics, "byzcoin_transactions_rejected_total{class=\"contract\"} 1\n")
+	require.Contains(t, metrics, "byzcoin_view_changes_total 0\n")
+	require.Contains(t, metrics, "byzcoin_catch_ups_total 0\n")
+	require.Contains(t, metrics, "byzcoin_state_trie_nodes{chain=\"")
+	require.Contains(t, metrics, "byzcoin_state_change_storage_bytes ")
+}
+
+func TestService_SharedHTTPServer(t *testing.T) {
+	const addr = "127.0.0.1:0"
+	require.NoError(t, SetNodeConfig(NodeConfig{MetricsAddress: addr}))
+	defer SetNodeConfig(NodeConfig{})
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// All the nodes of the process share one server.
+	httpServers.Lock()
+	require.Equal(t, 1, len(httpServers.servers))
+	hs := httpServers.servers[addr]
+	require.Equal(t, len(s.services), len(hs.metrics))
+	url := "http://" + hs.addr.String()
+	httpServers.Unlock()
+
+	r, err := http.Get(url + "/metrics")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	require.NoError(t, err)
+	for _, srv := range s.services {
+		require.Contains(t, string(body), fmt.Sprintf(
+			"byzcoin_catch_ups_total{node=\"%s\"} 0\n", srv.ServerIdentity().Address))
+	}
+	require.Equal(t, 1, strings.Count(string(body), "# TYPE byzcoin_catch_ups_total "))
+
+	// The server is closed with the last node.
+	for _, srv := range s.services {
+		srv.TestClose()
+	}
+	httpServers.Lock()
+	require.Equal(t, 0, len(httpServers.servers))
+	httpServers.Unlock()
+	_, err = http.Get(url + "/metrics")
+	require.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package byzcoin
+
+import (
+	"sync"
+)
+
+// NodeConfig holds the settings of the ByzCoin services of a conode. Unlike
+// the configuration of a chain, they can differ between the nodes.
+type NodeConfig struct {
+	// SnapshotInterval is the number of blocks between two snapshots of the
+	// global state. 0 uses the default, and a negative value disables the
+	// snapshots.
+	SnapshotInterval int
+	// PruneDepth enables the pruning mode if it is bigger than 0: the
+	// payload of the blocks more than PruneDepth blocks behind the latest
+	// one is dropped once a snapshot covers them. With 0, the node keeps the
+	// payload of all blocks.
+	PruneDepth int
+	// StateRetention is the number of past global states, one per block,
+	// that the state tries created by the node keep. They are persistent
+	// tries, which don't delete the superseded nodes until their states get
+	// out of the retention window. With 0, the state tries only keep the
+	// current state.
+	StateRetention int
+	// StateTrieCacheSize is the size in bytes of the cache of decoded nodes
+	// of each state trie. 0 uses the default of 32 MiB, and a negative value
+	// disables the cache.
+	StateTrieCacheSize int
+	// MetricsAddress is the address on which the metrics are served over
+	// HTTP, on /metrics. If it is empty, the metrics are not served.
+	MetricsAddress string
+	// RESTAddress is the address on which the REST/JSON gateway is served.
+	// If it is empty, the gateway is not served. It can be the same as
+	// MetricsAddress.
+	RESTAddress string
+	// RESTAllowedOrigins are the origins allowed to call the REST gateway
+	// from a browser, or "*" for all of them.
+	RESTAllowedOrigins []string
+}
+
+var nodeConfig = struct {
+	sync.Mutex
+	config NodeConfig
+}{}
+
+// SetNodeConfig sets the configuration of the ByzCoin services created
+// afterwards. It is called by the conode before starting the services.
+func SetNodeConfig(config NodeConfig) error {
+	nodeConfig.Lock()
+	nodeConfig.config = config
+	nodeConfig.Unlock()
+	return nil
+}
+
+func getNodeConfig() NodeConfig {
+	nodeConfig.Lock()
+	defer nodeConfig.Unlock()
+	return nodeConfig.config
+}
@@ -0,0 +1,190 @@
+package byzcoin
+
+import (
+	"runtime"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// parallelMinTxs is the minimum number of transactions a block must have
+// before createStateChanges executes them concurrently. For smaller blocks
+// the overhead of cloning the staging tries is not worth it.
+var parallelMinTxs = 16
+
+// parallelWorkers is the number of go-routines used to execute the
+// transactions of one block.
+var parallelWorkers = runtime.NumCPU()
+
+// accessTracker records the keys that are read or written by one
+// transaction. It is shared between a staging trie and all its clones, so
+// that every access done by processOneTx is seen, whatever copy of the trie
+// is used. All methods can be called on a nil tracker, which does nothing.
+type accessTracker struct {
+	sync.Mutex
+	keys map[string]bool
+	// all is set if the transaction went through all the keys, e.g.,
+	// using ForEach.
+	all bool
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{keys: make(map[string]bool)}
+}
+
+func (a *accessTracker) read(key []byte) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	a.keys[string(key)] = true
+	a.Unlock()
+}
+
+func (a *accessTracker) readAll() {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	a.all = true
+	a.Unlock()
+}
+
+// write records the keys of the state changes, except for the events, which
+// don't modify the state.
+func (a *accessTracker) write(scs StateChanges) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	for _, sc := range scs {
+		if sc.StateAction != EmitEvent {
+			a.keys[string(sc.InstanceID)] = true
+		}
+	}
+	a.Unlock()
+}
+
+// conflicts returns true if any of the accessed keys is in the written set.
+func (a *accessTracker) conflicts(written map[string]bool) bool {
+	a.Lock()
+	defer a.Unlock()
+	if a.all {
+		return len(written) > 0
+	}
+	// Iterate over the smaller of the two maps.
+	if len(written) < len(a.keys) {
+		for k := range written {
+			if a.keys[k] {
+				return true
+			}
+		}
+		return false
+	}
+	for k := range a.keys {
+		if written[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// speculativeTx is the result of executing a transaction on the state at the
+// beginning of the block, independently of the other transactions.
+type speculativeTx struct {
+	scs StateChanges
+	// class is the class of err, which is only recorded once the result
+	// is used.
+	class   string
+	err     error
+	tracker *accessTracker
+}
+
+// speculateTxs executes all transactions concurrently, each one on its own
+// clone of sst, and records which keys each of them accessed. The errors are
+// not recorded, as the transactions might succeed when replayed.
+func (s *Service) speculateTxs(sst *stagingStateTrie, scID skipchain.SkipBlockID,
+	txIn TxResults, timestamp int64) []speculativeTx {
+	out := make([]speculativeTx, len(txIn))
+	next := make(chan int, len(txIn))
+	for i := range txIn {
+		next <- i
+	}
+	close(next)
+
+	workers := parallelWorkers
+	if workers > len(txIn) {
+		workers = len(txIn)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				base := sst.Clone()
+				base.tracker = newAccessTracker()
+				scs, _, _, class, err := s.runOneTx(base, txIn[i].ClientTransaction,
+					scID, timestamp)
+				base.tracker.write(scs)
+				out[i] = speculativeTx{scs: scs, class: class, err: err,
+					tracker: base.tracker}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return out
+}
+
+// createStateChangesParallel has the same outcome as executing all
+// transactions one after the other on sst, but first runs them concurrently
+// on the state at the beginning of the block. The speculative results are
+// then applied in the order of the block: a transaction whose accessed keys
+// have been written by an earlier transaction of the block is executed again
+// on the up-to-date state, all others use their speculative result. The state
+// changes are returned for each transaction, nil for the refused ones.
+func (s *Service) createStateChangesParallel(sst *stagingStateTrie, scID skipchain.SkipBlockID,
+	txIn TxResults, timestamp int64) (txOut TxResults, states []StateChanges, sstOut *stagingStateTrie) {
+	specs := s.speculateTxs(sst, scID, txIn, timestamp)
+
+	written := make(map[string]bool)
+	var replayed int
+	for i, tx := range txIn {
+		scs, err := specs[i].scs, specs[i].err
+		if specs[i].tracker.conflicts(written) {
+			replayed++
+			var sstTemp *stagingStateTrie
+			scs, sstTemp, err = s.processOneTx(sst, tx.ClientTransaction, scID, timestamp)
+			if err == nil {
+				sst = sstTemp
+			}
+		} else if err == nil {
+			err = sst.StoreAll(scs)
+		} else if specs[i].class != "" {
+			s.addError(tx.ClientTransaction, specs[i].class, err)
+		}
+
+		if err != nil {
+			tx.Accepted = false
+			txOut = append(txOut, tx)
+			states = append(states, nil)
+			log.Warnf("%s: %+v", s.ServerIdentity(), err)
+			continue
+		}
+
+		tx.Accepted = true
+		txOut = append(txOut, tx)
+		states = append(states, scs)
+		for _, sc := range scs {
+			if sc.StateAction != EmitEvent {
+				written[string(sc.InstanceID)] = true
+			}
+		}
+	}
+	log.Lvlf3("%s: executed %d transactions in parallel, %d had to be replayed",
+		s.ServerIdentity(), len(txIn), replayed)
+
+	return txOut, states, sst
+}
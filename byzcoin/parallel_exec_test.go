@@ -0,0 +1,146 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestAccessTracker(t *testing.T) {
+	var nilTracker *accessTracker
+	// Must not panic.
+	nilTracker.read([]byte("a"))
+	nilTracker.readAll()
+	nilTracker.write(StateChanges{{InstanceID: []byte("a")}})
+
+	at := newAccessTracker()
+	require.False(t, at.conflicts(map[string]bool{"a": true}))
+	at.read([]byte("a"))
+	require.True(t, at.conflicts(map[string]bool{"a": true}))
+	require.False(t, at.conflicts(map[string]bool{"b": true}))
+	at.write(StateChanges{{InstanceID: []byte("b")}})
+	require.True(t, at.conflicts(map[string]bool{"b": true, "c": true}))
+	require.False(t, at.conflicts(map[string]bool{}))
+	// Events are not state writes.
+	at.write(StateChanges{{StateAction: EmitEvent, InstanceID: []byte("d")}})
+	require.False(t, at.conflicts(map[string]bool{"d": true}))
+
+	at = newAccessTracker()
+	at.readAll()
+	require.True(t, at.conflicts(map[string]bool{"z": true}))
+	require.False(t, at.conflicts(map[string]bool{}))
+}
+
+// TestService_CreateStateChangesParallel makes sure that the parallel
+// execution of the transactions gives the same result as the sequential one.
+func TestService_CreateStateChangesParallel(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	// The counter contract increments the value of an instance, so that
+	// two invokes on the same instance depend on each other.
+	counter := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		val, _, cid, _, err := cdb.GetValues(inst.InstanceID.Slice())
+		if err != nil {
+			return nil, nil, err
+		}
+		if inst.GetType() != InvokeType {
+			return nil, nil, xerrors.New("can only invoke")
+		}
+		v := binary.LittleEndian.Uint64(val)
+		if inst.Invoke.Command == "fail_above" && v >= 2 {
+			return nil, nil, xerrors.New("value too big")
+		}
+		if inst.Invoke.Command == "fail_below" && v < 1 {
+			return nil, nil, xerrors.New("value too small")
+		}
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, v+1)
+		return []StateChange{
+			NewStateChange(Update, inst.InstanceID, cid, buf, nil),
+		}, nil, nil
+	}
+	for _, srv := range s.services {
+		require.NoError(t, srv.testRegisterContract("counter", adaptorNoVerify(counter)))
+	}
+
+	cdb, err := s.service().getStateTrie(s.genesis.SkipChainID())
+	require.NoError(t, err)
+
+	instances := make([]InstanceID, 8)
+	var scs StateChanges
+	for i := range instances {
+		instances[i] = genID()
+		scs = append(scs, NewStateChange(Create, instances[i], "counter",
+			make([]byte, 8), nil))
+	}
+	require.NoError(t, cdb.StoreAll(scs, 0, CurrentVersion))
+
+	var txs TxResults
+	for i := 0; i < 40; i++ {
+		cmd := ""
+		if i%5 == 0 {
+			cmd = "fail_above"
+		}
+		txs = append(txs, TxResult{ClientTransaction: ClientTransaction{
+			Instructions: Instructions{{
+				InstanceID: instances[i%3],
+				Invoke:     &Invoke{ContractID: "counter", Command: cmd},
+			}},
+		}})
+		txs = append(txs, TxResult{ClientTransaction: ClientTransaction{
+			Instructions: Instructions{{
+				InstanceID: instances[3+i%5],
+				Invoke:     &Invoke{ContractID: "counter"},
+			}},
+		}})
+	}
+	// One transaction on an instance that doesn't exist.
+	txs = append(txs, TxResult{ClientTransaction: ClientTransaction{
+		Instructions: Instructions{{
+			InstanceID: genID(),
+			Invoke:     &Invoke{ContractID: "counter"},
+		}},
+	}})
+
+	defer func(old int) { parallelMinTxs = old }(parallelMinTxs)
+	timestamp := time.Now().UnixNano()
+	scID := s.genesis.SkipChainID()
+
+	parallelMinTxs = len(txs) + 1
+	root1, txOut1, states1, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(),
+		scID, txs, noTimeout, CurrentVersion, timestamp)
+
+	// Clear the cache, else the second call returns the first result.
+	s.service().stateChangeCache = newStateChangeCache()
+	parallelMinTxs = 1
+	root2, txOut2, states2, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(),
+		scID, txs, noTimeout, CurrentVersion, timestamp)
+
+	require.Equal(t, len(txs), len(txOut2))
+	require.Equal(t, txOut1.Hash(), txOut2.Hash())
+	require.Equal(t, states1.Hash(), states2.Hash())
+	require.Equal(t, root1, root2)
+	require.False(t, txOut2[0].Accepted == txOut2[len(txOut2)-1].Accepted)
+
+	// The second transaction fails speculatively, but not when it is
+	// replayed after the first one: its error must not be recorded.
+	s.service().stateChangeCache = newStateChangeCache()
+	txs = TxResults{}
+	for _, cmd := range []string{"", "fail_below"} {
+		txs = append(txs, TxResult{ClientTransaction: ClientTransaction{
+			Instructions: Instructions{{
+				InstanceID: instances[0],
+				Invoke:     &Invoke{ContractID: "counter", Command: cmd},
+			}},
+		}})
+	}
+	_, txOut, _, _ := s.service().createStateChanges(cdb.MakeStagingStateTrie(),
+		scID, txs, noTimeout, CurrentVersion, timestamp)
+	require.True(t, txOut[1].Accepted)
+	_, ok := s.service().txErrorBuf.get(txs[1].ClientTransaction.Instructions.HashWithSignatures())
+	require.False(t, ok)
+}
@@ -26,16 +26,71 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
 	}
 	p.InclusionProof = *pr
+	latest, links, err := proofLinks(c.GetIndex(), s, id)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get links: %w", err)
+	}
+	p.Latest = *latest
+	p.Links = links
+	return
+}
+
+// newMultiProof creates a proof for all the keys in the skipchain with the
+// given id, like NewProof.
+func newMultiProof(st *stateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	keys [][]byte) (*MultiProof, error) {
+	pr, err := st.GetMultiProof(keys)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
+	}
+	latest, links, err := proofLinks(st.GetIndex(), s, id)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get links: %w", err)
+	}
+	return &MultiProof{
+		InclusionProof: *pr,
+		Latest:         *latest,
+		Links:          links,
+	}, nil
+}
+
+// newRangeProof creates a proof for all the keys under the binary prefix in
+// the skipchain with the given id, like NewProof.
+func newRangeProof(st *stateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	prefix []bool) (*RangeProof, error) {
+	pr, err := st.GetRange(prefix)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
+	}
+	if len(pr.Leaves) > maxRangeProofKeys {
+		return nil, xerrors.Errorf("too many keys under the prefix: %d > %d",
+			len(pr.Leaves), maxRangeProofKeys)
+	}
+	latest, links, err := proofLinks(st.GetIndex(), s, id)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get links: %w", err)
+	}
+	return &RangeProof{
+		InclusionProof: *pr,
+		Latest:         *latest,
+		Links:          links,
+	}, nil
+}
+
+// proofLinks returns the block at the given index and the forward links
+// that lead to it from the block with the given id.
+func proofLinks(index int, s *skipchain.SkipBlockDB, id skipchain.SkipBlockID) (
+	*skipchain.SkipBlock, []skipchain.ForwardLink, error) {
 	sb := s.GetByID(id)
 	if sb == nil {
-		return nil, xerrors.New("didn't find skipchain")
+		return nil, nil, xerrors.New("didn't find skipchain")
 	}
-	p.Links = []skipchain.ForwardLink{{
+	links := []skipchain.ForwardLink{{
 		From:      []byte{},
 		To:        id,
 		NewRoster: sb.Roster,
 	}}
-	for len(sb.ForwardLink) > 0 && sb.Index < c.GetIndex() {
+	for len(sb.ForwardLink) > 0 && sb.Index < index {
 		var link *skipchain.ForwardLink
 		// Corner-case when the database is downloading blocks and a proof is
 		// requested before all blocks are stored - then we need to make sure that
@@ -48,25 +103,24 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 				log.Warnf("Found block %d with invalid forward-link at level"+
 					" %d", sb.Index, height)
 				if height == 0 {
-					return nil, xerrors.New("missing block in chain")
+					return nil, nil, xerrors.New("missing block in chain")
 				}
 				continue
 			}
 			if sbTemp.Index <= sb.Index {
-				return nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
+				return nil, nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
 			}
-			if sbTemp.Index <= c.GetIndex() {
+			if sbTemp.Index <= index {
 				sb = sbTemp
 				break
 			}
 		}
-		p.Links = append(p.Links, *link)
+		links = append(links, *link)
 	}
-	if c.GetIndex() != sb.Index {
-		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+	if index != sb.Index {
+		return nil, nil, xerrors.New("didn't find skipblock with same index as state-trie")
 	}
-	p.Latest = *sb
-	return
+	return sb, links, nil
 }
 
 // ErrorVerifyTrie is returned if the proof itself is not properly set up.
@@ -125,19 +179,47 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 		return cothority.WrapError(err)
 	}
 
-	if len(p.Links) == 0 {
+	return verifyLinks(sbID, &p.Latest, p.Links)
+}
+
+// VerifyMany verifies the proof like Proof.Verify, and the proofs of other
+// keys that end at the same latest block, such as the proofs returned by
+// MultiProof.VerifyMany. The forward links are only verified once, with the
+// ones of p. As for Proof.Verify, it does not verify whether the keys exist
+// in the proofs, and the roster of the first link must be verified before.
+func (p Proof) VerifyMany(sbID skipchain.SkipBlockID, proofs []Proof) error {
+	if err := p.Verify(sbID); err != nil {
+		return err
+	}
+	latestID := p.Latest.CalculateHash()
+	for i := range proofs {
+		if !proofs[i].Latest.CalculateHash().Equal(latestID) {
+			return xerrors.Errorf("proof %d: %w", i, ErrorVerifyHash)
+		}
+		if err := proofs[i].VerifyInclusionProof(&proofs[i].Latest); err != nil {
+			return xerrors.Errorf("proof %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// verifyLinks verifies that the forward links lead from the block sbID to
+// the latest block. The first link is a synthetic link whose roster must be
+// verified by the caller.
+func verifyLinks(sbID skipchain.SkipBlockID, latest *skipchain.SkipBlock, links []skipchain.ForwardLink) error {
+	if len(links) == 0 {
 		return cothority.WrapError(ErrorMissingForwardLinks)
 	}
-	if p.Links[0].NewRoster == nil {
+	if links[0].NewRoster == nil {
 		return cothority.WrapError(ErrorMalformedForwardLink)
 	}
 
 	// Get the first from the synthetic link which is assumed to be verified
 	// before against the block with ID stored in the To field by the caller.
-	publics := p.Links[0].NewRoster.ServicePublics(skipchain.ServiceName)
+	publics := links[0].NewRoster.ServicePublics(skipchain.ServiceName)
 
-	for _, l := range p.Links[1:] {
-		if err = l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, p.Latest.SignatureScheme); err != nil {
+	for _, l := range links[1:] {
+		if err := l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, latest.SignatureScheme); err != nil {
 			return cothority.WrapError(ErrorVerifySkipchain)
 		}
 		if !l.From.Equal(sbID) {
@@ -150,7 +232,7 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 	}
 
 	// Check that the given latest block matches the last forward link target
-	if !p.Latest.CalculateHash().Equal(sbID) {
+	if !latest.CalculateHash().Equal(sbID) {
 		return cothority.WrapError(ErrorVerifyHash)
 	}
 
@@ -172,6 +254,110 @@ func (p Proof) VerifyInclusionProof(latest *skipchain.SkipBlock) error {
 	return nil
 }
 
+// VerifyManyFromBlock verifies the proof like Proof.VerifyFromBlock and
+// returns the proof of each key, in the same order.
+func (p MultiProof) VerifyManyFromBlock(verifiedBlock *skipchain.SkipBlock, keys [][]byte) ([]Proof, error) {
+	if len(p.Links) > 0 {
+		// As for Proof.VerifyFromBlock, the roster of the verified block is
+		// trusted.
+		p.Links[0].NewRoster = verifiedBlock.Roster
+	}
+
+	proofs, err := p.VerifyMany(verifiedBlock.Hash, keys)
+	return proofs, cothority.ErrorOrNil(err, "verification failed")
+}
+
+// VerifyMany verifies that the proof is valid for the skipchain, like
+// Proof.Verify, and returns the proof of each key, in the same order. The
+// links are only verified once and the returned proofs share them, so they
+// can be used with KeyValue, Get or VerifyAndDecode without being verified
+// again. The caller must still check whether each key is present.
+//
+// Notice: as for Proof.Verify, the roster of the first link must be verified
+// before. See MultiProof.VerifyManyFromBlock for example.
+func (p MultiProof) VerifyMany(sbID skipchain.SkipBlockID, keys [][]byte) ([]Proof, error) {
+	var header DataHeader
+	err := protobuf.Decode(p.Latest.Data, &header)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(p.InclusionProof.GetRoot(), header.TrieRoot) {
+		return nil, cothority.WrapError(ErrorVerifyTrieRoot)
+	}
+	if err := verifyLinks(sbID, &p.Latest, p.Links); err != nil {
+		return nil, cothority.WrapError(err)
+	}
+
+	inclusionProofs, err := p.InclusionProof.Proofs(keys)
+	if err != nil {
+		return nil, xerrors.Errorf("%w: %v", ErrorVerifyTrie, err)
+	}
+	proofs := make([]Proof, len(keys))
+	for i, ip := range inclusionProofs {
+		proofs[i] = Proof{
+			InclusionProof: ip,
+			Latest:         p.Latest,
+			Links:          p.Links,
+		}
+	}
+	return proofs, nil
+}
+
+// VerifyFromBlock verifies the proof like Proof.VerifyFromBlock and returns
+// the keys and the bodies stored under the prefix.
+func (p RangeProof) VerifyFromBlock(verifiedBlock *skipchain.SkipBlock, prefix []bool) ([][]byte, []StateChangeBody, error) {
+	if len(p.Links) > 0 {
+		// As for Proof.VerifyFromBlock, the roster of the verified block is
+		// trusted.
+		p.Links[0].NewRoster = verifiedBlock.Roster
+	}
+
+	keys, bodies, err := p.Verify(verifiedBlock.Hash, prefix)
+	return keys, bodies, cothority.ErrorOrNil(err, "verification failed")
+}
+
+// Verify verifies that the proof is valid for the skipchain, like
+// Proof.Verify, and that it holds the given prefix. It returns the keys and
+// the bodies stored under the prefix, in the order of the trie, which are all
+// the ones of the block.
+//
+// Notice: as for Proof.Verify, the roster of the first link must be verified
+// before. See RangeProof.VerifyFromBlock for example.
+func (p RangeProof) Verify(sbID skipchain.SkipBlockID, prefix []bool) ([][]byte, []StateChangeBody, error) {
+	if len(prefix) != len(p.InclusionProof.Prefix) {
+		return nil, nil, xerrors.Errorf("%w: wrong prefix", ErrorVerifyTrie)
+	}
+	for i := range prefix {
+		if prefix[i] != p.InclusionProof.Prefix[i] {
+			return nil, nil, xerrors.Errorf("%w: wrong prefix", ErrorVerifyTrie)
+		}
+	}
+	var header DataHeader
+	err := protobuf.Decode(p.Latest.Data, &header)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(p.InclusionProof.GetRoot(), header.TrieRoot) {
+		return nil, nil, cothority.WrapError(ErrorVerifyTrieRoot)
+	}
+	if err := verifyLinks(sbID, &p.Latest, p.Links); err != nil {
+		return nil, nil, cothority.WrapError(err)
+	}
+
+	keys, values, err := p.InclusionProof.KeyValues()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("%w: %v", ErrorVerifyTrie, err)
+	}
+	bodies := make([]StateChangeBody, len(values))
+	for i, v := range values {
+		bodies[i], err = decodeStateChangeBody(v)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("decoding body: %v", err)
+		}
+	}
+	return keys, bodies, nil
+}
+
 // KeyValue returns the key and the values stored in the proof. The caller
 // should check both the key and the value because it should not trust the
 // service to always return a key/value pair (via the proof) that corresponds
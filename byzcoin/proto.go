@@ -51,12 +51,29 @@ type DataHeader struct {
 	Timestamp int64
 	// Version is the version of ByzCoin at the creation of the block.
 	Version Version `protobuf:"opt"`
+	// EventsHash is the sha256 of all the Events emitted by the accepted
+	// transactions. It is empty if there are no events.
+	EventsHash []byte `protobuf:"opt"`
 }
 
 // DataBody is stored in the body of the skipblock, and it's hash is stored
 // in the DataHeader.
 type DataBody struct {
 	TxResults TxResults
+	// Events emitted by the accepted transactions, in the order of execution.
+	Events []Event `protobuf:"opt"`
+}
+
+// Event is emitted by a contract to tell the clients that something happened
+// on an instance. Events are not stored in the global state, only in the
+// blocks.
+type Event struct {
+	// InstanceID of the instance the event is about.
+	InstanceID InstanceID
+	// Name of the event, as chosen by the contract.
+	Name string
+	// Attributes hold the details of the event.
+	Attributes Arguments `protobuf:"opt"`
 }
 
 // ***
@@ -116,6 +133,38 @@ type AddTxResponse struct {
 	Proof *Proof `protobuf:"opt"`
 }
 
+// SimulateTransaction asks the service to run a transaction against the
+// latest state without adding it to the ledger.
+type SimulateTransaction struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+	// Transaction to be simulated
+	Transaction ClientTransaction
+}
+
+// SimulateTransactionResponse holds the outcome of running the transaction
+// on a copy of the latest state.
+type SimulateTransactionResponse struct {
+	// Version of the protocol
+	Version Version
+	// StateChanges that the transaction would create, including the signer
+	// counters.
+	StateChanges []StateChange
+	// Coins that are left over after the last instruction.
+	Coins []Coin
+	// Error describes why the transaction would be refused. It is empty if
+	// the transaction would be accepted.
+	Error string `protobuf:"opt"`
+	// Fee is the fee of the transaction, given by the fee function of the
+	// conode, which ranks the transactions of the mempool. It is 0 by
+	// default, as ByzCoin doesn't charge for transactions.
+	Fee uint64
+	// Index of the block the simulation has been run on.
+	Index int
+}
+
 // GetProof returns the proof that the given key is in the trie.
 type GetProof struct {
 	// Version of the protocol
@@ -140,6 +189,49 @@ type GetProofResponse struct {
 	Proof Proof
 }
 
+// GetMultiProof asks for the proofs of several keys at once.
+type GetMultiProof struct {
+	// Version of the protocol
+	Version Version
+	// Keys are the keys we want to look up
+	Keys [][]byte
+	// ID is any block that is known to us in the skipchain, can be the genesis
+	// block or any later block. The proof returned will be starting at this block.
+	ID skipchain.SkipBlockID
+}
+
+// GetMultiProofResponse can be used together with the Genesis block to proof
+// the presence or the absence of all the keys.
+type GetMultiProofResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proof contains everything necessary to prove the inclusion or the
+	// absence of the keys given a genesis skipblock.
+	Proof MultiProof
+}
+
+// GetRangeProof asks for the proof of all the keys whose hash starts with a
+// binary prefix.
+type GetRangeProof struct {
+	// Version of the protocol
+	Version Version
+	// Prefix is the binary prefix of the hashed keys, see trie.GetRange.
+	Prefix []bool
+	// ID is any block that is known to us in the skipchain, can be the genesis
+	// block or any later block. The proof returned will be starting at this block.
+	ID skipchain.SkipBlockID
+}
+
+// GetRangeProofResponse can be used together with the Genesis block to proof
+// that the returned key/value pairs are the only ones under the prefix.
+type GetRangeProofResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proof contains everything necessary to prove the key/value pairs
+	// under the prefix given a genesis skipblock.
+	Proof RangeProof
+}
+
 // CheckAuthorization returns the list of actions that could be executed if the
 // signatures of the given identities are present and valid
 type CheckAuthorization struct {
@@ -160,6 +252,31 @@ type CheckAuthorizationResponse struct {
 	Actions []darc.Action
 }
 
+// ExplainAuthorization asks for the evaluation of the rule of an action of a
+// darc, given the identities that sign together, to find out why it is
+// satisfied or not.
+type ExplainAuthorization struct {
+	// Version of the protocol
+	Version Version
+	// ByzCoinID where to look up the darc
+	ByzCoinID skipchain.SkipBlockID
+	// DarcID that holds the rule
+	DarcID darc.ID
+	// Action of the rule
+	Action darc.Action
+	// Identities that will sign together
+	Identities []darc.Identity
+	// Attributes, like "attr:block:after=10", that are assumed to be
+	// satisfied instead of being interpreted on the latest state.
+	Attributes []string
+}
+
+// ExplainAuthorizationResponse holds the steps of the evaluation of the rule.
+// The first step is the whole expression.
+type ExplainAuthorizationResponse struct {
+	Steps []darc.ExplainStep
+}
+
 // ChainConfig stores all the configuration information for one skipchain. It
 // will be stored under the key [32]byte{} in the tree.
 type ChainConfig struct {
@@ -167,6 +284,20 @@ type ChainConfig struct {
 	Roster          onet.Roster
 	MaxBlockSize    int
 	DarcContractIDs []string
+	// ContractVersions holds the switches of the versions of the contracts,
+	// in the order they were scheduled.
+	ContractVersions []ContractVersionSwitch `protobuf:"opt"`
+}
+
+// ContractVersionSwitch makes a version of a contract the active one from
+// the given block index on.
+type ContractVersionSwitch struct {
+	ContractID string
+	Version    uint32
+	BlockIndex int
+	// Applied is set once the existing instances have been migrated and the
+	// version is active.
+	Applied bool
 }
 
 // Proof represents everything necessary to verify a given
@@ -189,6 +320,28 @@ type Proof struct {
 	Links []skipchain.ForwardLink
 }
 
+// MultiProof represents the proof that several keys are present or absent
+// in the same block.
+type MultiProof struct {
+	// InclusionProof holds the paths of all the keys in the trie.
+	InclusionProof trie.MultiProof
+	// Providing the latest skipblock to retrieve the Merkle tree root.
+	Latest skipchain.SkipBlock
+	// Proving the path to the latest skipblock, like in Proof.
+	Links []skipchain.ForwardLink
+}
+
+// RangeProof represents the proof of all the keys under a binary prefix in
+// the same block.
+type RangeProof struct {
+	// InclusionProof holds the subtree of the prefix in the trie.
+	InclusionProof trie.RangeProof
+	// Providing the latest skipblock to retrieve the Merkle tree root.
+	Latest skipchain.SkipBlock
+	// Proving the path to the latest skipblock, like in Proof.
+	Links []skipchain.ForwardLink
+}
+
 // Instruction holds only one of Spawn, Invoke, or Delete
 type Instruction struct {
 	// InstanceID is either the instance that can spawn a new instance, or the instance
@@ -210,6 +363,10 @@ type Instruction struct {
 	// Signatures that are verified using the Darc controlling access to
 	// the instance.
 	Signatures [][]byte
+	// Delegations are the delegation instances presented by the signers.
+	// If the rule of the action is not satisfied, it is enough that the
+	// delegate of one of them signs.
+	Delegations []InstanceID `protobuf:"opt"`
 	// synthetic is a private field indicating that the instruction has been
 	// artificially created, which can give it additional rights (see
 	// Instruction.usesForbiddenIdentities()).
@@ -261,9 +418,42 @@ type Argument struct {
 // If any of the instructions fails, none of them will be applied.
 // InstructionsHash must be the hash of the concatenation of all the
 // instruction hashes (see the Hash method in Instruction), this hash is what
-// every instruction must sign for the transaction to be valid.
+// every instruction must sign for the transaction to be valid. If the
+// transaction has a validity window, the window is added to the hash (see
+// the Hash method in ClientTransaction).
 type ClientTransaction struct {
 	Instructions Instructions
+	// NotBefore is the first block the transaction can be included in.
+	NotBefore *TxBound `protobuf:"opt"`
+	// NotAfter is the last block the transaction can be included in.
+	NotAfter *TxBound `protobuf:"opt"`
+}
+
+// TxBound is one end of the validity window of a transaction. It is given
+// either as the index of a block, or as the timestamp of a block as found in
+// its DataHeader, but not both.
+type TxBound struct {
+	// Index of the block.
+	Index int `protobuf:"opt"`
+	// Timestamp of the block, in nanoseconds since the Unix epoch.
+	Timestamp int64 `protobuf:"opt"`
+}
+
+// Delegation is the value of a delegation instance. It allows the delegate
+// to satisfy the rule of an action of a darc, by presenting the delegation
+// with the instruction or with the delegation:<id> identity, until it expires
+// or is revoked.
+type Delegation struct {
+	// DarcID is the base ID of the darc whose rule is delegated.
+	DarcID darc.ID
+	// Action is the delegated action of the darc.
+	Action darc.Action
+	// Delegate is the identity that receives the right.
+	Delegate darc.Identity
+	// Expiry is the last block in which the delegation can be used.
+	Expiry TxBound
+	// Revoked is true once the delegation has been revoked.
+	Revoked bool
 }
 
 // TxResult holds a transaction and the result of running it.
@@ -301,11 +491,69 @@ type Coin struct {
 // on the chain specified by ID.
 type StreamingRequest struct {
 	ID skipchain.SkipBlockID
+	// Filter, if given, asks the service to only send the matching
+	// transactions and state changes of each block, instead of the blocks.
+	Filter *StreamingFilter `protobuf:"opt"`
+}
+
+// StreamingFilter selects the transactions to stream. A transaction matches
+// if all the non-empty conditions are true for it. The state changes are
+// selected per transaction: only those produced by the matching
+// transactions are sent, and among them only those of the ContractIDs and
+// InstanceIDs. A transaction also matches the ContractIDs and InstanceIDs if
+// one of its state changes does, like a coin transfer into a watched account
+// from an instruction on another account. The node takes the state changes
+// of each transaction from the cache of the block it executed last. If the
+// cache holds another block, because the node already executed the
+// transactions of the next one, the matching transactions are sent without
+// their state changes, and only their instructions are matched.
+type StreamingFilter struct {
+	// ContractIDs lets through the transactions with at least one
+	// instruction or state change for one of these contracts.
+	ContractIDs []string `protobuf:"opt"`
+	// InstanceIDs lets through the transactions with at least one
+	// instruction or state change on one of these instances.
+	InstanceIDs []InstanceID `protobuf:"opt"`
+	// SignerIDs lets through the transactions with at least one instruction
+	// signed by one of these identities, given as darc.Identity.String().
+	SignerIDs []string `protobuf:"opt"`
+	// AcceptedOnly drops the refused transactions.
+	AcceptedOnly bool `protobuf:"opt"`
+}
+
+// StreamingResponse is the reply (block) that is streamed back to the client.
+// If the request has a filter, the Block is not set, but the matching
+// transactions and state changes are sent with the ID of their block.
+type StreamingResponse struct {
+	Block *skipchain.SkipBlock `protobuf:"opt"`
+	// BlockID of the block holding the transactions.
+	BlockID skipchain.SkipBlockID `protobuf:"opt"`
+	// Index of the block holding the transactions.
+	Index        int           `protobuf:"opt"`
+	TxResults    []TxResult    `protobuf:"opt"`
+	StateChanges []StateChange `protobuf:"opt"`
+}
+
+// StreamEventsRequest asks the service to stream the events emitted on the
+// chain specified by ID. If a filter is given, only the matching events are
+// sent.
+type StreamEventsRequest struct {
+	ID skipchain.SkipBlockID
+	// Names, if not empty, only lets through the events with one of these
+	// names.
+	Names []string `protobuf:"opt"`
+	// InstanceIDs, if not empty, only lets through the events of one of these
+	// instances.
+	InstanceIDs []InstanceID `protobuf:"opt"`
 }
 
-// StreamingResponse is the reply (block) that is streamed back to the client
-type StreamingResponse struct {
-	Block *skipchain.SkipBlock
+// StreamEventsResponse holds the matching events of one block.
+type StreamEventsResponse struct {
+	// BlockID is the ID of the block holding the events.
+	BlockID skipchain.SkipBlockID
+	// Index of the block.
+	Index  int
+	Events []Event
 }
 
 // PaginateRequest is a request to get NumPages times the consecutive list of
@@ -378,6 +626,53 @@ type DBKeyValue struct {
 	Value []byte
 }
 
+// GetSnapshot requests the manifest of the latest snapshot of the global state
+// that a node holds for a chain.
+type GetSnapshot struct {
+	ByzCoinID skipchain.SkipBlockID
+}
+
+// GetSnapshotResponse holds the manifest of the latest snapshot.
+type GetSnapshotResponse struct {
+	Manifest SnapshotManifest
+}
+
+// SnapshotManifest describes a snapshot of the global state right after a
+// block. The chunks are referenced by their hash, so they can be fetched from
+// any node holding the same snapshot.
+type SnapshotManifest struct {
+	// BlockID is the ID of the block the snapshot is tied to.
+	BlockID skipchain.SkipBlockID
+	// Index of the block.
+	Index int
+	// TrieRoot is the root of the global state after the block, as stored in
+	// the DataHeader of the block.
+	TrieRoot []byte
+	// Nonce of the trie.
+	Nonce []byte
+	// Chunks holds the sha256 of the encoded SnapshotChunks.
+	Chunks [][]byte
+}
+
+// GetSnapshotChunk requests one chunk of a snapshot.
+type GetSnapshotChunk struct {
+	ByzCoinID skipchain.SkipBlockID
+	// Hash of the chunk, as given in the SnapshotManifest.
+	Hash []byte
+}
+
+// GetSnapshotChunkResponse holds the requested chunk. It is kept encoded so
+// that the client can check it against its hash.
+type GetSnapshotChunkResponse struct {
+	Chunk []byte
+}
+
+// SnapshotChunk holds a part of the global state. Every key/value pair comes
+// with its inclusion proof against the TrieRoot of the snapshot.
+type SnapshotChunk struct {
+	Proofs []trie.Proof
+}
+
 // StateChangeBody represents the body part of a state change, which is the
 // part that needs to be serialised and stored in a merkle tree.
 type StateChangeBody struct {
@@ -507,6 +802,30 @@ type DebugRemoveRequest struct {
 	Signature []byte
 }
 
+// GetMempoolRequest asks a conode for the transactions waiting in its
+// mempool. It is only allowed on loopback.
+type GetMempoolRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+}
+
+// GetMempoolResponse holds the transactions of the mempool, the highest
+// priority first.
+type GetMempoolResponse struct {
+	Entries []MempoolEntry
+}
+
+// MempoolEntry is one transaction waiting to be included in a block.
+type MempoolEntry struct {
+	Transaction ClientTransaction
+	// Received is the time the conode got the transaction, in nanoseconds
+	// since the Unix epoch.
+	Received int64
+	// Fee is the fee given by the fee function of the conode, 0 by default.
+	Fee uint64
+	// Size is the number of bytes the transaction uses in a block.
+	Size int
+}
+
 // IDVersion holds the InstanceID and the latest known version of an instance.
 type IDVersion struct {
 	ID      InstanceID
@@ -0,0 +1,116 @@
+package byzcoin
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// SetPruneDepth enables the pruning mode if depth is bigger than 0. In this
+// mode, the payload of a block is dropped once it is more than depth blocks
+// behind the latest block and a verified snapshot of the global state covers
+// it. The headers and the forward links are kept, so the proofs and the
+// update chains stay valid. With a depth of 0, the node is an archive node and
+// keeps the payload of all blocks.
+func (s *Service) SetPruneDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	s.snapshotLock.Lock()
+	s.pruneDepth = depth
+	s.snapshotLock.Unlock()
+}
+
+func (s *Service) getPruneDepth() int {
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	return s.pruneDepth
+}
+
+// isPruned returns true if the payload of the block has been dropped by a
+// node in pruning mode. Blocks without transactions, like the ones upgrading
+// the version, have an empty payload too.
+func isPruned(sb *skipchain.SkipBlock, header *DataHeader) bool {
+	return len(sb.Payload) == 0 &&
+		!bytes.Equal(header.ClientTransactionHash, TxResults{}.Hash())
+}
+
+// pruneBlocks drops the payload of the blocks of the chain of sb that are
+// covered by the latest snapshot and that are at least the prune depth behind
+// sb. The genesis block is always kept, as it holds the nonce of the trie.
+// The last pruned block is saved with the storage of the service, so the
+// blocks are only visited once. It must be called with updateTrieLock held.
+func (s *Service) pruneBlocks(sb *skipchain.SkipBlock) error {
+	depth := s.getPruneDepth()
+	if depth <= 0 {
+		return nil
+	}
+	scID := sb.SkipChainID()
+	m, err := s.snapshots.getManifest(scID)
+	if err != nil {
+		// Nothing can be pruned until there is a snapshot.
+		return nil
+	}
+	limit := sb.Index - depth
+	if m.Index < limit {
+		limit = m.Index
+	}
+
+	idStr := fmt.Sprintf("%x", scID)
+	s.storage.Lock()
+	last, ok := s.storage.Pruned[idStr]
+	s.storage.Unlock()
+	if !ok {
+		last = scID
+	}
+	cur := s.db().GetByID(last)
+	if cur == nil {
+		return xerrors.New("missing last pruned block")
+	}
+	var count int
+	for len(cur.ForwardLink) > 0 {
+		next := s.db().GetByID(cur.ForwardLink[0].To)
+		if next == nil || next.Index > limit {
+			break
+		}
+		if err = s.db().PrunePayload(next.Hash); err != nil {
+			err = xerrors.Errorf("pruning block %d: %v", next.Index, err)
+			break
+		}
+		cur = next
+		count++
+	}
+	if count == 0 {
+		return err
+	}
+
+	s.storage.Lock()
+	if s.storage.Pruned == nil {
+		s.storage.Pruned = make(map[string]skipchain.SkipBlockID)
+	}
+	s.storage.Pruned[idStr] = cur.Hash
+	s.storage.Unlock()
+	s.save()
+	log.Lvlf2("%s: pruned %d blocks of %x up to block %d", s.ServerIdentity(),
+		count, scID, cur.Index)
+	return err
+}
+
+// GetStatus returns the status of the service. It tells whether the node
+// keeps the full history of the chains or prunes the old blocks.
+func (s *Service) GetStatus() *onet.Status {
+	depth := s.getPruneDepth()
+	history := "full"
+	if depth > 0 {
+		history = "pruned"
+	}
+	return &onet.Status{Field: map[string]string{
+		"History":    history,
+		"PruneDepth": strconv.Itoa(depth),
+	}}
+}
@@ -0,0 +1,109 @@
+package byzcoin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestService_Pruning(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	setSnapshotInterval(s, 3)
+
+	// The first node is an archive node.
+	for _, srv := range s.services[1:] {
+		srv.SetPruneDepth(2)
+	}
+	require.Equal(t, "full", s.services[0].GetStatus().Field["History"])
+	require.Equal(t, "pruned", s.services[1].GetStatus().Field["History"])
+
+	addDummyTxs(t, s, 6, 1, 1)
+
+	scID := s.genesis.SkipChainID()
+	latest, err := s.services[1].db().GetLatestByID(scID)
+	require.NoError(t, err)
+	waitSnapshot(t, s, latest.Index-latest.Index%3)
+	m, err := s.services[1].snapshots.getManifest(scID)
+	require.NoError(t, err)
+	limit := latest.Index - 2
+	if m.Index < limit {
+		limit = m.Index
+	}
+	require.True(t, limit > 0)
+
+	for i := 0; i <= latest.Index; i++ {
+		for j, srv := range s.services {
+			reply, err := srv.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
+				Genesis: scID,
+				Index:   i,
+			})
+			require.NoError(t, err)
+			header, err := decodeBlockHeader(reply.SkipBlock)
+			require.NoError(t, err)
+			pruned := j > 0 && i > 0 && i <= limit
+			require.Equal(t, pruned, isPruned(reply.SkipBlock, header),
+				"node %d, block %d", j, i)
+		}
+	}
+
+	// The last pruned block is stored, to resume from there after a
+	// restart.
+	msg, err := s.services[1].Load(storageID)
+	require.NoError(t, err)
+	lastPruned := msg.(*bcStorage).Pruned[fmt.Sprintf("%x", scID)]
+	require.NotNil(t, lastPruned)
+	require.True(t, s.services[1].db().GetByID(lastPruned).Index >= limit)
+
+	// Proofs and update chains still work on the pruning nodes.
+	proof, err := s.services[1].GetProof(&GetProof{
+		Version: CurrentVersion,
+		ID:      scID,
+		Key:     s.darc.GetBaseID(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, proof.Proof.VerifyFromBlock(s.genesis))
+	chain, err := s.services[1].skService().GetUpdateChain(&skipchain.GetUpdateChain{
+		LatestID: scID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, latest.Hash, chain.Update[len(chain.Update)-1].Hash)
+}
+
+func TestService_PrunedCatchUp(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	setSnapshotInterval(s, 3)
+	for _, srv := range s.services {
+		srv.SetPruneDepth(1)
+	}
+
+	ct := addDummyTxs(t, s, 6, 1, 1)
+	latest, err := s.service().db().GetLatestByID(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	waitSnapshot(t, s, latest.Index-latest.Index%3)
+
+	// The new node cannot replay the pruned blocks, so it catches up with a
+	// snapshot.
+	_, newRoster, _ := s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	newRoster = onet.NewRoster(append(s.roster.List, newRoster.List...))
+	ctx, _ := createConfigTxWithCounter(t, testInterval, *newRoster,
+		defaultMaxBlockSize, s, ct)
+	ct++
+	s.sendTxAndWait(t, ctx, 10)
+	addDummyTxs(t, s, 2, 1, ct)
+
+	newNode := newRoster.List[len(newRoster.List)-1]
+	leanClient := onet.NewClient(cothority.Suite, ServiceName)
+	reply := &GetProofResponse{}
+	require.NoError(t, leanClient.SendProtobuf(newNode, &GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.Hash,
+		Key:     s.darc.GetBaseID(),
+	}, reply))
+	require.True(t, reply.Proof.InclusionProof.Match(s.darc.GetBaseID()))
+}
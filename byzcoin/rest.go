@@ -0,0 +1,509 @@
+package byzcoin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// restPrefix is the prefix of all the routes of the gateway.
+const restPrefix = "/v1/"
+
+// The gateway maps the following routes to the API of the service, where
+// {id} is the ID of the ByzCoin chain:
+//
+//   GET  /v1/openapi.json                              the OpenAPI description
+//   GET  /v1/byzcoin/{id}/proofs/{key}[?from={block}]  GetProof
+//   POST /v1/byzcoin/{id}/transactions                 AddTransaction
+//   GET  /v1/byzcoin/{id}/counters?signer={identity}   GetSignerCounters
+//   GET  /v1/byzcoin/{id}/blocks/{index}               GetSingleBlockByIndex
+//   GET  /v1/byzcoin/{id}/names/{darc}/{name}          ResolveInstanceID
+//   GET  /v1/byzcoin/{id}/instances/{key}/versions/{v} GetInstanceVersion
+//
+// The IDs, like the instance IDs, the darc IDs and the block IDs, are
+// encoded in hexadecimal, and the values and the signatures in base64.
+
+// hexBytes is encoded as an hexadecimal string in JSON.
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *hexBytes) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return xerrors.Errorf("decoding hex: %v", err)
+	}
+	*h = b
+	return nil
+}
+
+type restArgument struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
+type restSpawn struct {
+	ContractID string         `json:"contract_id"`
+	Args       []restArgument `json:"args,omitempty"`
+}
+
+type restInvoke struct {
+	ContractID string         `json:"contract_id"`
+	Command    string         `json:"command"`
+	Args       []restArgument `json:"args,omitempty"`
+}
+
+type restDelete struct {
+	ContractID string         `json:"contract_id"`
+	Args       []restArgument `json:"args,omitempty"`
+}
+
+type restInstruction struct {
+	InstanceID hexBytes    `json:"instance_id"`
+	Spawn      *restSpawn  `json:"spawn,omitempty"`
+	Invoke     *restInvoke `json:"invoke,omitempty"`
+	Delete     *restDelete `json:"delete,omitempty"`
+	// SignerIdentities are given in their string form, like
+	// ed25519:0123...
+	SignerIdentities []string `json:"signer_identities"`
+	SignerCounter    []uint64 `json:"signer_counter"`
+	Signatures       [][]byte `json:"signatures"`
+	// Delegations are the IDs of the delegation instances presented by
+	// the signers.
+	Delegations []hexBytes `json:"delegations,omitempty"`
+}
+
+type restTxBound struct {
+	Index     int   `json:"index,omitempty"`
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+type restTransaction struct {
+	Instructions []restInstruction `json:"instructions"`
+	NotBefore    *restTxBound      `json:"not_before,omitempty"`
+	NotAfter     *restTxBound      `json:"not_after,omitempty"`
+}
+
+type restAddTxRequest struct {
+	Transaction   restTransaction `json:"transaction"`
+	InclusionWait int             `json:"inclusion_wait,omitempty"`
+}
+
+type restAddTxResponse struct {
+	Version Version `json:"version"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type restProof struct {
+	Key        hexBytes `json:"key"`
+	Exists     bool     `json:"exists"`
+	Value      []byte   `json:"value,omitempty"`
+	ContractID string   `json:"contract_id,omitempty"`
+	DarcID     hexBytes `json:"darc_id,omitempty"`
+	Version    uint64   `json:"version"`
+	BlockIndex int      `json:"block_index"`
+	BlockID    hexBytes `json:"block_id"`
+	// Proof is the protobuf encoding of the Proof, for the clients that
+	// verify it.
+	Proof []byte `json:"proof"`
+}
+
+type restCounters struct {
+	Counters []uint64 `json:"counters"`
+	Index    uint64   `json:"index"`
+}
+
+type restServer struct {
+	Address string   `json:"address"`
+	Public  string   `json:"public"`
+	ID      hexBytes `json:"id"`
+}
+
+type restForwardLink struct {
+	From hexBytes `json:"from"`
+	To   hexBytes `json:"to"`
+}
+
+type restHeader struct {
+	TrieRoot              hexBytes `json:"trie_root"`
+	ClientTransactionHash hexBytes `json:"client_transaction_hash"`
+	StateChangesHash      hexBytes `json:"state_changes_hash"`
+	Timestamp             int64    `json:"timestamp"`
+	Version               Version  `json:"version"`
+	EventsHash            hexBytes `json:"events_hash,omitempty"`
+}
+
+type restBlock struct {
+	Index        int               `json:"index"`
+	Height       int               `json:"height"`
+	Hash         hexBytes          `json:"hash"`
+	BackLinks    []hexBytes        `json:"back_links"`
+	ForwardLinks []restForwardLink `json:"forward_links"`
+	Roster       []restServer      `json:"roster"`
+	Header       *restHeader       `json:"header,omitempty"`
+	Data         []byte            `json:"data"`
+	Payload      []byte            `json:"payload"`
+}
+
+type restInstanceID struct {
+	InstanceID hexBytes `json:"instance_id"`
+}
+
+type restStateChange struct {
+	InstanceID  hexBytes `json:"instance_id"`
+	StateAction string   `json:"state_action"`
+	ContractID  string   `json:"contract_id"`
+	Value       []byte   `json:"value"`
+	DarcID      hexBytes `json:"darc_id"`
+	Version     uint64   `json:"version"`
+	BlockIndex  int      `json:"block_index"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+// restErr is an error with the HTTP status to return.
+type restErr struct {
+	status int
+	err    error
+}
+
+func (e restErr) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return restErr{http.StatusBadRequest, xerrors.Errorf(format, args...)}
+}
+
+// bodyError returns the error of decoding the body of a request, with the
+// status 413 if the body is bigger than httpMaxBodySize.
+func bodyError(err error) error {
+	// The error of http.MaxBytesReader has no type of its own.
+	if strings.Contains(err.Error(), "request body too large") {
+		return restErr{http.StatusRequestEntityTooLarge,
+			xerrors.Errorf("decoding request: %v", err)}
+	}
+	return badRequest("decoding request: %v", err)
+}
+
+// RESTHandler returns the handler of the REST/JSON gateway, whose routes
+// start with /v1/.
+func (s *Service) RESTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, httpMaxBodySize)
+		resp, err := s.serveREST(r)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			status := http.StatusInternalServerError
+			var re restErr
+			if xerrors.As(err, &re) {
+				status = re.status
+			}
+			w.WriteHeader(status)
+			resp = restError{Error: err.Error()}
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Warn(s.ServerIdentity(), "couldn't write response:", err)
+		}
+	})
+}
+
+// restChainID returns the ID of the chain of the route.
+func restChainID(path string) (skipchain.SkipBlockID, bool) {
+	if !strings.HasPrefix(path, restPrefix) {
+		return nil, false
+	}
+	parts := strings.Split(strings.Trim(path[len(restPrefix):], "/"), "/")
+	if len(parts) < 2 || parts[0] != "byzcoin" {
+		return nil, false
+	}
+	bcID, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	return bcID, true
+}
+
+func (s *Service) serveREST(r *http.Request) (interface{}, error) {
+	if !strings.HasPrefix(r.URL.Path, restPrefix) {
+		return nil, restErr{http.StatusNotFound, xerrors.New("unknown route")}
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path[len(restPrefix):], "/"), "/")
+	if len(parts) == 1 && parts[0] == "openapi.json" && r.Method == http.MethodGet {
+		return json.RawMessage(restOpenAPI), nil
+	}
+	if len(parts) < 3 || parts[0] != "byzcoin" {
+		return nil, restErr{http.StatusNotFound, xerrors.New("unknown route")}
+	}
+	bcID, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, badRequest("invalid byzcoin ID: %v", err)
+	}
+	route, args := parts[2], parts[3:]
+
+	switch {
+	case r.Method == http.MethodGet && route == "proofs" && len(args) == 1:
+		return s.restGetProof(bcID, args[0], r.URL.Query().Get("from"))
+	case r.Method == http.MethodPost && route == "transactions" && len(args) == 0:
+		return s.restAddTransaction(bcID, r)
+	case r.Method == http.MethodGet && route == "counters" && len(args) == 0:
+		return s.restGetSignerCounters(bcID, r.URL.Query()["signer"])
+	case r.Method == http.MethodGet && route == "blocks" && len(args) == 1:
+		return s.restGetBlock(bcID, args[0])
+	case r.Method == http.MethodGet && route == "names" && len(args) == 2:
+		return s.restResolveInstanceID(bcID, args[0], args[1])
+	case r.Method == http.MethodGet && route == "instances" && len(args) == 3 &&
+		args[1] == "versions":
+		return s.restGetInstanceVersion(bcID, args[0], args[2])
+	}
+	return nil, restErr{http.StatusNotFound, xerrors.New("unknown route")}
+}
+
+func (s *Service) restGetProof(bcID skipchain.SkipBlockID, keyHex, fromHex string) (interface{}, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, badRequest("invalid key: %v", err)
+	}
+	from := bcID
+	if fromHex != "" {
+		if from, err = hex.DecodeString(fromHex); err != nil {
+			return nil, badRequest("invalid block ID: %v", err)
+		}
+	}
+	reply, err := s.GetProof(&GetProof{Version: CurrentVersion, Key: key, ID: from})
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	proof := reply.Proof
+	if !proof.Latest.SkipChainID().Equal(bcID) {
+		return nil, badRequest("block is not in this chain")
+	}
+
+	buf, err := protobuf.Encode(&proof)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding proof: %v", err)
+	}
+	resp := restProof{
+		Key:        key,
+		Exists:     proof.InclusionProof.Match(key),
+		BlockIndex: proof.Latest.Index,
+		BlockID:    hexBytes(proof.Latest.Hash),
+		Proof:      buf,
+	}
+	if resp.Exists {
+		_, vals := proof.InclusionProof.KeyValue()
+		body, err := decodeStateChangeBody(vals)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding body: %v", err)
+		}
+		resp.Value = body.Value
+		resp.ContractID = body.ContractID
+		resp.DarcID = hexBytes(body.DarcID)
+		resp.Version = body.Version
+	}
+	return resp, nil
+}
+
+func (s *Service) restAddTransaction(bcID skipchain.SkipBlockID, r *http.Request) (interface{}, error) {
+	var req restAddTxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, bodyError(err)
+	}
+	tx, err := req.Transaction.clientTransaction()
+	if err != nil {
+		return nil, badRequest("invalid transaction: %v", err)
+	}
+	reply, err := s.AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   bcID,
+		Transaction:   tx,
+		InclusionWait: req.InclusionWait,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("adding transaction: %v", err)
+	}
+	return restAddTxResponse{Version: reply.Version, Error: reply.Error}, nil
+}
+
+func (s *Service) restGetSignerCounters(bcID skipchain.SkipBlockID, signers []string) (interface{}, error) {
+	if len(signers) == 0 {
+		return nil, badRequest("missing signer")
+	}
+	reply, err := s.GetSignerCounters(&GetSignerCounters{
+		SignerIDs:   signers,
+		SkipchainID: bcID,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting counters: %v", err)
+	}
+	return restCounters{Counters: reply.Counters, Index: reply.Index}, nil
+}
+
+func (s *Service) restGetBlock(bcID skipchain.SkipBlockID, indexStr string) (interface{}, error) {
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 {
+		return nil, badRequest("invalid index: %s", indexStr)
+	}
+	reply, err := s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
+		Genesis: bcID,
+		Index:   index,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting block: %v", err)
+	}
+	sb := reply.SkipBlock
+
+	resp := restBlock{
+		Index:        sb.Index,
+		Height:       sb.Height,
+		Hash:         hexBytes(sb.Hash),
+		BackLinks:    []hexBytes{},
+		ForwardLinks: []restForwardLink{},
+		Roster:       []restServer{},
+		Data:         sb.Data,
+		Payload:      sb.Payload,
+	}
+	for _, bl := range sb.BackLinkIDs {
+		resp.BackLinks = append(resp.BackLinks, hexBytes(bl))
+	}
+	for _, fl := range sb.ForwardLink {
+		resp.ForwardLinks = append(resp.ForwardLinks,
+			restForwardLink{From: hexBytes(fl.From), To: hexBytes(fl.To)})
+	}
+	if sb.Roster != nil {
+		for _, si := range sb.Roster.List {
+			resp.Roster = append(resp.Roster, restServer{
+				Address: si.Address.NetworkAddress(),
+				Public:  si.Public.String(),
+				ID:      hexBytes(si.ID[:]),
+			})
+		}
+	}
+	// The genesis block of ByzCoin holds no header.
+	if header, err := decodeBlockHeader(sb); err == nil {
+		resp.Header = &restHeader{
+			TrieRoot:              header.TrieRoot,
+			ClientTransactionHash: header.ClientTransactionHash,
+			StateChangesHash:      header.StateChangesHash,
+			Timestamp:             header.Timestamp,
+			Version:               header.Version,
+			EventsHash:            header.EventsHash,
+		}
+	}
+	return resp, nil
+}
+
+func (s *Service) restResolveInstanceID(bcID skipchain.SkipBlockID, darcHex, name string) (interface{}, error) {
+	darcID, err := hex.DecodeString(darcHex)
+	if err != nil {
+		return nil, badRequest("invalid darc ID: %v", err)
+	}
+	reply, err := s.ResolveInstanceID(&ResolveInstanceID{
+		SkipChainID: bcID,
+		DarcID:      darc.ID(darcID),
+		Name:        name,
+	})
+	if err != nil {
+		return nil, restErr{http.StatusNotFound, xerrors.Errorf("resolving name: %v", err)}
+	}
+	return restInstanceID{InstanceID: reply.InstanceID[:]}, nil
+}
+
+func (s *Service) restGetInstanceVersion(bcID skipchain.SkipBlockID, keyHex, versionStr string) (interface{}, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != len(InstanceID{}) {
+		return nil, badRequest("invalid instance ID: %s", keyHex)
+	}
+	version, err := strconv.ParseUint(versionStr, 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid version: %v", err)
+	}
+	reply, err := s.GetInstanceVersion(&GetInstanceVersion{
+		SkipChainID: bcID,
+		InstanceID:  NewInstanceID(key),
+		Version:     version,
+	})
+	if err != nil {
+		return nil, restErr{http.StatusNotFound, xerrors.Errorf("getting version: %v", err)}
+	}
+	sc := reply.StateChange
+	return restStateChange{
+		InstanceID:  sc.InstanceID,
+		StateAction: sc.StateAction.String(),
+		ContractID:  sc.ContractID,
+		Value:       sc.Value,
+		DarcID:      hexBytes(sc.DarcID),
+		Version:     sc.Version,
+		BlockIndex:  reply.BlockIndex,
+	}, nil
+}
+
+func (rt restTransaction) clientTransaction() (ClientTransaction, error) {
+	var tx ClientTransaction
+	if rt.NotBefore != nil {
+		tx.NotBefore = &TxBound{Index: rt.NotBefore.Index, Timestamp: rt.NotBefore.Timestamp}
+	}
+	if rt.NotAfter != nil {
+		tx.NotAfter = &TxBound{Index: rt.NotAfter.Index, Timestamp: rt.NotAfter.Timestamp}
+	}
+	for i, ri := range rt.Instructions {
+		if len(ri.InstanceID) != len(InstanceID{}) {
+			return tx, xerrors.Errorf("instruction %d: invalid instance ID", i)
+		}
+		inst := Instruction{
+			InstanceID:    NewInstanceID(ri.InstanceID),
+			SignerCounter: ri.SignerCounter,
+			Signatures:    ri.Signatures,
+		}
+		switch {
+		case ri.Spawn != nil:
+			inst.Spawn = &Spawn{ContractID: ri.Spawn.ContractID, Args: restArgs(ri.Spawn.Args)}
+		case ri.Invoke != nil:
+			inst.Invoke = &Invoke{ContractID: ri.Invoke.ContractID,
+				Command: ri.Invoke.Command, Args: restArgs(ri.Invoke.Args)}
+		case ri.Delete != nil:
+			inst.Delete = &Delete{ContractID: ri.Delete.ContractID, Args: restArgs(ri.Delete.Args)}
+		default:
+			return tx, xerrors.Errorf("instruction %d: missing spawn, invoke or delete", i)
+		}
+		for _, idStr := range ri.SignerIdentities {
+			id, err := darc.ParseIdentity(idStr)
+			if err != nil {
+				return tx, xerrors.Errorf("instruction %d: invalid identity: %v", i, err)
+			}
+			inst.SignerIdentities = append(inst.SignerIdentities, id)
+		}
+		for _, d := range ri.Delegations {
+			if len(d) != len(InstanceID{}) {
+				return tx, xerrors.Errorf("instruction %d: invalid delegation ID", i)
+			}
+			inst.Delegations = append(inst.Delegations, NewInstanceID(d))
+		}
+		tx.Instructions = append(tx.Instructions, inst)
+	}
+	return tx, nil
+}
+
+func restArgs(args []restArgument) Arguments {
+	var out Arguments
+	for _, arg := range args {
+		out = append(out, Argument{Name: arg.Name, Value: arg.Value})
+	}
+	return out
+}
@@ -0,0 +1,220 @@
+package byzcoin
+
+// restOpenAPI is the OpenAPI description of the REST/JSON gateway, served on
+// /v1/openapi.json.
+const restOpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "ByzCoin REST gateway",
+    "version": "1.0.0",
+    "description": "JSON mapping of the main calls of the ByzCoin and skipchain services. IDs are hexadecimal strings, values and signatures are base64 strings."
+  },
+  "paths": {
+    "/v1/byzcoin/{id}/proofs/{key}": {
+      "get": {
+        "summary": "Proof of the presence or the absence of a key",
+        "operationId": "getProof",
+        "parameters": [
+          {"$ref": "#/components/parameters/ByzCoinID"},
+          {"name": "key", "in": "path", "required": true, "schema": {"$ref": "#/components/schemas/Hex"}},
+          {"name": "from", "in": "query", "description": "Block from which the proof starts, the genesis block by default.", "schema": {"$ref": "#/components/schemas/Hex"}}
+        ],
+        "responses": {
+          "200": {"description": "The proof", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Proof"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/byzcoin/{id}/transactions": {
+      "post": {
+        "summary": "Add a transaction",
+        "operationId": "addTransaction",
+        "parameters": [{"$ref": "#/components/parameters/ByzCoinID"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AddTxRequest"}}}
+        },
+        "responses": {
+          "200": {"description": "The transaction was accepted, or refused if error is set", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AddTxResponse"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/byzcoin/{id}/counters": {
+      "get": {
+        "summary": "Latest counters of signers",
+        "operationId": "getSignerCounters",
+        "parameters": [
+          {"$ref": "#/components/parameters/ByzCoinID"},
+          {"name": "signer", "in": "query", "required": true, "description": "Identity of a signer, like ed25519:0123...", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": true}
+        ],
+        "responses": {
+          "200": {"description": "The counters, in the order of the signers", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Counters"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/byzcoin/{id}/blocks/{index}": {
+      "get": {
+        "summary": "Block at an index",
+        "operationId": "getSingleBlockByIndex",
+        "parameters": [
+          {"$ref": "#/components/parameters/ByzCoinID"},
+          {"name": "index", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "The block", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Block"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/byzcoin/{id}/names/{darc}/{name}": {
+      "get": {
+        "summary": "Instance ID of a name given by the naming contract",
+        "operationId": "resolveInstanceID",
+        "parameters": [
+          {"$ref": "#/components/parameters/ByzCoinID"},
+          {"name": "darc", "in": "path", "required": true, "schema": {"$ref": "#/components/schemas/Hex"}},
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "The instance ID", "content": {"application/json": {"schema": {"type": "object", "properties": {"instance_id": {"$ref": "#/components/schemas/Hex"}}}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/v1/byzcoin/{id}/instances/{key}/versions/{version}": {
+      "get": {
+        "summary": "Version of an instance",
+        "operationId": "getInstanceVersion",
+        "parameters": [
+          {"$ref": "#/components/parameters/ByzCoinID"},
+          {"name": "key", "in": "path", "required": true, "schema": {"$ref": "#/components/schemas/Hex"}},
+          {"name": "version", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {"description": "The state change that created the version", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StateChange"}}}},
+          "default": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "ByzCoinID": {"name": "id", "in": "path", "required": true, "description": "ID of the genesis block of the chain", "schema": {"$ref": "#/components/schemas/Hex"}}
+    },
+    "responses": {
+      "Error": {"description": "The request failed", "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}}}}}}
+    },
+    "schemas": {
+      "Hex": {"type": "string", "pattern": "^([0-9a-f]{2})*$"},
+      "Base64": {"type": "string", "format": "byte"},
+      "Proof": {
+        "type": "object",
+        "properties": {
+          "key": {"$ref": "#/components/schemas/Hex"},
+          "exists": {"type": "boolean"},
+          "value": {"$ref": "#/components/schemas/Base64"},
+          "contract_id": {"type": "string"},
+          "darc_id": {"$ref": "#/components/schemas/Hex"},
+          "version": {"type": "integer"},
+          "block_index": {"type": "integer"},
+          "block_id": {"$ref": "#/components/schemas/Hex"},
+          "proof": {"description": "Protobuf encoding of the proof, to verify it", "$ref": "#/components/schemas/Base64"}
+        }
+      },
+      "Argument": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "value": {"$ref": "#/components/schemas/Base64"}
+        }
+      },
+      "Instruction": {
+        "type": "object",
+        "description": "Exactly one of spawn, invoke and delete must be set.",
+        "properties": {
+          "instance_id": {"$ref": "#/components/schemas/Hex"},
+          "spawn": {"type": "object", "properties": {"contract_id": {"type": "string"}, "args": {"type": "array", "items": {"$ref": "#/components/schemas/Argument"}}}},
+          "invoke": {"type": "object", "properties": {"contract_id": {"type": "string"}, "command": {"type": "string"}, "args": {"type": "array", "items": {"$ref": "#/components/schemas/Argument"}}}},
+          "delete": {"type": "object", "properties": {"contract_id": {"type": "string"}, "args": {"type": "array", "items": {"$ref": "#/components/schemas/Argument"}}}},
+          "signer_identities": {"type": "array", "items": {"type": "string"}},
+          "signer_counter": {"type": "array", "items": {"type": "integer"}},
+          "signatures": {"type": "array", "items": {"$ref": "#/components/schemas/Base64"}},
+          "delegations": {"type": "array", "items": {"$ref": "#/components/schemas/Hex"}, "description": "IDs of the delegation instances presented by the signers"}
+        }
+      },
+      "TxBound": {
+        "type": "object",
+        "properties": {
+          "index": {"type": "integer"},
+          "timestamp": {"type": "integer"}
+        }
+      },
+      "AddTxRequest": {
+        "type": "object",
+        "properties": {
+          "transaction": {
+            "type": "object",
+            "properties": {
+              "instructions": {"type": "array", "items": {"$ref": "#/components/schemas/Instruction"}},
+              "not_before": {"$ref": "#/components/schemas/TxBound"},
+              "not_after": {"$ref": "#/components/schemas/TxBound"}
+            }
+          },
+          "inclusion_wait": {"type": "integer", "description": "How many block intervals to wait for the inclusion"}
+        }
+      },
+      "AddTxResponse": {
+        "type": "object",
+        "properties": {
+          "version": {"type": "integer"},
+          "error": {"type": "string"}
+        }
+      },
+      "Counters": {
+        "type": "object",
+        "properties": {
+          "counters": {"type": "array", "items": {"type": "integer"}},
+          "index": {"type": "integer"}
+        }
+      },
+      "Block": {
+        "type": "object",
+        "properties": {
+          "index": {"type": "integer"},
+          "height": {"type": "integer"},
+          "hash": {"$ref": "#/components/schemas/Hex"},
+          "back_links": {"type": "array", "items": {"$ref": "#/components/schemas/Hex"}},
+          "forward_links": {"type": "array", "items": {"type": "object", "properties": {"from": {"$ref": "#/components/schemas/Hex"}, "to": {"$ref": "#/components/schemas/Hex"}}}},
+          "roster": {"type": "array", "items": {"type": "object", "properties": {"address": {"type": "string"}, "public": {"type": "string"}, "id": {"$ref": "#/components/schemas/Hex"}}}},
+          "header": {
+            "type": "object",
+            "properties": {
+              "trie_root": {"$ref": "#/components/schemas/Hex"},
+              "client_transaction_hash": {"$ref": "#/components/schemas/Hex"},
+              "state_changes_hash": {"$ref": "#/components/schemas/Hex"},
+              "timestamp": {"type": "integer"},
+              "version": {"type": "integer"},
+              "events_hash": {"$ref": "#/components/schemas/Hex", "description": "Hash of the events of the block, if it has any"}
+            }
+          },
+          "data": {"$ref": "#/components/schemas/Base64"},
+          "payload": {"$ref": "#/components/schemas/Base64"}
+        }
+      },
+      "StateChange": {
+        "type": "object",
+        "properties": {
+          "instance_id": {"$ref": "#/components/schemas/Hex"},
+          "state_action": {"type": "string"},
+          "contract_id": {"type": "string"},
+          "value": {"$ref": "#/components/schemas/Base64"},
+          "darc_id": {"$ref": "#/components/schemas/Hex"},
+          "version": {"type": "integer"},
+          "block_index": {"type": "integer"}
+        }
+      }
+    }
+  }
+}`
@@ -0,0 +1,248 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+func restTransactionOf(tx ClientTransaction) restTransaction {
+	var rt restTransaction
+	for _, inst := range tx.Instructions {
+		ri := restInstruction{
+			InstanceID:    inst.InstanceID[:],
+			SignerCounter: inst.SignerCounter,
+			Signatures:    inst.Signatures,
+		}
+		for _, id := range inst.SignerIdentities {
+			ri.SignerIdentities = append(ri.SignerIdentities, id.String())
+		}
+		for _, d := range inst.Delegations {
+			ri.Delegations = append(ri.Delegations, d[:])
+		}
+		ri.Spawn = &restSpawn{ContractID: inst.Spawn.ContractID}
+		for _, arg := range inst.Spawn.Args {
+			ri.Spawn.Args = append(ri.Spawn.Args, restArgument{Name: arg.Name, Value: arg.Value})
+		}
+		rt.Instructions = append(rt.Instructions, ri)
+	}
+	return rt
+}
+
+func TestService_REST(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	srv := httptest.NewServer(s.service().RESTHandler())
+	defer srv.Close()
+	bcPath := srv.URL + "/v1/byzcoin/" + hex.EncodeToString(s.genesis.SkipChainID())
+
+	get := func(path string, status int, resp interface{}) {
+		r, err := http.Get(path)
+		require.NoError(t, err)
+		defer r.Body.Close()
+		require.Equal(t, status, r.StatusCode)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(resp))
+	}
+
+	var api map[string]interface{}
+	get(srv.URL+"/v1/openapi.json", http.StatusOK, &api)
+	require.Equal(t, "3.0.3", api["openapi"])
+
+	id := NewInstanceID(s.tx.Instructions[0].Hash())
+	var proof restProof
+	get(bcPath+"/proofs/"+hex.EncodeToString(id[:]), http.StatusOK, &proof)
+	require.True(t, proof.Exists)
+	require.Equal(t, s.value, proof.Value)
+	require.Equal(t, dummyContract, proof.ContractID)
+	require.Equal(t, hexBytes(s.darc.GetBaseID()), proof.DarcID)
+	require.NotEmpty(t, proof.Proof)
+
+	var counters restCounters
+	get(bcPath+"/counters?signer="+s.signer.Identity().String(), http.StatusOK, &counters)
+	require.Equal(t, []uint64{1}, counters.Counters)
+
+	var block restBlock
+	get(bcPath+"/blocks/1", http.StatusOK, &block)
+	require.Equal(t, 1, block.Index)
+	require.NotNil(t, block.Header)
+	require.Len(t, block.Roster, len(s.hosts))
+
+	post := func(tx ClientTransaction) {
+		buf, err := json.Marshal(restAddTxRequest{
+			Transaction:   restTransactionOf(tx),
+			InclusionWait: 10,
+		})
+		require.NoError(t, err)
+		r, err := http.Post(bcPath+"/transactions", "application/json", bytes.NewReader(buf))
+		require.NoError(t, err)
+		defer r.Body.Close()
+		var txResp restAddTxResponse
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&txResp))
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Empty(t, txResp.Error)
+	}
+
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract,
+		[]byte("rest"), s.signer, 2)
+	require.NoError(t, err)
+	post(tx)
+
+	id = NewInstanceID(tx.Instructions[0].Hash())
+	var sc restStateChange
+	get(bcPath+"/instances/"+hex.EncodeToString(id[:])+"/versions/0", http.StatusOK, &sc)
+	require.Equal(t, []byte("rest"), sc.Value)
+	require.Equal(t, "Create", sc.StateAction)
+	require.Equal(t, 2, sc.BlockIndex)
+
+	// The header of a block with events holds their hash.
+	emitter := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		ev, err := NewEvent(inst.DeriveID(""), "emitted")
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{ev}, c, nil
+	}
+	for _, srv := range s.services {
+		require.NoError(t, srv.testRegisterContract(emitterContract, adaptorNoVerify(emitter)))
+	}
+	tx, err = createOneClientTxWithCounter(s.darc.GetBaseID(), emitterContract,
+		s.value, s.signer, 3)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+	sb, err := s.service().db().GetLatestByID(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	header, err := decodeBlockHeader(sb)
+	require.NoError(t, err)
+	require.NotEmpty(t, header.EventsHash)
+	get(bcPath+"/blocks/"+strconv.Itoa(sb.Index), http.StatusOK, &block)
+	require.Equal(t, hexBytes(header.EventsHash), block.Header.EventsHash)
+
+	var errResp restError
+	get(bcPath+"/names/"+hex.EncodeToString(s.darc.GetBaseID())+"/unknown",
+		http.StatusNotFound, &errResp)
+	require.NotEmpty(t, errResp.Error)
+	get(srv.URL+"/v1/byzcoin/xyz/blocks/0", http.StatusBadRequest, &errResp)
+	get(bcPath+"/unknown", http.StatusNotFound, &errResp)
+}
+
+// TestService_RESTDelegations checks that the delegations of an instruction
+// survive the JSON mapping, as they are covered by the signature.
+func TestService_RESTDelegations(t *testing.T) {
+	s := newSer(t, 2, testInterval)
+	defer s.local.CloseAll()
+
+	srv := httptest.NewServer(s.service().RESTHandler())
+	defer srv.Close()
+	bcPath := srv.URL + "/v1/byzcoin/" + hex.EncodeToString(s.genesis.SkipChainID())
+
+	d2 := s.darc.Copy()
+	require.NoError(t, d2.EvolveFrom(s.darc))
+	require.NoError(t, d2.Rules.AddRule("spawn:"+ContractDelegationID,
+		d2.Rules.GetSignExpr()))
+	s.testDarcEvolution(t, *d2, false)
+
+	delegate := darc.NewSignerEd25519(nil, nil)
+	resp, ctx := s.sendInstructions(t, 10, Instruction{
+		InstanceID: NewInstanceID(s.darc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: ContractDelegationID,
+			Args: Arguments{
+				{Name: "action", Value: []byte("spawn:" + dummyContract)},
+				{Name: "delegate", Value: []byte(delegate.Identity().String())},
+				{Name: "expiry_index", Value: leUint64(100)},
+			},
+		},
+		SignerCounter: []uint64{3},
+	})
+	require.Empty(t, resp.Error)
+	instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", []byte("delegated"))
+	instr.SignerIdentities = []darc.Identity{delegate.Identity()}
+	instr.SignerCounter = []uint64{1}
+	instr.Delegations = []InstanceID{ctx.Instructions[0].DeriveID("")}
+	tx := NewClientTransaction(CurrentVersion, instr)
+	require.NoError(t, tx.SignWith(delegate))
+
+	buf, err := json.Marshal(restAddTxRequest{
+		Transaction:   restTransactionOf(tx),
+		InclusionWait: 10,
+	})
+	require.NoError(t, err)
+	r, err := http.Post(bcPath+"/transactions", "application/json", bytes.NewReader(buf))
+	require.NoError(t, err)
+	var txResp restAddTxResponse
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&txResp))
+	r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+	require.Empty(t, txResp.Error)
+
+	id := NewInstanceID(tx.Instructions[0].Hash())
+	r, err = http.Get(bcPath + "/instances/" + hex.EncodeToString(id[:]) + "/versions/0")
+	require.NoError(t, err)
+	var sc restStateChange
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&sc))
+	r.Body.Close()
+	require.Equal(t, http.StatusOK, r.StatusCode)
+	require.Equal(t, []byte("delegated"), sc.Value)
+}
+
+func TestService_RESTSharedServer(t *testing.T) {
+	const addr = "127.0.0.1:0"
+	require.NoError(t, SetNodeConfig(NodeConfig{
+		RESTAddress:        addr,
+		RESTAllowedOrigins: []string{"https://example.com"},
+	}))
+	defer SetNodeConfig(NodeConfig{})
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	httpServers.Lock()
+	hs := httpServers.servers[addr]
+	require.Equal(t, len(s.services), len(hs.rest))
+	url := "http://" + hs.addr.String()
+	httpServers.Unlock()
+
+	preflight := func(origin string) string {
+		req, err := http.NewRequest(http.MethodOptions, url+"/v1/openapi.json", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		r, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		r.Body.Close()
+		return r.Header.Get("Access-Control-Allow-Origin")
+	}
+	require.Equal(t, "https://example.com", preflight("https://example.com"))
+	require.Empty(t, preflight("https://other.com"))
+
+	// The origins of the services are merged.
+	require.NoError(t, SetNodeConfig(NodeConfig{
+		RESTAddress:        addr,
+		RESTAllowedOrigins: []string{"https://other.com"},
+	}))
+	s.service().unregisterHTTP()
+	require.NoError(t, s.service().registerHTTP())
+	require.Equal(t, "https://example.com", preflight("https://example.com"))
+	require.Equal(t, "https://other.com", preflight("https://other.com"))
+
+	// A body bigger than the limit is refused, even if it starts as a
+	// valid request.
+	big := `{"transaction": {"instructions": [], "padding": "` +
+		strings.Repeat("a", httpMaxBodySize) + `"}}`
+	r, err := http.Post(url+"/v1/byzcoin/"+hex.EncodeToString(s.genesis.SkipChainID())+
+		"/transactions", "application/json", strings.NewReader(big))
+	require.NoError(t, err)
+	var resp restError
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&resp))
+	r.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, r.StatusCode)
+	require.Contains(t, resp.Error, "too large")
+}
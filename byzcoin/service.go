@@ -60,6 +60,13 @@ const defaultRotationWindow time.Duration = 10
 
 const noTimeout time.Duration = 0
 
+// maxMultiProofKeys is the maximum number of keys in a GetMultiProof request.
+const maxMultiProofKeys = 10000
+
+// maxRangeProofKeys is the maximum number of keys in the proof of a
+// GetRangeProof request.
+const maxRangeProofKeys = 10000
+
 const collectTxProtocol = "CollectTxProtocol"
 
 const viewChangeSubFtCosi = "viewchange_sub_ftcosi"
@@ -97,6 +104,10 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	err = RegisterGlobalContract(ContractDelegationID, contractDelegationFromBytes)
+	if err != nil {
+		panic(err)
+	}
 }
 
 // GenNonce returns a random nonce.
@@ -117,6 +128,21 @@ type Service struct {
 	// We need to store the state changes for keeping track
 	// of the history of an instance
 	stateChangeStorage *stateChangeStorage
+	// snapshots holds the latest snapshot of the global state of every
+	// chain, to be served to new nodes.
+	snapshots *snapshotStorage
+	// snapshotInterval is the number of blocks between two snapshots, 0
+	// if they are disabled.
+	snapshotInterval int
+	// snapshotRunning is true while a snapshot is created in the
+	// background.
+	snapshotRunning bool
+	// pruneDepth is the number of most recent blocks whose payload is
+	// always kept. If it is 0, the node keeps the payload of all blocks.
+	// As the pruning relies on the snapshots, it is guarded by
+	// snapshotLock too.
+	pruneDepth   int
+	snapshotLock sync.Mutex
 	// notifications is used for client transaction and block notification
 	notifications bcNotifications
 
@@ -130,7 +156,7 @@ type Service struct {
 	// will slow down our service, an improvement is to go-routines to
 	// store transactions. But there is more management overhead, e.g.,
 	// restarting after shutdown, answer getTxs requests and so on.
-	txBuffer txBuffer
+	mempool *mempool
 
 	heartbeats             heartbeats
 	heartbeatsTimeout      chan string
@@ -141,6 +167,11 @@ type Service struct {
 
 	storage *bcStorage
 
+	// trieStore holds the databases of the state tries.
+	trieStore trieStore
+	// stateConfig holds the settings of the state tries, see NodeConfig.
+	stateConfig stateTrieConfig
+
 	createSkipChainMut sync.Mutex
 
 	darcToSc    map[string]skipchain.SkipBlockID
@@ -165,7 +196,10 @@ type Service struct {
 
 	rotationWindow time.Duration
 
-	txErrorBuf ringBuf
+	txErrorBuf      ringBuf
+	txErrorClassBuf ringBuf
+
+	metrics *serviceMetrics
 
 	// defaultVersion is the new version to use for new
 	// ByzCoin chains.
@@ -197,6 +231,9 @@ type bcStorage struct {
 	// PropTimeout is used when sending the request to integrate a new block
 	// to all nodes.
 	PropTimeout time.Duration
+	// Pruned holds, for each chain, the last block whose payload has been
+	// dropped, so that the pruning resumes from there after a restart.
+	Pruned map[string]skipchain.SkipBlockID
 
 	sync.Mutex
 }
@@ -427,7 +464,7 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 		log.Lvlf2("Instruction[%d]: %s on instance ID %s", i, instr.Action(), instr.InstanceID.String())
 	}
 
-	// Note to my future self: s.txBuffer.add used to be out here. It used to work
+	// Note to my future self: s.mempool.add used to be out here. It used to work
 	// even. But while investigating other race conditions, we realized that
 	// IF there will be a wait channel, THEN it must exist before the call to add().
 	// If add() comes first, there's a race condition where the block could theoretically
@@ -445,11 +482,15 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 			return nil, xerrors.Errorf("couldn't get block info: %v", err)
 		}
 
-		ctxHash := req.Transaction.Instructions.Hash()
+		ctxHash := req.Transaction.Hash()
+		ctxSigs := req.Transaction.Instructions.HashWithSignatures()
 		ch := s.notifications.registerForBlocks()
 		defer s.notifications.unregisterForBlocks(ch)
 
-		s.txBuffer.add(string(req.SkipchainID), req.Transaction)
+		err = s.mempool.add(string(req.SkipchainID), req.Transaction)
+		if err != nil {
+			return nil, xerrors.Errorf("adding to mempool: %v", err)
+		}
 
 		// In case we don't have any blocks, because there are no transactions,
 		// have a hard timeout in twice the minimal expected time to create the
@@ -462,7 +503,7 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 		for {
 			select {
 			case notif := <-ch:
-				if tx := notif.getTx(ctxHash); tx != nil {
+				if tx := notif.getTx(ctxHash, ctxSigs); tx != nil {
 					return s.prepareTxResponse(req, tx)
 				}
 
@@ -477,12 +518,110 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 			}
 		}
 	} else {
-		s.txBuffer.add(string(req.SkipchainID), req.Transaction)
+		err := s.mempool.add(string(req.SkipchainID), req.Transaction)
+		if err != nil {
+			return nil, xerrors.Errorf("adding to mempool: %v", err)
+		}
 	}
 
 	return &AddTxResponse{Version: CurrentVersion}, nil
 }
 
+// SimulateTransaction runs the transaction on a copy of the latest state and
+// returns the state changes it would produce. The transaction is not added to
+// the buffer of pending transactions, and the global state is not modified.
+// Like for AddTransaction, a refused transaction is not returned as an error,
+// but in SimulateTransactionResponse.Error.
+func (s *Service) SimulateTransaction(req *SimulateTransaction) (*SimulateTransactionResponse, error) {
+	if len(req.Transaction.Instructions) == 0 {
+		return nil, xerrors.New("no instructions to simulate")
+	}
+
+	s.closedMutex.Lock()
+	if s.closed {
+		s.closedMutex.Unlock()
+		return nil, xerrors.New("cannot simulate transaction while in closed state")
+	}
+	s.working.Add(1)
+	s.closedMutex.Unlock()
+	defer s.working.Done()
+
+	gen := s.db().GetByID(req.SkipchainID)
+	if gen == nil || gen.Index != 0 {
+		return nil, xerrors.New("skipchain ID is does not exist")
+	}
+
+	// A persistent trie keeps the root of the latest block while the next
+	// ones are stored, so the trie lock is only held to take a view of it
+	// and the simulations don't delay the new blocks. Any other trie is
+	// rewritten by the new blocks, so it is locked for the whole
+	// simulation.
+	s.catchingLock.Lock()
+	s.updateTrieLock.Lock()
+	locked := true
+	unlock := func() {
+		s.updateTrieLock.Unlock()
+		s.catchingLock.Unlock()
+		locked = false
+	}
+	defer func() {
+		if locked {
+			unlock()
+		}
+	}()
+	latest, err := s.db().GetLatest(gen)
+	if err != nil {
+		return nil, xerrors.Errorf("reading latest block: %v", err)
+	}
+	if i, _ := latest.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		return nil, xerrors.New("refusing to simulate transaction for a chain we're not part of")
+	}
+	st, err := s.getStateTrie(req.SkipchainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting trie: %v", err)
+	}
+	index := st.GetIndex()
+	var sst *stagingStateTrie
+	if st.IsPersistent() {
+		sst, err = st.makeSnapshotStagingStateTrie()
+		if err != nil {
+			return nil, xerrors.Errorf("getting state: %v", err)
+		}
+		unlock()
+	} else {
+		sst = st.MakeStagingStateTrie()
+	}
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	// Use the hash function of the chain and a timestamp as the next block
+	// would have it, without modifying the request.
+	tx := req.Transaction
+	tx.Instructions = append(Instructions{}, tx.Instructions...)
+	tx.Instructions.SetVersion(header.Version)
+	timestamp := time.Now().UnixNano()
+
+	resp := &SimulateTransactionResponse{
+		Version: CurrentVersion,
+		Fee:     s.mempool.feeOf(tx),
+		Index:   index,
+	}
+
+	// The errors of a dry run must not be seen by the clients and the
+	// metrics of the real transactions.
+	scs, _, cout, _, err := s.runOneTx(sst, tx, req.SkipchainID, timestamp)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp, nil
+	}
+	resp.StateChanges = scs
+	resp.Coins = cout
+
+	return resp, nil
+}
+
 // GetProof searches for a key and returns a proof of the
 // presence or the absence of this key.
 func (s *Service) GetProof(req *GetProof) (*GetProofResponse, error) {
@@ -532,6 +671,89 @@ func (s *Service) GetProof(req *GetProof) (*GetProofResponse, error) {
 	}, nil
 }
 
+// GetMultiProof searches for several keys and returns a single proof of the
+// presence or the absence of each of them.
+func (s *Service) GetMultiProof(req *GetMultiProof) (*GetMultiProofResponse, error) {
+	if len(req.Keys) == 0 {
+		return nil, xerrors.New("no keys")
+	}
+	if len(req.Keys) > maxMultiProofKeys {
+		return nil, xerrors.Errorf("too many keys: %d > %d", len(req.Keys), maxMultiProofKeys)
+	}
+
+	s.catchingLock.Lock()
+	s.updateTrieLock.Lock()
+
+	defer func() {
+		s.updateTrieLock.Unlock()
+		s.catchingLock.Unlock()
+	}()
+
+	s.closedMutex.Lock()
+	defer s.closedMutex.Unlock()
+	if s.closed {
+		return nil, xerrors.New("cannot get proof while in closed state")
+	}
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting proof")
+	}
+	st, err := s.getStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	proof, err := newMultiProof(st, s.db(), req.ID, req.Keys)
+	if err != nil {
+		return nil, xerrors.Errorf("making proof: %w", err)
+	}
+
+	log.Lvlf2("%s: Returning proof for %d keys from chain %x at index %v",
+		s.ServerIdentity(), len(req.Keys), sb.SkipChainID(), sb.Index)
+	return &GetMultiProofResponse{
+		Version: CurrentVersion,
+		Proof:   *proof,
+	}, nil
+}
+
+// GetRangeProof returns a single proof of all the keys whose hash starts
+// with the prefix of the request.
+func (s *Service) GetRangeProof(req *GetRangeProof) (*GetRangeProofResponse, error) {
+	s.catchingLock.Lock()
+	s.updateTrieLock.Lock()
+
+	defer func() {
+		s.updateTrieLock.Unlock()
+		s.catchingLock.Unlock()
+	}()
+
+	s.closedMutex.Lock()
+	defer s.closedMutex.Unlock()
+	if s.closed {
+		return nil, xerrors.New("cannot get proof while in closed state")
+	}
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting proof")
+	}
+	st, err := s.getStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	proof, err := newRangeProof(st, s.db(), req.ID, req.Prefix)
+	if err != nil {
+		return nil, xerrors.Errorf("making proof: %w", err)
+	}
+
+	log.Lvlf2("%s: Returning proof for %d keys from chain %x at index %v",
+		s.ServerIdentity(), len(proof.InclusionProof.Leaves), sb.SkipChainID(), sb.Index)
+	return &GetRangeProofResponse{
+		Version: CurrentVersion,
+		Proof:   *proof,
+	}, nil
+}
+
 // CheckAuthorization verifies whether a given combination of identities can
 // fulfill a given rule of a given darc. Because all darcs are now used in
 // an online fashion, we need to offer this check.
@@ -547,7 +769,80 @@ func (s *Service) CheckAuthorization(req *CheckAuthorization) (resp *CheckAuthor
 	if err != nil {
 		return nil, xerrors.Errorf("couldn't find darc: %v", err)
 	}
-	getDarcs := func(s string, latest bool) *darc.Darc {
+	getDarcs := latestDarcGetter(st)
+	var ids []string
+	for _, i := range req.Identities {
+		ids = append(ids, i.String())
+	}
+	// The delegations are checked at the current time.
+	gs := globalState{st, nil, &currentBlockInfo{time.Now().UnixNano()}}
+	for _, r := range d.Rules.List {
+		ruleDarcs := delegationDarcGetter(gs, d.GetBaseID(), r.Action, getDarcs)
+		err = darc.EvalExprDarc(r.Expr, ruleDarcs, true, ids...)
+		if err == nil {
+			resp.Actions = append(resp.Actions, r.Action)
+		}
+	}
+	return resp, nil
+}
+
+// ExplainAuthorization evaluates the rule of an action of a darc like the
+// verification of an instruction, and returns the steps of the evaluation.
+// The attributes are interpreted on the latest state, at the current time,
+// unless they are assumed to be satisfied by the request.
+func (s *Service) ExplainAuthorization(req *ExplainAuthorization) (*ExplainAuthorizationResponse, error) {
+	log.Lvlf2("%s explaining rule %s of darc %x", s.ServerIdentity(), req.Action, req.DarcID)
+
+	st, err := s.GetReadOnlyStateTrie(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting trie: %v", err)
+	}
+	d, err := st.LoadDarc(req.DarcID)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't find darc: %v", err)
+	}
+	if !d.Rules.Contains(req.Action) {
+		return nil, xerrors.Errorf("action '%v' does not exist", req.Action)
+	}
+
+	gs := globalState{st, nil, &currentBlockInfo{time.Now().UnixNano()}}
+	attrFuncs := MakeAttrInterpreters(gs, Instruction{})
+	assumed := make(map[string]bool)
+	for _, a := range req.Attributes {
+		tokens := strings.SplitN(a, ":", 3)
+		if len(tokens) != 3 || tokens[0] != "attr" {
+			return nil, xerrors.Errorf("invalid attribute %s", a)
+		}
+		assumed[a] = true
+		name := tokens[1]
+		interpreter := attrFuncs[name]
+		attrFuncs[name] = func(value string) error {
+			if assumed["attr:"+name+":"+value] {
+				return nil
+			}
+			if interpreter == nil {
+				return xerrors.New("no such attr interpreter: " + name)
+			}
+			return interpreter(value)
+		}
+	}
+
+	var ids []string
+	for _, i := range req.Identities {
+		ids = append(ids, i.String())
+	}
+	getDarc := delegationDarcGetter(gs, d.GetBaseID(), req.Action, latestDarcGetter(st))
+	steps, err := darc.ExplainExpr(d.Rules.Get(req.Action), getDarc, attrFuncs, ids...)
+	if err != nil {
+		return nil, xerrors.Errorf("evaluating darc: %v", err)
+	}
+	return &ExplainAuthorizationResponse{Steps: steps}, nil
+}
+
+// latestDarcGetter returns a darc.GetDarc that loads the latest darcs from
+// the state trie.
+func latestDarcGetter(st ReadOnlyStateTrie) darc.GetDarc {
+	return func(s string, latest bool) *darc.Darc {
 		if !latest {
 			log.Error("cannot handle intermediate darcs")
 			return nil
@@ -564,17 +859,6 @@ func (s *Service) CheckAuthorization(req *CheckAuthorization) (resp *CheckAuthor
 		}
 		return d
 	}
-	var ids []string
-	for _, i := range req.Identities {
-		ids = append(ids, i.String())
-	}
-	for _, r := range d.Rules.List {
-		err = darc.EvalExprDarc(r.Expr, getDarcs, true, ids...)
-		if err == nil {
-			resp.Actions = append(resp.Actions, r.Action)
-		}
-	}
-	return resp, nil
 }
 
 // GetSignerCounters gets the latest signer counters for the given identities.
@@ -616,10 +900,18 @@ func (s *Service) GetUpdates(pr *GetUpdatesRequest) (*GetUpdatesReply, error) {
 		return nil, xerrors.New("can only give proofs for latest block")
 	}
 
-	st, err := s.GetReadOnlyStateTrie(sb.SkipChainID())
+	st, err := s.getStateTrie(sb.SkipChainID())
 	if err != nil {
 		return nil, xerrors.Errorf("getting state trie: %w", err)
 	}
+	// The proofs are then created from the cache of the trie.
+	ids := make([][]byte, len(pr.Instances))
+	for i := range pr.Instances {
+		ids[i] = pr.Instances[i].ID[:]
+	}
+	if err := st.PrefetchProofs(ids); err != nil {
+		return nil, xerrors.Errorf("prefetching proofs: %v", err)
+	}
 
 	sendVersion0 := pr.Flags&GUFSendVersion0 > 0
 	reply := &GetUpdatesReply{}
@@ -680,10 +972,12 @@ func (s *Service) DownloadState(req *DownloadState) (resp *DownloadStateResponse
 		total := make(chan int)
 		go func(ds downloadState) {
 			idStr := fmt.Sprintf("%x", ds.id)
-			db, bucketName := s.GetAdditionalBucket([]byte(idStr))
-			err := db.View(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
-				total <- bucket.Stats().KeyN
+			n, err := s.trieStore.count(idStr)
+			if err != nil {
+				log.Error("couldn't count the entries of the database:", err)
+			}
+			total <- n
+			err = s.trieStore.db(idStr).View(func(bucket trie.Bucket) error {
 				return bucket.ForEach(func(k []byte, v []byte) error {
 					key := make([]byte, len(k))
 					copy(key, k)
@@ -854,7 +1148,7 @@ type leafNode struct {
 // we normally get from embedding onet.ServiceProcessor in order to
 // hook it and get a look at the http.Request.
 func (s *Service) ProcessClientRequest(req *http.Request, path string, buf []byte) ([]byte, *onet.StreamingTunnel, error) {
-	if path == "Debug" {
+	if path == "Debug" || path == "GetMempoolRequest" {
 		h, _, err := net.SplitHostPort(req.RemoteAddr)
 		if err != nil {
 			return nil, nil, xerrors.Errorf("invalid address: %v", err)
@@ -862,7 +1156,7 @@ func (s *Service) ProcessClientRequest(req *http.Request, path string, buf []byt
 		ip := net.ParseIP(h)
 
 		if !ip.IsLoopback() {
-			return nil, nil, xerrors.New("the 'debug'-endpoint is only allowed on loopback")
+			return nil, nil, xerrors.Errorf("the '%s'-endpoint is only allowed on loopback", path)
 		}
 	}
 
@@ -925,6 +1219,25 @@ func (s *Service) Debug(req *DebugRequest) (resp *DebugResponse, err error) {
 	return
 }
 
+// GetMempool returns the transactions of the given chain that are waiting in
+// the mempool of this node, the highest priority first. Like Debug, it is
+// only allowed from the loopback interface.
+func (s *Service) GetMempool(req *GetMempoolRequest) (*GetMempoolResponse, error) {
+	if len(req.ByzCoinID) == 0 {
+		return nil, xerrors.New("no byzcoin ID given")
+	}
+	return &GetMempoolResponse{
+		Entries: s.mempool.entries(string(req.ByzCoinID)),
+	}, nil
+}
+
+// SetFeeFunc sets the function used by the mempool to get the fee of a
+// transaction. Transactions paying a higher fee per byte are included first
+// in the blocks.
+func (s *Service) SetFeeFunc(f FeeFunc) {
+	s.mempool.setFeeFunc(f)
+}
+
 // DebugRemove deletes an existing byzcoin-instance from the conode.
 func (s *Service) DebugRemove(req *DebugRemoveRequest) (*DebugResponse, error) {
 	if err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public, req.ByzCoinID, req.Signature); err != nil {
@@ -951,15 +1264,9 @@ func (s *Service) DebugRemove(req *DebugRemoveRequest) (*DebugResponse, error) {
 	_, exists = s.stateTries[idStrHex]
 	if exists {
 		log.Lvl2("Removing state-trie")
-		db, bn := s.GetAdditionalBucket([]byte(idStrHex))
-		if db == nil {
-			return nil, xerrors.New("didn't find trie for this byzcoin-ID")
-		}
-		err := db.Update(func(tx *bbolt.Tx) error {
-			return tx.DeleteBucket(bn)
-		})
+		err := s.trieStore.remove(idStrHex)
 		if err != nil {
-			return nil, xerrors.Errorf("deleting bucket: %v", err)
+			return nil, xerrors.Errorf("deleting trie: %v", err)
 		}
 		delete(s.stateTries, idStr)
 		err = s.db().RemoveSkipchain(req.ByzCoinID)
@@ -1001,6 +1308,7 @@ func (s *Service) SetPropagationTimeout(p time.Duration) {
 // inform all nodes to update their internal trie
 // to include the new transactions.
 func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx []TxResult) (*skipchain.SkipBlock, error) {
+	start := time.Now()
 	var sb *skipchain.SkipBlock
 	var mr []byte
 	var sst *stagingStateTrie
@@ -1076,8 +1384,13 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 		return nil, xerrors.New("no transactions")
 	}
 
-	// Store transactions in the body
-	body := &DataBody{TxResults: txRes}
+	events, err := scs.Events()
+	if err != nil {
+		return nil, xerrors.Errorf("getting events: %v", err)
+	}
+
+	// Store transactions and events in the body
+	body := &DataBody{TxResults: txRes, Events: events}
 	sb.Payload, err = protobuf.Encode(body)
 	if err != nil {
 		return nil, xerrors.Errorf("Couldn't marshal data: %v", err)
@@ -1089,6 +1402,7 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 		StateChangesHash:      scs.Hash(),
 		Timestamp:             timestamp,
 		Version:               version,
+		EventsHash:            eventsHash(events),
 	}
 	sb.Data, err = protobuf.Encode(header)
 	if err != nil {
@@ -1129,6 +1443,7 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 	if err != nil {
 		return nil, xerrors.Errorf("storing block: %v", err)
 	}
+	s.metrics.observeBlockCreation(time.Since(start))
 
 	// State changes are cached only when the block is confirmed
 	err = s.stateChangeStorage.append(scs, ssbReply.Latest)
@@ -1190,7 +1505,7 @@ func (s *Service) createUpgradeVersionBlock(scID skipchain.SkipBlockID, version
 }
 
 // downloadDB downloads the full database over the network from a remote block.
-// It does so by copying the bboltDB database entry by entry over the network,
+// It does so by copying the database of the state trie entry by entry over the network,
 // and recreating it on the remote side.
 // sb is a block in the byzcoin instance that we want
 // to download.
@@ -1205,10 +1520,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 		_, err := s.getStateTrie(sb.SkipChainID())
 		if err == nil {
 			// Suppose we _do_ have a statetrie
-			db, stBucket := s.GetAdditionalBucket(sb.SkipChainID())
-			err := db.Update(func(tx *bbolt.Tx) error {
-				return cothority.ErrorOrNil(tx.DeleteBucket(stBucket), "deleting bucket")
-			})
+			err := s.trieStore.remove(idStr)
 			if err != nil {
 				return xerrors.Errorf("Cannot delete existing trie while trying to download: %v", err)
 			}
@@ -1220,8 +1532,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 		// Then start downloading the stateTrie over the network.
 		cl := NewClient(sb.SkipChainID(), *sb.Roster)
 		cl.DontContact(s.ServerIdentity())
-		var db *bbolt.DB
-		var bucketName []byte
+		var db trie.DB
 		var nonce uint64
 		var cursor int
 		for {
@@ -1235,12 +1546,11 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 				cl.noncesSI[resp.Nonce])
 			cursor += len(resp.KeyValues)
 			if db == nil {
-				db, bucketName = s.GetAdditionalBucket([]byte(idStr))
+				db = s.trieStore.db(idStr)
 				nonce = resp.Nonce
 			}
 			// And store all entries in our local database.
-			err = db.Update(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
+			err = db.Update(func(bucket trie.Bucket) error {
 				for _, kv := range resp.KeyValues {
 					err := bucket.Put(kv.Key, kv.Value)
 					if err != nil {
@@ -1258,7 +1568,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 		}
 
 		// Check the new trie is correct
-		st, err := loadStateTrie(db, bucketName)
+		st, err := loadStateTrie(db, s.stateConfig)
 		if err != nil {
 			return xerrors.Errorf("couldn't load state trie: %v", err)
 		}
@@ -1439,6 +1749,7 @@ func (s *Service) catchupFromID(r *onet.Roster, scID skipchain.SkipBlockID, sbID
 // the full DB over the network.
 func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	log.Lvlf1("%v Catching up %x / %d", s.ServerIdentity(), sb.SkipChainID(), sb.Index)
+	s.metrics.incCatchUps()
 
 	// Load the trie.
 	download := false
@@ -1484,45 +1795,43 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	}
 
 	// Check if we are updating the right index.
+	var latest *skipchain.SkipBlock
 	if download {
-		log.Lvl2(s.ServerIdentity(), "Downloading whole DB for catching up")
-		err := s.downloadDB(sb)
-		if err != nil {
-			log.Error("Error while downloading trie:", err)
+		st, latest = s.replaceState(sb)
+		if latest == nil {
+			return
 		}
-
-		// Note: in that case we don't get the previous blocks and therefore we can't
-		// recreate the state changes. The storage will then be filled with new
-		// incoming blocks
-		return
 	}
 
 	// Get the latest block known and processed by the conode
 	trieIndex := st.GetIndex()
-	var reply *skipchain.GetSingleBlockByIndexReply
-	for trieIndex >= 0 {
-		reply, err = s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
-			Genesis: sb.SkipChainID(),
-			Index:   trieIndex,
-		})
-		if err != nil {
-			trieIndex--
-			log.Errorf("%v cannot catch up from block %v: %v, retrying with block before", s.ServerIdentity(), trieIndex, err)
-		} else {
-			// Got it, exit loop.
-			break
+	if latest == nil {
+		var reply *skipchain.GetSingleBlockByIndexReply
+		for trieIndex >= 0 {
+			reply, err = s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
+				Genesis: sb.SkipChainID(),
+				Index:   trieIndex,
+			})
+			if err != nil {
+				trieIndex--
+				log.Errorf("%v cannot catch up from block %v: %v, retrying with block before", s.ServerIdentity(), trieIndex, err)
+			} else {
+				// Got it, exit loop.
+				break
+			}
 		}
-	}
 
-	// All the trieIndex failed and we did not get a reply.
-	if reply == nil || err != nil {
-		log.Errorf("%v could not catch up, tried all previous blocks", s.ServerIdentity())
-		return
-	}
+		// All the trieIndex failed and we did not get a reply.
+		if reply == nil || err != nil {
+			log.Errorf("%v could not catch up, tried all previous blocks", s.ServerIdentity())
+			return
+		}
 
-	latest := reply.SkipBlock
+		latest = reply.SkipBlock
+	}
 
 	// Fetch all missing blocks to fill the hole
+fetch:
 	for trieIndex < sb.Index {
 		log.Lvlf2("%s: our index: %d - latest known index: %d", s.ServerIdentity(), trieIndex, sb.Index)
 		updates, err := cl.GetUpdateChainLevel(sb.Roster, latest.Hash, 1, catchupFetchBlocks)
@@ -1532,8 +1841,27 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 		}
 
 		// This will call updateTrieCallback with the next block to add
-		for _, sb := range updates {
-			log.Lvlf2("Storing block %d: %x", sb.Index, sb.CalculateHash())
+		for _, b := range updates {
+			log.Lvlf2("Storing block %d: %x", b.Index, b.CalculateHash())
+			header, err := decodeBlockHeader(b)
+			if err != nil || !isPruned(b, header) {
+				continue
+			}
+			// The transactions of the block cannot be replayed, so
+			// the state is downloaded instead. The snapshots of the
+			// other nodes cover their pruned blocks.
+			if download {
+				log.Errorf("%v cannot catch up: block %d has been pruned", s.ServerIdentity(), b.Index)
+				return
+			}
+			log.Lvlf2("%v block %d has been pruned", s.ServerIdentity(), b.Index)
+			download = true
+			_, latest = s.replaceState(sb)
+			if latest == nil {
+				return
+			}
+			trieIndex = latest.Index
+			continue fetch
 		}
 		_, err = s.db().StoreBlocks(updates)
 		if err != nil {
@@ -1546,6 +1874,28 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	log.Lvlf2("%v Done catch up %x / %d", s.ServerIdentity(), sb.SkipChainID(), trieIndex)
 }
 
+// replaceState replaces the state of the chain of sb with the latest snapshot
+// of the other nodes, or else with their whole database. It returns the new
+// state trie and the block to continue the catch up from, or a nil block if
+// the catch up cannot continue.
+func (s *Service) replaceState(sb *skipchain.SkipBlock) (*stateTrie, *skipchain.SkipBlock) {
+	log.Lvl2(s.ServerIdentity(), "Downloading snapshot for catching up")
+	st, latest, err := s.downloadSnapshot(sb)
+	if err == nil {
+		return st, latest
+	}
+	log.Lvl2(s.ServerIdentity(), "Couldn't use a snapshot, downloading whole DB for catching up:", err)
+	err = s.downloadDB(sb)
+	if err != nil {
+		log.Error("Error while downloading trie:", err)
+	}
+
+	// Note: in that case we don't get the previous blocks and therefore we can't
+	// recreate the state changes. The storage will then be filled with new
+	// incoming blocks
+	return nil, nil
+}
+
 // updateTrieCallback is registered in skipchain and is called after a
 // skipblock is updated. When this function is called, it is not always after
 // the addition of a new block, but an updates to forward links, for example.
@@ -1634,6 +1984,9 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	if err != nil {
 		return xerrors.Errorf("decoding header: %v", err)
 	}
+	if isPruned(sb, header) {
+		return xerrors.New("the payload of the block has been pruned")
+	}
 
 	var body DataBody
 	err = protobuf.Decode(sb.Payload, &body)
@@ -1643,7 +1996,7 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	}
 
 	log.Lvlf2("%s Updating %d transactions for %x on index %v", s.ServerIdentity(), len(body.TxResults), sb.SkipChainID(), sb.Index)
-	_, _, scs, _ := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout, header.Version, header.Timestamp)
+	_, _, scs, txScs, _ := s.createTxStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout, header.Version, header.Timestamp)
 
 	log.Lvlf3("%s Storing index %d with %d state changes %v",
 		s.ServerIdentity(), sb.Index, len(scs), scs.ShortStrings())
@@ -1658,6 +2011,16 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 		panic("Couldn't append the state changes to the storage - this might " +
 			"mean that the db is broken.")
 	}
+	s.metrics.observeBlock(body.TxResults, s.errorClass)
+	s.mempool.removeIncluded(string(sb.SkipChainID()), body.TxResults)
+
+	if interval := s.getSnapshotInterval(); interval > 0 && sb.Index > 0 &&
+		sb.Index%interval == 0 {
+		s.startSnapshot(st, sb)
+	}
+	if err := s.pruneBlocks(sb); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't prune blocks:", err)
+	}
 
 	// If we are adding a genesis block, then look into it for the darc ID
 	// and add it to the darcToSc hash map.
@@ -1758,7 +2121,7 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	s.notifications.informBlock(sb, body.TxResults)
 
 	// At this point everything should be stored.
-	s.streamingMan.notify(string(sb.SkipChainID()), sb)
+	s.streamingMan.notify(string(sb.SkipChainID()), sb, body.TxResults, txScs)
 
 	log.Lvlf2("%s updated trie for %x with root %x", s.ServerIdentity(), sb.SkipChainID(), st.GetRoot())
 	return nil
@@ -1815,8 +2178,7 @@ func (s *Service) getStateTrie(id skipchain.SkipBlockID) (*stateTrie, error) {
 	idStr := fmt.Sprintf("%x", id)
 	col := s.stateTries[idStr]
 	if col == nil {
-		db, name := s.GetAdditionalBucket([]byte(idStr))
-		st, err := loadStateTrie(db, name)
+		st, err := loadStateTrie(s.trieStore.db(idStr), s.stateConfig)
 		if err != nil {
 			return nil, xerrors.Errorf("getting trie: %v", err)
 		}
@@ -1836,8 +2198,7 @@ func (s *Service) createStateTrie(id skipchain.SkipBlockID, nonce []byte) (*stat
 	if s.stateTries[idStr] != nil {
 		return nil, xerrors.New("state trie already exists")
 	}
-	db, name := s.GetAdditionalBucket([]byte(idStr))
-	st, err := newStateTrie(db, name, nonce)
+	st, err := newStateTrie(s.trieStore.db(idStr), nonce, s.stateConfig)
 	if err != nil {
 		return nil, xerrors.Errorf("making trie: %v", err)
 	}
@@ -2077,6 +2438,25 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		return false
 	}
 
+	if header.Version < VersionEvents {
+		if len(header.EventsHash) > 0 || len(body.Events) > 0 {
+			log.Lvl2(s.ServerIdentity(), "Events are not allowed before version",
+				VersionEvents)
+			return false
+		}
+	} else {
+		events, err := scs.Events()
+		if err != nil {
+			log.Error(s.ServerIdentity(), err)
+			return false
+		}
+		if !bytes.Equal(header.EventsHash, eventsHash(events)) ||
+			!bytes.Equal(header.EventsHash, eventsHash(body.Events)) {
+			log.Lvl2(s.ServerIdentity(), "Events hash doesn't verify")
+			return false
+		}
+	}
+
 	// Compute the new state and check whether the roster in newSB matches
 	// the config.
 	if err := sst.StoreAll(scs); err != nil {
@@ -2159,6 +2539,14 @@ func txSize(txr ...TxResult) (out int) {
 // followers by 1/2.
 func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipBlockID, txIn TxResults, timeout time.Duration, version Version, timestamp int64) (
 	merkleRoot []byte, txOut TxResults, states StateChanges, sstTemp *stagingStateTrie) {
+	merkleRoot, txOut, states, _, sstTemp = s.createTxStateChanges(sst, scID, txIn, timeout, version, timestamp)
+	return
+}
+
+// createTxStateChanges is like createStateChanges but also returns the state
+// changes produced by each transaction of txOut, nil for the refused ones.
+func (s *Service) createTxStateChanges(sst *stagingStateTrie, scID skipchain.SkipBlockID, txIn TxResults, timeout time.Duration, version Version, timestamp int64) (
+	merkleRoot []byte, txOut TxResults, states StateChanges, txStates []StateChanges, sstTemp *stagingStateTrie) {
 	// Make sure that we're using the correct implementation for the
 	// version of the byzcoin protocol.
 	txIn.SetVersion(version)
@@ -2166,7 +2554,7 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 	// If what we want is in the cache, then take it from there. Otherwise
 	// ignore the error and compute the state changes.
 	var err error
-	merkleRoot, txOut, states, err = s.stateChangeCache.get(scID, txIn.Hash())
+	merkleRoot, txOut, states, txStates, err = s.stateChangeCache.getWithTxStates(scID, txIn.Hash())
 	if err == nil {
 		log.Lvlf3("%s: loaded state changes %x from cache", s.ServerIdentity(), scID)
 		return
@@ -2184,51 +2572,75 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 
 	sstTemp = sst.Clone()
 
-	for _, tx := range txIn {
-		txsz := txSize(tx)
+	// The switches of contract versions that are due happen before any
+	// transaction of the block.
+	switchScs, err := s.applyContractSwitches(sstTemp)
+	if err != nil {
+		log.Errorf("%s: couldn't switch contract versions: %+v", s.ServerIdentity(), err)
+		sstTemp = sst.Clone()
+		switchScs = nil
+	}
+	err = nil
+	states = append(states, switchScs...)
+
+	// The state changes of each transaction are kept for the streaming
+	// filters.
+	if timeout == noTimeout && len(txIn) >= parallelMinTxs {
+		// Without a timeout there is nothing to plan, so all transactions
+		// will be executed and this can be done concurrently.
+		txOut, txStates, sstTemp = s.createStateChangesParallel(sstTemp, scID, txIn, timestamp)
+		for _, scs := range txStates {
+			states = append(states, scs...)
+		}
+	} else {
+		for _, tx := range txIn {
+			txsz := txSize(tx)
 
-		var sstTempC *stagingStateTrie
-		var statesTemp StateChanges
-		statesTemp, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID, timestamp)
-		if err != nil {
-			tx.Accepted = false
-			txOut = append(txOut, tx)
-			log.Warnf("%s: %+v", s.ServerIdentity(), err)
-		} else {
-			// We would like to be able to check if this txn is so big it could never fit into a block,
-			// and if so, drop it. But we can't with the current API of createStateChanges.
-			// For now, the only thing we can do is accept or refuse them, but they will go into a block
-			// one way or the other.
-			// TODO: In issue #1409, we will refactor things such that we can drop transactions in here.
-			//if txsz > maxsz {
-			//	log.Errorf("%s transaction size %v is bigger than one block (%v), dropping it.", s.ServerIdentity(), txsz, maxsz)
-			//	continue clientTransactions
-			//}
-
-			// Planning mode:
-			//
-			// Timeout is used when the leader calls createStateChanges as
-			// part of planning which transactions fit into one block.
-			if timeout != noTimeout {
-				if time.Now().After(deadline) {
-					log.Warnf("%s ran out of time after %v", s.ServerIdentity(), timeout)
-					return
-				}
+			var sstTempC *stagingStateTrie
+			var statesTemp StateChanges
+			statesTemp, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID, timestamp)
+			if err != nil {
+				tx.Accepted = false
+				txOut = append(txOut, tx)
+				txStates = append(txStates, nil)
+				log.Warnf("%s: %+v", s.ServerIdentity(), err)
+			} else {
+				// We would like to be able to check if this txn is so big it could never fit into a block,
+				// and if so, drop it. But we can't with the current API of createStateChanges.
+				// For now, the only thing we can do is accept or refuse them, but they will go into a block
+				// one way or the other.
+				// TODO: In issue #1409, we will refactor things such that we can drop transactions in here.
+				//if txsz > maxsz {
+				//	log.Errorf("%s transaction size %v is bigger than one block (%v), dropping it.", s.ServerIdentity(), txsz, maxsz)
+				//	continue clientTransactions
+				//}
+
+				// Planning mode:
+				//
+				// Timeout is used when the leader calls createStateChanges as
+				// part of planning which transactions fit into one block.
+				if timeout != noTimeout {
+					if time.Now().After(deadline) {
+						log.Warnf("%s ran out of time after %v", s.ServerIdentity(), timeout)
+						return
+					}
 
-				// If the last txn would have made the state changes too big, return
-				// just like we do for a timeout. The caller will make a block with
-				// what's in txOut.
-				if blocksz+txsz > maxsz {
-					log.Lvlf3("stopping block creation when %v > %v, with len(txOut) of %v", blocksz+txsz, maxsz, len(txOut))
-					return
+					// If the last txn would have made the state changes too big, return
+					// just like we do for a timeout. The caller will make a block with
+					// what's in txOut.
+					if blocksz+txsz > maxsz {
+						log.Lvlf3("stopping block creation when %v > %v, with len(txOut) of %v", blocksz+txsz, maxsz, len(txOut))
+						return
+					}
 				}
-			}
 
-			tx.Accepted = true
-			sstTemp = sstTempC
-			blocksz += txsz
-			states = append(states, statesTemp...)
-			txOut = append(txOut, tx)
+				tx.Accepted = true
+				sstTemp = sstTempC
+				blocksz += txsz
+				states = append(states, statesTemp...)
+				txOut = append(txOut, tx)
+				txStates = append(txStates, statesTemp)
+			}
 		}
 	}
 
@@ -2237,17 +2649,11 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 	// Store the result in the cache before returning.
 	merkleRoot = sstTemp.GetRoot()
 	if len(states) != 0 && len(txOut) != 0 {
-		s.stateChangeCache.update(scID, txOut.Hash(), merkleRoot, txOut, states)
+		s.stateChangeCache.update(scID, txOut.Hash(), merkleRoot, txOut, states, txStates)
 	}
 	return
 }
 
-// addError simply stores the given error using the hash with signatures of the
-// given instruction as the key.
-func (s *Service) addError(tx ClientTransaction, err error) {
-	s.txErrorBuf.add(tx.Instructions.HashWithSignatures(), err.Error())
-}
-
 // ComputeSeed is used to compute the seed provided as argument to the
 // `Spawn()` synthetic instructions, generated by EVM executions. It can also
 // be used by clients in order to determine the seed and the InstanceID of
@@ -2263,6 +2669,36 @@ func ComputeSeed(instr Instruction, index uint8) []byte {
 // from the trie should be read from sst and not the service.
 func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 	scID skipchain.SkipBlockID, timestamp int64) (StateChanges, *stagingStateTrie, error) {
+	scs, sstOut, cout, err := s.processOneTxCoins(sst, tx, scID, timestamp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cout) != 0 {
+		log.Lvl2(s.ServerIdentity(), "Leftover coins detected, discarding.")
+	}
+
+	return scs, sstOut, nil
+}
+
+// processOneTxCoins is the same as processOneTx, but also returns the coins
+// that are left over after the last instruction.
+func (s *Service) processOneTxCoins(sst *stagingStateTrie, tx ClientTransaction,
+	scID skipchain.SkipBlockID, timestamp int64) (StateChanges, *stagingStateTrie, []Coin, error) {
+	scs, sstOut, cout, class, err := s.runOneTx(sst, tx, scID, timestamp)
+	if err != nil {
+		if class != "" {
+			s.addError(tx, class, err)
+		}
+		return nil, nil, nil, err
+	}
+	return scs, sstOut, cout, nil
+}
+
+// runOneTx executes the transaction like processOneTxCoins, but doesn't
+// record the error for the clients and the metrics. The class of the error
+// is returned instead, and is empty for errors that are not recorded.
+func (s *Service) runOneTx(sst *stagingStateTrie, tx ClientTransaction,
+	scID skipchain.SkipBlockID, timestamp int64) (StateChanges, *stagingStateTrie, []Coin, string, error) {
 
 	// Make a new trie for each instruction. If the instruction is
 	// sucessfully implemented and changes applied, then keep it
@@ -2273,7 +2709,13 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 	roSC := newROSkipChain(s.skService(), scID)
 	gs := globalState{sst, roSC, &currentBlockInfo{timestamp}}
 
-	h := tx.Instructions.Hash()
+	err := tx.verifyWindow(sst.GetVersion(), sst.GetIndex()+1, timestamp)
+	if err != nil {
+		err = xerrors.Errorf("%s refused transaction: %v", s.ServerIdentity(), err)
+		return nil, nil, nil, errClassWindow, err
+	}
+
+	h := tx.Hash()
 	var statesTemp StateChanges
 	var cin []Coin
 	for i := 0; i < len(tx.Instructions); i++ {
@@ -2288,16 +2730,14 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 			}
 			err = xerrors.Errorf("%s Contract %s got %x and returned error: %v",
 				s.ServerIdentity(), cid, instr.Hash(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, nil, errClassContract, err
 		}
 
 		counterScs, err := incrementSignerCounters(sst, instr.SignerIdentities)
 		if err != nil {
 			err = xerrors.Errorf("%s failed to update signature counters: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, nil, errClassCounter, err
 		}
 
 		// Counter used in the seed provided to generated Spawn instructions.
@@ -2335,13 +2775,11 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 					err = xerrors.Errorf("%s couldn't get contractID from the "+
 						"following instruction: %x (with instanceID %x)",
 						s.ServerIdentity(), instr.Hash(), instr.InstanceID.Slice())
-					s.addError(tx, err)
-					return nil, nil, err
+					return nil, nil, nil, errClassState, err
 				}
 				err = xerrors.Errorf("%s: contract %s %s %x", s.ServerIdentity(),
 					contractID, reason, sc.InstanceID)
-				s.addError(tx, err)
-				return nil, nil, err
+				return nil, nil, nil, errClassState, err
 			}
 			log.Lvlf2("StateChange %s for id %x - contract: %s", sc.StateAction,
 				sc.InstanceID, sc.ContractID)
@@ -2350,7 +2788,7 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 				var newInstr Instruction
 				err = protobuf.Decode(sc.Value, &newInstr)
 				if err != nil {
-					return nil, nil, xerrors.Errorf("failed to decode "+
+					return nil, nil, nil, "", xerrors.Errorf("failed to decode "+
 						"new instruction: %v", err)
 				}
 
@@ -2374,11 +2812,24 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 				continue
 			}
 
+			if sc.StateAction == EmitEvent {
+				if sst.GetVersion() < VersionEvents {
+					err = xerrors.Errorf("%s: contract %s emitted an event before version %d",
+						s.ServerIdentity(), sc.ContractID, VersionEvents)
+					return nil, nil, nil, errClassEvent, err
+				}
+				if _, err = decodeEvent(sc); err != nil {
+					err = xerrors.Errorf("%s: contract %s emitted an invalid event: %v",
+						s.ServerIdentity(), sc.ContractID, err)
+					return nil, nil, nil, errClassEvent, err
+				}
+				continue
+			}
+
 			err = sst.StoreAll(StateChanges{sc})
 			if err != nil {
 				err = xerrors.Errorf("%s StoreAll failed: %v", s.ServerIdentity(), err)
-				s.addError(tx, err)
-				return nil, nil, err
+				return nil, nil, nil, errClassStorage, err
 			}
 		}
 
@@ -2393,18 +2844,14 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 		if err = sst.StoreAll(counterScs); err != nil {
 			err = xerrors.Errorf("%s StoreAll failed to add counter changes: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, nil, errClassStorage, err
 		}
 		statesTemp = append(statesTemp, scs...)
 		statesTemp = append(statesTemp, counterScs...)
 		cin = cout
 	}
-	if len(cin) != 0 {
-		log.Lvl2(s.ServerIdentity(), "Leftover coins detected, discarding.")
-	}
 
-	return statesTemp, sst, nil
+	return statesTemp, sst, cin, "", nil
 }
 
 // GetContractConstructor gets the contract constructor of the contract
@@ -2453,7 +2900,9 @@ func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
 		return
 	}
 
-	contractFactory, exists := s.GetContractConstructor(contractID)
+	// The registry gives the version of the contract active in this state.
+	reg := s.contracts.withState(gs)
+	contractFactory, exists := reg.Search(contractID)
 	if !exists {
 		if ConfigInstanceID.Equal(instr.InstanceID) {
 			// Special case 1: first time call to
@@ -2487,7 +2936,7 @@ func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
 		return
 	}
 	if sc, ok := c.(ContractWithRegistry); ok {
-		sc.SetRegistry(s.contracts)
+		sc.SetRegistry(reg)
 	}
 
 	err = c.VerifyInstruction(gs, instr, ctxHash)
@@ -2520,6 +2969,10 @@ func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
 			log.Errorf("Found unknown contract ID \"%s\"", sc.ContractID)
 			return nil, nil, xerrors.New("unknown contract ID")
 		}
+		// Events are not stored as versions of their instance.
+		if sc.StateAction == EmitEvent {
+			continue
+		}
 
 		ver, ok := vv[hex.EncodeToString(sc.InstanceID)]
 		if !ok {
@@ -2597,7 +3050,18 @@ func (s *Service) getTxs(leader *network.ServerIdentity, roster *onet.Roster, sc
 
 	s.heartbeats.beat(string(scID))
 
-	return s.txBuffer.take(string(scID), maxNumTxs)
+	st, err := s.getStateTrie(scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), err)
+		return []ClientTransaction{}
+	}
+	return s.mempool.take(string(scID), maxNumTxs, poolState{
+		counter: func(id string) (uint64, error) {
+			return getSignerCounter(st, id)
+		},
+		index:     st.GetIndex() + 1,
+		timestamp: time.Now().UnixNano(),
+	})
 }
 
 // loadNonceFromTxs gets the nonce from a TxResults. This only works for the genesis-block.
@@ -2630,6 +3094,9 @@ func (s *Service) TestClose() {
 		s.closedMutex.Unlock()
 		s.cleanupGoroutines()
 		s.working.Wait()
+		if err := s.trieStore.close(); err != nil {
+			log.Error("closing the storage of the tries:", err)
+		}
 	} else {
 		s.closedMutex.Unlock()
 	}
@@ -2641,6 +3108,11 @@ func (s *Service) TestRestart() error {
 	if err := s.skService().TestRestart(); err != nil {
 		return err
 	}
+	var err error
+	s.trieStore, err = newTrieStore(s.Context)
+	if err != nil {
+		return xerrors.Errorf("opening the storage of the tries: %v", err)
+	}
 	return s.startAllChains()
 }
 
@@ -2649,6 +3121,7 @@ func (s *Service) cleanupGoroutines() {
 	s.closeLeaderMonitorChan <- true
 	s.viewChangeMan.closeAll()
 	s.streamingMan.stopAll()
+	s.unregisterHTTP()
 
 	s.pollChanMut.Lock()
 	for k, c := range s.pollChan {
@@ -2740,6 +3213,10 @@ func (s *Service) startAllChains() error {
 	s.closed = false
 	s.closedMutex.Unlock()
 
+	if err := s.registerHTTP(); err != nil {
+		return xerrors.Errorf("registering HTTP handlers: %v", err)
+	}
+
 	// Recreate the polling channles.
 	s.pollChanMut.Lock()
 	s.pollChan = make(map[string]chan bool)
@@ -2925,6 +3402,14 @@ func (s *Service) getBlockTx(sid skipchain.SkipBlockID) (TxResults, *skipchain.S
 		return nil, nil, err
 	}
 
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isPruned(sb, header) {
+		return nil, nil, xerrors.New("the payload of the block has been pruned")
+	}
+
 	var body DataBody
 	err = protobuf.Decode(sb.Payload, &body)
 	if err != nil {
@@ -3005,6 +3490,9 @@ func (s *Service) repairStateTrie(from *skipchain.SkipBlock, st *stateTrie) erro
 		if err != nil {
 			return xerrors.Errorf("decoding header: %v", err)
 		}
+		if isPruned(from, header) {
+			return xerrors.New("cannot repair from pruned blocks")
+		}
 
 		var body DataBody
 		if err := protobuf.Decode(from.Payload, &body); err != nil {
@@ -3044,15 +3532,16 @@ var existingDB = regexp.MustCompile(`^ByzCoin_[0-9a-f]+$`)
 // running on. Saving and loading can be done using the context. The data will
 // be stored in memory for tests and simulations, and on disk for real
 // deployments.
-func newService(c *onet.Context) (onet.Service, error) {
+func newService(c *onet.Context) (_ onet.Service, err error) {
 	s := &Service{
 		ServiceProcessor:       onet.NewServiceProcessor(c),
 		contracts:              globalContractRegistry.clone(),
-		txBuffer:               newTxBuffer(),
+		mempool:                newMempool(),
 		storage:                &bcStorage{},
 		darcToSc:               make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:       newStateChangeCache(),
 		stateChangeStorage:     newStateChangeStorage(c),
+		snapshots:              newSnapshotStorage(c),
 		heartbeatsTimeout:      make(chan string, 1),
 		closeLeaderMonitorChan: make(chan bool, 1),
 		heartbeats:             newHeartbeats(),
@@ -3064,16 +3553,40 @@ func newService(c *onet.Context) (onet.Service, error) {
 		defaultVersion:         CurrentVersion,
 		// We need a large enough buffer for all errors in 2 blocks
 		// where each block might be 1 MB in size and each tx is 1 KB.
-		txErrorBuf: newRingBuf(2048),
+		txErrorBuf:      newRingBuf(2048),
+		txErrorClassBuf: newRingBuf(2048),
+		metrics:         newServiceMetrics(),
+	}
+
+	nc := getNodeConfig()
+	s.SetSnapshotInterval(nc.SnapshotInterval)
+	s.SetPruneDepth(nc.PruneDepth)
+	s.stateConfig = stateTrieConfig{
+		retention: nc.StateRetention,
+		cacheSize: nc.StateTrieCacheSize,
+	}
+
+	s.trieStore, err = newTrieStore(c)
+	if err != nil {
+		return nil, xerrors.Errorf("opening the storage of the tries: %v", err)
 	}
+	defer func() {
+		if err != nil {
+			s.trieStore.close()
+		}
+	}()
 
-	err := s.RegisterHandlers(
+	err = s.RegisterHandlers(
 		s.GetAllByzCoinIDs,
 		s.CreateGenesisBlock,
 		s.AddTransaction,
+		s.SimulateTransaction,
 		s.GetProof,
+		s.GetMultiProof,
+		s.GetRangeProof,
 		s.GetUpdates,
 		s.CheckAuthorization,
+		s.ExplainAuthorization,
 		s.GetSignerCounters,
 		s.DownloadState,
 		s.GetInstanceVersion,
@@ -3082,15 +3595,20 @@ func newService(c *onet.Context) (onet.Service, error) {
 		s.CheckStateChangeValidity,
 		s.ResolveInstanceID,
 		s.Debug,
-		s.DebugRemove)
+		s.DebugRemove,
+		s.GetMempool,
+		s.GetSnapshot,
+		s.GetSnapshotChunk)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.PaginateBlocks); err != nil {
+	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.PaginateBlocks,
+		s.StreamEvents); err != nil {
 		return nil, xerrors.Errorf("registering handlers: %v", err)
 	}
 	s.RegisterProcessorFunc(viewChangeMsgID, s.handleViewChangeReq)
+	s.RegisterStatusReporter("ByzCoin", s)
 
 	if err := skipchain.RegisterVerification(c, Verify, s.verifySkipBlock); err != nil {
 		log.ErrFatal(err)
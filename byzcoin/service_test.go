@@ -42,6 +42,7 @@ const panicContract = "panic"
 const invalidContract = "invalid"
 const versionContract = "testVersionContract"
 const stateChangeCacheContract = "stateChangeCacheTest"
+const emitterContract = "emitter"
 
 func TestMain(m *testing.M) {
 	log.SetShowTime(true)
@@ -627,6 +628,116 @@ func TestService_GetProof(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestService_SimulateTransaction(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	tx, err := createOneClientTx(s.darc.GetBaseID(), dummyContract, s.value, s.signer)
+	require.NoError(t, err)
+	resp, err := s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Error)
+	require.Equal(t, 0, resp.Index)
+	require.Zero(t, resp.Fee)
+	// One state change for the new instance and one for the counter.
+	require.Equal(t, 2, len(resp.StateChanges))
+	require.Equal(t, Create, resp.StateChanges[0].StateAction)
+	require.Equal(t, dummyContract, resp.StateChanges[0].ContractID)
+
+	// Nothing must have been stored or buffered.
+	require.Empty(t, s.service().mempool.entries(string(s.genesis.SkipChainID())))
+	key := resp.StateChanges[0].InstanceID
+	rep, err := s.service().GetProof(&GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.SkipChainID(),
+		Key:     key,
+	})
+	require.NoError(t, err)
+	require.False(t, rep.Proof.InclusionProof.Match(key))
+
+	// A refused transaction returns the reason.
+	tx, err = createOneClientTx(s.darc.GetBaseID(), invalidContract, s.value, s.signer)
+	require.NoError(t, err)
+	resp, err = s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Error, "this invalid contract always returns an error")
+	require.Empty(t, resp.StateChanges)
+	// The error of a dry run is not seen by the clients.
+	_, ok := s.service().txErrorBuf.get(tx.Instructions.HashWithSignatures())
+	require.False(t, ok)
+
+	// The fee is the one of the fee function of the node.
+	s.service().SetFeeFunc(func(tx ClientTransaction) uint64 {
+		return 10 * uint64(len(tx.Instructions))
+	})
+	resp, err = s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), resp.Fee)
+
+	// An unknown chain is refused like for AddTransaction.
+	_, err = s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: skipchain.SkipBlockID("unknown"),
+		Transaction: tx,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist")
+}
+
+func TestService_TxWindow(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	newTx := func(counter uint64, notBefore, notAfter *TxBound) ClientTransaction {
+		instr := createSpawnInstr(s.darc.GetBaseID(), dummyContract, "data", s.value)
+		instr.SignerCounter = []uint64{counter}
+		instr.SignerIdentities = []darc.Identity{s.signer.Identity()}
+		tx := ClientTransaction{
+			Instructions: Instructions{instr},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+		}
+		require.NoError(t, tx.SignWith(s.signer))
+		return tx
+	}
+
+	resp, err := s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: newTx(1, &TxBound{Index: 10}, nil),
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Error, "not valid before")
+
+	// Changing the window after signing breaks the signature.
+	tx := newTx(1, &TxBound{Index: 1}, &TxBound{Index: 10})
+	tx.NotAfter.Index = 11
+	resp, err = s.service().SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: s.genesis.SkipChainID(),
+		Transaction: tx,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.Error)
+
+	tx = newTx(1, &TxBound{Index: 1},
+		&TxBound{Timestamp: time.Now().Add(time.Hour).UnixNano()})
+	s.sendTxAndWait(t, tx, 10)
+	s.waitProof(t, NewInstanceID(tx.Instructions[0].Hash()))
+}
+
 func TestService_DarcProxy(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -1561,6 +1672,38 @@ func TestService_CheckAuthorization(t *testing.T) {
 	require.Contains(t, resp.Actions, darc.Action("spawn:"+ContractDarcID))
 }
 
+func TestService_ExplainAuthorization(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	req := &ExplainAuthorization{
+		Version:    CurrentVersion,
+		ByzCoinID:  s.genesis.SkipChainID(),
+		DarcID:     s.darc.GetBaseID(),
+		Action:     "_sign",
+		Identities: []darc.Identity{s.signer.Identity()},
+	}
+	resp, err := s.service().ExplainAuthorization(req)
+	require.NoError(t, err)
+	require.True(t, resp.Steps[0].Satisfied)
+
+	other := darc.NewSignerEd25519(nil, nil).Identity()
+	req.Identities = []darc.Identity{other}
+	resp, err = s.service().ExplainAuthorization(req)
+	require.NoError(t, err)
+	require.False(t, resp.Steps[0].Satisfied)
+	require.Equal(t, "not one of the signers", resp.Steps[len(resp.Steps)-1].Reason)
+
+	req.Attributes = []string{"block:after=1"}
+	_, err = s.service().ExplainAuthorization(req)
+	require.Error(t, err)
+
+	req.Attributes = nil
+	req.Action = "spawn:nonexistent"
+	_, err = s.service().ExplainAuthorization(req)
+	require.Error(t, err)
+}
+
 func TestService_GetLeader(t *testing.T) {
 	s := newSer(t, 1, testInterval)
 	defer s.local.CloseAll()
@@ -2798,6 +2941,7 @@ func newSerWithVersion(t *testing.T, step int, interval time.Duration, n int, rw
 			"spawn:" + slowContract,
 			"spawn:" + versionContract,
 			"spawn:" + stateChangeCacheContract,
+			"spawn:" + emitterContract,
 			"delete:" + dummyContract,
 		}, s.signer.Identity())
 	require.NoError(t, err)
@@ -0,0 +1,569 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// defaultSnapshotInterval is the number of blocks between two snapshots of the
+// global state, if the conode doesn't configure it.
+const defaultSnapshotInterval = 1000
+
+// How many key/value pairs go in one snapshot chunk. All nodes must use the
+// same value, else their chunks have different hashes and a new node can only
+// download from the node that gave it the manifest.
+var snapshotChunkSize = 100
+
+var bucketSnapshots = []byte("snapshots")
+
+var snapshotManifestKey = []byte("manifest")
+
+// snapshotStorage keeps the latest snapshot of each chain. Every chain has
+// its own bucket, holding the manifest and the encoded chunks indexed by
+// their hash.
+type snapshotStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newSnapshotStorage(c *onet.Context) *snapshotStorage {
+	db, name := c.GetAdditionalBucket(bucketSnapshots)
+	return &snapshotStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// putChunk stores an encoded chunk and returns its hash.
+func (s *snapshotStorage) putChunk(scID skipchain.SkipBlockID, buf []byte) ([]byte, error) {
+	h := sha256.Sum256(buf)
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(scID)
+		if err != nil {
+			return err
+		}
+		return b.Put(h[:], buf)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("storing chunk: %v", err)
+	}
+	return h[:], nil
+}
+
+// setManifest replaces the manifest of the chain and removes the chunks of
+// the previous snapshot.
+func (s *snapshotStorage) setManifest(scID skipchain.SkipBlockID, m *SnapshotManifest) error {
+	buf, err := protobuf.Encode(m)
+	if err != nil {
+		return xerrors.Errorf("encoding manifest: %v", err)
+	}
+	keep := make(map[string]bool)
+	for _, h := range m.Chunks {
+		keep[string(h)] = true
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(scID)
+		if err != nil {
+			return err
+		}
+		var obsolete [][]byte
+		err = b.ForEach(func(k, v []byte) error {
+			if !keep[string(k)] && !bytes.Equal(k, snapshotManifestKey) {
+				obsolete = append(obsolete, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range obsolete {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put(snapshotManifestKey, buf)
+	})
+}
+
+// getManifest returns the manifest of the latest snapshot of the chain.
+func (s *snapshotStorage) getManifest(scID skipchain.SkipBlockID) (*SnapshotManifest, error) {
+	var buf []byte
+	s.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket(s.bucket).Bucket(scID); b != nil {
+			buf = append([]byte{}, b.Get(snapshotManifestKey)...)
+		}
+		return nil
+	})
+	if len(buf) == 0 {
+		return nil, xerrors.New("no snapshot for this chain")
+	}
+	m := &SnapshotManifest{}
+	if err := protobuf.Decode(buf, m); err != nil {
+		return nil, xerrors.Errorf("decoding manifest: %v", err)
+	}
+	return m, nil
+}
+
+// getChunk returns the encoded chunk with the given hash.
+func (s *snapshotStorage) getChunk(scID skipchain.SkipBlockID, hash []byte) ([]byte, error) {
+	var buf []byte
+	s.db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket(s.bucket).Bucket(scID); b != nil && !bytes.Equal(hash, snapshotManifestKey) {
+			buf = append([]byte{}, b.Get(hash)...)
+		}
+		return nil
+	})
+	if len(buf) == 0 {
+		return nil, xerrors.New("unknown chunk")
+	}
+	return buf, nil
+}
+
+// makeSnapshot splits the global state at root into chunks that are given to
+// put, and adds their hashes to the manifest. The chunks only depend on the
+// state, so all nodes create the same chunks for the same block. Every chunk
+// is read in its own read transaction, so that the database isn't held during
+// the whole snapshot and put can write to it. As a trie that isn't persistent
+// removes the nodes of root once the state is updated, makeSnapshot then
+// fails.
+func makeSnapshot(st *stateTrie, root []byte, m *SnapshotManifest, put func(buf []byte) ([]byte, error)) error {
+	nonce, err := st.GetNonce()
+	if err != nil {
+		return xerrors.Errorf("getting nonce: %v", err)
+	}
+	return addSnapshotChunks(m, nonce, root, func(key []byte) ([]trie.Proof, error) {
+		return st.ProofsAfter(root, key, snapshotChunkSize)
+	}, put)
+}
+
+// makeSnapshotAt is like makeSnapshot for a retained root of a persistent
+// state trie, which stays valid while the state is updated.
+func makeSnapshotAt(snap *trie.Snapshot, m *SnapshotManifest, put func(buf []byte) ([]byte, error)) error {
+	nonce, err := snap.GetNonce()
+	if err != nil {
+		return xerrors.Errorf("getting nonce: %v", err)
+	}
+	return addSnapshotChunks(m, nonce, snap.GetRoot(), func(key []byte) ([]trie.Proof, error) {
+		return snap.ProofsAfter(key, snapshotChunkSize)
+	}, put)
+}
+
+// addSnapshotChunks fills the manifest with the chunks of the pages of proofs
+// that come after the given key, until an empty page.
+func addSnapshotChunks(m *SnapshotManifest, nonce, root []byte,
+	page func(key []byte) ([]trie.Proof, error), put func(buf []byte) ([]byte, error)) error {
+	m.Nonce = nonce
+	m.TrieRoot = root
+	m.Chunks = nil
+
+	var key []byte
+	for {
+		proofs, err := page(key)
+		if err != nil {
+			return xerrors.Errorf("getting proofs: %v", err)
+		}
+		if len(proofs) == 0 {
+			return nil
+		}
+		if err := addSnapshotChunk(m, &SnapshotChunk{Proofs: proofs}, put); err != nil {
+			return err
+		}
+		key = proofs[len(proofs)-1].Key()
+	}
+}
+
+// addSnapshotChunk encodes the chunk, gives it to put and adds its hash to
+// the manifest.
+func addSnapshotChunk(m *SnapshotManifest, chunk *SnapshotChunk, put func(buf []byte) ([]byte, error)) error {
+	buf, err := protobuf.Encode(chunk)
+	if err != nil {
+		return xerrors.Errorf("encoding chunk: %v", err)
+	}
+	h, err := put(buf)
+	if err != nil {
+		return cothority.ErrorOrNil(err, "storing chunk")
+	}
+	m.Chunks = append(m.Chunks, h)
+	return nil
+}
+
+// verifyChunk checks that the chunk has the given hash and that all its
+// key/value pairs are included in the state of the snapshot.
+func (m *SnapshotManifest) verifyChunk(hash, buf []byte) (*SnapshotChunk, error) {
+	h := sha256.Sum256(buf)
+	if !bytes.Equal(h[:], hash) {
+		return nil, xerrors.New("chunk doesn't match its hash")
+	}
+	chunk := &SnapshotChunk{}
+	if err := protobuf.Decode(buf, chunk); err != nil {
+		return nil, xerrors.Errorf("decoding chunk: %v", err)
+	}
+	for i, p := range chunk.Proofs {
+		if !bytes.Equal(p.Nonce, m.Nonce) {
+			return nil, xerrors.Errorf("proof %d has a wrong nonce", i)
+		}
+		if !bytes.Equal(p.GetRoot(), m.TrieRoot) {
+			return nil, xerrors.Errorf("proof %d has a wrong root", i)
+		}
+		ok, err := p.Exists(p.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("proof %d is invalid: %v", i, err)
+		}
+		if !ok {
+			return nil, xerrors.Errorf("proof %d is not an inclusion proof", i)
+		}
+	}
+	return chunk, nil
+}
+
+// SetSnapshotInterval sets the number of blocks between two snapshots of the
+// global state: a snapshot is taken after every block whose index is a
+// multiple of interval. A negative interval disables the snapshots, and 0
+// uses the default interval.
+func (s *Service) SetSnapshotInterval(interval int) {
+	switch {
+	case interval < 0:
+		interval = 0
+	case interval == 0:
+		interval = defaultSnapshotInterval
+	}
+	s.snapshotLock.Lock()
+	s.snapshotInterval = interval
+	s.snapshotLock.Unlock()
+}
+
+// getSnapshotInterval returns the snapshot interval, 0 if the snapshots are
+// disabled.
+func (s *Service) getSnapshotInterval() int {
+	s.snapshotLock.Lock()
+	defer s.snapshotLock.Unlock()
+	return s.snapshotInterval
+}
+
+// startSnapshot creates a snapshot of the global state in the background, so
+// that the blocks are not held up while the proofs are computed. It must be
+// called right after the block sb has been applied to the state, with
+// updateTrieLock and closedMutex held, while the service is not closed. Only
+// one snapshot is created at a time, and a snapshot is skipped if the
+// previous one is still running.
+//
+// If the state trie is persistent, the snapshot is read from the root of sb,
+// which is retained with all the following ones until the snapshot is done.
+// Otherwise the snapshot fails if the state is updated before it is done, and
+// the next one is taken at the next interval.
+func (s *Service) startSnapshot(st *stateTrie, sb *skipchain.SkipBlock) {
+	s.snapshotLock.Lock()
+	if s.snapshotRunning {
+		s.snapshotLock.Unlock()
+		log.Warnf("%s: skipping the snapshot of block %d, the previous one "+
+			"is still running", s.ServerIdentity(), sb.Index)
+		return
+	}
+	s.snapshotRunning = true
+	s.snapshotLock.Unlock()
+
+	root := st.GetRoot()
+	var snap *trie.Snapshot
+	retention := st.Retention()
+	if st.IsPersistent() {
+		var err error
+		snap, err = st.At(st.GetRoot())
+		if err != nil {
+			log.Error(s.ServerIdentity(), "couldn't create snapshot:", err)
+			s.snapshotLock.Lock()
+			s.snapshotRunning = false
+			s.snapshotLock.Unlock()
+			return
+		}
+		// No root is released while all of them are retained.
+		st.SetRetention(0)
+	}
+
+	s.working.Add(1)
+	go func() {
+		defer s.working.Done()
+		defer func() {
+			s.snapshotLock.Lock()
+			s.snapshotRunning = false
+			s.snapshotLock.Unlock()
+		}()
+
+		m := &SnapshotManifest{
+			BlockID: sb.Hash,
+			Index:   sb.Index,
+		}
+		put := func(buf []byte) ([]byte, error) {
+			return s.snapshots.putChunk(sb.SkipChainID(), buf)
+		}
+		var err error
+		if snap != nil {
+			err = makeSnapshotAt(snap, m, put)
+		} else {
+			err = makeSnapshot(st, root, m, put)
+		}
+		if err == nil {
+			err = cothority.ErrorOrNil(s.snapshots.setManifest(sb.SkipChainID(), m),
+				"storing manifest")
+		}
+
+		s.updateTrieLock.Lock()
+		defer s.updateTrieLock.Unlock()
+		if snap != nil {
+			st.SetRetention(retention)
+			if err := st.GC(); err != nil {
+				log.Error(s.ServerIdentity(), "couldn't release the states:", err)
+			}
+		}
+		if err != nil {
+			log.Error(s.ServerIdentity(), "couldn't create snapshot:", err)
+			return
+		}
+
+		// The new snapshot might allow to prune more blocks.
+		latest, err := s.db().GetLatestByID(sb.SkipChainID())
+		if err == nil {
+			err = s.pruneBlocks(latest)
+		}
+		if err != nil {
+			log.Error(s.ServerIdentity(), "couldn't prune blocks:", err)
+		}
+	}()
+}
+
+// GetSnapshot returns the manifest of the latest snapshot of the global state
+// of the chain.
+func (s *Service) GetSnapshot(req *GetSnapshot) (*GetSnapshotResponse, error) {
+	m, err := s.snapshots.getManifest(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting snapshot: %v", err)
+	}
+	return &GetSnapshotResponse{Manifest: *m}, nil
+}
+
+// GetSnapshotChunk returns one chunk of the latest snapshot of the chain.
+func (s *Service) GetSnapshotChunk(req *GetSnapshotChunk) (*GetSnapshotChunkResponse, error) {
+	buf, err := s.snapshots.getChunk(req.ByzCoinID, req.Hash)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chunk: %v", err)
+	}
+	return &GetSnapshotChunkResponse{Chunk: buf}, nil
+}
+
+// verifySnapshotBlock fetches the block of the snapshot and checks that it is
+// part of the chain given by its genesis block, and that its root matches the
+// snapshot.
+func verifySnapshotBlock(roster *onet.Roster, genesis *skipchain.SkipBlock,
+	m *SnapshotManifest, skCl *skipchain.Client) (*skipchain.SkipBlock, *DataHeader, error) {
+	reply, err := skCl.GetSingleBlockByIndex(roster, genesis.Hash, m.Index)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting block: %v", err)
+	}
+	sb := reply.SkipBlock
+	if !sb.Hash.Equal(m.BlockID) {
+		return nil, nil, xerrors.New("snapshot is not tied to the block at its index")
+	}
+	if len(reply.Links) == 0 {
+		return nil, nil, cothority.WrapError(ErrorMissingForwardLinks)
+	}
+	links := make([]skipchain.ForwardLink, len(reply.Links))
+	for i, l := range reply.Links {
+		links[i] = *l
+	}
+	// The genesis block has been verified against the ID of the chain, so
+	// its roster can be trusted for the synthetic first link.
+	links[0].NewRoster = genesis.Roster
+	if err := verifyLinks(genesis.Hash, sb, links); err != nil {
+		return nil, nil, xerrors.Errorf("verifying links: %v", err)
+	}
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(header.TrieRoot, m.TrieRoot) {
+		return nil, nil, cothority.WrapError(ErrorVerifyTrieRoot)
+	}
+	return sb, header, nil
+}
+
+// fetchSnapshotChunks downloads all chunks of the snapshot, using one
+// go-routine per node. Every chunk is verified as it arrives and given to
+// store. A node that fails to deliver a valid chunk is not asked again, and
+// its chunk goes to the other nodes.
+func fetchSnapshotChunks(cl *Client, nodes []*network.ServerIdentity, m *SnapshotManifest,
+	store func(hash, buf []byte, chunk *SnapshotChunk) error) error {
+	if len(m.Chunks) == 0 {
+		return nil
+	}
+	if len(nodes) == 0 {
+		return xerrors.New("no nodes to download from")
+	}
+
+	jobs := make(chan []byte, len(m.Chunks))
+	for _, h := range m.Chunks {
+		jobs <- h
+	}
+	remaining := int32(len(m.Chunks))
+	alive := int32(len(nodes))
+	finished := make(chan struct{})
+	failed := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, si := range nodes {
+		wg.Add(1)
+		go func(si *network.ServerIdentity) {
+			defer wg.Done()
+			for {
+				var h []byte
+				select {
+				case h = <-jobs:
+				case <-finished:
+					return
+				}
+				err := func() error {
+					buf, err := cl.GetSnapshotChunk(si, h)
+					if err != nil {
+						return err
+					}
+					chunk, err := m.verifyChunk(h, buf)
+					if err != nil {
+						return err
+					}
+					return store(h, buf, chunk)
+				}()
+				if err != nil {
+					log.Warnf("couldn't get chunk %x from %s: %v", h, si, err)
+					jobs <- h
+					if atomic.AddInt32(&alive, -1) == 0 {
+						close(failed)
+					}
+					return
+				}
+				if atomic.AddInt32(&remaining, -1) == 0 {
+					close(finished)
+				}
+			}
+		}(si)
+	}
+	wg.Wait()
+
+	select {
+	case <-finished:
+		return nil
+	case <-failed:
+		return xerrors.New("no node could deliver all chunks of the snapshot")
+	}
+}
+
+// downloadSnapshot replaces the global state of the chain of sb with the
+// latest snapshot of the other nodes of the roster. It returns the new state
+// trie and the block the snapshot is tied to.
+func (s *Service) downloadSnapshot(sb *skipchain.SkipBlock) (*stateTrie, *skipchain.SkipBlock, error) {
+	scID := sb.SkipChainID()
+	idStr := fmt.Sprintf("%x", scID)
+	cl := NewClient(scID, *sb.Roster)
+	cl.DontContact(s.ServerIdentity())
+	skCl := skipchain.NewClient()
+	skCl.DontContact(s.ServerIdentity())
+
+	resp, err := cl.GetSnapshot()
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting snapshot: %v", err)
+	}
+	// The snapshot can be after sb, as its block is verified from the
+	// genesis block and the catch up continues from it.
+	m := &resp.Manifest
+	if st, err := s.getStateTrie(scID); err == nil && st.GetIndex() >= m.Index {
+		return nil, nil, xerrors.New("snapshot is older than our state")
+	}
+
+	genesis, err := skCl.GetSingleBlock(sb.Roster, scID)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting genesis block: %v", err)
+	}
+	if !genesis.CalculateHash().Equal(scID) {
+		return nil, nil, xerrors.New("got a wrong genesis block")
+	}
+	snapshotBlock, header, err := verifySnapshotBlock(sb.Roster, genesis, m, skCl)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("verifying snapshot: %v", err)
+	}
+
+	// Delete the existing state trie. There cannot be another write-access
+	// to the database because of catchingLock.
+	deleteTrie := func() error {
+		s.stateTriesLock.Lock()
+		delete(s.stateTries, idStr)
+		s.stateTriesLock.Unlock()
+		return s.trieStore.remove(idStr)
+	}
+	if err := deleteTrie(); err != nil {
+		return nil, nil, xerrors.Errorf("deleting trie: %v", err)
+	}
+	st, err := newStateTrie(s.trieStore.db(idStr), m.Nonce, s.stateConfig)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("creating trie: %v", err)
+	}
+
+	var nodes []*network.ServerIdentity
+	for _, si := range sb.Roster.List {
+		if !si.Equal(s.ServerIdentity()) {
+			nodes = append(nodes, si)
+		}
+	}
+	log.Lvlf2("%s: downloading %d chunks of the snapshot at block %d from %d nodes",
+		s.ServerIdentity(), len(m.Chunks), m.Index, len(nodes))
+	err = fetchSnapshotChunks(cl, nodes, m, func(hash, buf []byte, chunk *SnapshotChunk) error {
+		err := st.DB().Update(func(b trie.Bucket) error {
+			for _, p := range chunk.Proofs {
+				k, v := p.KeyValue()
+				if err := st.SetWithBucket(k, v, b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("storing key/values: %v", err)
+		}
+		// Keep the chunk to serve it to other nodes.
+		_, err = s.snapshots.putChunk(scID, buf)
+		return err
+	})
+	if err == nil {
+		// The chunks only prove that their key/values are in the state, the
+		// root proves that no key/value is missing.
+		err = st.VerifiedStoreAll(nil, m.Index, header.Version, m.TrieRoot)
+	}
+	if err != nil {
+		if errDel := deleteTrie(); errDel != nil {
+			log.Error("couldn't delete the partial trie:", errDel)
+		}
+		return nil, nil, xerrors.Errorf("downloading chunks: %v", err)
+	}
+	if err := s.snapshots.setManifest(scID, m); err != nil {
+		log.Error("couldn't store the manifest:", err)
+	}
+
+	s.stateTriesLock.Lock()
+	s.stateTries[idStr] = st
+	s.stateTriesLock.Unlock()
+	// Both blocks are needed to continue the catch up from the snapshot.
+	s.db().Store(genesis)
+	s.db().Store(snapshotBlock)
+	log.Lvlf1("%s: successfully downloaded snapshot for chain %s at block %d", s.ServerIdentity(),
+		idStr, m.Index)
+	return st, snapshotBlock, nil
+}
@@ -0,0 +1,207 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+func TestSnapshot_Chunks(t *testing.T) {
+	scs := snapshotChunkSize
+	defer func() {
+		snapshotChunkSize = scs
+	}()
+	snapshotChunkSize = 10
+	hash := func(buf []byte) []byte {
+		h := sha256.Sum256(buf)
+		return h[:]
+	}
+
+	st, err := newMemStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	var changes StateChanges
+	for i := 0; i < 25; i++ {
+		changes = append(changes, NewStateChange(Create, genID(), "dummy", []byte{byte(i)}, nil))
+	}
+	require.NoError(t, st.StoreAll(changes, 5, CurrentVersion))
+
+	chunks := make(map[string][]byte)
+	m := &SnapshotManifest{Index: 5}
+	require.NoError(t, makeSnapshot(st, st.GetRoot(), m, func(buf []byte) ([]byte, error) {
+		h := hash(buf)
+		chunks[string(h)] = buf
+		return h, nil
+	}))
+	require.Equal(t, st.GetRoot(), m.TrieRoot)
+	require.Equal(t, 3, len(m.Chunks))
+
+	// Every key/value of the state is in exactly one chunk.
+	keys := make(map[string]bool)
+	for _, h := range m.Chunks {
+		chunk, err := m.verifyChunk(h, chunks[string(h)])
+		require.NoError(t, err)
+		for _, p := range chunk.Proofs {
+			keys[string(p.Key())] = true
+		}
+	}
+	require.Equal(t, 25, len(keys))
+	for _, sc := range changes {
+		require.True(t, keys[string(sc.Key())])
+	}
+
+	// The same state gives the same chunks.
+	m2 := &SnapshotManifest{Index: 5}
+	require.NoError(t, makeSnapshot(st, st.GetRoot(), m2, func(buf []byte) ([]byte, error) {
+		return hash(buf), nil
+	}))
+	require.Equal(t, m.Chunks, m2.Chunks)
+
+	// No read transaction is open while a chunk is stored.
+	m3 := &SnapshotManifest{Index: 5}
+	require.NoError(t, makeSnapshot(st, st.GetRoot(), m3, func(buf []byte) ([]byte, error) {
+		require.Equal(t, m.TrieRoot, st.GetRoot())
+		return hash(buf), nil
+	}))
+	require.Equal(t, m.Chunks, m3.Chunks)
+
+	// The snapshot of a state that is updated in the meantime fails.
+	root := st.GetRoot()
+	m5 := &SnapshotManifest{Index: 5}
+	err = makeSnapshot(st, root, m5, func(buf []byte) ([]byte, error) {
+		if len(m5.Chunks) == 0 {
+			require.NoError(t, st.StoreAll(StateChanges{
+				NewStateChange(Create, genID(), "dummy", []byte{1}, nil)}, 6, CurrentVersion))
+		}
+		return hash(buf), nil
+	})
+	require.Error(t, err)
+
+	// A persistent state trie gives the same chunks from a retained root,
+	// even if it is updated in the meantime.
+	pst, err := newStateTrie(trie.NewMemDB(), []byte("nonce"), stateTrieConfig{retention: 1})
+	require.NoError(t, err)
+	require.NoError(t, pst.StoreAll(changes, 5, CurrentVersion))
+	snap, err := pst.At(pst.GetRoot())
+	require.NoError(t, err)
+	pst.SetRetention(0)
+	require.NoError(t, pst.StoreAll(StateChanges{
+		NewStateChange(Create, genID(), "dummy", []byte{1}, nil)}, 6, CurrentVersion))
+	m4 := &SnapshotManifest{Index: 5}
+	require.NoError(t, makeSnapshotAt(snap, m4, func(buf []byte) ([]byte, error) {
+		return hash(buf), nil
+	}))
+	require.Equal(t, m.TrieRoot, m4.TrieRoot)
+	require.Equal(t, m.Chunks, m4.Chunks)
+
+	// A chunk must match its hash.
+	_, err = m.verifyChunk(m.Chunks[0], chunks[string(m.Chunks[1])])
+	require.Error(t, err)
+
+	// A modified value doesn't match the root anymore.
+	var chunk SnapshotChunk
+	require.NoError(t, protobuf.Decode(chunks[string(m.Chunks[0])], &chunk))
+	chunk.Proofs[0].Leaf.Value = []byte("modified")
+	buf, err := protobuf.Encode(&chunk)
+	require.NoError(t, err)
+	_, err = m.verifyChunk(hash(buf), buf)
+	require.Error(t, err)
+
+	// A chunk of another state is refused.
+	other := *m
+	other.TrieRoot = genID().Slice()
+	_, err = other.verifyChunk(m.Chunks[0], chunks[string(m.Chunks[0])])
+	require.Error(t, err)
+}
+
+// setSnapshotInterval sets the snapshot interval of all nodes.
+func setSnapshotInterval(s *ser, interval int) {
+	for _, srv := range s.services {
+		srv.SetSnapshotInterval(interval)
+	}
+}
+
+// waitSnapshot waits until all nodes have a snapshot of the given block or a
+// later one, and are not creating another one.
+func waitSnapshot(t *testing.T, s *ser, index int) {
+	for _, srv := range s.services {
+		for i := 0; ; i++ {
+			m, err := srv.snapshots.getManifest(s.genesis.SkipChainID())
+			srv.snapshotLock.Lock()
+			running := srv.snapshotRunning
+			srv.snapshotLock.Unlock()
+			if err == nil && m.Index >= index && !running {
+				break
+			}
+			require.True(t, i < 100, "no snapshot of block %d", index)
+			time.Sleep(testInterval / 10)
+		}
+	}
+}
+
+func TestService_Snapshot(t *testing.T) {
+	cda := catchupDownloadAll
+	defer func() {
+		catchupDownloadAll = cda
+	}()
+	catchupDownloadAll = 1
+
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+	setSnapshotInterval(s, -1)
+
+	ct := addDummyTxs(t, s, 2, 3, 1)
+
+	log.Lvl1("Creating a snapshot on all nodes")
+	setSnapshotInterval(s, 1)
+	ct = addDummyTxs(t, s, 1, 1, ct)
+	setSnapshotInterval(s, -1)
+	latest, err := s.service().db().GetLatestByID(s.genesis.SkipChainID())
+	require.NoError(t, err)
+	waitSnapshot(t, s, latest.Index)
+	resp, err := s.service().GetSnapshot(&GetSnapshot{ByzCoinID: s.genesis.SkipChainID()})
+	require.NoError(t, err)
+	m := resp.Manifest
+	require.NotEmpty(t, m.Chunks)
+	for _, srv := range s.services {
+		chunk, err := srv.GetSnapshotChunk(&GetSnapshotChunk{
+			ByzCoinID: s.genesis.SkipChainID(),
+			Hash:      m.Chunks[0],
+		})
+		require.NoError(t, err)
+		_, err = m.verifyChunk(m.Chunks[0], chunk.Chunk)
+		require.NoError(t, err)
+	}
+
+	log.Lvl1("Adding a new node that bootstraps from the snapshot")
+	_, newRoster, _ := s.local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	newRoster = onet.NewRoster(append(s.roster.List, newRoster.List...))
+	ctx, _ := createConfigTxWithCounter(t, testInterval, *newRoster,
+		defaultMaxBlockSize, s, ct)
+	ct++
+	s.sendTxAndWait(t, ctx, 10)
+	addDummyTxs(t, s, 2, 1, ct)
+
+	// The new node never processed the snapshot block, so it can only have
+	// the snapshot if it downloaded it.
+	newNode := newRoster.List[len(newRoster.List)-1]
+	leanClient := onet.NewClient(cothority.Suite, ServiceName)
+	newResp := &GetSnapshotResponse{}
+	require.NoError(t, leanClient.SendProtobuf(newNode,
+		&GetSnapshot{ByzCoinID: s.genesis.SkipChainID()}, newResp))
+	require.Equal(t, m.BlockID, newResp.Manifest.BlockID)
+
+	reply := &GetProofResponse{}
+	require.NoError(t, leanClient.SendProtobuf(newNode, &GetProof{
+		Version: CurrentVersion,
+		ID:      s.genesis.Hash,
+		Key:     s.darc.GetBaseID(),
+	}, reply))
+	require.True(t, reply.Proof.InclusionProof.Match(s.darc.GetBaseID()))
+}
@@ -24,6 +24,9 @@ type stateChangeValue struct {
 	merkleRoot []byte
 	txOut      []TxResult
 	states     StateChanges
+	// txStates holds the state changes produced by each transaction of
+	// txOut, nil for the refused ones.
+	txStates []StateChanges
 }
 
 func newStateChangeCache() stateChangeCache {
@@ -33,6 +36,13 @@ func newStateChangeCache() stateChangeCache {
 }
 
 func (c *stateChangeCache) get(scID skipchain.SkipBlockID, digest []byte) (merkleRoot []byte, txOut TxResults, states StateChanges, err error) {
+	merkleRoot, txOut, states, _, err = c.getWithTxStates(scID, digest)
+	return
+}
+
+// getWithTxStates is like get but also returns the state changes produced by
+// each transaction of txOut.
+func (c *stateChangeCache) getWithTxStates(scID skipchain.SkipBlockID, digest []byte) (merkleRoot []byte, txOut TxResults, states StateChanges, txStates []StateChanges, err error) {
 	c.Lock()
 	defer c.Unlock()
 	key := string(scID)
@@ -49,10 +59,11 @@ func (c *stateChangeCache) get(scID skipchain.SkipBlockID, digest []byte) (merkl
 	merkleRoot = out.merkleRoot
 	txOut = out.txOut
 	states = out.states
+	txStates = out.txStates
 	return
 }
 
-func (c *stateChangeCache) update(scID skipchain.SkipBlockID, digest []byte, merkleRoot []byte, txOut TxResults, states StateChanges) {
+func (c *stateChangeCache) update(scID skipchain.SkipBlockID, digest []byte, merkleRoot []byte, txOut TxResults, states StateChanges, txStates []StateChanges) {
 	c.Lock()
 	defer c.Unlock()
 	key := string(scID)
@@ -61,5 +72,6 @@ func (c *stateChangeCache) update(scID skipchain.SkipBlockID, digest []byte, mer
 		merkleRoot: merkleRoot,
 		txOut:      txOut,
 		states:     states,
+		txStates:   txStates,
 	}
 }
@@ -19,11 +19,18 @@ func TestStateChangeCache(t *testing.T) {
 	root := []byte("root")
 	txs := NewTxResults()
 	scs := StateChanges([]StateChange{})
-	cache.update(scID, digest, root, txs, scs)
+	txScs := []StateChanges{scs}
+	cache.update(scID, digest, root, txs, scs, txScs)
 
 	root1, txs1, scs1, err := cache.get(scID, digest)
 	require.NoError(t, err)
 	require.Equal(t, root, root1)
 	require.Equal(t, txs, txs1)
 	require.Equal(t, scs, scs1)
+
+	_, _, _, txScs1, err := cache.getWithTxStates(scID, digest)
+	require.NoError(t, err)
+	require.Equal(t, txScs, txScs1)
+	_, _, _, _, err = cache.getWithTxStates(scID, []byte("other"))
+	require.Error(t, err)
 }
@@ -14,7 +14,6 @@ import (
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
-	"go.etcd.io/bbolt"
 	"golang.org/x/xerrors"
 )
 
@@ -83,16 +82,38 @@ type stagingStateTrie struct {
 	trie.StagingTrie
 	trieCache
 	sync.Mutex
+	// tracker, if set, records the keys that are read from this trie and
+	// all its clones.
+	tracker *accessTracker
 }
 
 // Clone makes a copy of the staged data of the structure, the source Trie is
-// not copied.
+// not copied. The clone shares the access tracker of the original.
 func (t *stagingStateTrie) Clone() *stagingStateTrie {
 	return &stagingStateTrie{
 		StagingTrie: *t.StagingTrie.Clone(),
+		tracker:     t.tracker,
 	}
 }
 
+// Get returns the raw value stored under the key.
+func (t *stagingStateTrie) Get(key []byte) ([]byte, error) {
+	t.tracker.read(key)
+	return t.StagingTrie.Get(key)
+}
+
+// GetProof produces an existence or absence proof for the given key.
+func (t *stagingStateTrie) GetProof(key []byte) (*trie.Proof, error) {
+	t.tracker.read(key)
+	return t.StagingTrie.GetProof(key)
+}
+
+// ForEach calls the callback function on every key/value pair of the trie.
+func (t *stagingStateTrie) ForEach(cb func(k, v []byte) error) error {
+	t.tracker.readAll()
+	return t.StagingTrie.ForEach(cb)
+}
+
 // StoreAll puts all the state changes and the index in the staging area.
 func (t *stagingStateTrie) StoreAll(scs StateChanges) error {
 	t.Lock()
@@ -285,23 +306,69 @@ type stateTrie struct {
 	sync.Mutex
 }
 
+// defaultStateTrieCacheSize is the number of bytes of decoded nodes that a
+// state trie keeps in memory for the lookups and the proofs, if the node
+// configuration doesn't set it.
+const defaultStateTrieCacheSize = 32 << 20
+
+// stateTrieConfig holds the settings of the state tries of a node, see
+// NodeConfig.
+type stateTrieConfig struct {
+	// retention is the number of past states kept by the new state tries.
+	retention int
+	// cacheSize is the size in bytes of the node cache of a state trie. 0
+	// uses the default, and a negative value disables the cache.
+	cacheSize int
+}
+
+func (c stateTrieConfig) setup(t *trie.Trie) error {
+	size := c.cacheSize
+	if size == 0 {
+		size = defaultStateTrieCacheSize
+	}
+	return t.SetCacheSize(size)
+}
+
 // loadStateTrie loads an existing StateTrie, an error is returned if no trie
-// exists in db
-func loadStateTrie(db *bbolt.DB, bucket []byte) (*stateTrie, error) {
-	t, err := trie.LoadTrie(trie.NewDiskDB(db, bucket))
+// exists in db. If the trie is persistent, it keeps the number of past states
+// of the configuration, and only the current one if it is 0.
+func loadStateTrie(db trie.DB, conf stateTrieConfig) (*stateTrie, error) {
+	t, err := trie.LoadTrie(db)
 	if err != nil {
 		return nil, xerrors.Errorf("loading trie: %v", err)
 	}
+	if t.IsPersistent() {
+		retention := conf.retention
+		if retention <= 0 {
+			retention = 1
+		}
+		t.SetRetention(retention)
+	}
+	if err := conf.setup(t); err != nil {
+		return nil, xerrors.Errorf("setting cache: %v", err)
+	}
 	return &stateTrie{Trie: *t}, nil
 }
 
-// newStateTrie creates a new, disk-based trie.Trie, an error is returned if
-// the db already contains a trie.
-func newStateTrie(db *bbolt.DB, bucket, nonce []byte) (*stateTrie, error) {
-	t, err := trie.NewTrie(trie.NewDiskDB(db, bucket), nonce)
+// newStateTrie creates a new trie.Trie in the db, an error is returned if the
+// db already contains a trie. If the retention of the configuration is bigger
+// than 0, the trie is persistent and keeps this number of past states, one
+// per block.
+func newStateTrie(db trie.DB, nonce []byte, conf stateTrieConfig) (*stateTrie, error) {
+	var t *trie.Trie
+	var err error
+	if conf.retention > 0 {
+		t, err = trie.NewPersistentTrie(db, nonce)
+	} else {
+		t, err = trie.NewTrie(db, nonce)
+	}
 	if err != nil {
 		return nil, xerrors.Errorf("creating trie: %v", err)
 	}
+	t.SetRetention(conf.retention)
+	if err := conf.setup(t); err != nil {
+		return nil, xerrors.Errorf("setting cache: %v", err)
+	}
 	return &stateTrie{Trie: *t}, nil
 }
 
@@ -341,7 +408,7 @@ func (t *stateTrie) VerifiedStoreAll(scs StateChanges, index int, version Versio
 		if expectedRoot != nil && !bytes.Equal(t.GetRootWithBucket(b), expectedRoot) {
 			return xerrors.New("root verification failed")
 		}
-		return nil
+		return t.CommitRootWithBucket(b)
 	})
 }
 
@@ -398,6 +465,23 @@ func (t *stateTrie) MakeStagingStateTrie() *stagingStateTrie {
 	}
 }
 
+// makeSnapshotStagingStateTrie creates a StagingStateTrie that reads the
+// current root of a persistent trie, so that it stays valid while the next
+// blocks are stored, as long as the root is retained. A trie that isn't
+// persistent doesn't keep its past roots and returns an error.
+func (t *stateTrie) makeSnapshotStagingStateTrie() (*stagingStateTrie, error) {
+	if !t.IsPersistent() {
+		return nil, xerrors.New("snapshot of a trie that isn't persistent")
+	}
+	snap, err := t.At(t.GetRoot())
+	if err != nil {
+		return nil, xerrors.Errorf("getting snapshot: %v", err)
+	}
+	return &stagingStateTrie{
+		StagingTrie: *snap.MakeStagingTrie(),
+	}, nil
+}
+
 // StoreAllToReplica is not supported. It cannot be implemented in an immutable
 // way because writing state changes to the replica will change the underlying
 // trie since the receiver is not a stagingStateTrie. Convert it to a
@@ -18,6 +18,7 @@ import (
 
 	"go.dedis.ch/kyber/v3/util/random"
 
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 )
 
@@ -199,3 +200,38 @@ func TestDarcRetrieval(t *testing.T) {
 	}
 	log.Lvl1("time to search:", time.Now().Sub(start))
 }
+
+// TestStateTrie_Retention checks that the state tries keep the states of the
+// last blocks when the retention is set.
+func TestStateTrie_Retention(t *testing.T) {
+	db := trie.NewMemDB()
+	st, err := newStateTrie(db, []byte("nonce"), stateTrieConfig{retention: 2})
+	require.NoError(t, err)
+	require.True(t, st.IsPersistent())
+
+	var roots [][]byte
+	for i := 1; i <= 3; i++ {
+		sc := NewStateChange(Create, NewInstanceID([]byte{byte(i)}), "dummy",
+			[]byte("value"), nil)
+		require.NoError(t, st.StoreAll(StateChanges{sc}, i, CurrentVersion))
+		roots = append(roots, st.GetRoot())
+	}
+	require.Equal(t, roots[1:], st.RetainedRoots())
+	old, err := st.At(roots[1])
+	require.NoError(t, err)
+	val, err := old.Get(NewInstanceID([]byte{3}).Slice())
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	// Without a retention, a persistent trie only keeps the current state.
+	st, err = loadStateTrie(db, stateTrieConfig{})
+	require.NoError(t, err)
+	sc := NewStateChange(Create, NewInstanceID([]byte{4}), "dummy", []byte("value"), nil)
+	require.NoError(t, st.StoreAll(StateChanges{sc}, 4, CurrentVersion))
+	require.Equal(t, [][]byte{st.GetRoot()}, st.RetainedRoots())
+	require.NoError(t, st.IsValid())
+
+	st, err = newStateTrie(trie.NewMemDB(), []byte("nonce"), stateTrieConfig{})
+	require.NoError(t, err)
+	require.False(t, st.IsPersistent())
+}
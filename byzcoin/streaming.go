@@ -5,7 +5,10 @@ import (
 	"sync"
 
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
 )
 
 const (
@@ -26,43 +29,96 @@ const (
 
 func init() {
 	network.RegisterMessages(&StreamingRequest{}, &StreamingResponse{},
-		&PaginateRequest{}, &PaginateResponse{})
+		&PaginateRequest{}, &PaginateResponse{},
+		&StreamEventsRequest{}, &StreamEventsResponse{})
+}
+
+// blockListener is a client of StreamTransactions, with its optional filter.
+type blockListener struct {
+	filter *StreamingFilter
+	out    chan *StreamingResponse
+}
+
+// eventListener is a client of StreamEvents, with its filter.
+type eventListener struct {
+	req *StreamEventsRequest
+	out chan *StreamEventsResponse
 }
 
 type streamingManager struct {
 	sync.Mutex
 	// key: skipchain ID, value: slice of listeners
-	listeners map[string][]chan *StreamingResponse
+	listeners map[string][]blockListener
+	// key: skipchain ID, value: slice of event listeners
+	eventListeners map[string][]eventListener
 }
 
-func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
+// notify sends the new block to all listeners of the chain. The listeners
+// with a filter only get the matching transactions and their state changes,
+// and nothing if nothing matches. txScs holds the state changes produced by
+// each transaction.
+func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock,
+	txs TxResults, txScs []StateChanges) {
 	s.Lock()
 	defer s.Unlock()
 
-	ls, ok := s.listeners[scID]
-	if !ok {
-		return
+	for _, l := range s.listeners[scID] {
+		if l.filter == nil {
+			l.out <- &StreamingResponse{
+				Block: block,
+			}
+			continue
+		}
+		mTxs, mScs := l.filter.match(txs, txScs)
+		if len(mTxs) > 0 {
+			l.out <- &StreamingResponse{
+				BlockID:      block.Hash,
+				Index:        block.Index,
+				TxResults:    mTxs,
+				StateChanges: mScs,
+			}
+		}
 	}
 
-	for _, c := range ls {
-		c <- &StreamingResponse{
-			Block: block,
+	els := s.eventListeners[scID]
+	if len(els) == 0 {
+		return
+	}
+	var body DataBody
+	if err := protobuf.Decode(block.Payload, &body); err != nil {
+		log.Errorf("couldn't decode the body of block %x: %v", block.Hash, err)
+		return
+	}
+	if len(body.Events) == 0 {
+		return
+	}
+	for _, el := range els {
+		var evs []Event
+		for _, ev := range body.Events {
+			if el.req.matches(ev) {
+				evs = append(evs, ev)
+			}
+		}
+		if len(evs) > 0 {
+			el.out <- &StreamEventsResponse{
+				BlockID: block.Hash,
+				Index:   block.Index,
+				Events:  evs,
+			}
 		}
 	}
 }
 
-func (s *streamingManager) newListener(scID string) chan *StreamingResponse {
+func (s *streamingManager) newListener(scID string, filter *StreamingFilter) chan *StreamingResponse {
 	s.Lock()
 	defer s.Unlock()
 
 	if s.listeners == nil {
-		s.listeners = make(map[string][]chan *StreamingResponse)
+		s.listeners = make(map[string][]blockListener)
 	}
 
-	ls := s.listeners[scID]
 	outChan := make(chan *StreamingResponse)
-	ls = append(ls, outChan)
-	s.listeners[scID] = ls
+	s.listeners[scID] = append(s.listeners[scID], blockListener{filter, outChan})
 	return outChan
 }
 
@@ -76,14 +132,42 @@ func (s *streamingManager) stopListener(scID string, outChan chan *StreamingResp
 	}
 
 	for i, listener := range ls {
-		if listener == outChan {
-			close(listener)
+		if listener.out == outChan {
+			close(listener.out)
 			s.listeners[scID] = append(ls[:i], ls[i+1:]...)
 			return
 		}
 	}
 }
 
+func (s *streamingManager) newEventListener(req *StreamEventsRequest) chan *StreamEventsResponse {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.eventListeners == nil {
+		s.eventListeners = make(map[string][]eventListener)
+	}
+
+	scID := string(req.ID)
+	outChan := make(chan *StreamEventsResponse)
+	s.eventListeners[scID] = append(s.eventListeners[scID], eventListener{req, outChan})
+	return outChan
+}
+
+func (s *streamingManager) stopEventListener(scID string, outChan chan *StreamEventsResponse) {
+	s.Lock()
+	defer s.Unlock()
+
+	els := s.eventListeners[scID]
+	for i, el := range els {
+		if el.out == outChan {
+			close(el.out)
+			s.eventListeners[scID] = append(els[:i], els[i+1:]...)
+			return
+		}
+	}
+}
+
 func (s *streamingManager) stopAll() {
 	s.Lock()
 	defer s.Unlock()
@@ -91,19 +175,117 @@ func (s *streamingManager) stopAll() {
 	for key, l := range s.listeners {
 		for _, c := range l {
 			// Force the streaming connection in Onet to close.
-			close(c)
+			close(c.out)
 		}
 
 		delete(s.listeners, key)
 	}
+
+	for key, els := range s.eventListeners {
+		for _, el := range els {
+			close(el.out)
+		}
+
+		delete(s.eventListeners, key)
+	}
+}
+
+// match returns the transactions that pass the filter, and the state changes
+// produced by these transactions that pass the filter. txScs[i] holds the state
+// changes of txs[i].
+func (f *StreamingFilter) match(txs TxResults, txScs []StateChanges) (TxResults, StateChanges) {
+	var outTxs TxResults
+	var outScs StateChanges
+	for i, tx := range txs {
+		var scs StateChanges
+		if i < len(txScs) {
+			scs = txScs[i]
+		}
+		if !f.matchTx(tx, scs) {
+			continue
+		}
+		outTxs = append(outTxs, tx)
+		for _, sc := range scs {
+			if f.matchStateChange(sc) {
+				outScs = append(outScs, sc)
+			}
+		}
+	}
+	return outTxs, outScs
+}
+
+// matchTx returns true if the transaction tx with the state changes scs
+// passes the filter. The contracts and instances of the filter must match the
+// same instruction, or the same state change, which can be about other
+// instances.
+func (f *StreamingFilter) matchTx(tx TxResult, scs StateChanges) bool {
+	if f.AcceptedOnly && !tx.Accepted {
+		return false
+	}
+	var match bool
+	signer := len(f.SignerIDs) == 0
+	for _, instr := range tx.ClientTransaction.Instructions {
+		match = match || (f.matchContract(instr.ContractID()) &&
+			f.matchInstance(instr.InstanceID))
+		for _, id := range instr.SignerIdentities {
+			signer = signer || f.matchSigner(id.String())
+		}
+	}
+	if !match {
+		for _, sc := range scs {
+			if f.matchStateChange(sc) {
+				match = true
+				break
+			}
+		}
+	}
+	return match && signer
+}
+
+func (f *StreamingFilter) matchStateChange(sc StateChange) bool {
+	return f.matchContract(sc.ContractID) && f.matchInstance(NewInstanceID(sc.InstanceID))
+}
+
+func (f *StreamingFilter) matchContract(cID string) bool {
+	if len(f.ContractIDs) == 0 {
+		return true
+	}
+	for _, c := range f.ContractIDs {
+		if c == cID {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *StreamingFilter) matchInstance(iID InstanceID) bool {
+	if len(f.InstanceIDs) == 0 {
+		return true
+	}
+	for _, id := range f.InstanceIDs {
+		if id.Equal(iID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *StreamingFilter) matchSigner(id string) bool {
+	for _, s := range f.SignerIDs {
+		if s == id {
+			return true
+		}
+	}
+	return false
 }
 
 // StreamTransactions will stream all transactions IDs to the client until the
-// client closes the connection.
+// client closes the connection. If the request has a filter, only the
+// matching transactions and state changes are sent instead of the blocks.
 func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResponse, chan bool, error) {
 	stopChan := make(chan bool)
 	key := string(msg.ID)
-	outChan := s.streamingMan.newListener(key)
+	outChan := s.streamingMan.newListener(key, msg.Filter)
 
 	go func() {
 		s.closedMutex.Lock()
@@ -124,6 +306,33 @@ func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResp
 	return outChan, stopChan, nil
 }
 
+// StreamEvents streams the events emitted on the chain to the client, until
+// the client closes the connection. Only the events matching the filter of
+// the request are sent, and blocks without matching events are skipped.
+func (s *Service) StreamEvents(msg *StreamEventsRequest) (chan *StreamEventsResponse, chan bool, error) {
+	if len(msg.ID) == 0 {
+		return nil, nil, xerrors.New("missing skipchain ID")
+	}
+	stopChan := make(chan bool)
+	key := string(msg.ID)
+	outChan := s.streamingMan.newEventListener(msg)
+
+	go func() {
+		s.closedMutex.Lock()
+		if s.closed {
+			s.closedMutex.Unlock()
+			return
+		}
+		s.working.Add(1)
+		defer s.working.Done()
+		s.closedMutex.Unlock()
+
+		<-stopChan
+		s.streamingMan.stopEventListener(key, outChan)
+	}()
+	return outChan, stopChan, nil
+}
+
 // PaginateBlocks return blocks with pagination, ie. N asynchounous requests
 // that contain each K consecutive block. The caller is responsible for closing
 // the close chan when the caller wants to close the connection.
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 )
 
@@ -393,3 +394,139 @@ func TestStreamingService_PaginateBlocks(t *testing.T) {
 
 	close(closeChan)
 }
+
+func TestStreamingFilter_Match(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	other := darc.NewSignerEd25519(nil, nil)
+	iID := genID()
+	tx := TxResult{
+		ClientTransaction: ClientTransaction{Instructions: Instructions{{
+			InstanceID:       iID,
+			Invoke:           &Invoke{ContractID: "value"},
+			SignerIdentities: []darc.Identity{signer.Identity()},
+		}}},
+		Accepted: false,
+	}
+	otherTx := TxResult{
+		ClientTransaction: ClientTransaction{Instructions: Instructions{{
+			InstanceID:       iID,
+			Invoke:           &Invoke{ContractID: "value"},
+			SignerIdentities: []darc.Identity{other.Identity()},
+		}}},
+		Accepted: true,
+	}
+	scs := StateChanges{
+		NewStateChange(Update, iID, "value", nil, nil),
+		NewStateChange(Update, genID(), "other", nil, nil),
+	}
+	otherScs := StateChanges{NewStateChange(Update, iID, "value", []byte("other"), nil)}
+	txs := TxResults{tx, otherTx}
+	txScs := []StateChanges{scs, otherScs}
+
+	out, outScs := (&StreamingFilter{}).match(txs, txScs)
+	require.Equal(t, 2, len(out))
+	require.Equal(t, 3, len(outScs))
+
+	out, outScs = (&StreamingFilter{AcceptedOnly: true}).match(txs, txScs)
+	require.Equal(t, TxResults{otherTx}, out)
+	require.Equal(t, otherScs, outScs)
+
+	out, outScs = (&StreamingFilter{ContractIDs: []string{"value"}}).match(txs, txScs)
+	require.Equal(t, 2, len(out))
+	require.Equal(t, StateChanges{scs[0], otherScs[0]}, outScs)
+
+	out, outScs = (&StreamingFilter{InstanceIDs: []InstanceID{genID()}}).match(txs, txScs)
+	require.Empty(t, out)
+	require.Empty(t, outScs)
+
+	// Only the state changes of the transactions of the signer are
+	// returned.
+	out, outScs = (&StreamingFilter{SignerIDs: []string{signer.Identity().String()},
+		InstanceIDs: []InstanceID{iID}}).match(txs, txScs)
+	require.Equal(t, TxResults{tx}, out)
+	require.Equal(t, StateChanges{scs[0]}, outScs)
+	out, _ = (&StreamingFilter{SignerIDs: []string{"ed25519:00"}}).match(txs, txScs)
+	require.Empty(t, out)
+
+	// Without the state changes of the transactions, none is returned.
+	out, outScs = (&StreamingFilter{}).match(txs, nil)
+	require.Equal(t, 2, len(out))
+	require.Empty(t, outScs)
+
+	// A transfer from another account matches the account that receives
+	// the coins through its state change.
+	from, to := genID(), genID()
+	transfer := TxResult{
+		ClientTransaction: ClientTransaction{Instructions: Instructions{{
+			InstanceID:       from,
+			Invoke:           &Invoke{ContractID: "coin", Command: "transfer"},
+			SignerIdentities: []darc.Identity{signer.Identity()},
+		}}},
+		Accepted: true,
+	}
+	transferScs := StateChanges{
+		NewStateChange(Update, from, "coin", []byte("9"), nil),
+		NewStateChange(Update, to, "coin", []byte("1"), nil),
+	}
+	txs = TxResults{tx, transfer}
+	txScs = []StateChanges{scs, transferScs}
+	out, outScs = (&StreamingFilter{ContractIDs: []string{"coin"},
+		InstanceIDs: []InstanceID{to}}).match(txs, txScs)
+	require.Equal(t, TxResults{transfer}, out)
+	require.Equal(t, StateChanges{transferScs[1]}, outScs)
+	out, _ = (&StreamingFilter{ContractIDs: []string{"value"},
+		InstanceIDs: []InstanceID{to}}).match(txs, txScs)
+	require.Empty(t, out)
+
+	// The contract and the instance must match the same instruction.
+	split := TxResult{
+		ClientTransaction: ClientTransaction{Instructions: Instructions{
+			{InstanceID: genID(), Invoke: &Invoke{ContractID: "value"}},
+			{InstanceID: to, Invoke: &Invoke{ContractID: "coin"}},
+		}},
+		Accepted: true,
+	}
+	out, _ = (&StreamingFilter{ContractIDs: []string{"value"},
+		InstanceIDs: []InstanceID{to}}).match(TxResults{split}, nil)
+	require.Empty(t, out)
+	out, _ = (&StreamingFilter{ContractIDs: []string{"coin"},
+		InstanceIDs: []InstanceID{to}}).match(TxResults{split}, nil)
+	require.Equal(t, TxResults{split}, out)
+}
+
+func TestStreamingService_Filter(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	responses, closeChan, err := s.service().StreamTransactions(&StreamingRequest{
+		ID: s.genesis.SkipChainID(),
+		Filter: &StreamingFilter{
+			ContractIDs:  []string{dummyContract, invalidContract},
+			AcceptedOnly: true,
+		},
+	})
+	require.NoError(t, err)
+	defer close(closeChan)
+
+	// The refused transaction is in its own block, which must be skipped.
+	_, _, _, _, err = sendTransaction(t, s, 0, invalidContract, 10)
+	require.NoError(t, err)
+	tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, s.value, s.signer, 1)
+	require.NoError(t, err)
+	s.sendTxAndWait(t, tx, 10)
+
+	var resp *StreamingResponse
+	select {
+	case resp = <-responses:
+	case <-time.After(10 * testInterval):
+		t.Fatal("didn't get the transaction")
+	}
+	require.Nil(t, resp.Block)
+	require.NotNil(t, s.service().db().GetByID(resp.BlockID))
+	require.Equal(t, 1, len(resp.TxResults))
+	require.True(t, resp.TxResults[0].Accepted)
+	require.Equal(t, tx.Hash(), resp.TxResults[0].ClientTransaction.Hash())
+	// Only the state change of the new instance, not the counter.
+	require.Equal(t, 1, len(resp.StateChanges))
+	require.Equal(t, dummyContract, resp.StateChanges[0].ContractID)
+}
@@ -122,6 +122,13 @@ func (s *stateChangeStorage) setMaxNbrBlock(nbr int) {
 	s.maxNbrBlock = nbr
 }
 
+// getSize returns the size of the stored state changes.
+func (s *stateChangeStorage) getSize() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
+
 // calculateSize reads the entries in the database and sums up their
 // sizes
 func (s *stateChangeStorage) calculateSize() error {
@@ -350,6 +357,10 @@ func (s *stateChangeStorage) append(scs StateChanges, sb *skipchain.SkipBlock) e
 
 		// append each list of state changes (or create the entry)
 		for i, sc := range scs {
+			if sc.StateAction == EmitEvent {
+				// Events are stored in the blocks.
+				continue
+			}
 			if len(sc.InstanceID) != prefixLength {
 				// as we use it as a prefix, all must have the same length
 				return cothority.WrapError(errLengthInstanceID)
@@ -582,9 +593,13 @@ type notification struct {
 	hashes [][]byte
 }
 
-func (n *notification) getTx(id []byte) *TxResult {
+// getTx returns the result of the transaction with the given hash and
+// signatures, or nil if it is not in the block. A copy of the transaction
+// with other signatures can be in the block instead.
+func (n *notification) getTx(id []byte, sigs []byte) *TxResult {
 	for i, h := range n.hashes {
-		if bytes.Equal(h, id) {
+		if bytes.Equal(h, id) && bytes.Equal(sigs,
+			n.txs[i].ClientTransaction.Instructions.HashWithSignatures()) {
 			return &n.txs[i]
 		}
 	}
@@ -606,7 +621,7 @@ func (bc *bcNotifications) informBlock(block *skipchain.SkipBlock, txs TxResults
 	hashes := make([][]byte, len(txs))
 	for i, tx := range txs {
 		// Pre-computed hash to save some computation load.
-		hashes[i] = tx.ClientTransaction.Instructions.Hash()
+		hashes[i] = tx.ClientTransaction.Hash()
 	}
 
 	notif := &notification{
@@ -715,6 +730,13 @@ func (c ChainConfig) String() string {
 	for i, darcID := range c.DarcContractIDs {
 		fmt.Fprintf(res, "--- darc contract ID %d: %s\n", i, darcID)
 	}
+	if len(c.ContractVersions) > 0 {
+		res.WriteString("-- ContractVersions:\n")
+		for _, sw := range c.ContractVersions {
+			fmt.Fprintf(res, "--- %s version %d at block %d (applied: %t)\n",
+				sw.ContractID, sw.Version, sw.BlockIndex, sw.Applied)
+		}
+	}
 	return res.String()
 }
 
@@ -9,11 +9,12 @@ import (
 	"hash"
 	"regexp"
 	"strings"
-	"sync"
+	"time"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
@@ -109,7 +110,7 @@ func (ctx *ClientTransaction) FillSignersAndSignWith(signers ...darc.Signer) err
 // SignWith signs all the instructions with the same signers. If some instructions need to be signed by different sets
 // of signers, then use the SignWith method of Instruction.
 func (ctx *ClientTransaction) SignWith(signers ...darc.Signer) error {
-	digest := ctx.Instructions.Hash()
+	digest := ctx.Hash()
 	for i := range ctx.Instructions {
 		if err := ctx.Instructions[i].SignWith(digest, signers...); err != nil {
 			return err
@@ -118,6 +119,98 @@ func (ctx *ClientTransaction) SignWith(signers ...darc.Signer) error {
 	return nil
 }
 
+// Hash returns the digest that all instructions must sign. If the transaction
+// has no validity window, it is the hash of the instructions, so that
+// transactions without a window have the same hash as before.
+func (ctx ClientTransaction) Hash() []byte {
+	if ctx.NotBefore == nil && ctx.NotAfter == nil {
+		return ctx.Instructions.Hash()
+	}
+	h := sha256.New()
+	h.Write(ctx.Instructions.Hash())
+	ctx.NotBefore.hash(h)
+	ctx.NotAfter.hash(h)
+	return h.Sum(nil)
+}
+
+// verifyWindow returns an error if the transaction has a validity window and
+// the block with the given index and timestamp is outside of it.
+func (ctx ClientTransaction) verifyWindow(version Version, index int, timestamp int64) error {
+	if ctx.NotBefore == nil && ctx.NotAfter == nil {
+		return nil
+	}
+	if version < VersionTxValidity {
+		return xerrors.Errorf("validity windows are only supported from version %d on",
+			VersionTxValidity)
+	}
+	if ctx.NotBefore != nil {
+		if err := ctx.NotBefore.verify(); err != nil {
+			return xerrors.Errorf("invalid NotBefore: %v", err)
+		}
+		if ctx.NotBefore.after(index, timestamp) {
+			return xerrors.Errorf("transaction is not valid before %s", ctx.NotBefore)
+		}
+	}
+	if ctx.NotAfter != nil {
+		if err := ctx.NotAfter.verify(); err != nil {
+			return xerrors.Errorf("invalid NotAfter: %v", err)
+		}
+		if ctx.NotAfter.before(index, timestamp) {
+			return xerrors.Errorf("transaction expired after %s", ctx.NotAfter)
+		}
+	}
+	return nil
+}
+
+// expired returns true if the transaction cannot be included anymore in a
+// block with the given index or timestamp, or any later block.
+func (ctx ClientTransaction) expired(index int, timestamp int64) bool {
+	return ctx.NotAfter != nil && ctx.NotAfter.before(index, timestamp)
+}
+
+// String returns a readable output of the bound.
+func (b *TxBound) String() string {
+	if b.Index != 0 {
+		return fmt.Sprintf("block %d", b.Index)
+	}
+	return fmt.Sprintf("timestamp %s", time.Unix(0, b.Timestamp).UTC())
+}
+
+func (b *TxBound) verify() error {
+	if (b.Index == 0) == (b.Timestamp == 0) {
+		return xerrors.New("exactly one of index and timestamp must be set")
+	}
+	return nil
+}
+
+// before returns true if the bound is before the given block.
+func (b *TxBound) before(index int, timestamp int64) bool {
+	if b.Index != 0 {
+		return b.Index < index
+	}
+	return b.Timestamp < timestamp
+}
+
+// after returns true if the bound is after the given block.
+func (b *TxBound) after(index int, timestamp int64) bool {
+	if b.Index != 0 {
+		return b.Index > index
+	}
+	return b.Timestamp > timestamp
+}
+
+func (b *TxBound) hash(h hash.Hash) {
+	if b == nil {
+		h.Write([]byte{0})
+		return
+	}
+	buf := make([]byte, 17)
+	buf[0] = 1
+	binary.LittleEndian.PutUint64(buf[1:], uint64(b.Index))
+	binary.LittleEndian.PutUint64(buf[9:], uint64(b.Timestamp))
+	h.Write(buf)
+}
+
 // NewClientTransaction creates a transaction compatible with the version passed
 // in arguments. Depending on the version, the hash will have a different value.
 // Most common usage is:
@@ -134,6 +227,7 @@ func (instr Instruction) Hash() []byte {
 	h := sha256.New()
 	instr.hashType(h)
 	instr.hashSigners(h)
+	instr.hashDelegations(h)
 	return h.Sum(nil)
 }
 
@@ -185,6 +279,20 @@ func (instr Instruction) hashSigners(h hash.Hash) {
 	}
 }
 
+// hashDelegations only adds the presented delegations if there are some, so
+// that the hash of the other instructions doesn't change.
+func (instr Instruction) hashDelegations(h hash.Hash) {
+	if len(instr.Delegations) == 0 {
+		return
+	}
+	lenBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenBuf, uint64(len(instr.Delegations)))
+	h.Write(lenBuf)
+	for _, id := range instr.Delegations {
+		h.Write(id[:])
+	}
+}
+
 // DeriveID derives a new InstanceID from the hash of the instruction, its signatures,
 // and the given string.
 //
@@ -367,6 +475,9 @@ func (instr Instruction) GetIdentityStrings() []string {
 type VerificationOptions struct {
 	IgnoreCounters bool
 	EvalAttr       darc.AttrInterpreters
+	// action replaces the action of the instruction, for the instructions
+	// of the delegations.
+	action darc.Action
 }
 
 // Verify will look up the darc of the instance pointed to by the instruction
@@ -376,7 +487,7 @@ func (instr Instruction) Verify(st ReadOnlyStateTrie, msg []byte) error {
 	return instr.VerifyWithOption(st, msg, nil)
 }
 
-func (instr Instruction) usesForbiddenIdentities() bool {
+func (instr Instruction) usesForbiddenIdentities(version Version) bool {
 	// A synthetic instruction is currently not restricted
 	if instr.synthetic {
 		return false
@@ -391,11 +502,59 @@ func (instr Instruction) usesForbiddenIdentities() bool {
 		if id.Type() == evmContractType {
 			return true
 		}
+		// Older nodes don't know about the wallet identities
+		if version < VersionWalletIdentities &&
+			(id.Secp256k1 != nil || id.WebAuthn != nil) {
+			return true
+		}
 	}
 
 	return false
 }
 
+// verifyRuleVersion returns an error if the rule of the action, or the sign
+// rule of a darc that it reaches through the "darc:" ids, holds a term that
+// the version of the chain doesn't support.
+func verifyRuleVersion(st ReadOnlyStateTrie, d *darc.Darc, action darc.Action) error {
+	version := st.GetVersion()
+	if version >= VersionDelegation {
+		return nil
+	}
+	visited := make(map[string]bool)
+	var verify func(expr expression.Expr) error
+	verify = func(expr expression.Expr) error {
+		if err := verifyExprVersion(version, expr); err != nil {
+			return err
+		}
+		var refs []string
+		// The expressions that cannot be parsed are rejected by the
+		// evaluation.
+		expression.Explain(expr, func(id string) bool {
+			if strings.HasPrefix(id, "darc:") && !visited[id] {
+				visited[id] = true
+				refs = append(refs, id)
+			}
+			return false
+		})
+		for _, ref := range refs {
+			darcID, err := hex.DecodeString(ref[len("darc:"):])
+			if err != nil {
+				continue
+			}
+			// A missing darc is false in the evaluation.
+			rd, err := st.LoadDarc(darcID)
+			if err != nil {
+				continue
+			}
+			if err := verify(rd.Rules.GetSignExpr()); err != nil {
+				return xerrors.Errorf("%s: %v", ref, err)
+			}
+		}
+		return nil
+	}
+	return verify(d.Rules.Get(action))
+}
+
 // VerifyWithOption adds the ability to the Verify(...) method to specify if
 // the counters should be checked. This is used with the "defered" contract
 // where the clients sign the root instruction without the counters.
@@ -433,11 +592,19 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 	}
 
 	// check the action
-	if !d.Rules.Contains(darc.Action(instr.Action())) {
-		return xerrors.Errorf("action '%v' does not exist", instr.Action())
+	action := darc.Action(instr.Action())
+	if ops.action != "" {
+		action = ops.action
+	}
+	if !d.Rules.Contains(action) {
+		return xerrors.Errorf("action '%v' does not exist", action)
+	}
+	// Older nodes cannot evaluate the newer expressions
+	if err := verifyRuleVersion(st, d, action); err != nil {
+		return xerrors.Errorf("rule %s: %v", action, err)
 	}
 
-	if instr.usesForbiddenIdentities() {
+	if instr.usesForbiddenIdentities(st.GetVersion()) {
 		return xerrors.Errorf("instruction is using a forbidden signer identity")
 	}
 
@@ -470,12 +637,45 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 		}
 		return d
 	}
+	// A delegation cannot be used to manage the delegations, so that the
+	// delegate cannot extend it.
+	var delegates []darc.Identity
+	if ops.action == "" {
+		getDarc = delegationDarcGetter(st, d.GetBaseID(), action, getDarc)
+		delegates, err = presentedDelegates(st, instr, d.GetBaseID(), action)
+		if err != nil {
+			return xerrors.Errorf("presented delegations: %v", err)
+		}
+	} else if len(instr.Delegations) > 0 {
+		return xerrors.New("a delegation cannot be used to manage the delegations")
+	}
 
-	if ops.EvalAttr != nil {
-		err := darc.EvalExprAttr(d.Rules.Get(darc.Action(instr.Action())), getDarc, ops.EvalAttr, identitiesWithCorrectSignatures...)
-		return cothority.ErrorOrNil(err, "evaluating darc")
+	evalAttr := ops.EvalAttr
+	if st.GetVersion() >= VersionAttrInterpreters {
+		// The interpreters given by the contract take precedence over the
+		// registered ones.
+		evalAttr = MakeAttrInterpreters(st, instr)
+		for name, fn := range ops.EvalAttr {
+			evalAttr[name] = fn
+		}
+	}
+	eval := func(expr expression.Expr) error {
+		if evalAttr != nil {
+			return darc.EvalExprAttr(expr, getDarc, evalAttr, identitiesWithCorrectSignatures...)
+		}
+		return darc.EvalExpr(expr, getDarc, identitiesWithCorrectSignatures...)
+	}
+	err = eval(d.Rules.Get(action))
+	// The rule is not changed by the presented delegations: it is enough
+	// that one of their delegates signs.
+	for _, delegate := range delegates {
+		if err == nil {
+			break
+		}
+		if eval(expression.Expr(delegate.String())) == nil {
+			err = nil
+		}
 	}
-	err = darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), getDarc, identitiesWithCorrectSignatures...)
 	return cothority.ErrorOrNil(err, "evaluating darc")
 }
 
@@ -565,7 +765,7 @@ func (txr TxResults) Hash() []byte {
 
 	h := sha256.New()
 	for _, tx := range txr {
-		h.Write(tx.ClientTransaction.Instructions.Hash())
+		h.Write(tx.ClientTransaction.Hash())
 		if tx.Accepted {
 			h.Write(one[:])
 		} else {
@@ -648,7 +848,7 @@ func (sc *StateChange) Op() trie.OpType {
 		return trie.OpSet
 	case Remove:
 		return trie.OpDel
-	case GenerateInstruction:
+	case GenerateInstruction, EmitEvent:
 		return trie.Nop
 	}
 	return 0
@@ -697,6 +897,9 @@ const (
 	Remove
 	// GenerateInstruction allows to generate an instruction
 	GenerateInstruction
+	// EmitEvent allows to emit an event, which is stored in the block but
+	// not in the trie
+	EmitEvent
 )
 
 // String returns a readable output of the action.
@@ -710,68 +913,9 @@ func (sc StateAction) String() string {
 		return "Remove"
 	case GenerateInstruction:
 		return "GenerateInstruction"
+	case EmitEvent:
+		return "EmitEvent"
 	default:
 		return "Invalid stateChange"
 	}
 }
-
-const defaultMaxBufferSize = 1000
-
-// txBuffer is thread-safe data structure that store client transactions.
-type txBuffer struct {
-	sync.Mutex
-	txsMap map[string][]ClientTransaction
-}
-
-func newTxBuffer() txBuffer {
-	return txBuffer{
-		txsMap: make(map[string][]ClientTransaction),
-	}
-}
-
-func (r *txBuffer) take(key string, max int) []ClientTransaction {
-	r.Lock()
-	defer r.Unlock()
-
-	txs, ok := r.txsMap[key]
-	if !ok {
-		return []ClientTransaction{}
-	}
-
-	out := len(txs)
-	if max >= 0 && out > max {
-		// Take only up to the maximum required transactions.
-		out = max
-	}
-
-	ret := make([]ClientTransaction, out)
-	copy(ret, txs)
-
-	if out == max {
-		// Keep the overflow for the next collection.
-		r.txsMap[key] = txs[max:]
-	} else {
-		delete(r.txsMap, key)
-	}
-
-	return ret
-}
-
-func (r *txBuffer) add(key string, newTx ClientTransaction) {
-	r.Lock()
-	defer r.Unlock()
-
-	if txs, ok := r.txsMap[key]; !ok {
-		r.txsMap[key] = []ClientTransaction{newTx}
-	} else {
-		if len(txs) >= defaultMaxBufferSize {
-			// Drop transactions if the buffer is full. We cannot drop earlier
-			// transactions because an attacker could send multiple ones to
-			// replace legit transactions.
-			return
-		}
-
-		txs = append(txs, newTx)
-		r.txsMap[key] = txs
-	}
-}
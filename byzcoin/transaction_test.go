@@ -4,10 +4,12 @@ import (
 	"encoding/binary"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/protobuf"
 )
 
@@ -27,7 +29,7 @@ func TestTransaction_Signing(t *testing.T) {
 	mdb := trie.NewMemDB()
 	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
 	require.NoError(t, err)
-	sst := &stagingStateTrie{*tr.MakeStagingTrie(), trieCache{}, sync.Mutex{}}
+	sst := &stagingStateTrie{*tr.MakeStagingTrie(), trieCache{}, sync.Mutex{}, nil}
 
 	// verification should fail because trie is empty
 	ctxHash := ctx.Instructions.Hash()
@@ -76,53 +78,57 @@ func TestTransaction_Signing(t *testing.T) {
 	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
 }
 
-func TestTransactionBuffer_Add(t *testing.T) {
-	b := newTxBuffer()
-	key := "abc"
-	key2 := "abcd"
-
-	for i := 0; i < defaultMaxBufferSize*2; i++ {
-		b.add(key, ClientTransaction{})
-		b.add(key2, ClientTransaction{})
-	}
+func TestInstruction_ForbiddenIdentities(t *testing.T) {
+	signer, err := darc.NewSignerSecp256k1(nil)
+	require.NoError(t, err)
+	instr := Instruction{SignerIdentities: []darc.Identity{signer.Identity()}}
+	require.True(t, instr.usesForbiddenIdentities(VersionAttrInterpreters))
+	require.False(t, instr.usesForbiddenIdentities(VersionWalletIdentities))
 
-	require.Equal(t, defaultMaxBufferSize, len(b.txsMap[key]))
-	require.Equal(t, defaultMaxBufferSize, len(b.txsMap[key2]))
+	instr.SignerIdentities = []darc.Identity{darc.NewIdentityEvmContract(
+		&darc.SignerEvmContract{})}
+	require.True(t, instr.usesForbiddenIdentities(VersionWalletIdentities))
+	instr.synthetic = true
+	require.False(t, instr.usesForbiddenIdentities(VersionWalletIdentities))
 }
 
-func TestTransactionBuffer_Take(t *testing.T) {
-	b := newTxBuffer()
-	key := "abc"
-
-	for i := 0; i < 100; i++ {
-		b.add(key, ClientTransaction{})
-	}
-
-	txs := b.take(key, 12)
-	require.Equal(t, 12, len(txs))
-	require.Equal(t, 88, len(b.txsMap[key]))
+func TestClientTransaction_Window(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	ctx, err := createOneClientTx(darc.ID{}, "dummy_kind", []byte("dummy_value"), signer)
+	require.NoError(t, err)
+	require.Equal(t, ctx.Instructions.Hash(), ctx.Hash())
+	require.NoError(t, ctx.verifyWindow(VersionSpawnerCoins, 1, 0))
 
-	txs = b.take(key, 100)
-	require.Equal(t, 88, len(txs))
-	_, ok := b.txsMap[key]
-	require.False(t, ok)
+	ctx.NotBefore = &TxBound{Index: 10}
+	h1 := ctx.Hash()
+	require.NotEqual(t, ctx.Instructions.Hash(), h1)
+	ctx.NotBefore = &TxBound{Index: 11}
+	require.NotEqual(t, h1, ctx.Hash())
+	ctx.NotBefore = nil
+	ctx.NotAfter = &TxBound{Index: 10}
+	require.NotEqual(t, h1, ctx.Hash())
 
-	txs = b.take(key, 100)
-	require.Equal(t, 0, len(txs))
-}
+	ctx.NotBefore = &TxBound{Index: 10}
+	ctx.NotAfter = &TxBound{Index: 12}
+	require.Contains(t, ctx.verifyWindow(VersionSpawnerCoins, 10, 0).Error(), "only supported")
+	require.Contains(t, ctx.verifyWindow(VersionTxValidity, 9, 0).Error(), "not valid before")
+	require.NoError(t, ctx.verifyWindow(VersionTxValidity, 10, 0))
+	require.NoError(t, ctx.verifyWindow(VersionTxValidity, 12, 0))
+	require.Contains(t, ctx.verifyWindow(VersionTxValidity, 13, 0).Error(), "expired")
+	require.False(t, ctx.expired(12, 0))
+	require.True(t, ctx.expired(13, 0))
 
-func TestTransactionBuffer_TakeDisabled(t *testing.T) {
-	b := newTxBuffer()
-	key := "abc"
-
-	for i := 0; i < 10; i++ {
-		b.add(key, ClientTransaction{})
-	}
+	now := time.Now().UnixNano()
+	ctx.NotBefore = &TxBound{Timestamp: now}
+	ctx.NotAfter = &TxBound{Timestamp: now + int64(time.Minute)}
+	require.Error(t, ctx.verifyWindow(VersionTxValidity, 1, now-1))
+	require.NoError(t, ctx.verifyWindow(VersionTxValidity, 1, now))
+	require.Error(t, ctx.verifyWindow(VersionTxValidity, 1, now+int64(time.Hour)))
 
-	txs := b.take(key, -1)
-	require.Equal(t, 10, len(txs))
-	_, ok := b.txsMap[key]
-	require.False(t, ok)
+	ctx.NotAfter = &TxBound{Index: 1, Timestamp: now}
+	require.Contains(t, ctx.verifyWindow(VersionTxValidity, 1, now).Error(), "invalid NotAfter")
+	ctx.NotAfter = &TxBound{}
+	require.Contains(t, ctx.verifyWindow(VersionTxValidity, 1, now).Error(), "invalid NotAfter")
 }
 
 func TestInstruction_DeriveIDArg(t *testing.T) {
@@ -233,3 +239,44 @@ func combineInstrsAndSign(signer darc.Signer, instrs ...Instruction) (ClientTran
 	}
 	return t, nil
 }
+
+// TestInstruction_RuleVersion checks that the rules are verified against the
+// version of the chain, including the sign rules of the referenced darcs.
+func TestInstruction_RuleVersion(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	setVersion := func(v Version) {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(v))
+		require.NoError(t, sst.Set([]byte(trieVersionKey), buf))
+	}
+
+	ids := []darc.Identity{darc.NewSignerEd25519(nil, nil).Identity(),
+		darc.NewSignerEd25519(nil, nil).Identity()}
+	ref := darc.NewDarc(darc.InitRules(ids, ids), []byte("referenced"))
+	require.NoError(t, ref.Rules.UpdateSign(
+		expression.InitThresholdExpr(1, ids[0].String(), ids[1].String())))
+	refExpr := expression.Expr(ref.GetIdentityString())
+	d := darc.NewDarc(darc.InitRules([]darc.Identity{ids[0]},
+		[]darc.Identity{ids[0]}), []byte("referencing"))
+	require.NoError(t, d.Rules.AddRule("spawn:value", refExpr))
+
+	configBuf, err := protobuf.Encode(&ChainConfig{DarcContractIDs: []string{ContractDarcID}})
+	require.NoError(t, err)
+	refBuf, err := ref.ToProto()
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll([]StateChange{
+		NewStateChange(Create, NewInstanceID(nil), ContractConfigID, configBuf, nil),
+		NewStateChange(Create, NewInstanceID(ref.GetBaseID()), ContractDarcID,
+			refBuf, ref.GetBaseID()),
+	}))
+
+	setVersion(VersionEvents)
+	require.NoError(t, verifyRuleVersion(sst, d, "_sign"))
+	err = verifyRuleVersion(sst, d, "spawn:value")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "threshold expressions")
+
+	setVersion(VersionThreshold)
+	require.NoError(t, verifyRuleVersion(sst, d, "spawn:value"))
+}
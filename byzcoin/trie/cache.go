@@ -0,0 +1,196 @@
+package trie
+
+import (
+	"sort"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/xerrors"
+)
+
+// The cache holds the decoded nodes keyed by their hash. As the key of a node
+// is the hash of its content, a cached node is never stale, even when it is
+// read by a transaction that is rolled back. The superseded nodes are evicted
+// when they are deleted, so that the cache is refreshed by every commit.
+
+// decodedNode is a node decoded from the database. Its slices are shared by
+// all the readers and must not be modified.
+type decodedNode struct {
+	typ      nodeType
+	interior interiorNode
+	empty    emptyNode
+	leaf     leafNode
+	// size is the number of bytes the node takes in the cache.
+	size int64
+}
+
+// nodeOverhead approximates the memory taken by a cached node in addition to
+// its encoding and its key.
+const nodeOverhead = 200
+
+// nodeCache is an LRU cache of the decoded nodes bounded by their size, so
+// that large values cannot make it grow without limit.
+type nodeCache struct {
+	// size is the total size of the cached nodes, it is updated
+	// atomically. It comes first to be aligned for the atomic operations,
+	// like the numbers of lookups found and not found in the cache.
+	size    int64
+	hits    int64
+	misses  int64
+	maxSize int64
+	lru     *lru.Cache
+}
+
+func newNodeCache(maxSize int) (*nodeCache, error) {
+	c := &nodeCache{maxSize: int64(maxSize)}
+	// Every node takes more than nodeOverhead bytes, which bounds the
+	// number of entries.
+	cache, err := lru.NewWithEvict(maxSize/nodeOverhead+1, func(_, v interface{}) {
+		atomic.AddInt64(&c.size, -v.(*decodedNode).size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.lru = cache
+	return c, nil
+}
+
+func (c *nodeCache) get(key []byte) (*decodedNode, bool) {
+	n, ok := c.lru.Get(string(key))
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return n.(*decodedNode), true
+}
+
+// add caches the node, unless it is bigger than the whole cache, and evicts
+// the least recently used nodes until the cache fits in its size.
+func (c *nodeCache) add(key []byte, n *decodedNode) {
+	if n.size > c.maxSize {
+		return
+	}
+	if ok, _ := c.lru.ContainsOrAdd(string(key), n); ok {
+		return
+	}
+	atomic.AddInt64(&c.size, n.size)
+	for atomic.LoadInt64(&c.size) > c.maxSize && c.lru.Len() > 0 {
+		c.lru.RemoveOldest()
+	}
+}
+
+func (c *nodeCache) remove(key []byte) {
+	c.lru.Remove(string(key))
+}
+
+// SetCacheSize sets the maximal size in bytes of the decoded nodes kept in
+// memory, which are shared by the readers of the trie and its copies. Zero,
+// the default, disables the cache.
+func (t *Trie) SetCacheSize(size int) error {
+	if size <= 0 {
+		t.cache = nil
+		return nil
+	}
+	cache, err := newNodeCache(size)
+	if err != nil {
+		return xerrors.Errorf("creating cache: %v", err)
+	}
+	t.cache = cache
+	return nil
+}
+
+// loadNode returns the decoded node, from the cache if it is there. It
+// returns nil if the node doesn't exist.
+func (t *Trie) loadNode(b Bucket, key []byte) (*decodedNode, error) {
+	if t.cache != nil {
+		if n, ok := t.cache.get(key); ok {
+			return n, nil
+		}
+	}
+	nodeVal := clone(b.Get(key))
+	if len(nodeVal) == 0 {
+		return nil, nil
+	}
+	n := &decodedNode{
+		typ:  nodeType(nodeVal[0]),
+		size: int64(len(key) + len(nodeVal) + nodeOverhead),
+	}
+	var err error
+	switch n.typ {
+	case typeEmpty:
+		n.empty, err = decodeEmptyNode(nodeVal)
+	case typeLeaf:
+		n.leaf, err = decodeLeafNode(nodeVal)
+	case typeInterior:
+		n.interior, err = decodeInteriorNode(nodeVal)
+	default:
+		err = xerrors.New("invalid node type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.cache != nil {
+		t.cache.add(key, n)
+	}
+	return n, nil
+}
+
+// evict removes a deleted node from the cache.
+func (t *Trie) evict(key []byte) {
+	if t.cache != nil {
+		t.cache.remove(key)
+	}
+}
+
+// PrefetchProofs loads the nodes on the paths of the keys into the cache, so
+// that their proofs are created from memory. The nodes are loaded in a single
+// transaction, one level of the trie at a time, and the lookups of a level
+// are sorted so that they follow the order of the database. It does nothing
+// if the cache is disabled.
+func (t *Trie) PrefetchProofs(keys [][]byte) error {
+	if t.cache == nil {
+		return nil
+	}
+	bits := make([][]bool, len(keys))
+	for i, key := range keys {
+		bits[i] = t.binSlice(key)
+	}
+	return t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		level := map[string][][]bool{string(rootKey): bits}
+		for depth := 0; len(level) > 0; depth++ {
+			nodeKeys := make([]string, 0, len(level))
+			for k := range level {
+				nodeKeys = append(nodeKeys, k)
+			}
+			sort.Strings(nodeKeys)
+
+			next := make(map[string][][]bool)
+			for _, k := range nodeKeys {
+				n, err := t.loadNode(b, []byte(k))
+				if err != nil {
+					return err
+				}
+				if n == nil {
+					return xerrors.New("invalid node key")
+				}
+				if n.typ != typeInterior {
+					continue
+				}
+				left, right := splitBits(depth, level[k])
+				if len(left) > 0 {
+					next[string(n.interior.Left)] = append(next[string(n.interior.Left)], left...)
+				}
+				if len(right) > 0 {
+					next[string(n.interior.Right)] = append(next[string(n.interior.Right)], right...)
+				}
+			}
+			level = next
+		}
+		return nil
+	})
+}
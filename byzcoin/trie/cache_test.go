@@ -0,0 +1,162 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	testMemAndDisk(t, testCache)
+}
+
+func testCache(t *testing.T, db DB) {
+	nonce := genNonce()
+	testTrie, err := NewTrie(db, nonce)
+	require.NoError(t, err)
+	const cacheSize = 16 << 10
+	require.NoError(t, testTrie.SetCacheSize(cacheSize))
+
+	// The same operations on a trie without a cache.
+	mem := NewMemDB()
+	defer mem.Close()
+	refTrie, err := NewTrie(mem, nonce)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprint("key", i%30))
+		if i%7 == 6 {
+			require.NoError(t, testTrie.Delete(key))
+			require.NoError(t, refTrie.Delete(key))
+		} else {
+			require.NoError(t, testTrie.Set(key, []byte(fmt.Sprint(i))))
+			require.NoError(t, refTrie.Set(key, []byte(fmt.Sprint(i))))
+		}
+
+		// The readers fill the cache with the nodes of the current root.
+		for j := 0; j < 30; j += 4 {
+			key := []byte(fmt.Sprint("key", j))
+			val, err := testTrie.Get(key)
+			require.NoError(t, err)
+			refVal, err := refTrie.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, refVal, val)
+
+			p, err := testTrie.GetProof(key)
+			require.NoError(t, err)
+			refP, err := refTrie.GetProof(key)
+			require.NoError(t, err)
+			require.Equal(t, refP, p)
+		}
+		require.Equal(t, refTrie.GetRoot(), testTrie.GetRoot())
+	}
+	require.NoError(t, testTrie.IsValid())
+	// The cache is full but doesn't exceed its size.
+	var size int64
+	for _, k := range testTrie.cache.lru.Keys() {
+		n, ok := testTrie.cache.lru.Peek(k)
+		require.True(t, ok)
+		size += n.(*decodedNode).size
+	}
+	require.Equal(t, size, testTrie.cache.size)
+	require.True(t, size <= cacheSize)
+	require.True(t, size > cacheSize-1000)
+
+	// Only the nodes that are still in the database are cached.
+	require.NoError(t, db.View(func(b Bucket) error {
+		for _, k := range testTrie.cache.lru.Keys() {
+			require.NotNil(t, b.Get([]byte(k.(string))))
+		}
+		return nil
+	}))
+
+	// The staging trie reads the nodes through the cache of its source.
+	staging := testTrie.MakeStagingTrie()
+	require.NoError(t, staging.Set([]byte("key0"), []byte("staged")))
+	require.NoError(t, refTrie.Set([]byte("key0"), []byte("staged")))
+	require.Equal(t, refTrie.GetRoot(), staging.GetRoot())
+}
+
+func TestCache_Prefetch(t *testing.T) {
+	testMemAndDisk(t, testCachePrefetch)
+}
+
+func testCachePrefetch(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		keys = append(keys, []byte{byte(i)})
+		require.NoError(t, testTrie.Set(keys[i], keys[i]))
+	}
+	// Without a cache, it does nothing.
+	require.NoError(t, testTrie.PrefetchProofs(keys))
+
+	require.NoError(t, testTrie.SetCacheSize(1<<20))
+	require.NoError(t, testTrie.PrefetchProofs(append(keys[:10:10], []byte("missing"))))
+	cached := testTrie.cache.lru.Len()
+	for _, key := range keys[:10] {
+		p, err := testTrie.GetProof(key)
+		require.NoError(t, err)
+		require.True(t, p.Match(key))
+		for _, n := range p.Interiors {
+			require.True(t, testTrie.cache.lru.Contains(string(n.hash())))
+		}
+		require.True(t, testTrie.cache.lru.Contains(string(p.Leaf.hash(p.Nonce))))
+	}
+	// The proofs didn't load any other node.
+	require.Equal(t, cached, testTrie.cache.lru.Len())
+}
+
+func TestCache_MultiProof(t *testing.T) {
+	testMemAndDisk(t, testCacheMultiProof)
+}
+
+func testCacheMultiProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		keys = append(keys, []byte{byte(i)})
+		require.NoError(t, testTrie.Set(keys[i], keys[i]))
+	}
+	require.NoError(t, testTrie.SetCacheSize(1<<20))
+
+	// The paths are prefetched, every node once, and then the proof is
+	// made from the cache only.
+	p, err := testTrie.GetMultiProof(append(keys[:20:20], []byte("missing")))
+	require.NoError(t, err)
+	nodes := int64(len(p.Interiors) + len(p.Leaves) + len(p.Empties))
+	require.Equal(t, nodes, testTrie.cache.misses)
+	require.Equal(t, nodes, testTrie.cache.hits)
+
+	// A second proof is only made of hits.
+	_, err = testTrie.GetMultiProof(keys[:20])
+	require.NoError(t, err)
+	require.Equal(t, nodes, testTrie.cache.misses)
+}
+
+func TestCache_LargeValue(t *testing.T) {
+	testTrie, err := NewTrie(NewMemDB(), genNonce())
+	require.NoError(t, err)
+	require.NoError(t, testTrie.SetCacheSize(4096))
+	require.NoError(t, testTrie.Set([]byte("small"), []byte("value")))
+	require.NoError(t, testTrie.Set([]byte("large"), make([]byte, 8192)))
+
+	// A node bigger than the cache is read but never cached.
+	val, err := testTrie.Get([]byte("large"))
+	require.NoError(t, err)
+	require.Equal(t, 8192, len(val))
+	p, err := testTrie.GetProof([]byte("large"))
+	require.NoError(t, err)
+	require.False(t, testTrie.cache.lru.Contains(string(p.Leaf.hash(p.Nonce))))
+	require.True(t, testTrie.cache.size <= 4096)
+
+	val, err = testTrie.Get([]byte("small"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val)
+	p, err = testTrie.GetProof([]byte("small"))
+	require.NoError(t, err)
+	require.True(t, testTrie.cache.lru.Contains(string(p.Leaf.hash(p.Nonce))))
+}
@@ -100,4 +100,8 @@ func testMemAndDisk(t *testing.T, f func(*testing.T, DB)) {
 	disk := newDiskDB(t)
 	defer delDiskDB(t, disk)
 	f(t, disk)
+
+	level, closeLevel := newLevelDB(t)
+	defer closeLevel()
+	f(t, level)
 }
@@ -10,8 +10,10 @@ type nodeProcessor interface {
 
 // dfs is a depth first traversal. On every node, the corresponding function in
 // nodeProcessor is called. If an error is returned, then the traversal stops.
+// The nodes given to the processor don't share memory with the bucket, so they
+// stay valid after the transaction.
 func (t *Trie) dfs(p nodeProcessor, nodeKey []byte, b Bucket) error {
-	nodeVal := b.Get(nodeKey)
+	nodeVal := clone(b.Get(nodeKey))
 	if len(nodeVal) == 0 {
 		return xerrors.New("node key does not exist in copyTo")
 	}
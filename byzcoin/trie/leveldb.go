@@ -0,0 +1,12 @@
+package trie
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.dedis.ch/cothority/v3/kvstore"
+)
+
+// NewLevelDB creates a new LevelDB-backed database whose keys are stored with
+// the given prefix, see kvstore.NewLevelDB.
+func NewLevelDB(db *leveldb.DB, prefix []byte) DB {
+	return kvstore.NewLevelDB(db, prefix)
+}
@@ -0,0 +1,47 @@
+package trie
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const testLevelDBName = "test_trie.ldb"
+
+func newLevelDB(t *testing.T) (DB, func()) {
+	db, err := leveldb.OpenFile(testLevelDBName, nil)
+	require.NoError(t, err)
+	return NewLevelDB(db, []byte(bucketName)), func() {
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(testLevelDBName))
+	}
+}
+
+func TestLevelDB_Prefix(t *testing.T) {
+	db, err := leveldb.OpenFile(testLevelDBName, nil)
+	require.NoError(t, err)
+	defer os.RemoveAll(testLevelDBName)
+	defer db.Close()
+
+	// Two tries sharing the same database don't see each other.
+	db1 := NewLevelDB(db, []byte("trie1/"))
+	db2 := NewLevelDB(db, []byte("trie2/"))
+	trie1, err := NewTrie(db1, genNonce())
+	require.NoError(t, err)
+	trie2, err := NewTrie(db2, genNonce())
+	require.NoError(t, err)
+	require.NoError(t, trie1.Set([]byte("key"), []byte("1")))
+	val, err := trie2.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Nil(t, val)
+	require.NoError(t, trie1.IsValid())
+	require.NoError(t, trie2.IsValid())
+
+	trie1, err = LoadTrie(db1)
+	require.NoError(t, err)
+	val, err = trie1.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+}
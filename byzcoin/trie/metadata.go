@@ -11,8 +11,10 @@ const nonceKey = "dedis_trie_nonce"
 const metaMaxLen = 31
 
 func isIllegalKey(buf []byte) bool {
-	if bytes.Equal(buf, []byte(entryKey)) || bytes.Equal(buf, []byte(nonceKey)) {
-		return true
+	for _, k := range []string{entryKey, nonceKey, persistentKey, rootsFirstKey, rootsNextKey} {
+		if bytes.Equal(buf, []byte(k)) {
+			return true
+		}
 	}
 	return false
 }
@@ -33,7 +35,7 @@ func (t *Trie) SetMetadataWithBucket(key []byte, val []byte, b Bucket) error {
 		return xerrors.New("key must be " + string(metaMaxLen) + " bytes or shorter")
 	}
 	if isIllegalKey(key) {
-		return xerrors.New("the key is illegal, it cannot be \"" + entryKey + "\" or \"" + nonceKey + "\"")
+		return xerrors.New("the key is illegal, it is reserved by the trie")
 	}
 	return b.Put(key, val)
 }
@@ -84,7 +86,7 @@ func (t *Trie) DeleteMetadataWithBucket(key []byte, b Bucket) error {
 		return xerrors.New("key must be " + string(metaMaxLen) + " bytes or shorter")
 	}
 	if isIllegalKey(key) {
-		return xerrors.New("the key is illegal, it cannot be \"" + entryKey + "\" or \"" + nonceKey + "\"")
+		return xerrors.New("the key is illegal, it is reserved by the trie")
 	}
 	return b.Delete(key)
 }
@@ -0,0 +1,195 @@
+package trie
+
+import (
+	"bytes"
+
+	"golang.org/x/xerrors"
+)
+
+// GetMultiProof gets the inclusion/absence proofs of all the given keys in a
+// single proof.
+func (t *Trie) GetMultiProof(keys [][]byte) (*MultiProof, error) {
+	if len(keys) == 0 {
+		return nil, xerrors.New("no keys")
+	}
+	// The paths are then read from the cache, if it is enabled.
+	if err := t.PrefetchProofs(keys); err != nil {
+		return nil, xerrors.Errorf("prefetching the paths: %v", err)
+	}
+	bits := make([][]bool, len(keys))
+	for i, key := range keys {
+		bits[i] = t.binSlice(key)
+	}
+	p := &MultiProof{noHashKey: t.noHashKey}
+	err := t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		p.Nonce = clone(t.nonce)
+		return t.getMultiProof(0, rootKey, bits, p, b)
+	})
+	return p, err
+}
+
+// getMultiProof updates MultiProof p as it traverses the tree along the paths
+// of all the bits.
+func (t *Trie) getMultiProof(depth int, nodeKey []byte, bits [][]bool, p *MultiProof, b Bucket) error {
+	n, err := t.loadNode(b, nodeKey)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return xerrors.New("invalid node key")
+	}
+	switch n.typ {
+	case typeEmpty:
+		p.Empties = append(p.Empties, n.empty)
+		return nil
+	case typeLeaf:
+		p.Leaves = append(p.Leaves, n.leaf)
+		return nil
+	case typeInterior:
+		node := n.interior
+		p.Interiors = append(p.Interiors, node)
+		left, right := splitBits(depth, bits)
+		if len(left) > 0 {
+			if err := t.getMultiProof(depth+1, node.Left, left, p, b); err != nil {
+				return err
+			}
+		}
+		if len(right) > 0 {
+			return t.getMultiProof(depth+1, node.Right, right, p, b)
+		}
+		return nil
+	}
+	return xerrors.New("invalid node type")
+}
+
+func splitBits(depth int, bits [][]bool) (left, right [][]bool) {
+	for _, b := range bits {
+		if b[depth] {
+			left = append(left, b)
+		} else {
+			right = append(right, b)
+		}
+	}
+	return
+}
+
+// GetRoot returns the Merkle root.
+func (p *MultiProof) GetRoot() []byte {
+	if len(p.Interiors) == 0 {
+		return nil
+	}
+	return p.Interiors[0].hash()
+}
+
+// Proofs verifies the hash chains of the multi-proof and returns the proof of
+// each of the keys, in the same order. The keys must be the ones that were
+// given to create the proof. Like for a Proof, the caller must check the
+// root and use Exists, Match or Get on the returned proofs.
+func (p *MultiProof) Proofs(keys [][]byte) ([]Proof, error) {
+	if len(keys) == 0 {
+		return nil, xerrors.New("no keys")
+	}
+	if len(p.Interiors) == 0 {
+		return nil, xerrors.New("no interior nodes")
+	}
+
+	w := multiProofWalker{
+		MultiProof: p,
+		bits:       make([][]bool, len(keys)),
+		proofs:     make([]Proof, len(keys)),
+	}
+	indexes := make([]int, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			return nil, xerrors.New("key is nil")
+		}
+		w.bits[i] = p.binSlice(key)
+		indexes[i] = i
+	}
+	if err := w.walk(0, p.GetRoot(), nil, indexes); err != nil {
+		return nil, err
+	}
+	if w.interiors != len(p.Interiors) || w.leaves != len(p.Leaves) ||
+		w.empties != len(p.Empties) {
+		return nil, xerrors.New("unused nodes in the proof")
+	}
+	return w.proofs, nil
+}
+
+func (p *MultiProof) binSlice(buf []byte) []bool {
+	return (&Proof{noHashKey: p.noHashKey}).binSlice(buf)
+}
+
+// multiProofWalker follows the same traversal as getMultiProof to rebuild
+// the proof of each key.
+type multiProofWalker struct {
+	*MultiProof
+	bits   [][]bool
+	proofs []Proof
+	// Positions of the next nodes to be used.
+	interiors, leaves, empties int
+}
+
+func (w *multiProofWalker) walk(depth int, expectedHash []byte, path []interiorNode, indexes []int) error {
+	for _, i := range indexes {
+		if depth >= len(w.bits[i]) {
+			return xerrors.New("proof is deeper than the key")
+		}
+	}
+
+	if w.interiors < len(w.Interiors) && bytes.Equal(expectedHash, w.Interiors[w.interiors].hash()) {
+		node := w.Interiors[w.interiors]
+		w.interiors++
+		// Copy the path so that the branches don't share the appended node.
+		path = append(path[:len(path):len(path)], node)
+		var left, right []int
+		for _, i := range indexes {
+			if w.bits[i][depth] {
+				left = append(left, i)
+			} else {
+				right = append(right, i)
+			}
+		}
+		if len(left) > 0 {
+			if err := w.walk(depth+1, node.Left, path, left); err != nil {
+				return err
+			}
+		}
+		if len(right) > 0 {
+			return w.walk(depth+1, node.Right, path, right)
+		}
+		return nil
+	}
+
+	if w.leaves < len(w.Leaves) && bytes.Equal(expectedHash, w.Leaves[w.leaves].hash(w.Nonce)) {
+		leaf := w.Leaves[w.leaves]
+		w.leaves++
+		for _, i := range indexes {
+			if !equal(w.bits[i][:depth], leaf.Prefix) {
+				return xerrors.New("invalid prefix in leaf node")
+			}
+			w.proofs[i] = Proof{Interiors: path, Leaf: leaf, Nonce: w.Nonce,
+				noHashKey: w.noHashKey}
+		}
+		return nil
+	}
+
+	if w.empties < len(w.Empties) && bytes.Equal(expectedHash, w.Empties[w.empties].hash(w.Nonce)) {
+		empty := w.Empties[w.empties]
+		w.empties++
+		for _, i := range indexes {
+			if !equal(w.bits[i][:depth], empty.Prefix) {
+				return xerrors.New("invalid prefix in empty node")
+			}
+			w.proofs[i] = Proof{Interiors: path, Empty: empty, Nonce: w.Nonce,
+				noHashKey: w.noHashKey}
+		}
+		return nil
+	}
+
+	return xerrors.New("invalid hash chain")
+}
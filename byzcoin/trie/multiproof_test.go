@@ -0,0 +1,63 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiProof(t *testing.T) {
+	testMemAndDisk(t, testMultiProof)
+}
+
+func testMultiProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	for i := 10; i < 60; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+
+	// Present and absent keys, with a duplicate.
+	var keys [][]byte
+	for i := 0; i < 70; i += 3 {
+		keys = append(keys, []byte{byte(i)})
+	}
+	keys = append(keys, []byte{12})
+
+	p, err := testTrie.GetMultiProof(keys)
+	require.NoError(t, err)
+	require.Equal(t, testTrie.GetRoot(), p.GetRoot())
+
+	proofs, err := p.Proofs(keys)
+	require.NoError(t, err)
+	require.Len(t, proofs, len(keys))
+	interiors := 0
+	for i, key := range keys {
+		single, err := testTrie.GetProof(key)
+		require.NoError(t, err)
+		require.Equal(t, single.Interiors, proofs[i].Interiors)
+		interiors += len(single.Interiors)
+
+		ok, err := proofs[i].Exists(key)
+		require.NoError(t, err)
+		require.Equal(t, key[0] >= 10 && key[0] < 60, ok)
+		if ok {
+			require.Equal(t, key, proofs[i].Get(key))
+		}
+	}
+	// The interior nodes are shared.
+	require.True(t, len(p.Interiors) < interiors)
+
+	// All the nodes must be used by the keys.
+	_, err = p.Proofs([][]byte{{11}})
+	require.Error(t, err)
+
+	// A modified node breaks the hash chain.
+	p.Leaves[0].Value = []byte("forged")
+	_, err = p.Proofs(keys)
+	require.Error(t, err)
+
+	_, err = testTrie.GetMultiProof(nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,465 @@
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// In the persistent mode, the nodes are never deleted in place when they are
+// superseded. Every node has a reference count, which is the number of
+// interior nodes pointing to it plus, for the roots, the number of times it
+// is the entry point or a retained root. A node is removed, and the reference
+// counts of its children decremented, only when its count drops to zero. The
+// root of every commit is retained so that it can be read with At until it
+// gets older than the retention window.
+
+const persistentKey = "dedis_trie_persistent"
+
+// The retained roots are stored under one key each, numbered from
+// rootsFirstKey to rootsNextKey, so that a commit doesn't rewrite the older
+// roots. The number of times a root is retained is stored too, to find out
+// whether it is retained without reading all of them.
+const rootsFirstKey = "dedis_trie_roots_first"
+const rootsNextKey = "dedis_trie_roots_next"
+
+// refCountPrefix, rootPrefix and retainedPrefix are the prefixes of the keys
+// of the reference counts, of the retained roots and of their counts. These
+// keys are longer than the node keys and the metadata keys so that they
+// cannot collide.
+const refCountPrefix = "dedis_trie_refcount_"
+const rootPrefix = "dedis_trie_committed_root_number_"
+const retainedPrefix = "dedis_trie_retained_"
+
+// NewPersistentTrie creates a new trie in the persistent mode with a
+// user-specified nonce. Like NewTrie, it will return an error if it is called
+// on an existing database. The root of the new trie is retained.
+func NewPersistentTrie(db DB, nonce []byte) (*Trie, error) {
+	return newTrie(db, nonce, true)
+}
+
+// IsPersistent returns whether the trie keeps the superseded nodes.
+func (t *Trie) IsPersistent() bool {
+	return t.persistent
+}
+
+// SetRetention sets the number of retained roots. When a root is committed
+// and more roots are retained, the oldest ones are released and the nodes
+// that are not reachable anymore are removed. Zero, the default, retains all
+// the roots. The retention is not stored in the database.
+func (t *Trie) SetRetention(n int) {
+	t.retention = n
+}
+
+// Retention returns the number of retained roots, 0 if all the roots are
+// retained.
+func (t *Trie) Retention() int {
+	return t.retention
+}
+
+// CommitRoot retains the current root, then releases the roots that are
+// older than the retention window. It is a no-op if the trie is not
+// persistent.
+func (t *Trie) CommitRoot() error {
+	return t.db.Update(func(b Bucket) error {
+		return t.CommitRootWithBucket(b)
+	})
+}
+
+// CommitRootWithBucket is like CommitRoot but must be called inside a
+// DB.Update transaction. Set, Delete, Batch and StagingTrie.Commit call it,
+// but the *WithBucket methods leave it to the caller, so that all the
+// operations of a transaction create a single root.
+func (t *Trie) CommitRootWithBucket(b Bucket) error {
+	if !t.persistent {
+		return nil
+	}
+	root := clone(t.GetRootWithBucket(b))
+	if root == nil {
+		return xerrors.New("no root key")
+	}
+	if err := t.incRef(b, root); err != nil {
+		return err
+	}
+	next := getUint64(b, []byte(rootsNextKey))
+	if err := b.Put(rootKey(next), root); err != nil {
+		return err
+	}
+	if err := putUint64(b, []byte(rootsNextKey), next+1); err != nil {
+		return err
+	}
+	key := retainedKey(root)
+	if err := putUint64(b, key, getUint64(b, key)+1); err != nil {
+		return err
+	}
+	return t.gcWithBucket(b)
+}
+
+// GC releases the roots that are older than the retention window. It is
+// only needed when the retention is reduced, as it is also done on every
+// commit.
+func (t *Trie) GC() error {
+	if !t.persistent {
+		return nil
+	}
+	return t.db.Update(func(b Bucket) error {
+		return t.gcWithBucket(b)
+	})
+}
+
+func (t *Trie) gcWithBucket(b Bucket) error {
+	first := getUint64(b, []byte(rootsFirstKey))
+	next := getUint64(b, []byte(rootsNextKey))
+	if t.retention <= 0 || next-first <= uint64(t.retention) {
+		return nil
+	}
+	for ; next-first > uint64(t.retention); first++ {
+		root := clone(b.Get(rootKey(first)))
+		if err := b.Delete(rootKey(first)); err != nil {
+			return err
+		}
+		key := retainedKey(root)
+		n := getUint64(b, key)
+		if n > 1 {
+			if err := putUint64(b, key, n-1); err != nil {
+				return err
+			}
+		} else if err := b.Delete(key); err != nil {
+			return err
+		}
+		if err := t.decRef(b, root); err != nil {
+			return err
+		}
+	}
+	return putUint64(b, []byte(rootsFirstKey), first)
+}
+
+// RetainedRoots returns the retained roots, from the oldest to the newest.
+func (t *Trie) RetainedRoots() [][]byte {
+	var roots [][]byte
+	t.db.View(func(b Bucket) error {
+		roots = t.getRoots(b)
+		return nil
+	})
+	return roots
+}
+
+func (t *Trie) getRoots(b Bucket) [][]byte {
+	first := getUint64(b, []byte(rootsFirstKey))
+	next := getUint64(b, []byte(rootsNextKey))
+	roots := make([][]byte, 0, next-first)
+	for i := first; i < next; i++ {
+		roots = append(roots, clone(b.Get(rootKey(i))))
+	}
+	return roots
+}
+
+func (t *Trie) isRetained(b Bucket, root []byte) bool {
+	return bytes.Equal(root, t.GetRootWithBucket(b)) ||
+		getUint64(b, retainedKey(root)) > 0
+}
+
+func rootKey(i uint64) []byte {
+	key := make([]byte, len(rootPrefix)+8)
+	copy(key, rootPrefix)
+	binary.BigEndian.PutUint64(key[len(rootPrefix):], i)
+	return key
+}
+
+func retainedKey(root []byte) []byte {
+	return append([]byte(retainedPrefix), root...)
+}
+
+func getUint64(b Bucket, key []byte) uint64 {
+	buf := b.Get(key)
+	if len(buf) != 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(buf)
+}
+
+func putUint64(b Bucket, key []byte, n uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, n)
+	return b.Put(key, buf)
+}
+
+// Rollback sets the current root back to a retained root. The roots that
+// have been committed since then stay retained until they get out of the
+// retention window.
+func (t *Trie) Rollback(root []byte) error {
+	return t.db.Update(func(b Bucket) error {
+		return t.RollbackWithBucket(root, b)
+	})
+}
+
+// RollbackWithBucket is like Rollback but must be called inside a DB.Update
+// transaction.
+func (t *Trie) RollbackWithBucket(root []byte, b Bucket) error {
+	if !t.persistent {
+		return xerrors.New("trie is not persistent")
+	}
+	if !t.isRetained(b, root) {
+		return xerrors.New("root is not retained")
+	}
+	return t.setRootWithBucket(b, clone(root))
+}
+
+// At returns a read-only view of the trie at a retained root.
+func (t *Trie) At(root []byte) (*Snapshot, error) {
+	if !t.persistent {
+		return nil, xerrors.New("trie is not persistent")
+	}
+	var ok bool
+	err := t.db.View(func(b Bucket) error {
+		ok = t.isRetained(b, root)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, xerrors.New("root is not retained")
+	}
+	return &Snapshot{trie: t, root: clone(root)}, nil
+}
+
+// Snapshot is a read-only view of a persistent trie at a given root. It stays
+// valid until the root is released by the garbage collector.
+type Snapshot struct {
+	trie *Trie
+	root []byte
+}
+
+// GetRoot returns the root of the snapshot.
+func (s *Snapshot) GetRoot() []byte {
+	return clone(s.root)
+}
+
+// GetNonce returns the nonce of the trie.
+func (s *Snapshot) GetNonce() ([]byte, error) {
+	return s.trie.nonce, nil
+}
+
+// Get looks up whether a value exists for the given key at the root of the
+// snapshot.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.view(func(b Bucket) error {
+		v, err := s.trie.get(0, s.root, s.trie.binSlice(key), key, b)
+		val = clone(v)
+		return err
+	})
+	return val, err
+}
+
+// GetProof gets the inclusion/absence proof for the given key at the root of
+// the snapshot.
+func (s *Snapshot) GetProof(key []byte) (*Proof, error) {
+	p := &Proof{}
+	err := s.view(func(b Bucket) error {
+		p.Nonce = clone(s.trie.nonce)
+		return s.trie.getProof(0, s.root, s.trie.binSlice(key), p, b)
+	})
+	return p, err
+}
+
+// ForEach runs the callback cb on every key/value pair of the trie at the
+// root of the snapshot.
+func (s *Snapshot) ForEach(cb func(k, v []byte) error) error {
+	p := leafCallbackProcessor{cb}
+	return s.view(func(b Bucket) error {
+		return s.trie.dfs(&p, s.root, b)
+	})
+}
+
+// MakeStagingTrie makes a StagingTrie that reads from the snapshot. It stays
+// valid when the trie is modified, but it cannot be committed.
+func (s *Snapshot) MakeStagingTrie() *StagingTrie {
+	return &StagingTrie{
+		source:     s.trie,
+		snapshot:   s,
+		overlay:    make(map[string][]byte),
+		deleteList: make(map[string][]byte),
+	}
+}
+
+func (s *Snapshot) view(f func(Bucket) error) error {
+	return s.trie.db.View(func(b Bucket) error {
+		if b.Get(s.root) == nil {
+			return xerrors.New("root has been released")
+		}
+		return f(b)
+	})
+}
+
+// setRootWithBucket updates the entry point, which holds a reference to the
+// current root.
+func (t *Trie) setRootWithBucket(b Bucket, root []byte) error {
+	old := clone(t.GetRootWithBucket(b))
+	if err := b.Put([]byte(entryKey), root); err != nil {
+		return err
+	}
+	if !t.persistent {
+		return nil
+	}
+	// The new root must be referenced before the old one is released,
+	// because they can share nodes or be equal.
+	if err := t.incRef(b, root); err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	return t.decRef(b, old)
+}
+
+// putNode stores a node. In the persistent mode, an existing node is left
+// as it is and a new interior node references its children.
+func (t *Trie) putNode(b Bucket, key, val []byte) error {
+	if !t.persistent {
+		return b.Put(key, val)
+	}
+	if b.Get(key) != nil {
+		return nil
+	}
+	if nodeType(val[0]) == typeInterior {
+		node, err := decodeInteriorNode(val)
+		if err != nil {
+			return err
+		}
+		if err := t.incRef(b, node.Left); err != nil {
+			return err
+		}
+		if err := t.incRef(b, node.Right); err != nil {
+			return err
+		}
+	}
+	return b.Put(key, val)
+}
+
+// deleteNode deletes a superseded node, unless the trie is persistent, in
+// which case it is removed once it isn't referenced anymore.
+func (t *Trie) deleteNode(b Bucket, key []byte) error {
+	if t.persistent {
+		return nil
+	}
+	t.evict(key)
+	return b.Delete(key)
+}
+
+func refCountKey(key []byte) []byte {
+	return append([]byte(refCountPrefix), key...)
+}
+
+func (t *Trie) getRef(b Bucket, key []byte) uint64 {
+	return getUint64(b, refCountKey(key))
+}
+
+func (t *Trie) incRef(b Bucket, key []byte) error {
+	return putUint64(b, refCountKey(key), t.getRef(b, key)+1)
+}
+
+// decRef decrements the reference count of the node and removes it if it
+// drops to zero, which in turn decrements the counts of its children.
+func (t *Trie) decRef(b Bucket, key []byte) error {
+	n := t.getRef(b, key)
+	if n == 0 {
+		return xerrors.New("node is not referenced")
+	}
+	if n > 1 {
+		return putUint64(b, refCountKey(key), n-1)
+	}
+
+	nodeVal := clone(b.Get(key))
+	if len(nodeVal) == 0 {
+		return xerrors.New("node key does not exist in decRef")
+	}
+	if err := b.Delete(refCountKey(key)); err != nil {
+		return err
+	}
+	t.evict(key)
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	if nodeType(nodeVal[0]) != typeInterior {
+		return nil
+	}
+	node, err := decodeInteriorNode(nodeVal)
+	if err != nil {
+		return err
+	}
+	if err := t.decRef(b, node.Left); err != nil {
+		return err
+	}
+	return t.decRef(b, node.Right)
+}
+
+// checkRefCounts checks that the reference counts match the nodes reachable
+// from the current and the retained roots, and that there are no other
+// nodes.
+func (t *Trie) checkRefCounts() error {
+	return t.db.View(func(b Bucket) error {
+		expected := make(map[string]uint64)
+		visited := make(map[string]bool)
+		var visit func(key []byte) error
+		visit = func(key []byte) error {
+			if visited[string(key)] {
+				return nil
+			}
+			visited[string(key)] = true
+			nodeVal := b.Get(key)
+			if len(nodeVal) == 0 {
+				return xerrors.New("missing node")
+			}
+			if nodeType(nodeVal[0]) != typeInterior {
+				return nil
+			}
+			node, err := decodeInteriorNode(nodeVal)
+			if err != nil {
+				return err
+			}
+			expected[string(node.Left)]++
+			expected[string(node.Right)]++
+			if err := visit(node.Left); err != nil {
+				return err
+			}
+			return visit(node.Right)
+		}
+
+		roots := append(t.getRoots(b), clone(t.GetRootWithBucket(b)))
+		for _, root := range roots {
+			expected[string(root)]++
+			if err := visit(root); err != nil {
+				return err
+			}
+		}
+
+		var nodes, refs int
+		err := b.ForEach(func(k, v []byte) error {
+			switch {
+			case bytes.HasPrefix(k, []byte(refCountPrefix)):
+				refs++
+				key := k[len(refCountPrefix):]
+				if binary.LittleEndian.Uint64(v) != expected[string(key)] {
+					return xerrors.New("wrong reference count")
+				}
+			case len(k) == sha256.Size:
+				nodes++
+				if !visited[string(k)] {
+					return xerrors.New("dangling nodes")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if nodes != len(visited) || refs != len(visited) {
+			return xerrors.New("missing reference counts")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,179 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentTrie(t *testing.T) {
+	testMemAndDisk(t, testPersistentTrie)
+}
+
+func testPersistentTrie(t *testing.T, db DB) {
+	testTrie, err := NewPersistentTrie(db, genNonce())
+	require.NoError(t, err)
+	require.True(t, testTrie.IsPersistent())
+	require.NoError(t, testTrie.IsValid())
+	require.Equal(t, [][]byte{testTrie.GetRoot()}, testTrie.RetainedRoots())
+
+	// Every batch is a commit which keeps its root.
+	var roots [][]byte
+	for i := 0; i < 5; i++ {
+		pairs := []KVPair{
+			kvPair{OpSet, []byte("key"), []byte(fmt.Sprint(i))},
+			kvPair{OpSet, []byte(fmt.Sprint("key", i)), []byte("value")},
+		}
+		if i > 0 {
+			pairs = append(pairs, kvPair{OpDel, []byte(fmt.Sprint("key", i-1)), nil})
+		}
+		require.NoError(t, testTrie.Batch(pairs))
+		require.NoError(t, testTrie.IsValid())
+		roots = append(roots, testTrie.GetRoot())
+	}
+	require.Equal(t, 6, len(testTrie.RetainedRoots()))
+
+	for i, root := range roots {
+		snap, err := testTrie.At(root)
+		require.NoError(t, err)
+		val, err := snap.Get([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprint(i)), val)
+		val, err = snap.Get([]byte(fmt.Sprint("key", i)))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), val)
+		if i > 0 {
+			val, err = snap.Get([]byte(fmt.Sprint("key", i-1)))
+			require.NoError(t, err)
+			require.Nil(t, val)
+		}
+
+		proof, err := snap.GetProof([]byte("key"))
+		require.NoError(t, err)
+		require.Equal(t, root, proof.GetRoot())
+		require.True(t, proof.Match([]byte("key")))
+
+		var n int
+		require.NoError(t, snap.ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		}))
+		require.Equal(t, 2, n)
+	}
+
+	_, err = testTrie.At([]byte("not a root"))
+	require.Error(t, err)
+
+	// Rollback to an older root.
+	require.NoError(t, testTrie.Rollback(roots[1]))
+	require.Equal(t, roots[1], testTrie.GetRoot())
+	val, err := testTrie.Get([]byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+	require.NoError(t, testTrie.IsValid())
+
+	// The staging trie commits a single root.
+	staging := testTrie.MakeStagingTrie()
+	require.NoError(t, staging.Set([]byte("a"), []byte("1")))
+	require.NoError(t, staging.Set([]byte("b"), []byte("2")))
+	root := staging.GetRoot()
+	require.NoError(t, staging.Commit())
+	require.Equal(t, root, testTrie.GetRoot())
+	require.Equal(t, 7, len(testTrie.RetainedRoots()))
+	require.NoError(t, testTrie.IsValid())
+
+	// A staging trie of a snapshot keeps reading its root.
+	snap, err := testTrie.At(root)
+	require.NoError(t, err)
+	staging = snap.MakeStagingTrie()
+	require.NoError(t, testTrie.Set([]byte("a"), []byte("3")))
+	val, err = staging.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+	require.Equal(t, root, staging.GetRoot())
+	require.NoError(t, staging.Set([]byte("c"), []byte("4")))
+	val, err = staging.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("4"), val)
+	require.NotEqual(t, root, staging.GetRoot())
+	require.Error(t, staging.Commit())
+
+	// The mode is stored in the database.
+	testTrie, err = LoadTrie(db)
+	require.NoError(t, err)
+	require.True(t, testTrie.IsPersistent())
+	require.Error(t, testTrie.SetMetadata([]byte(rootsFirstKey), []byte{}))
+	require.Error(t, testTrie.SetMetadata([]byte(rootsNextKey), []byte{}))
+	require.Error(t, testTrie.SetMetadata([]byte(persistentKey), []byte{}))
+}
+
+func TestPersistentTrie_GC(t *testing.T) {
+	testMemAndDisk(t, testPersistentTrieGC)
+}
+
+func testPersistentTrieGC(t *testing.T, db DB) {
+	nonce := genNonce()
+	testTrie, err := NewPersistentTrie(db, nonce)
+	require.NoError(t, err)
+	testTrie.SetRetention(3)
+
+	// The same operations on a trie that is not persistent.
+	mem := NewMemDB()
+	defer mem.Close()
+	refTrie, err := NewTrie(mem, nonce)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprint("key", i%7))
+		if i%5 == 4 {
+			require.NoError(t, testTrie.Delete(key))
+			require.NoError(t, refTrie.Delete(key))
+		} else {
+			require.NoError(t, testTrie.Set(key, []byte(fmt.Sprint(i))))
+			require.NoError(t, refTrie.Set(key, []byte(fmt.Sprint(i))))
+		}
+		require.NoError(t, testTrie.IsValid())
+		require.Equal(t, refTrie.GetRoot(), testTrie.GetRoot())
+	}
+	roots := testTrie.RetainedRoots()
+	require.Equal(t, 3, len(roots))
+	require.Equal(t, testTrie.GetRoot(), roots[2])
+
+	// Reducing the retention releases all the superseded nodes, so that only
+	// the nodes of the reference trie are left.
+	testTrie.SetRetention(1)
+	require.NoError(t, testTrie.GC())
+	require.NoError(t, testTrie.IsValid())
+	_, err = testTrie.At(roots[0])
+	require.Error(t, err)
+	require.Equal(t, countNodes(t, mem), countNodes(t, db))
+
+	// Only the retained roots are stored, under one key each.
+	var n int
+	require.NoError(t, db.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if bytes.HasPrefix(k, []byte(rootPrefix)) {
+				n++
+			}
+			return nil
+		})
+	}))
+	require.Equal(t, 1, n)
+	require.Equal(t, [][]byte{testTrie.GetRoot()}, testTrie.RetainedRoots())
+}
+
+// countNodes counts the nodes stored in the database.
+func countNodes(t *testing.T, db DB) int {
+	var n int
+	require.NoError(t, db.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) == 32 {
+				n++
+			}
+			return nil
+		})
+	}))
+	return n
+}
@@ -117,30 +117,22 @@ func (t *Trie) GetProof(key []byte) (*Proof, error) {
 
 // getProof updates Proof p as it traverses the tree.
 func (t *Trie) getProof(depth int, nodeKey []byte, bits []bool, p *Proof, b Bucket) error {
-	nodeVal := clone(b.Get(nodeKey))
-	if len(nodeVal) == 0 {
+	n, err := t.loadNode(b, nodeKey)
+	if err != nil {
+		return err
+	}
+	if n == nil {
 		return xerrors.New("invalid node key")
 	}
-	switch nodeType(nodeVal[0]) {
+	switch n.typ {
 	case typeEmpty:
-		node, err := decodeEmptyNode(nodeVal)
-		if err != nil {
-			return err
-		}
-		p.Empty = node
+		p.Empty = n.empty
 		return nil
 	case typeLeaf:
-		node, err := decodeLeafNode(nodeVal)
-		if err != nil {
-			return err
-		}
-		p.Leaf = node
+		p.Leaf = n.leaf
 		return nil
 	case typeInterior:
-		node, err := decodeInteriorNode(nodeVal)
-		if err != nil {
-			return err
-		}
+		node := n.interior
 		p.Interiors = append(p.Interiors, node)
 		if bits[depth] {
 			return t.getProof(depth+1, node.Left, bits, p, b)
@@ -151,6 +143,135 @@ func (t *Trie) getProof(depth int, nodeKey []byte, bits []bool, p *Proof, b Buck
 	return xerrors.New("invalid node type")
 }
 
+// ForEachProof calls cb with the inclusion proof of every key/value pair of
+// the trie, in the order of ForEach. All the proofs are taken from the same
+// read transaction, so cb must not write to the database.
+func (t *Trie) ForEachProof(cb func(p *Proof) error) error {
+	return t.db.View(func(b Bucket) error {
+		return t.ForEachProofWithBucket(b, cb)
+	})
+}
+
+// ForEachProofWithBucket is like ForEachProof but must be called inside a
+// DB.View or DB.Update transaction.
+func (t *Trie) ForEachProofWithBucket(b Bucket, cb func(p *Proof) error) error {
+	rootKey := t.GetRootWithBucket(b)
+	if rootKey == nil {
+		return xerrors.New("no root key")
+	}
+	return t.proofsAfter(rootKey, nil, b, cb)
+}
+
+// ProofsAfter returns the inclusion proofs of at most n key/value pairs at
+// the given root. They are the pairs that come after the given key in the
+// order of ForEach, or the first ones if key is nil. Every call uses its own
+// read transaction, so that the proofs of a large trie can be taken in steps
+// without holding the database. If the trie isn't persistent, the nodes of
+// the root are removed once a new root is committed, and ProofsAfter fails.
+func (t *Trie) ProofsAfter(root, key []byte, n int) ([]Proof, error) {
+	var proofs []Proof
+	err := t.db.View(func(b Bucket) error {
+		var err error
+		proofs, err = t.proofsPage(b, root, key, n)
+		return err
+	})
+	return proofs, err
+}
+
+// ProofsAfter is like Trie.ProofsAfter at the root of the snapshot.
+func (s *Snapshot) ProofsAfter(key []byte, n int) ([]Proof, error) {
+	var proofs []Proof
+	err := s.view(func(b Bucket) error {
+		var err error
+		proofs, err = s.trie.proofsPage(b, s.root, key, n)
+		return err
+	})
+	return proofs, err
+}
+
+// proofsPage returns the proofs of at most n key/value pairs after key at the
+// given root.
+func (t *Trie) proofsPage(b Bucket, root, key []byte, n int) ([]Proof, error) {
+	var after []bool
+	if key != nil {
+		after = t.binSlice(key)
+	}
+	var proofs []Proof
+	err := t.proofsAfter(root, after, b, func(p *Proof) error {
+		if len(proofs) == n {
+			return errStopIteration
+		}
+		proofs = append(proofs, *p)
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	return proofs, nil
+}
+
+// errStopIteration is returned by a callback to stop proofsAfter early.
+var errStopIteration = xerrors.New("stop iteration")
+
+// proofsAfter calls cb with the proofs of the leaves under the root whose
+// path comes after the path after, in the order of dfs.
+func (t *Trie) proofsAfter(root []byte, after []bool, b Bucket, cb func(p *Proof) error) error {
+	return t.forEachLeafAfter(0, root, after, b, func(leaf leafNode) error {
+		p := &Proof{Nonce: clone(t.nonce)}
+		if err := t.getProof(0, root, t.binSlice(leaf.Key), p, b); err != nil {
+			return err
+		}
+		return cb(p)
+	})
+}
+
+// forEachLeafAfter walks the leaves like dfs, left first, but skips the
+// subtrees that come before the path after. A nil path visits every leaf.
+func (t *Trie) forEachLeafAfter(depth int, nodeKey []byte, after []bool, b Bucket, cb func(leafNode) error) error {
+	n, err := t.loadNode(b, nodeKey)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return xerrors.New("invalid node key")
+	}
+	switch n.typ {
+	case typeEmpty:
+		return nil
+	case typeLeaf:
+		if after != nil && !pathAfter(t.binSlice(n.leaf.Key), after, depth) {
+			return nil
+		}
+		return cb(n.leaf)
+	case typeInterior:
+		if after == nil || depth >= len(after) {
+			if err := t.forEachLeafAfter(depth+1, n.interior.Left, nil, b, cb); err != nil {
+				return err
+			}
+			return t.forEachLeafAfter(depth+1, n.interior.Right, nil, b, cb)
+		}
+		if !after[depth] {
+			return t.forEachLeafAfter(depth+1, n.interior.Right, after, b, cb)
+		}
+		if err := t.forEachLeafAfter(depth+1, n.interior.Left, after, b, cb); err != nil {
+			return err
+		}
+		return t.forEachLeafAfter(depth+1, n.interior.Right, nil, b, cb)
+	}
+	return xerrors.New("invalid node type")
+}
+
+// pathAfter returns whether the path comes after the path after in the order
+// of dfs, given that they share their first depth bits.
+func pathAfter(path, after []bool, depth int) bool {
+	for i := depth; i < len(path) && i < len(after); i++ {
+		if path[i] != after[i] {
+			return after[i]
+		}
+	}
+	return false
+}
+
 func (p *Proof) binSlice(buf []byte) []bool {
 	if p.noHashKey {
 		return toBinSlice(buf)
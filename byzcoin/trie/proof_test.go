@@ -96,6 +96,128 @@ func (disjointSet) Generate(rand *rand.Rand, size int) reflect.Value {
 	return reflect.ValueOf(res)
 }
 
+func TestProof_ForEach(t *testing.T) {
+	testMemAndDisk(t, testProofForEach)
+}
+
+func testProofForEach(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	var pairs []KVPair
+	for i := 0; i < 50; i++ {
+		k := []byte{byte(i)}
+		pairs = append(pairs, kvPair{OpSet, k, k})
+	}
+	require.NoError(t, testTrie.Batch(pairs))
+
+	var keys [][]byte
+	require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+		keys = append(keys, clone(k))
+		return nil
+	}))
+	require.Equal(t, 50, len(keys))
+
+	// The proofs come in the order of ForEach.
+	root := testTrie.GetRoot()
+	var i int
+	require.NoError(t, testTrie.ForEachProof(func(p *Proof) error {
+		ok, err := p.Exists(keys[i])
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, root, p.GetRoot())
+		i++
+		return nil
+	}))
+	require.Equal(t, len(keys), i)
+}
+
+func TestProof_SnapshotProofsAfter(t *testing.T) {
+	testMemAndDisk(t, testProofSnapshotProofsAfter)
+}
+
+func testProofSnapshotProofsAfter(t *testing.T, db DB) {
+	testTrie, err := NewPersistentTrie(db, genNonce())
+	require.NoError(t, err)
+	var pairs []KVPair
+	for i := 0; i < 50; i++ {
+		k := []byte{byte(i)}
+		pairs = append(pairs, kvPair{OpSet, k, k})
+	}
+	require.NoError(t, testTrie.Batch(pairs))
+
+	var keys [][]byte
+	require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+		keys = append(keys, clone(k))
+		return nil
+	}))
+	root := testTrie.GetRoot()
+
+	// The snapshot gives the same proofs in steps, even if the trie is
+	// updated in the meantime.
+	snap, err := testTrie.At(root)
+	require.NoError(t, err)
+	require.NoError(t, testTrie.Set([]byte("new key"), []byte("value")))
+	var key []byte
+	for i := 0; i < len(keys); i += 7 {
+		proofs, err := snap.ProofsAfter(key, 7)
+		require.NoError(t, err)
+		end := i + 7
+		if end > len(keys) {
+			end = len(keys)
+		}
+		require.Equal(t, end-i, len(proofs))
+		for j, p := range proofs {
+			require.True(t, p.Match(keys[i+j]))
+			require.Equal(t, snap.GetRoot(), p.GetRoot())
+		}
+		key = proofs[len(proofs)-1].Key()
+	}
+	proofs, err := snap.ProofsAfter(key, 7)
+	require.NoError(t, err)
+	require.Empty(t, proofs)
+}
+
+func TestProof_ProofsAfter(t *testing.T) {
+	testMemAndDisk(t, testProofsAfter)
+}
+
+func testProofsAfter(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	var pairs []KVPair
+	for i := 0; i < 20; i++ {
+		k := []byte{byte(i)}
+		pairs = append(pairs, kvPair{OpSet, k, k})
+	}
+	require.NoError(t, testTrie.Batch(pairs))
+
+	// The pages give the proofs of ForEachProof.
+	var all []Proof
+	require.NoError(t, testTrie.ForEachProof(func(p *Proof) error {
+		all = append(all, *p)
+		return nil
+	}))
+	root := testTrie.GetRoot()
+	var key []byte
+	var paged []Proof
+	for {
+		proofs, err := testTrie.ProofsAfter(root, key, 6)
+		require.NoError(t, err)
+		if len(proofs) == 0 {
+			break
+		}
+		require.True(t, len(proofs) <= 6)
+		paged = append(paged, proofs...)
+		key = proofs[len(proofs)-1].Key()
+	}
+	require.Equal(t, all, paged)
+
+	// The nodes of the previous root are gone after an update.
+	require.NoError(t, testTrie.Set([]byte("new key"), []byte("value")))
+	_, err = testTrie.ProofsAfter(root, nil, 6)
+	require.Error(t, err)
+}
+
 func TestProofQuickCheck(t *testing.T) {
 	mem := NewMemDB()
 	defer mem.Close()
@@ -28,3 +28,36 @@ type Proof struct {
 	Nonce     []byte
 	noHashKey bool
 }
+
+// MultiProof contains the inclusion/absence proofs of several keys. The nodes
+// shared by the paths of the keys are only stored once.
+type MultiProof struct {
+	// Interiors are the interior nodes of the paths, in the order of a
+	// depth-first traversal from the root where the left child is visited
+	// first.
+	Interiors []interiorNode
+	// Leaves are the leaf nodes at the end of the paths, in the same order.
+	Leaves []leafNode
+	// Empties are the empty nodes at the end of the paths, in the same
+	// order.
+	Empties   []emptyNode
+	Nonce     []byte
+	noHashKey bool
+}
+
+// RangeProof contains all the key/value pairs whose hashed key starts with a
+// binary prefix, and proves that there are no other ones.
+type RangeProof struct {
+	// Prefix is the binary prefix of the range.
+	Prefix []bool
+	// Interiors are the interior nodes from the root to the subtree of the
+	// prefix, followed by the ones of the subtree in the order of a
+	// depth-first traversal where the left child is visited first.
+	Interiors []interiorNode
+	// Leaves are the leaf nodes of the subtree, in the same order.
+	Leaves []leafNode
+	// Empties are the empty nodes of the subtree, in the same order.
+	Empties   []emptyNode
+	Nonce     []byte
+	noHashKey bool
+}
@@ -0,0 +1,161 @@
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"golang.org/x/xerrors"
+)
+
+// GetRange gets all the key/value pairs whose hashed key starts with the
+// given binary prefix, in a proof that there are no other ones. The prefix
+// follows the path of the keys in the trie: a true bit goes to the left.
+func (t *Trie) GetRange(prefix []bool) (*RangeProof, error) {
+	if !t.noHashKey && len(prefix) > sha256.Size*8 {
+		return nil, xerrors.New("prefix is longer than the keys")
+	}
+	p := &RangeProof{Prefix: append([]bool{}, prefix...), noHashKey: t.noHashKey}
+	err := t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		p.Nonce = clone(t.nonce)
+		return t.getRange(0, rootKey, p, b)
+	})
+	return p, err
+}
+
+// getRange updates RangeProof p as it follows the prefix, then adds all the
+// nodes of the subtree under it.
+func (t *Trie) getRange(depth int, nodeKey []byte, p *RangeProof, b Bucket) error {
+	if depth == len(p.Prefix) {
+		return t.dfs(&rangeProcessor{p}, nodeKey, b)
+	}
+	nodeVal := clone(b.Get(nodeKey))
+	if len(nodeVal) == 0 {
+		return xerrors.New("invalid node key")
+	}
+	if nodeType(nodeVal[0]) != typeInterior {
+		// The subtree of the prefix is in this leaf or empty node.
+		return t.dfs(&rangeProcessor{p}, nodeKey, b)
+	}
+	node, err := decodeInteriorNode(nodeVal)
+	if err != nil {
+		return err
+	}
+	p.Interiors = append(p.Interiors, node)
+	if p.Prefix[depth] {
+		return t.getRange(depth+1, node.Left, p, b)
+	}
+	return t.getRange(depth+1, node.Right, p, b)
+}
+
+// rangeProcessor adds the nodes of a subtree to a RangeProof.
+type rangeProcessor struct {
+	p *RangeProof
+}
+
+func (r *rangeProcessor) OnEmpty(n emptyNode, k, v []byte) error {
+	r.p.Empties = append(r.p.Empties, n)
+	return nil
+}
+
+func (r *rangeProcessor) OnLeaf(n leafNode, k, v []byte) error {
+	r.p.Leaves = append(r.p.Leaves, n)
+	return nil
+}
+
+func (r *rangeProcessor) OnInterior(n interiorNode, k, v []byte) error {
+	r.p.Interiors = append(r.p.Interiors, n)
+	return nil
+}
+
+// GetRoot returns the Merkle root.
+func (p *RangeProof) GetRoot() []byte {
+	if len(p.Interiors) == 0 {
+		return nil
+	}
+	return p.Interiors[0].hash()
+}
+
+// KeyValues verifies the range proof and returns the keys and the values
+// under its prefix, in the order of the trie. Like for a Proof, the caller
+// must check the root, and that the prefix is the expected one.
+func (p *RangeProof) KeyValues() ([][]byte, [][]byte, error) {
+	if len(p.Interiors) == 0 {
+		return nil, nil, xerrors.New("no interior nodes")
+	}
+	w := rangeProofWalker{RangeProof: p}
+	if err := w.walk(p.GetRoot(), nil); err != nil {
+		return nil, nil, err
+	}
+	if w.interiors != len(p.Interiors) || w.leaves != len(p.Leaves) ||
+		w.empties != len(p.Empties) {
+		return nil, nil, xerrors.New("unused nodes in the proof")
+	}
+	return w.keys, w.values, nil
+}
+
+func (p *RangeProof) binSlice(buf []byte) []bool {
+	return (&Proof{noHashKey: p.noHashKey}).binSlice(buf)
+}
+
+// rangeProofWalker follows the same traversal as getRange to verify the hash
+// chains and collect the key/value pairs.
+type rangeProofWalker struct {
+	*RangeProof
+	keys, values [][]byte
+	// Positions of the next nodes to be used.
+	interiors, leaves, empties int
+}
+
+func (w *rangeProofWalker) walk(expectedHash []byte, path []bool) error {
+	depth := len(path)
+	if w.interiors < len(w.Interiors) && bytes.Equal(expectedHash, w.Interiors[w.interiors].hash()) {
+		node := w.Interiors[w.interiors]
+		w.interiors++
+		left := append(path[:depth:depth], true)
+		right := append(path[:depth:depth], false)
+		if depth < len(w.Prefix) {
+			if w.Prefix[depth] {
+				return w.walk(node.Left, left)
+			}
+			return w.walk(node.Right, right)
+		}
+		if err := w.walk(node.Left, left); err != nil {
+			return err
+		}
+		return w.walk(node.Right, right)
+	}
+
+	if w.leaves < len(w.Leaves) && bytes.Equal(expectedHash, w.Leaves[w.leaves].hash(w.Nonce)) {
+		leaf := w.Leaves[w.leaves]
+		w.leaves++
+		if !equal(path, leaf.Prefix) {
+			return xerrors.New("invalid prefix in leaf node")
+		}
+		bits := w.binSlice(leaf.Key)
+		if len(bits) < len(path) || !equal(bits[:len(path)], path) {
+			return xerrors.New("leaf key does not match its prefix")
+		}
+		// A leaf above the prefix is outside of the range if its key
+		// doesn't continue with the prefix.
+		if len(bits) >= len(w.Prefix) && equal(bits[:len(w.Prefix)], w.Prefix) {
+			w.keys = append(w.keys, leaf.Key)
+			w.values = append(w.values, leaf.Value)
+		}
+		return nil
+	}
+
+	if w.empties < len(w.Empties) && bytes.Equal(expectedHash, w.Empties[w.empties].hash(w.Nonce)) {
+		empty := w.Empties[w.empties]
+		w.empties++
+		if !equal(path, empty.Prefix) {
+			return xerrors.New("invalid prefix in empty node")
+		}
+		return nil
+	}
+
+	return xerrors.New("invalid hash chain")
+}
@@ -0,0 +1,81 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeProof(t *testing.T) {
+	testMemAndDisk(t, testRangeProof)
+}
+
+func testRangeProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	for i := 10; i < 60; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+
+	prefixes := [][]bool{
+		{},
+		{true},
+		{false, true, true},
+		{true, true, false, true, false, false, true, false},
+		// The full path of an existing key and of a missing one.
+		testTrie.binSlice([]byte{20}),
+		testTrie.binSlice([]byte{70}),
+	}
+	for _, prefix := range prefixes {
+		p, err := testTrie.GetRange(prefix)
+		require.NoError(t, err)
+		require.Equal(t, testTrie.GetRoot(), p.GetRoot())
+		keys, values, err := p.KeyValues()
+		require.NoError(t, err)
+		require.Equal(t, keys, values)
+
+		// The keys are exactly the ones under the prefix, in the same order
+		// as ForEach.
+		var expected [][]byte
+		require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+			if equal(testTrie.binSlice(k)[:len(prefix)], prefix) {
+				expected = append(expected, clone(k))
+			}
+			return nil
+		}))
+		require.Equal(t, expected, keys)
+	}
+
+	p, err := testTrie.GetRange([]bool{true})
+	require.NoError(t, err)
+	require.True(t, len(p.Leaves) > 1)
+
+	// A missing leaf breaks the hash chain.
+	forged := *p
+	forged.Leaves = forged.Leaves[1:]
+	_, _, err = forged.KeyValues()
+	require.Error(t, err)
+
+	// The proof doesn't prove another prefix.
+	forged = *p
+	forged.Prefix = []bool{false}
+	_, _, err = forged.KeyValues()
+	require.Error(t, err)
+
+	_, err = testTrie.GetRange(make([]bool, 257))
+	require.Error(t, err)
+
+	// The proof stays valid once the database changes, its nodes being
+	// copied out of the transaction.
+	p, err = testTrie.GetRange([]bool{false})
+	require.NoError(t, err)
+	keys, _, err := p.KeyValues()
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, testTrie.Set([]byte{byte(i), byte(i >> 8), 1}, make([]byte, 100)))
+	}
+	keys2, _, err := p.KeyValues()
+	require.NoError(t, err)
+	require.Equal(t, keys, keys2)
+}
@@ -27,12 +27,19 @@ type instr struct {
 // StagingTrie represents a lazy copy of a Trie for staging operations. The
 // keys and values stored in this object will not go into the source Trie from
 // which it is created until the Commit function is called. The StagingTrie
-// becomes invalid if the source Trie is modified directly.
+// becomes invalid if the source Trie is modified directly, unless it is made
+// from a Snapshot.
 type StagingTrie struct {
-	source     *Trie
+	source *Trie
+	// snapshot, if set, is the read-only view of the source the staging trie
+	// reads from, instead of the current root of the source.
+	snapshot   *Snapshot
 	overlay    map[string][]byte
 	deleteList map[string][]byte
 	instrList  []instr
+	// root is the root of the staged nodes, it is nil when nothing is
+	// staged.
+	root *stagedNode
 
 	sync.Mutex
 }
@@ -49,9 +56,11 @@ func (t *StagingTrie) Clone() *StagingTrie {
 	defer t.Unlock()
 	out := StagingTrie{
 		source:     t.source,
+		snapshot:   t.snapshot,
 		overlay:    make(map[string][]byte),
 		deleteList: make(map[string][]byte),
 		instrList:  nil,
+		root:       t.root,
 	}
 	for k, v := range t.overlay {
 		val := clone(v)
@@ -85,6 +94,9 @@ func (t *StagingTrie) Get(k []byte) ([]byte, error) {
 	if v, ok := t.overlay[string(k)]; ok {
 		return v, nil
 	}
+	if t.snapshot != nil {
+		return t.snapshot.Get(k)
+	}
 	return t.source.Get(k)
 }
 
@@ -92,6 +104,9 @@ func (t *StagingTrie) Get(k []byte) ([]byte, error) {
 func (t *StagingTrie) Set(k, v []byte) error {
 	t.Lock()
 	defer t.Unlock()
+	if err := t.stage([]instr{{ty: OpSet, k: k, v: v}}); err != nil {
+		return err
+	}
 	return t.set(k, v)
 }
 
@@ -111,6 +126,9 @@ func (t *StagingTrie) set(k, v []byte) error {
 func (t *StagingTrie) Delete(k []byte) error {
 	t.Lock()
 	defer t.Unlock()
+	if err := t.stage([]instr{{ty: OpDel, k: k}}); err != nil {
+		return err
+	}
 	return t.del(k)
 }
 
@@ -130,21 +148,42 @@ func (t *StagingTrie) del(k []byte) error {
 func (t *StagingTrie) Batch(pairs []KVPair) error {
 	t.Lock()
 	defer t.Unlock()
+	instrs := make([]instr, 0, len(pairs))
 	for _, p := range pairs {
 		switch p.Op() {
 		case OpSet:
-			if err := t.set(p.Key(), p.Val()); err != nil {
-				return err
-			}
+			instrs = append(instrs, instr{ty: OpSet, k: p.Key(), v: p.Val()})
 		case OpDel:
-			if err := t.del(p.Key()); err != nil {
-				return err
-			}
+			instrs = append(instrs, instr{ty: OpDel, k: p.Key()})
 		case Nop:
 		default:
 			return xerrors.New("no such operation")
 		}
 	}
+	if err := t.stage(instrs); err != nil {
+		return err
+	}
+	for _, instr := range instrs {
+		var err error
+		if instr.ty == OpSet {
+			err = t.set(instr.k, instr.v)
+		} else {
+			err = t.del(instr.k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stage updates the staged nodes with the operations.
+func (t *StagingTrie) stage(instrs []instr) error {
+	root, err := t.applyStaged(instrs)
+	if err != nil {
+		return err
+	}
+	t.root = root
 	return nil
 }
 
@@ -153,6 +192,9 @@ func (t *StagingTrie) Batch(pairs []KVPair) error {
 func (t *StagingTrie) Commit() error {
 	t.Lock()
 	defer t.Unlock()
+	if t.snapshot != nil {
+		return xerrors.New("cannot commit a staging trie of a snapshot")
+	}
 	err := t.source.db.Update(func(b Bucket) error {
 		for _, instr := range t.instrList {
 			switch instr.ty {
@@ -168,7 +210,7 @@ func (t *StagingTrie) Commit() error {
 				return xerrors.New("invalid instruction during commit")
 			}
 		}
-		return nil
+		return t.source.CommitRootWithBucket(b)
 	})
 	if err != nil {
 		return err
@@ -176,30 +218,22 @@ func (t *StagingTrie) Commit() error {
 	t.overlay = make(map[string][]byte)
 	t.deleteList = make(map[string][]byte)
 	t.instrList = nil
+	t.root = nil
 	return nil
 }
 
-// GetRoot returns the root of the trie.
+// GetRoot returns the root of the trie. Only the nodes on the paths of the
+// staged keys are computed, the source trie is not modified.
 func (t *StagingTrie) GetRoot() []byte {
 	t.Lock()
 	defer t.Unlock()
 	var root []byte
-	err := t.source.db.UpdateDryRun(func(b Bucket) error {
-		for _, instr := range t.instrList {
-			switch instr.ty {
-			case OpSet:
-				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
-					return err
-				}
-			case OpDel:
-				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
-					return err
-				}
-			default:
-				return xerrors.New("invalid instruction during get root")
-			}
+	err := t.source.db.View(func(b Bucket) error {
+		n, err := t.stagedRoot(b)
+		if err != nil {
+			return err
 		}
-		root = clone(t.source.GetRootWithBucket(b))
+		root = clone(n.hash)
 		return nil
 	})
 	if err != nil {
@@ -213,29 +247,13 @@ func (t *StagingTrie) GetProof(key []byte) (*Proof, error) {
 	t.Lock()
 	defer t.Unlock()
 	p := &Proof{}
-	err := t.source.db.UpdateDryRun(func(b Bucket) error {
-		// run the pending instructions
-		for _, instr := range t.instrList {
-			switch instr.ty {
-			case OpSet:
-				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
-					return err
-				}
-			case OpDel:
-				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
-					return err
-				}
-			default:
-				return xerrors.New("invalid instruction during get proof")
-			}
-		}
-		// create the proof
-		rootKey := t.source.GetRootWithBucket(b)
-		if rootKey == nil {
-			return xerrors.New("no root key")
+	err := t.source.db.View(func(b Bucket) error {
+		root, err := t.stagedRoot(b)
+		if err != nil {
+			return err
 		}
 		p.Nonce = clone(t.source.nonce)
-		return t.source.getProof(0, rootKey, t.source.binSlice(key), p, b)
+		return t.stagedProof(root, t.source.binSlice(key), 0, p, b)
 	})
 	return p, err
 }
@@ -262,7 +280,11 @@ func (t *StagingTrie) ForEach(cb func(k, v []byte) error) error {
 		}
 	}
 
-	return t.source.ForEach(func(k, v []byte) error {
+	forEach := t.source.ForEach
+	if t.snapshot != nil {
+		forEach = t.snapshot.ForEach
+	}
+	return forEach(func(k, v []byte) error {
 		if t.isDeleted(k) {
 			return nil
 		}
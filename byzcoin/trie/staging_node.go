@@ -0,0 +1,253 @@
+package trie
+
+import (
+	"bytes"
+
+	"golang.org/x/xerrors"
+)
+
+// stagedNode is an in-memory copy of a node of the staging trie. The nodes
+// of the source trie are only known by their hash until they are loaded, and
+// the staged operations create new nodes along the paths of the modified keys
+// while sharing the other ones. A node is never modified once it is created,
+// so the clones of a staging trie can share them.
+type stagedNode struct {
+	hash   []byte
+	loaded bool
+	typ    nodeType
+
+	interior    interiorNode
+	left, right *stagedNode
+	empty       emptyNode
+	leaf        leafNode
+}
+
+func stagedRef(hash []byte) *stagedNode {
+	return &stagedNode{hash: hash}
+}
+
+func (t *StagingTrie) newStagedEmpty(prefix []bool) *stagedNode {
+	empty := newEmptyNode(append([]bool{}, prefix...))
+	return &stagedNode{
+		hash:   empty.hash(t.source.nonce),
+		loaded: true,
+		typ:    typeEmpty,
+		empty:  empty,
+	}
+}
+
+func (t *StagingTrie) newStagedLeaf(prefix []bool, key, value []byte) *stagedNode {
+	leaf := newLeafNode(append([]bool{}, prefix...), key, value)
+	return &stagedNode{
+		hash:   leaf.hash(t.source.nonce),
+		loaded: true,
+		typ:    typeLeaf,
+		leaf:   leaf,
+	}
+}
+
+func newStagedInterior(left, right *stagedNode) *stagedNode {
+	interior := newInteriorNode(left.hash, right.hash)
+	return &stagedNode{
+		hash:     interior.hash(),
+		loaded:   true,
+		typ:      typeInterior,
+		interior: interior,
+		left:     left,
+		right:    right,
+	}
+}
+
+// load returns the node with its content, read from the source trie if it
+// isn't known yet.
+func (t *StagingTrie) load(n *stagedNode, b Bucket) (*stagedNode, error) {
+	if n.loaded {
+		return n, nil
+	}
+	node, err := t.source.loadNode(b, n.hash)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, xerrors.New("node key does not exist in staging trie")
+	}
+	out := &stagedNode{
+		hash:     n.hash,
+		loaded:   true,
+		typ:      node.typ,
+		interior: node.interior,
+		empty:    node.empty,
+		leaf:     node.leaf,
+	}
+	if out.typ == typeInterior {
+		out.left = stagedRef(out.interior.Left)
+		out.right = stagedRef(out.interior.Right)
+	}
+	return out, nil
+}
+
+// stagedRoot returns the root of the staging trie, which is the one of the
+// source trie, or of its snapshot, as long as nothing is staged.
+func (t *StagingTrie) stagedRoot(b Bucket) (*stagedNode, error) {
+	if t.root != nil {
+		return t.root, nil
+	}
+	if t.snapshot != nil {
+		if b.Get(t.snapshot.root) == nil {
+			return nil, xerrors.New("root has been released")
+		}
+		return stagedRef(clone(t.snapshot.root)), nil
+	}
+	rootKey := t.source.GetRootWithBucket(b)
+	if rootKey == nil {
+		return nil, xerrors.New("no root key")
+	}
+	return stagedRef(clone(rootKey)), nil
+}
+
+// applyStaged computes the root after the given operations without changing
+// the staging trie, so that nothing is changed if one of them fails.
+func (t *StagingTrie) applyStaged(instrs []instr) (*stagedNode, error) {
+	var root *stagedNode
+	err := t.source.db.View(func(b Bucket) error {
+		var err error
+		root, err = t.stagedRoot(b)
+		if err != nil {
+			return err
+		}
+		for _, instr := range instrs {
+			bits := t.source.binSlice(instr.k)
+			switch instr.ty {
+			case OpSet:
+				root, err = t.stagedSet(root, bits, 0, instr.k, instr.v, b)
+			case OpDel:
+				var res *stagedNode
+				res, err = t.stagedDel(root, bits, 0, instr.k, b)
+				if res != nil {
+					root = res
+				}
+			default:
+				err = xerrors.New("invalid instruction")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return root, err
+}
+
+// stagedSet works like Trie.set on the staged nodes and returns the new node.
+func (t *StagingTrie) stagedSet(n *stagedNode, bits []bool, depth int, key, value []byte, b Bucket) (*stagedNode, error) {
+	n, err := t.load(n, b)
+	if err != nil {
+		return nil, err
+	}
+	switch n.typ {
+	case typeEmpty:
+		return t.newStagedLeaf(n.empty.Prefix, key, value), nil
+	case typeLeaf:
+		if bytes.Equal(n.leaf.Key, key) {
+			return t.newStagedLeaf(n.leaf.Prefix, key, value), nil
+		}
+		left, right := t.stagedExtendLeaf(n.leaf.Prefix, n.leaf.Key, n.leaf.Value,
+			t.source.binSlice(n.leaf.Key), key, value, bits)
+		return newStagedInterior(left, right), nil
+	case typeInterior:
+		if bits[depth] {
+			left, err := t.stagedSet(n.left, bits, depth+1, key, value, b)
+			if err != nil {
+				return nil, err
+			}
+			return newStagedInterior(left, n.right), nil
+		}
+		right, err := t.stagedSet(n.right, bits, depth+1, key, value, b)
+		if err != nil {
+			return nil, err
+		}
+		return newStagedInterior(n.left, right), nil
+	}
+	return nil, xerrors.New("invalid node type")
+}
+
+// stagedExtendLeaf works like Trie.extendLeaf on the staged nodes.
+func (t *StagingTrie) stagedExtendLeaf(currPrefix []bool,
+	key1, value1 []byte, bits1 []bool,
+	key2, value2 []byte, bits2 []bool) (*stagedNode, *stagedNode) {
+	i := len(currPrefix)
+	prefix1 := append(append([]bool{}, currPrefix...), bits1[i])
+	if bits1[i] != bits2[i] {
+		// base case:
+		leaf1 := t.newStagedLeaf(prefix1, key1, value1)
+		prefix2 := append(append([]bool{}, currPrefix...), bits2[i])
+		leaf2 := t.newStagedLeaf(prefix2, key2, value2)
+		if bits1[i] {
+			return leaf1, leaf2
+		}
+		return leaf2, leaf1
+	}
+	// recursive case:
+	left, right := t.stagedExtendLeaf(prefix1, key1, value1, bits1, key2, value2, bits2)
+	interior := newStagedInterior(left, right)
+	empty := t.newStagedEmpty(append(append([]bool{}, currPrefix...), !bits1[i]))
+	if bits1[i] {
+		return interior, empty
+	}
+	return empty, interior
+}
+
+// stagedDel works like Trie.del on the staged nodes. It returns nil if the key
+// doesn't exist.
+func (t *StagingTrie) stagedDel(n *stagedNode, bits []bool, depth int, key []byte, b Bucket) (*stagedNode, error) {
+	n, err := t.load(n, b)
+	if err != nil {
+		return nil, err
+	}
+	switch n.typ {
+	case typeEmpty:
+		return nil, nil
+	case typeLeaf:
+		if !bytes.Equal(n.leaf.Key, key) {
+			return nil, nil
+		}
+		return t.newStagedEmpty(n.leaf.Prefix), nil
+	case typeInterior:
+		if bits[depth] {
+			left, err := t.stagedDel(n.left, bits, depth+1, key, b)
+			if left == nil || err != nil {
+				return nil, err
+			}
+			return newStagedInterior(left, n.right), nil
+		}
+		right, err := t.stagedDel(n.right, bits, depth+1, key, b)
+		if right == nil || err != nil {
+			return nil, err
+		}
+		return newStagedInterior(n.left, right), nil
+	}
+	return nil, xerrors.New("invalid node type")
+}
+
+// stagedProof works like Trie.getProof on the staged nodes.
+func (t *StagingTrie) stagedProof(n *stagedNode, bits []bool, depth int, p *Proof, b Bucket) error {
+	n, err := t.load(n, b)
+	if err != nil {
+		return err
+	}
+	switch n.typ {
+	case typeEmpty:
+		p.Empty = n.empty
+		return nil
+	case typeLeaf:
+		p.Leaf = n.leaf
+		return nil
+	case typeInterior:
+		p.Interiors = append(p.Interiors, n.interior)
+		if bits[depth] {
+			return t.stagedProof(n.left, bits, depth+1, p, b)
+		}
+		return t.stagedProof(n.right, bits, depth+1, p, b)
+	}
+	return xerrors.New("invalid node type")
+}
@@ -459,3 +459,78 @@ func testStagingBatch(t *testing.T, db DB) {
 	require.NoError(t, sTrie2.Batch(pairs))
 	require.Equal(t, root1, sTrie2.GetRoot())
 }
+
+func TestStagingIncremental(t *testing.T) {
+	testMemAndDisk(t, testStagingIncremental)
+}
+
+func testStagingIncremental(t *testing.T, db DB) {
+	// A reference trie gets the same operations to compare the roots.
+	nonce := genNonce()
+	testTrie, err := NewTrie(db, nonce)
+	require.NoError(t, err)
+	ref, err := NewTrie(NewMemDB(), nonce)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, testTrie.Set([]byte{byte(i)}, []byte{byte(i)}))
+		require.NoError(t, ref.Set([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	dump := func() map[string]string {
+		out := make(map[string]string)
+		require.NoError(t, db.View(func(b Bucket) error {
+			return b.ForEach(func(k, v []byte) error {
+				out[string(k)] = string(v)
+				return nil
+			})
+		}))
+		return out
+	}
+	before := dump()
+
+	sTrie := testTrie.MakeStagingTrie()
+	var clones []*StagingTrie
+	var roots [][]byte
+	for i := 25; i < 100; i++ {
+		k := []byte{byte(i)}
+		if i%3 == 0 {
+			require.NoError(t, sTrie.Delete(k))
+			require.NoError(t, ref.Delete(k))
+		} else {
+			require.NoError(t, sTrie.Set(k, []byte{byte(i), 1}))
+			require.NoError(t, ref.Set(k, []byte{byte(i), 1}))
+		}
+		require.Equal(t, ref.GetRoot(), sTrie.GetRoot())
+		if i%10 == 0 {
+			clones = append(clones, sTrie.Clone())
+			roots = append(roots, sTrie.GetRoot())
+		}
+	}
+
+	// The clones keep their root and diverge from the original.
+	for i, c := range clones {
+		require.Equal(t, roots[i], c.GetRoot())
+		require.NoError(t, c.Set([]byte("clone"), []byte{byte(i)}))
+		require.NotEqual(t, roots[i], c.GetRoot())
+	}
+	require.Equal(t, ref.GetRoot(), sTrie.GetRoot())
+
+	for i := 0; i < 100; i++ {
+		k := []byte{byte(i)}
+		p, err := sTrie.GetProof(k)
+		require.NoError(t, err)
+		require.Equal(t, ref.GetRoot(), p.GetRoot())
+		exists, err := p.Exists(k)
+		require.NoError(t, err)
+		val, err := ref.Get(k)
+		require.NoError(t, err)
+		require.Equal(t, val != nil, exists)
+	}
+
+	// Nothing has been written in the source.
+	require.Equal(t, before, dump())
+
+	require.NoError(t, sTrie.Commit())
+	require.Equal(t, ref.GetRoot(), testTrie.GetRoot())
+	require.Equal(t, ref.GetRoot(), sTrie.GetRoot())
+	require.NoError(t, testTrie.IsValid())
+}
@@ -17,6 +17,12 @@ type Trie struct {
 	// flag, which should only be used in the unit test. (There is a copy of
 	// it in Proof as well.)
 	noHashKey bool
+	// persistent is set when the superseded nodes are kept, see
+	// NewPersistentTrie.
+	persistent bool
+	retention  int
+	// cache holds the decoded nodes, it is nil if it is disabled.
+	cache *nodeCache
 }
 
 // GetNonce returns the stored nonce.
@@ -29,6 +35,7 @@ func (t *Trie) GetNonce() ([]byte, error) {
 // database. If that is required, call IsValid.
 func LoadTrie(db DB) (*Trie, error) {
 	var nonce []byte
+	var persistent bool
 	err := db.View(func(b Bucket) error {
 		// load the nonce
 		nonceBuf := b.Get([]byte(nonceKey))
@@ -36,6 +43,7 @@ func LoadTrie(db DB) (*Trie, error) {
 			return xerrors.New("trie-error: db-nonce does not exist")
 		}
 		nonce = clone(nonceBuf)
+		persistent = b.Get([]byte(persistentKey)) != nil
 
 		// check the root node and that the value exists
 		rootKey := b.Get([]byte(entryKey))
@@ -52,14 +60,24 @@ func LoadTrie(db DB) (*Trie, error) {
 		return nil, err
 	}
 	return &Trie{
-		nonce: nonce,
-		db:    db,
+		nonce:      nonce,
+		db:         db,
+		persistent: persistent,
 	}, nil
 }
 
 // NewTrie creates a new trie with a user-specified nonce, it will return an
 // error if it is called on an existing database.
 func NewTrie(db DB, nonce []byte) (*Trie, error) {
+	return newTrie(db, nonce, false)
+}
+
+func newTrie(db DB, nonce []byte, persistent bool) (*Trie, error) {
+	t := &Trie{
+		nonce:      nonce,
+		db:         db,
+		persistent: persistent,
+	}
 	err := db.Update(func(b Bucket) error {
 		// create the nonce
 		nonceBuf := b.Get([]byte(nonceKey))
@@ -75,19 +93,21 @@ func NewTrie(db DB, nonce []byte) (*Trie, error) {
 		if rootKey != nil {
 			return xerrors.New("root already exists")
 		}
-		err := newRootNode(b, nonce)
+		if persistent {
+			if err := b.Put([]byte(persistentKey), []byte{1}); err != nil {
+				return err
+			}
+		}
+		err := t.newRootNode(b)
 		if err != nil {
 			return err
 		}
-		return nil
+		return t.CommitRootWithBucket(b)
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &Trie{
-		nonce: nonce,
-		db:    db,
-	}, nil
+	return t, nil
 }
 
 // DB returns the backend DB interface which is needed for creating transaction
@@ -101,7 +121,8 @@ func (t *Trie) DB() DB {
 
 // newRootNode creates the root node and two empty nodes and store these in the
 // bucket.
-func newRootNode(b Bucket, nonce []byte) error {
+func (t *Trie) newRootNode(b Bucket) error {
+	nonce := t.nonce
 	left := newEmptyNode([]bool{true})
 	right := newEmptyNode([]bool{false})
 	root := newInteriorNode(left.hash(nonce), right.hash(nonce))
@@ -121,21 +142,18 @@ func newRootNode(b Bucket, nonce []byte) error {
 	}
 
 	// put them into the database
-	if err := b.Put(left.hash(nonce), leftBuf); err != nil {
+	if err := t.putNode(b, left.hash(nonce), leftBuf); err != nil {
 		return err
 	}
-	if err := b.Put(right.hash(nonce), rightBuf); err != nil {
+	if err := t.putNode(b, right.hash(nonce), rightBuf); err != nil {
 		return err
 	}
-	if err := b.Put(root.hash(), rootBuf); err != nil {
+	if err := t.putNode(b, root.hash(), rootBuf); err != nil {
 		return err
 	}
 
 	// update the entry key
-	if err := b.Put([]byte(entryKey), root.hash()); err != nil {
-		return err
-	}
-	return nil
+	return t.setRootWithBucket(b, root.hash())
 }
 
 // GetRoot returns the root of the trie.
@@ -156,7 +174,10 @@ func (t *Trie) GetRootWithBucket(b Bucket) []byte {
 // Set sets or overwrites a key-value pair.
 func (t *Trie) Set(key []byte, value []byte) error {
 	return t.db.Update(func(b Bucket) error {
-		return t.SetWithBucket(key, value, b)
+		if err := t.SetWithBucket(key, value, b); err != nil {
+			return err
+		}
+		return t.CommitRootWithBucket(b)
 	})
 }
 
@@ -170,7 +191,10 @@ type KVPair interface {
 // Batch is similar to Set, but for multiple key-value pairs.
 func (t *Trie) Batch(pairs []KVPair) error {
 	return t.db.Update(func(b Bucket) error {
-		return t.BatchWithBucket(pairs, b)
+		if err := t.BatchWithBucket(pairs, b); err != nil {
+			return err
+		}
+		return t.CommitRootWithBucket(b)
 	})
 }
 
@@ -202,7 +226,7 @@ func (t *Trie) SetWithBucket(key []byte, value []byte, b Bucket) error {
 	if err != nil {
 		return err
 	}
-	return b.Put([]byte(entryKey), newRoot)
+	return t.setRootWithBucket(b, newRoot)
 }
 
 func (t *Trie) set(nodeKey []byte, bits []bool, depth int, key, value []byte, b Bucket) ([]byte, error) {
@@ -228,7 +252,7 @@ func (t *Trie) set(nodeKey []byte, bits []bool, depth int, key, value []byte, b
 		// If the key is the same, then we don't need to create a new
 		// internal node, just update the value and hash.
 		if bytes.Equal(node.Key, key) {
-			if err := b.Delete(node.hash(t.nonce)); err != nil {
+			if err := t.deleteNode(b, node.hash(t.nonce)); err != nil {
 				return nil, err
 			}
 			node.Value = value
@@ -236,7 +260,7 @@ func (t *Trie) set(nodeKey []byte, bits []bool, depth int, key, value []byte, b
 			if err != nil {
 				return nil, err
 			}
-			if err := b.Put(node.hash(t.nonce), leafBuf); err != nil {
+			if err := t.putNode(b, node.hash(t.nonce), leafBuf); err != nil {
 				return nil, err
 			}
 			return node.hash(t.nonce), nil
@@ -254,11 +278,11 @@ func (t *Trie) set(nodeKey []byte, bits []bool, depth int, key, value []byte, b
 		if err != nil {
 			return nil, err
 		}
-		if err := b.Put(interior.hash(), interiorBuff); err != nil {
+		if err := t.putNode(b, interior.hash(), interiorBuff); err != nil {
 			return nil, err
 		}
 		// Delete the old leaf node.
-		if err := b.Delete(node.hash(t.nonce)); err != nil {
+		if err := t.deleteNode(b, node.hash(t.nonce)); err != nil {
 			return nil, err
 		}
 		return interior.hash(), nil
@@ -284,14 +308,14 @@ func (t *Trie) set(nodeKey []byte, bits []bool, depth int, key, value []byte, b
 			node.Right = retHash
 		}
 		// update the interior node
-		if err := b.Delete(oldHash); err != nil {
+		if err := t.deleteNode(b, oldHash); err != nil {
 			return nil, err
 		}
 		newNodeBuf, err := node.encode()
 		if err != nil {
 			return nil, err
 		}
-		err = b.Put(node.hash(), newNodeBuf)
+		err = t.putNode(b, node.hash(), newNodeBuf)
 		if err != nil {
 			return nil, err
 		}
@@ -308,10 +332,10 @@ func (t *Trie) emptyToLeaf(empty emptyNode, key []byte, value []byte, b Bucket)
 	}
 
 	// delete the empty node and store the leaf and the actual data
-	if err := b.Delete(empty.hash(t.nonce)); err != nil {
+	if err := t.deleteNode(b, empty.hash(t.nonce)); err != nil {
 		return nil, err
 	}
-	if err := b.Put(leaf.hash(t.nonce), leafBuf); err != nil {
+	if err := t.putNode(b, leaf.hash(t.nonce), leafBuf); err != nil {
 		return nil, err
 	}
 	return leaf.hash(t.nonce), nil
@@ -336,10 +360,10 @@ func (t *Trie) extendLeaf(currPrefix []bool,
 		if err != nil {
 			return nil, nil, err
 		}
-		if err := b.Put(left.hash(t.nonce), leftBuf); err != nil {
+		if err := t.putNode(b, left.hash(t.nonce), leftBuf); err != nil {
 			return nil, nil, err
 		}
-		if err := b.Put(right.hash(t.nonce), rightBuf); err != nil {
+		if err := t.putNode(b, right.hash(t.nonce), rightBuf); err != nil {
 			return nil, nil, err
 		}
 		if bits1[i] {
@@ -358,7 +382,7 @@ func (t *Trie) extendLeaf(currPrefix []bool,
 	if err != nil {
 		return nil, nil, err
 	}
-	if err = b.Put(interior.hash(), interiorBuf); err != nil {
+	if err = t.putNode(b, interior.hash(), interiorBuf); err != nil {
 		return nil, nil, err
 	}
 	empty := newEmptyNode(append(currPrefix, !bits1[i]))
@@ -366,7 +390,7 @@ func (t *Trie) extendLeaf(currPrefix []bool,
 	if err != nil {
 		return nil, nil, err
 	}
-	if err = b.Put(empty.hash(t.nonce), emptyBuf); err != nil {
+	if err = t.putNode(b, empty.hash(t.nonce), emptyBuf); err != nil {
 		return nil, nil, err
 	}
 	if bits1[i] {
@@ -379,7 +403,10 @@ func (t *Trie) extendLeaf(currPrefix []bool,
 // exist.
 func (t *Trie) Delete(key []byte) error {
 	return t.db.Update(func(b Bucket) error {
-		return t.DeleteWithBucket(key, b)
+		if err := t.DeleteWithBucket(key, b); err != nil {
+			return err
+		}
+		return t.CommitRootWithBucket(b)
 	})
 }
 
@@ -398,7 +425,7 @@ func (t *Trie) DeleteWithBucket(key []byte, b Bucket) error {
 		// nothing was deleted, so don't update the root
 		return nil
 	}
-	return b.Put([]byte(entryKey), newRoot)
+	return t.setRootWithBucket(b, newRoot)
 }
 
 // Get looks up whether a value exists for the given key.
@@ -430,30 +457,26 @@ func (t *Trie) GetWithBucket(key []byte, b Bucket) ([]byte, error) {
 }
 
 func (t *Trie) get(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket) ([]byte, error) {
-	nodeVal := b.Get(nodeKey)
-	if len(nodeVal) == 0 {
+	n, err := t.loadNode(b, nodeKey)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
 		return nil, xerrors.New("node key does not exist in get")
 	}
-	switch nodeType(nodeVal[0]) {
+	switch n.typ {
 	case typeEmpty:
 		// base case 1
 		return nil, nil
 	case typeLeaf:
 		// base case 2
-		node, err := decodeLeafNode(nodeVal)
-		if err != nil {
-			return nil, err
-		}
-		if !bytes.Equal(key, node.Key) {
+		if !bytes.Equal(key, n.leaf.Key) {
 			return nil, nil
 		}
-		return node.Value, nil
+		return n.leaf.Value, nil
 	case typeInterior:
 		// recursive case
-		node, err := decodeInteriorNode(nodeVal)
-		if err != nil {
-			return nil, err
-		}
+		node := n.interior
 		if bits[depth] {
 			return t.get(depth+1, node.Left, bits, key, b)
 		}
@@ -504,7 +527,7 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 			// key doesn't exist, nothing to delete
 			return nil, nil
 		}
-		if err := b.Delete(node.hash(t.nonce)); err != nil {
+		if err := t.deleteNode(b, node.hash(t.nonce)); err != nil {
 			return nil, err
 		}
 		empty := newEmptyNode(node.Prefix)
@@ -512,7 +535,7 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 		if err != nil {
 			return nil, err
 		}
-		if err := b.Put(empty.hash(t.nonce), emptyBuf); err != nil {
+		if err := t.putNode(b, empty.hash(t.nonce), emptyBuf); err != nil {
 			return nil, err
 		}
 		return empty.hash(t.nonce), nil
@@ -533,7 +556,7 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 				return nil, nil
 			}
 			// delete the old interior node
-			if err := b.Delete(node.hash()); err != nil {
+			if err := t.deleteNode(b, node.hash()); err != nil {
 				return nil, err
 			}
 			// update this interior node
@@ -542,7 +565,7 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 			if err != nil {
 				return nil, err
 			}
-			return node.hash(), b.Put(node.hash(), nodeBuf)
+			return node.hash(), t.putNode(b, node.hash(), nodeBuf)
 		}
 		// look right
 		res, err := t.del(depth+1, node.Right, bits, key, b)
@@ -554,7 +577,7 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 			return nil, nil
 		}
 		// delete the old interior node
-		if err := b.Delete(node.hash()); err != nil {
+		if err := t.deleteNode(b, node.hash()); err != nil {
 			return nil, err
 		}
 		// update this interior node
@@ -563,7 +586,7 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 		if err != nil {
 			return nil, err
 		}
-		return node.hash(), b.Put(node.hash(), nodeBuf)
+		return node.hash(), t.putNode(b, node.hash(), nodeBuf)
 	}
 	return nil, xerrors.New("invalid node type")
 }
@@ -614,6 +637,12 @@ func (t *Trie) IsValid() error {
 		}
 	}
 
+	// The superseded nodes of a persistent trie are checked with their
+	// reference counts.
+	if t.persistent {
+		return t.checkRefCounts()
+	}
+
 	// Check that we have no dangling nodes.
 	var total int
 	err = t.db.View(func(b Bucket) error {
@@ -0,0 +1,291 @@
+package byzcoin
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/kvstore"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// The backends that can hold the state tries of a conode.
+const (
+	// TrieStorageBbolt stores the state tries in the bbolt database of the
+	// conode, next to the data of the other services.
+	TrieStorageBbolt = "bbolt"
+	// TrieStorageLevelDB stores the state tries in a LevelDB database. Its
+	// writes are appended to a log instead of copying the modified pages,
+	// which is faster for the large tries of long chains.
+	TrieStorageLevelDB = "leveldb"
+)
+
+// TrieStorage is the configuration of the storage of the state tries and of
+// the skipblocks. The other data of the services always stays in the bbolt
+// database of the conode.
+type TrieStorage struct {
+	// Backend is one of TrieStorageBbolt, the default, or TrieStorageLevelDB.
+	Backend string
+	// Path is the directory of the LevelDB database.
+	Path string
+}
+
+var trieStorage = struct {
+	sync.Mutex
+	config TrieStorage
+	// levelDBs holds the opened LevelDB databases by path, so that the
+	// conodes running in the same process share them. A database is closed
+	// once it has been released by all its users.
+	levelDBs map[string]*sharedLevelDB
+}{levelDBs: make(map[string]*sharedLevelDB)}
+
+// sharedLevelDB is an opened LevelDB database with the number of its users.
+type sharedLevelDB struct {
+	db   *leveldb.DB
+	refs int
+}
+
+// SetTrieStorage sets the storage of the state tries of the ByzCoin services
+// and of the skipblocks of the skipchain services created afterwards. It is
+// called by the conode before starting the services.
+func SetTrieStorage(config TrieStorage) error {
+	switch config.Backend {
+	case "", TrieStorageBbolt:
+	case TrieStorageLevelDB:
+		if config.Path == "" {
+			return xerrors.New("missing path of the LevelDB database")
+		}
+	default:
+		return xerrors.Errorf("unknown storage backend %v", config.Backend)
+	}
+	trieStorage.Lock()
+	trieStorage.config = config
+	trieStorage.Unlock()
+
+	if config.Backend != TrieStorageLevelDB {
+		skipchain.SetBlockStore(nil)
+		return nil
+	}
+	skipchain.SetBlockStore(func(si *network.ServerIdentity) (kvstore.DB, error) {
+		db, err := OpenTrieLevelDB(config.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &releasingStore{
+			DB:   kvstore.NewLevelDB(db, SkipBlockLevelDBPrefix(si.Public)),
+			path: config.Path,
+		}, nil
+	})
+	return nil
+}
+
+// OpenTrieLevelDB opens the LevelDB database at the given path, or returns
+// it if it is already open. It must be released by ReleaseTrieLevelDB.
+func OpenTrieLevelDB(path string) (*leveldb.DB, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, xerrors.Errorf("getting the path: %v", err)
+	}
+	trieStorage.Lock()
+	defer trieStorage.Unlock()
+	if shared, ok := trieStorage.levelDBs[path]; ok {
+		shared.refs++
+		return shared.db, nil
+	}
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("opening LevelDB: %v", err)
+	}
+	trieStorage.levelDBs[path] = &sharedLevelDB{db: db, refs: 1}
+	return db, nil
+}
+
+// ReleaseTrieLevelDB releases the LevelDB database at the given path, opened
+// by OpenTrieLevelDB. It is closed once all its users have released it.
+func ReleaseTrieLevelDB(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return xerrors.Errorf("getting the path: %v", err)
+	}
+	trieStorage.Lock()
+	defer trieStorage.Unlock()
+	shared, ok := trieStorage.levelDBs[path]
+	if !ok {
+		return xerrors.Errorf("LevelDB %s is not open", path)
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return nil
+	}
+	delete(trieStorage.levelDBs, path)
+	return cothority.ErrorOrNil(shared.db.Close(), "closing LevelDB")
+}
+
+// releasingStore is a store in a LevelDB database opened by OpenTrieLevelDB,
+// which is released when the store is closed.
+type releasingStore struct {
+	kvstore.DB
+	path string
+}
+
+func (s *releasingStore) Close() error {
+	if err := s.DB.Close(); err != nil {
+		return err
+	}
+	return ReleaseTrieLevelDB(s.path)
+}
+
+// TrieLevelDBPrefix returns the prefix of the keys of a state trie in a
+// LevelDB database. It contains the public key of the conode, because the
+// conodes running in the same process share the database.
+func TrieLevelDBPrefix(public kyber.Point, idStr string) []byte {
+	return []byte(fmt.Sprintf("%s/%s/", public, idStr))
+}
+
+// SkipBlockLevelDBPrefix returns the prefix of the keys of the skipblocks of
+// a conode in a LevelDB database. It cannot be the one of a state trie, which
+// is a chain ID.
+func SkipBlockLevelDBPrefix(public kyber.Point) []byte {
+	return TrieLevelDBPrefix(public, "skipblocks")
+}
+
+// trieStore holds the state tries of the chains of a service.
+type trieStore interface {
+	// db returns the database of the state trie of a chain.
+	db(idStr string) trie.DB
+	// remove deletes the state trie of a chain.
+	remove(idStr string) error
+	// count returns the number of entries in the database of the state trie
+	// of a chain.
+	count(idStr string) (int, error)
+	// close releases the storage, the databases cannot be used anymore.
+	close() error
+}
+
+func newTrieStore(c *onet.Context) (trieStore, error) {
+	trieStorage.Lock()
+	config := trieStorage.config
+	trieStorage.Unlock()
+
+	if config.Backend != TrieStorageLevelDB {
+		return &boltTrieStore{c: c}, nil
+	}
+	db, err := OpenTrieLevelDB(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &levelTrieStore{
+		ldb:    db,
+		path:   config.Path,
+		public: c.ServerIdentity().Public,
+		dbs:    make(map[string]trie.DB),
+	}, nil
+}
+
+// boltTrieStore stores every state trie in its own bucket of the database
+// of the conode.
+type boltTrieStore struct {
+	c *onet.Context
+}
+
+func (s *boltTrieStore) db(idStr string) trie.DB {
+	db, name := s.c.GetAdditionalBucket([]byte(idStr))
+	return trie.NewDiskDB(db, name)
+}
+
+func (s *boltTrieStore) remove(idStr string) error {
+	db, name := s.c.GetAdditionalBucket([]byte(idStr))
+	return db.Update(func(tx *bbolt.Tx) error {
+		err := tx.DeleteBucket(name)
+		if err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		_, err = tx.CreateBucket(name)
+		return err
+	})
+}
+
+func (s *boltTrieStore) count(idStr string) (int, error) {
+	db, name := s.c.GetAdditionalBucket([]byte(idStr))
+	var n int
+	err := db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket(name); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *boltTrieStore) close() error {
+	return nil
+}
+
+// levelTrieStore stores the state tries in a LevelDB database, with the
+// prefix given by TrieLevelDBPrefix.
+type levelTrieStore struct {
+	ldb    *leveldb.DB
+	path   string
+	public kyber.Point
+	// dbs holds the database of every state trie, because the transactions
+	// are serialized by the trie.DB.
+	dbs   map[string]trie.DB
+	dbsMu sync.Mutex
+}
+
+func (s *levelTrieStore) prefix(idStr string) []byte {
+	return TrieLevelDBPrefix(s.public, idStr)
+}
+
+func (s *levelTrieStore) db(idStr string) trie.DB {
+	s.dbsMu.Lock()
+	defer s.dbsMu.Unlock()
+	db, ok := s.dbs[idStr]
+	if !ok {
+		db = trie.NewLevelDB(s.ldb, s.prefix(idStr))
+		s.dbs[idStr] = db
+	}
+	return db
+}
+
+func (s *levelTrieStore) remove(idStr string) error {
+	return s.db(idStr).Update(func(b trie.Bucket) error {
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *levelTrieStore) count(idStr string) (int, error) {
+	it := s.ldb.NewIterator(util.BytesPrefix(s.prefix(idStr)), nil)
+	defer it.Release()
+	var n int
+	for it.Next() {
+		n++
+	}
+	return n, cothority.ErrorOrNil(it.Error(), "iterating")
+}
+
+func (s *levelTrieStore) close() error {
+	return ReleaseTrieLevelDB(s.path)
+}
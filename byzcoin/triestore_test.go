@@ -0,0 +1,72 @@
+package byzcoin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+func TestService_LevelDBStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "byzcoin-tries")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Error(t, SetTrieStorage(TrieStorage{Backend: "unknown"}))
+	require.Error(t, SetTrieStorage(TrieStorage{Backend: TrieStorageLevelDB}))
+	require.NoError(t, SetTrieStorage(TrieStorage{Backend: TrieStorageLevelDB, Path: dir}))
+	defer SetTrieStorage(TrieStorage{})
+
+	s := newSer(t, 1, testInterval)
+	// The LevelDB database is closed with the last conode, so the leaks
+	// are checked manually once it is closed.
+	s.local.Check = onet.CheckNone
+	defer func() {
+		s.local.CloseAll()
+		trieStorage.Lock()
+		require.Empty(t, trieStorage.levelDBs)
+		trieStorage.Unlock()
+		// goleveldb waits up to a second for its memory pool to drain
+		// after Close.
+		time.Sleep(time.Second)
+		log.AfterTest(t)
+	}()
+
+	pr, key, _, err, err2 := sendTransaction(t, s, 0, dummyContract, 10)
+	require.NoError(t, err)
+	require.NoError(t, err2)
+	require.True(t, pr.InclusionProof.Match(key))
+
+	// Every conode stores its trie in the shared LevelDB database.
+	db, err := OpenTrieLevelDB(dir)
+	require.NoError(t, err)
+	defer ReleaseTrieLevelDB(dir)
+	idStr := fmt.Sprintf("%x", s.genesis.SkipChainID())
+	for _, srv := range s.services {
+		require.IsType(t, &levelTrieStore{}, srv.trieStore)
+		n, err := srv.trieStore.count(idStr)
+		require.NoError(t, err)
+		require.True(t, n > 0)
+		has, err := db.Has(append(TrieLevelDBPrefix(srv.ServerIdentity().Public, idStr),
+			[]byte("dedis_trie")...), nil)
+		require.NoError(t, err)
+		require.True(t, has)
+		st, err := srv.getStateTrie(s.genesis.SkipChainID())
+		require.NoError(t, err)
+		_, _, cid, _, err := st.GetValues(key)
+		require.NoError(t, err)
+		require.Equal(t, dummyContract, cid)
+
+		// So are the skipblocks.
+		has, err = db.Has(append(SkipBlockLevelDBPrefix(srv.ServerIdentity().Public),
+			s.genesis.Hash...), nil)
+		require.NoError(t, err)
+		require.True(t, has)
+		require.Nil(t, srv.skService().GetDB().DB)
+	}
+}
@@ -193,6 +193,14 @@ collectTxLoop:
 		}
 	}
 
+	var supported []ContractVersion
+	select {
+	case supported = <-root.ContractVersionsChan:
+	default:
+		// The protocol didn't finish, so no new version is supported.
+	}
+	txs = s.holdContractVersionTxs(txs, supported)
+
 	return &collectTxResult{Txs: txs, CommonVersion: commonVersion}, nil
 }
 
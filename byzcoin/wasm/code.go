@@ -0,0 +1,254 @@
+package wasm
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// The supported opcodes. The floating point instructions are missing on
+// purpose.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+
+	opDrop   = 0x1a
+	opSelect = 0x1b
+
+	opLocalGet  = 0x20
+	opLocalSet  = 0x21
+	opLocalTee  = 0x22
+	opGlobalGet = 0x23
+	opGlobalSet = 0x24
+
+	opI32Load    = 0x28
+	opI64Load    = 0x29
+	opI32Load8S  = 0x2c
+	opI32Load8U  = 0x2d
+	opI32Load16S = 0x2e
+	opI32Load16U = 0x2f
+	opI64Load8S  = 0x30
+	opI64Load8U  = 0x31
+	opI64Load16S = 0x32
+	opI64Load16U = 0x33
+	opI64Load32S = 0x34
+	opI64Load32U = 0x35
+	opI32Store   = 0x36
+	opI64Store   = 0x37
+	opI32Store8  = 0x3a
+	opI32Store16 = 0x3b
+	opI64Store8  = 0x3c
+	opI64Store16 = 0x3d
+	opI64Store32 = 0x3e
+	opMemorySize = 0x3f
+	opMemoryGrow = 0x40
+
+	opI32Const = 0x41
+	opI64Const = 0x42
+
+	opI32Eqz = 0x45
+	opI32Eq  = 0x46
+	opI32Ne  = 0x47
+	opI32LtS = 0x48
+	opI32LtU = 0x49
+	opI32GtS = 0x4a
+	opI32GtU = 0x4b
+	opI32LeS = 0x4c
+	opI32LeU = 0x4d
+	opI32GeS = 0x4e
+	opI32GeU = 0x4f
+	opI64Eqz = 0x50
+	opI64Eq  = 0x51
+	opI64Ne  = 0x52
+	opI64LtS = 0x53
+	opI64LtU = 0x54
+	opI64GtS = 0x55
+	opI64GtU = 0x56
+	opI64LeS = 0x57
+	opI64LeU = 0x58
+	opI64GeS = 0x59
+	opI64GeU = 0x5a
+
+	opI32Clz    = 0x67
+	opI32Ctz    = 0x68
+	opI32Popcnt = 0x69
+	opI32Add    = 0x6a
+	opI32Sub    = 0x6b
+	opI32Mul    = 0x6c
+	opI32DivS   = 0x6d
+	opI32DivU   = 0x6e
+	opI32RemS   = 0x6f
+	opI32RemU   = 0x70
+	opI32And    = 0x71
+	opI32Or     = 0x72
+	opI32Xor    = 0x73
+	opI32Shl    = 0x74
+	opI32ShrS   = 0x75
+	opI32ShrU   = 0x76
+	opI32Rotl   = 0x77
+	opI32Rotr   = 0x78
+	opI64Clz    = 0x79
+	opI64Ctz    = 0x7a
+	opI64Popcnt = 0x7b
+	opI64Add    = 0x7c
+	opI64Sub    = 0x7d
+	opI64Mul    = 0x7e
+	opI64DivS   = 0x7f
+	opI64DivU   = 0x80
+	opI64RemS   = 0x81
+	opI64RemU   = 0x82
+	opI64And    = 0x83
+	opI64Or     = 0x84
+	opI64Xor    = 0x85
+	opI64Shl    = 0x86
+	opI64ShrS   = 0x87
+	opI64ShrU   = 0x88
+	opI64Rotl   = 0x89
+	opI64Rotr   = 0x8a
+
+	opI32WrapI64     = 0xa7
+	opI64ExtendI32S  = 0xac
+	opI64ExtendI32U  = 0xad
+	opI32Extend8S    = 0xc0
+	opI32Extend16S   = 0xc1
+	opI64Extend8S    = 0xc2
+	opI64Extend16S   = 0xc3
+	opI64Extend32S   = 0xc4
+	opPrefix         = 0xfc
+	opMemoryCopy     = 0xfc0a
+	opMemoryFill     = 0xfc0b
+	blockTypeEmpty   = 0x40
+	noElse           = ^uint64(0)
+	maxLocals        = 50000
+	maxBrTableLength = 65536
+)
+
+// instr is a decoded instruction. The meaning of the immediates depends on
+// the opcode:
+//   - block, loop and if: a is the index of the matching end, b the index of
+//     the else, or noElse, and arity the number of results;
+//   - else: a is the index of the end of the if;
+//   - br and br_if: a is the depth of the label;
+//   - br_table: table holds the depths, a the default depth;
+//   - call, call_indirect, local and global instructions: a is the index;
+//   - loads and stores: a is the offset;
+//   - constants: a is the value.
+type instr struct {
+	op    uint16
+	arity int
+	a     uint64
+	b     uint64
+	table []uint32
+}
+
+func isMemoryOp(op uint16) bool {
+	return op >= opI32Load && op <= opMemoryGrow ||
+		op == opMemoryCopy || op == opMemoryFill
+}
+
+// compile decodes the body of a function and resolves the targets of the
+// blocks.
+func compile(r *reader) ([]instr, error) {
+	var code []instr
+	var blocks []int
+	for r.err == nil {
+		in := instr{op: uint16(r.byte())}
+		switch {
+		case in.op == opBlock || in.op == opLoop || in.op == opIf:
+			switch bt := r.byte(); ValueType(bt) {
+			case blockTypeEmpty:
+			case I32, I64:
+				in.arity = 1
+			default:
+				return nil, xerrors.Errorf("unsupported block type 0x%x", bt)
+			}
+			in.b = noElse
+			blocks = append(blocks, len(code))
+		case in.op == opElse:
+			if len(blocks) == 0 || code[blocks[len(blocks)-1]].op != opIf ||
+				code[blocks[len(blocks)-1]].b != noElse {
+				return nil, xerrors.New("else without if")
+			}
+			code[blocks[len(blocks)-1]].b = uint64(len(code))
+		case in.op == opEnd:
+			if len(blocks) == 0 {
+				code = append(code, in)
+				return code, nil
+			}
+			start := &code[blocks[len(blocks)-1]]
+			start.a = uint64(len(code))
+			if start.op == opIf && start.b != noElse {
+				code[start.b].a = uint64(len(code))
+			}
+			blocks = blocks[:len(blocks)-1]
+		case in.op == opBr || in.op == opBrIf:
+			in.a = uint64(r.u32())
+		case in.op == opBrTable:
+			n := r.u32()
+			if n > maxBrTableLength {
+				return nil, xerrors.New("br_table too long")
+			}
+			in.table = make([]uint32, 0, n)
+			for i := uint32(0); i < n && r.err == nil; i++ {
+				in.table = append(in.table, r.u32())
+			}
+			in.a = uint64(r.u32())
+		case in.op == opCall:
+			in.a = uint64(r.u32())
+		case in.op == opCallIndirect:
+			in.a = uint64(r.u32())
+			if r.byte() != 0 {
+				return nil, xerrors.New("only one table is supported")
+			}
+		case in.op >= opLocalGet && in.op <= opGlobalSet:
+			in.a = uint64(r.u32())
+		case in.op >= opI32Load && in.op <= opI64Store32:
+			if in.op == 0x2a || in.op == 0x2b || in.op == 0x38 || in.op == 0x39 {
+				return nil, xerrors.New("floating point instructions are not supported")
+			}
+			r.u32() // the alignment is only a hint
+			in.a = uint64(r.u32())
+		case in.op == opMemorySize || in.op == opMemoryGrow:
+			if r.byte() != 0 {
+				return nil, xerrors.New("only one memory is supported")
+			}
+		case in.op == opI32Const:
+			in.a = uint64(uint32(r.s32()))
+		case in.op == opI64Const:
+			in.a = uint64(r.s64())
+		case in.op == opPrefix:
+			in.op = opPrefix<<8 | uint16(r.u32())
+			switch in.op {
+			case opMemoryCopy:
+				if r.byte() != 0 || r.byte() != 0 {
+					return nil, xerrors.New("only one memory is supported")
+				}
+			case opMemoryFill:
+				if r.byte() != 0 {
+					return nil, xerrors.New("only one memory is supported")
+				}
+			default:
+				return nil, xerrors.Errorf("unsupported instruction 0x%x", in.op)
+			}
+		case in.op == opUnreachable, in.op == opNop, in.op == opReturn,
+			in.op == opDrop, in.op == opSelect,
+			in.op >= opI32Eqz && in.op <= opI64GeU,
+			in.op >= opI32Clz && in.op <= opI64Rotr,
+			in.op == opI32WrapI64, in.op == opI64ExtendI32S, in.op == opI64ExtendI32U,
+			in.op >= opI32Extend8S && in.op <= opI64Extend32S:
+		default:
+			return nil, xerrors.Errorf("unsupported instruction 0x%x", in.op)
+		}
+		code = append(code, in)
+	}
+	return nil, xerrors.Errorf("reading code: %v", r.err)
+}
@@ -0,0 +1,599 @@
+// Package wasm implements a deterministic and gas-metered interpreter for
+// WebAssembly modules. Only the integer subset of the WebAssembly 1.0
+// specification is supported: the floating point types and instructions are
+// refused, as their results could differ between the nodes of a roster. The
+// only imports a module can have are functions, which are given by the host
+// when the module is instantiated.
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// ValueType is the type of a value in WebAssembly.
+type ValueType byte
+
+const (
+	// I32 is a 32-bit integer.
+	I32 ValueType = 0x7f
+	// I64 is a 64-bit integer.
+	I64 ValueType = 0x7e
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// Equal returns true if both signatures are the same.
+func (ft FuncType) Equal(other FuncType) bool {
+	return bytes.Equal(valueTypes(ft.Params), valueTypes(other.Params)) &&
+		bytes.Equal(valueTypes(ft.Results), valueTypes(other.Results))
+}
+
+func valueTypes(vts []ValueType) []byte {
+	buf := make([]byte, len(vts))
+	for i, vt := range vts {
+		buf[i] = byte(vt)
+	}
+	return buf
+}
+
+const (
+	pageSize = 65536
+	magic    = "\x00asm"
+	version  = 1
+
+	funcTypeTag = 0x60
+	funcRefType = 0x70
+	externFunc  = 0x00
+)
+
+const (
+	sectionCustom = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+type importedFunc struct {
+	module string
+	name   string
+	typ    uint32
+}
+
+type function struct {
+	typ    uint32
+	locals int
+	code   []instr
+}
+
+type global struct {
+	typ     ValueType
+	mutable bool
+	init    uint64
+}
+
+type segment struct {
+	offset uint32
+	data   []byte
+}
+
+type elemSegment struct {
+	offset uint32
+	funcs  []uint32
+}
+
+type limits struct {
+	min uint32
+	max uint32
+	// hasMax is false if the maximum is not given.
+	hasMax bool
+}
+
+// Module is a parsed WebAssembly module. It can be instantiated many times.
+type Module struct {
+	types   []FuncType
+	imports []importedFunc
+	funcs   []function
+	table   *limits
+	memory  *limits
+	globals []global
+	exports map[string]uint32
+	start   *uint32
+	elems   []elemSegment
+	data    []segment
+}
+
+// Parse reads the binary representation of a WebAssembly module. It returns
+// an error if the module is malformed or if it uses a feature that is not
+// supported.
+func Parse(code []byte) (*Module, error) {
+	r := &reader{buf: code}
+	if !bytes.Equal(r.bytes(4), []byte(magic)) {
+		return nil, xerrors.New("not a WebAssembly module")
+	}
+	if binary.LittleEndian.Uint32(r.bytes(4)) != version {
+		return nil, xerrors.New("unsupported WebAssembly version")
+	}
+	if r.err != nil {
+		return nil, xerrors.Errorf("reading header: %v", r.err)
+	}
+
+	m := &Module{exports: make(map[string]uint32)}
+	var funcTypes []uint32
+	var last byte
+	for r.len() > 0 {
+		id := r.byte()
+		size := r.u32()
+		content := &reader{buf: r.bytes(int(size))}
+		if r.err != nil {
+			return nil, xerrors.Errorf("reading section: %v", r.err)
+		}
+		if id != sectionCustom {
+			if id <= last && !(id == sectionDataCount && last < sectionCode) {
+				return nil, xerrors.Errorf("section %d is out of order", id)
+			}
+			last = id
+		}
+
+		var err error
+		switch id {
+		case sectionCustom, sectionDataCount:
+		case sectionType:
+			err = m.parseTypes(content)
+		case sectionImport:
+			err = m.parseImports(content)
+		case sectionFunction:
+			funcTypes, err = m.parseFunctions(content)
+		case sectionTable:
+			err = m.parseTable(content)
+		case sectionMemory:
+			err = m.parseMemory(content)
+		case sectionGlobal:
+			err = m.parseGlobals(content)
+		case sectionExport:
+			err = m.parseExports(content)
+		case sectionStart:
+			idx := content.u32()
+			m.start = &idx
+		case sectionElement:
+			err = m.parseElements(content)
+		case sectionCode:
+			err = m.parseCode(content, funcTypes)
+		case sectionData:
+			err = m.parseData(content)
+		default:
+			err = xerrors.Errorf("unknown section %d", id)
+		}
+		if err == nil {
+			err = content.err
+		}
+		if err == nil && id != sectionCustom && content.len() > 0 {
+			err = xerrors.New("unexpected bytes at the end")
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("section %d: %v", id, err)
+		}
+	}
+
+	if len(funcTypes) != len(m.funcs) {
+		return nil, xerrors.New("functions without code")
+	}
+	if err := m.check(); err != nil {
+		return nil, xerrors.Errorf("checking module: %v", err)
+	}
+	return m, nil
+}
+
+// check verifies the indexes that are used outside of the code.
+func (m *Module) check() error {
+	nbrFuncs := uint32(len(m.imports) + len(m.funcs))
+	for name, idx := range m.exports {
+		if idx >= nbrFuncs {
+			return xerrors.Errorf("export %s: unknown function %d", name, idx)
+		}
+	}
+	if m.start != nil {
+		if *m.start >= nbrFuncs {
+			return xerrors.New("unknown start function")
+		}
+		ft := m.funcType(*m.start)
+		if len(ft.Params) > 0 || len(ft.Results) > 0 {
+			return xerrors.New("start function must have no params and results")
+		}
+	}
+	for _, e := range m.elems {
+		if m.table == nil {
+			return xerrors.New("element segment without table")
+		}
+		for _, idx := range e.funcs {
+			if idx >= nbrFuncs {
+				return xerrors.Errorf("element: unknown function %d", idx)
+			}
+		}
+	}
+	if len(m.data) > 0 && m.memory == nil {
+		return xerrors.New("data segment without memory")
+	}
+	for _, f := range m.funcs {
+		for _, in := range f.code {
+			switch in.op {
+			case opCall:
+				if in.a >= uint64(nbrFuncs) {
+					return xerrors.Errorf("call of unknown function %d", in.a)
+				}
+			case opCallIndirect:
+				if m.table == nil || in.a >= uint64(len(m.types)) {
+					return xerrors.New("invalid indirect call")
+				}
+			case opGlobalGet, opGlobalSet:
+				if in.a >= uint64(len(m.globals)) {
+					return xerrors.Errorf("unknown global %d", in.a)
+				}
+				if in.op == opGlobalSet && !m.globals[in.a].mutable {
+					return xerrors.Errorf("global %d is immutable", in.a)
+				}
+			case opLocalGet, opLocalSet, opLocalTee:
+				if in.a >= uint64(len(m.types[f.typ].Params)+f.locals) {
+					return xerrors.Errorf("unknown local %d", in.a)
+				}
+			}
+			if isMemoryOp(in.op) && m.memory == nil {
+				return xerrors.New("memory instruction without memory")
+			}
+		}
+	}
+	return nil
+}
+
+// funcType returns the signature of the function with the given index,
+// imported functions coming first.
+func (m *Module) funcType(idx uint32) FuncType {
+	if int(idx) < len(m.imports) {
+		return m.types[m.imports[idx].typ]
+	}
+	return m.types[m.funcs[int(idx)-len(m.imports)].typ]
+}
+
+// Export returns the signature of the exported function with the given name.
+func (m *Module) Export(name string) (FuncType, bool) {
+	idx, ok := m.exports[name]
+	if !ok {
+		return FuncType{}, false
+	}
+	return m.funcType(idx), true
+}
+
+func (m *Module) parseTypes(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		if r.byte() != funcTypeTag {
+			return xerrors.New("invalid function type")
+		}
+		var ft FuncType
+		var err error
+		ft.Params, err = r.valueTypes()
+		if err != nil {
+			return xerrors.Errorf("params: %v", err)
+		}
+		ft.Results, err = r.valueTypes()
+		if err != nil {
+			return xerrors.Errorf("results: %v", err)
+		}
+		if len(ft.Results) > 1 {
+			return xerrors.New("multiple results are not supported")
+		}
+		m.types = append(m.types, ft)
+	}
+	return nil
+}
+
+func (m *Module) parseImports(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		imp := importedFunc{module: r.name(), name: r.name()}
+		if r.byte() != externFunc {
+			return xerrors.Errorf("import %s.%s: only functions can be imported",
+				imp.module, imp.name)
+		}
+		imp.typ = r.u32()
+		if imp.typ >= uint32(len(m.types)) {
+			return xerrors.Errorf("import %s.%s: unknown type", imp.module, imp.name)
+		}
+		m.imports = append(m.imports, imp)
+	}
+	return nil
+}
+
+func (m *Module) parseFunctions(r *reader) ([]uint32, error) {
+	n := r.u32()
+	var types []uint32
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		typ := r.u32()
+		if typ >= uint32(len(m.types)) {
+			return nil, xerrors.Errorf("function %d: unknown type", i)
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
+func (m *Module) parseTable(r *reader) error {
+	if r.u32() != 1 {
+		return xerrors.New("only one table is supported")
+	}
+	if r.byte() != funcRefType {
+		return xerrors.New("only tables of functions are supported")
+	}
+	l := r.limits()
+	m.table = &l
+	return nil
+}
+
+func (m *Module) parseMemory(r *reader) error {
+	if r.u32() != 1 {
+		return xerrors.New("only one memory is supported")
+	}
+	l := r.limits()
+	m.memory = &l
+	return nil
+}
+
+func (m *Module) parseGlobals(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		g := global{typ: ValueType(r.byte())}
+		if g.typ != I32 && g.typ != I64 {
+			return xerrors.Errorf("global %d: unsupported type", i)
+		}
+		switch r.byte() {
+		case 0:
+		case 1:
+			g.mutable = true
+		default:
+			return xerrors.Errorf("global %d: invalid mutability", i)
+		}
+		var err error
+		g.init, err = m.constExpr(r, g.typ)
+		if err != nil {
+			return xerrors.Errorf("global %d: %v", i, err)
+		}
+		m.globals = append(m.globals, g)
+	}
+	return nil
+}
+
+func (m *Module) parseExports(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		name := r.name()
+		kind := r.byte()
+		idx := r.u32()
+		if _, ok := m.exports[name]; ok {
+			return xerrors.Errorf("duplicate export %s", name)
+		}
+		// Only the functions can be used by the host, the other exports
+		// are ignored.
+		if kind == externFunc {
+			m.exports[name] = idx
+		}
+	}
+	return nil
+}
+
+func (m *Module) parseElements(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		if r.u32() != 0 {
+			return xerrors.Errorf("element %d: only active segments are supported", i)
+		}
+		offset, err := m.constExpr(r, I32)
+		if err != nil {
+			return xerrors.Errorf("element %d: %v", i, err)
+		}
+		e := elemSegment{offset: uint32(offset)}
+		nbr := r.u32()
+		for j := uint32(0); j < nbr && r.err == nil; j++ {
+			e.funcs = append(e.funcs, r.u32())
+		}
+		m.elems = append(m.elems, e)
+	}
+	return nil
+}
+
+func (m *Module) parseCode(r *reader, types []uint32) error {
+	n := r.u32()
+	if int(n) != len(types) {
+		return xerrors.New("number of functions and bodies differ")
+	}
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		size := r.u32()
+		body := &reader{buf: r.bytes(int(size))}
+		f := function{typ: types[i]}
+		nbrLocals := body.u32()
+		for j := uint32(0); j < nbrLocals && body.err == nil; j++ {
+			count := body.u32()
+			vt := ValueType(body.byte())
+			if vt != I32 && vt != I64 {
+				return xerrors.Errorf("function %d: unsupported local type", i)
+			}
+			f.locals += int(count)
+			if f.locals > maxLocals {
+				return xerrors.Errorf("function %d: too many locals", i)
+			}
+		}
+		var err error
+		f.code, err = compile(body)
+		if err == nil && body.len() > 0 {
+			err = xerrors.New("unexpected bytes after the end")
+		}
+		if err != nil {
+			return xerrors.Errorf("function %d: %v", i, err)
+		}
+		m.funcs = append(m.funcs, f)
+	}
+	return nil
+}
+
+func (m *Module) parseData(r *reader) error {
+	n := r.u32()
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		if r.u32() != 0 {
+			return xerrors.Errorf("data %d: only active segments are supported", i)
+		}
+		offset, err := m.constExpr(r, I32)
+		if err != nil {
+			return xerrors.Errorf("data %d: %v", i, err)
+		}
+		size := r.u32()
+		m.data = append(m.data, segment{offset: uint32(offset),
+			data: r.bytes(int(size))})
+	}
+	return nil
+}
+
+// constExpr reads a constant expression. The only constant expressions
+// supported are the constants themselves, as globals cannot be imported.
+func (m *Module) constExpr(r *reader, vt ValueType) (uint64, error) {
+	var v uint64
+	switch r.byte() {
+	case opI32Const:
+		if vt != I32 {
+			return 0, xerrors.New("wrong type of constant")
+		}
+		v = uint64(uint32(r.s32()))
+	case opI64Const:
+		if vt != I64 {
+			return 0, xerrors.New("wrong type of constant")
+		}
+		v = uint64(r.s64())
+	default:
+		return 0, xerrors.New("unsupported constant expression")
+	}
+	if r.byte() != opEnd {
+		return 0, xerrors.New("constant expression not terminated")
+	}
+	return v, r.err
+}
+
+// reader decodes the binary format. The first error is kept and every
+// following read returns zero values.
+type reader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *reader) len() int {
+	return len(r.buf) - r.pos
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.pos = len(r.buf)
+}
+
+func (r *reader) byte() byte {
+	if r.len() < 1 {
+		r.fail(xerrors.New("unexpected end"))
+		return 0
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || r.len() < n {
+		r.fail(xerrors.New("unexpected end"))
+		return nil
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+// leb reads an LEB128 integer of at most the given number of bits. The
+// result is sign-extended if signed is true.
+func (r *reader) leb(bits uint, signed bool) uint64 {
+	var v uint64
+	var shift uint
+	for {
+		b := r.byte()
+		if r.err != nil {
+			return 0
+		}
+		v |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if signed && shift < 64 && b&0x40 != 0 {
+				v |= ^uint64(0) << shift
+			}
+			return v
+		}
+		if shift >= bits {
+			r.fail(xerrors.New("integer too long"))
+			return 0
+		}
+	}
+}
+
+func (r *reader) u32() uint32 {
+	return uint32(r.leb(32, false))
+}
+
+func (r *reader) s32() int32 {
+	return int32(r.leb(32, true))
+}
+
+func (r *reader) s64() int64 {
+	return int64(r.leb(64, true))
+}
+
+func (r *reader) name() string {
+	return string(r.bytes(int(r.u32())))
+}
+
+func (r *reader) valueTypes() ([]ValueType, error) {
+	n := r.u32()
+	var vts []ValueType
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		vt := ValueType(r.byte())
+		if vt != I32 && vt != I64 {
+			return nil, xerrors.Errorf("unsupported value type 0x%x", byte(vt))
+		}
+		vts = append(vts, vt)
+	}
+	return vts, nil
+}
+
+func (r *reader) limits() limits {
+	var l limits
+	switch r.byte() {
+	case 0:
+		l.min = r.u32()
+	case 1:
+		l.min = r.u32()
+		l.max = r.u32()
+		l.hasMax = true
+	default:
+		r.fail(xerrors.New("invalid limits"))
+	}
+	return l
+}
@@ -0,0 +1,724 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrOutOfGas is returned when the execution uses more gas than allowed.
+var ErrOutOfGas = xerrors.New("out of gas")
+
+// Gas costs of the operations that are more expensive than a simple
+// instruction, which costs 1.
+const (
+	// GasPerPage is the cost of each page of memory given to the module.
+	GasPerPage = 1000
+	// GasPerByte is the cost of each byte copied or filled in the memory.
+	GasPerByte = 1
+)
+
+// Config holds the limits of an instance.
+type Config struct {
+	// Gas is the amount of gas available for the whole life of the
+	// instance.
+	Gas uint64
+	// MaxPages is the maximal number of pages of 64 KiB of the memory.
+	MaxPages uint32
+	// MaxCallDepth is the maximal number of nested calls.
+	MaxCallDepth int
+	// MaxStack is the maximal number of values on the stack.
+	MaxStack int
+}
+
+// DefaultConfig returns the limits used if none are given.
+func DefaultConfig() Config {
+	return Config{
+		Gas:          10000000,
+		MaxPages:     16,
+		MaxCallDepth: 256,
+		MaxStack:     65536,
+	}
+}
+
+// HostFunc is a function given by the host that a module can import. The
+// arguments are given as uint64, the i32 using only the lower 32 bits. An
+// error stops the execution.
+type HostFunc struct {
+	Type FuncType
+	Call func(vm *VM, args []uint64) ([]uint64, error)
+}
+
+// Imports are the host functions that can be imported by the modules,
+// indexed by module and name.
+type Imports map[string]map[string]HostFunc
+
+// trap is used to unwind the stack when the execution must stop.
+type trap struct {
+	err error
+}
+
+// VM is an instance of a module with its own memory, globals and gas.
+type VM struct {
+	mod     *Module
+	config  Config
+	host    []HostFunc
+	memory  []byte
+	globals []uint64
+	table   []*uint32
+	stack   []uint64
+	depth   int
+	gasUsed uint64
+}
+
+type label struct {
+	height int
+	arity  int
+	loop   bool
+	start  int
+	end    int
+}
+
+// Instantiate creates a new instance of the module. The imports of the module
+// are resolved using imports, then the memory and the table are initialised
+// and the start function, if any, is called.
+func Instantiate(mod *Module, imports Imports, config Config) (vm *VM, err error) {
+	vm = &VM{mod: mod, config: config}
+	for _, imp := range mod.imports {
+		hf, ok := imports[imp.module][imp.name]
+		if !ok {
+			return nil, xerrors.Errorf("unknown import %s.%s", imp.module, imp.name)
+		}
+		if !hf.Type.Equal(mod.types[imp.typ]) {
+			return nil, xerrors.Errorf("import %s.%s has the wrong signature",
+				imp.module, imp.name)
+		}
+		vm.host = append(vm.host, hf)
+	}
+
+	defer vm.catch(&err)
+	if mod.memory != nil {
+		if mod.memory.min > vm.maxPages() {
+			return nil, xerrors.New("memory too big")
+		}
+		vm.useGas(uint64(mod.memory.min) * GasPerPage)
+		vm.memory = make([]byte, int(mod.memory.min)*pageSize)
+	}
+	for _, g := range mod.globals {
+		vm.globals = append(vm.globals, g.init)
+	}
+	if mod.table != nil {
+		if mod.table.min > maxBrTableLength {
+			return nil, xerrors.New("table too big")
+		}
+		vm.table = make([]*uint32, mod.table.min)
+	}
+	for _, e := range mod.elems {
+		if uint64(e.offset)+uint64(len(e.funcs)) > uint64(len(vm.table)) {
+			return nil, xerrors.New("element segment out of bounds")
+		}
+		for i := range e.funcs {
+			vm.table[int(e.offset)+i] = &e.funcs[i]
+		}
+	}
+	for _, d := range mod.data {
+		if uint64(d.offset)+uint64(len(d.data)) > uint64(len(vm.memory)) {
+			return nil, xerrors.New("data segment out of bounds")
+		}
+		copy(vm.memory[d.offset:], d.data)
+	}
+	if mod.start != nil {
+		vm.call(*mod.start)
+	}
+	return vm, nil
+}
+
+// Call calls the exported function with the given name. The instance must
+// not be used after an error.
+func (vm *VM) Call(name string, args ...uint64) (results []uint64, err error) {
+	idx, ok := vm.mod.exports[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown function %s", name)
+	}
+	if len(args) != len(vm.mod.funcType(idx).Params) {
+		return nil, xerrors.Errorf("%s takes %d arguments", name,
+			len(vm.mod.funcType(idx).Params))
+	}
+
+	defer vm.catch(&err)
+	vm.stack = append(vm.stack[:0], args...)
+	vm.call(idx)
+	results = append([]uint64{}, vm.stack...)
+	vm.stack = vm.stack[:0]
+	return results, nil
+}
+
+// catch turns a trap into an error. Runtime errors of Go, like an access out
+// of the stack of a malformed module, are traps too, as they happen in the
+// same way on every node.
+func (vm *VM) catch(err *error) {
+	if r := recover(); r != nil {
+		if t, ok := r.(trap); ok {
+			*err = t.err
+		} else {
+			*err = xerrors.Errorf("execution failed: %v", r)
+		}
+	}
+}
+
+func (vm *VM) fail(err error) {
+	panic(trap{err})
+}
+
+// GasUsed returns the amount of gas used until now.
+func (vm *VM) GasUsed() uint64 {
+	return vm.gasUsed
+}
+
+// UseGas consumes the given amount of gas. It is meant to be used by the host
+// functions and returns ErrOutOfGas if there is not enough gas left.
+func (vm *VM) UseGas(gas uint64) error {
+	if gas > vm.config.Gas-vm.gasUsed {
+		vm.gasUsed = vm.config.Gas
+		return ErrOutOfGas
+	}
+	vm.gasUsed += gas
+	return nil
+}
+
+func (vm *VM) useGas(gas uint64) {
+	if err := vm.UseGas(gas); err != nil {
+		vm.fail(err)
+	}
+}
+
+// Read returns a copy of the memory at the given address.
+func (vm *VM) Read(ptr, length uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(length) > uint64(len(vm.memory)) {
+		return nil, xerrors.New("out of bounds memory access")
+	}
+	return append([]byte{}, vm.memory[ptr:ptr+length]...), nil
+}
+
+// Write copies the data in the memory at the given address.
+func (vm *VM) Write(ptr uint32, data []byte) error {
+	if uint64(ptr)+uint64(len(data)) > uint64(len(vm.memory)) {
+		return xerrors.New("out of bounds memory access")
+	}
+	copy(vm.memory[ptr:], data)
+	return nil
+}
+
+func (vm *VM) maxPages() uint32 {
+	max := vm.config.MaxPages
+	if vm.mod.memory != nil && vm.mod.memory.hasMax && vm.mod.memory.max < max {
+		max = vm.mod.memory.max
+	}
+	return max
+}
+
+func (vm *VM) push(v uint64) {
+	if len(vm.stack) >= vm.config.MaxStack {
+		vm.fail(xerrors.New("stack overflow"))
+	}
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *VM) pop() uint64 {
+	if len(vm.stack) == 0 {
+		vm.fail(xerrors.New("stack underflow"))
+	}
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v
+}
+
+func (vm *VM) push32(v uint32) {
+	vm.push(uint64(v))
+}
+
+func (vm *VM) pop32() uint32 {
+	return uint32(vm.pop())
+}
+
+func (vm *VM) pushBool(b bool) {
+	if b {
+		vm.push(1)
+	} else {
+		vm.push(0)
+	}
+}
+
+// keep moves the n values on the top of the stack to the given height.
+func (vm *VM) keep(height, n int) {
+	if height+n > len(vm.stack) {
+		vm.fail(xerrors.New("stack underflow"))
+	}
+	copy(vm.stack[height:], vm.stack[len(vm.stack)-n:])
+	vm.stack = vm.stack[:height+n]
+}
+
+// address returns the address of an access of size bytes in the memory.
+func (vm *VM) address(offset uint64, size uint64) uint64 {
+	ea := uint64(vm.pop32()) + offset
+	if ea+size > uint64(len(vm.memory)) {
+		vm.fail(xerrors.New("out of bounds memory access"))
+	}
+	return ea
+}
+
+func (vm *VM) load(offset, size uint64) uint64 {
+	ea := vm.address(offset, size)
+	switch size {
+	case 1:
+		return uint64(vm.memory[ea])
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(vm.memory[ea:]))
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(vm.memory[ea:]))
+	default:
+		return binary.LittleEndian.Uint64(vm.memory[ea:])
+	}
+}
+
+func (vm *VM) store(offset, size uint64) {
+	v := vm.pop()
+	ea := vm.address(offset, size)
+	switch size {
+	case 1:
+		vm.memory[ea] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(vm.memory[ea:], uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(vm.memory[ea:], uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(vm.memory[ea:], v)
+	}
+}
+
+// call calls the function with the given index, taking its arguments from
+// the stack and leaving its results on it.
+func (vm *VM) call(idx uint32) {
+	vm.depth++
+	defer func() { vm.depth-- }()
+	if vm.depth > vm.config.MaxCallDepth {
+		vm.fail(xerrors.New("call stack exhausted"))
+	}
+
+	ft := vm.mod.funcType(idx)
+	if len(vm.stack) < len(ft.Params) {
+		vm.fail(xerrors.New("stack underflow"))
+	}
+	if int(idx) < len(vm.host) {
+		args := append([]uint64{}, vm.stack[len(vm.stack)-len(ft.Params):]...)
+		vm.stack = vm.stack[:len(vm.stack)-len(ft.Params)]
+		vm.useGas(1)
+		results, err := vm.host[idx].Call(vm, args)
+		if err != nil {
+			vm.fail(err)
+		}
+		if len(results) != len(ft.Results) {
+			vm.fail(xerrors.New("host function returned wrong number of results"))
+		}
+		for _, r := range results {
+			vm.push(r)
+		}
+		return
+	}
+
+	f := &vm.mod.funcs[int(idx)-len(vm.host)]
+	vm.useGas(uint64(f.locals))
+	locals := make([]uint64, len(ft.Params)+f.locals)
+	copy(locals, vm.stack[len(vm.stack)-len(ft.Params):])
+	vm.stack = vm.stack[:len(vm.stack)-len(ft.Params)]
+	vm.exec(f.code, locals, len(ft.Results))
+}
+
+// exec runs the code of a function. The results are left on the stack, in
+// place of the arguments.
+func (vm *VM) exec(code []instr, locals []uint64, results int) {
+	height := len(vm.stack)
+	var labels []label
+	// branch jumps to the label at the given depth and returns the next pc,
+	// or -1 if the function returns.
+	branch := func(depth uint64) int {
+		if depth >= uint64(len(labels)) {
+			vm.keep(height, results)
+			return -1
+		}
+		l := labels[len(labels)-1-int(depth)]
+		if l.loop {
+			vm.keep(l.height, 0)
+			labels = labels[:len(labels)-int(depth)]
+			return l.start
+		}
+		vm.keep(l.height, l.arity)
+		labels = labels[:len(labels)-1-int(depth)]
+		return l.end + 1
+	}
+
+	// A branch to the outermost label returns from the function.
+	pc := 0
+	for pc >= 0 {
+		vm.useGas(1)
+		in := &code[pc]
+		pc++
+		switch in.op {
+		case opUnreachable:
+			vm.fail(xerrors.New("unreachable executed"))
+		case opNop:
+		case opBlock:
+			labels = append(labels, label{height: len(vm.stack), arity: in.arity,
+				end: int(in.a)})
+		case opLoop:
+			labels = append(labels, label{height: len(vm.stack), loop: true,
+				start: pc, end: int(in.a)})
+		case opIf:
+			cond := vm.pop32()
+			labels = append(labels, label{height: len(vm.stack), arity: in.arity,
+				end: int(in.a)})
+			if cond == 0 {
+				if in.b != noElse {
+					pc = int(in.b) + 1
+				} else {
+					pc = int(in.a)
+				}
+			}
+		case opElse:
+			pc = int(in.a)
+		case opEnd:
+			if len(labels) == 0 {
+				vm.keep(height, results)
+				return
+			}
+			labels = labels[:len(labels)-1]
+		case opBr:
+			pc = branch(in.a)
+		case opBrIf:
+			if vm.pop32() != 0 {
+				pc = branch(in.a)
+			}
+		case opBrTable:
+			i := vm.pop32()
+			if uint64(i) < uint64(len(in.table)) {
+				pc = branch(uint64(in.table[i]))
+			} else {
+				pc = branch(in.a)
+			}
+		case opReturn:
+			vm.keep(height, results)
+			return
+		case opCall:
+			vm.call(uint32(in.a))
+		case opCallIndirect:
+			i := vm.pop32()
+			if uint64(i) >= uint64(len(vm.table)) || vm.table[i] == nil {
+				vm.fail(xerrors.New("undefined element"))
+			}
+			if !vm.mod.funcType(*vm.table[i]).Equal(vm.mod.types[in.a]) {
+				vm.fail(xerrors.New("indirect call type mismatch"))
+			}
+			vm.call(*vm.table[i])
+
+		case opDrop:
+			vm.pop()
+		case opSelect:
+			cond := vm.pop32()
+			b := vm.pop()
+			a := vm.pop()
+			if cond != 0 {
+				vm.push(a)
+			} else {
+				vm.push(b)
+			}
+
+		case opLocalGet:
+			vm.push(locals[in.a])
+		case opLocalSet:
+			locals[in.a] = vm.pop()
+		case opLocalTee:
+			v := vm.pop()
+			locals[in.a] = v
+			vm.push(v)
+		case opGlobalGet:
+			vm.push(vm.globals[in.a])
+		case opGlobalSet:
+			vm.globals[in.a] = vm.pop()
+
+		case opI32Load, opI64Load32U:
+			vm.push(vm.load(in.a, 4))
+		case opI64Load:
+			vm.push(vm.load(in.a, 8))
+		case opI32Load8S:
+			vm.push32(uint32(int8(vm.load(in.a, 1))))
+		case opI32Load8U, opI64Load8U:
+			vm.push(vm.load(in.a, 1))
+		case opI32Load16S:
+			vm.push32(uint32(int16(vm.load(in.a, 2))))
+		case opI32Load16U, opI64Load16U:
+			vm.push(vm.load(in.a, 2))
+		case opI64Load8S:
+			vm.push(uint64(int8(vm.load(in.a, 1))))
+		case opI64Load16S:
+			vm.push(uint64(int16(vm.load(in.a, 2))))
+		case opI64Load32S:
+			vm.push(uint64(int32(vm.load(in.a, 4))))
+		case opI32Store, opI64Store32:
+			vm.store(in.a, 4)
+		case opI64Store:
+			vm.store(in.a, 8)
+		case opI32Store8, opI64Store8:
+			vm.store(in.a, 1)
+		case opI32Store16, opI64Store16:
+			vm.store(in.a, 2)
+		case opMemorySize:
+			vm.push32(uint32(len(vm.memory) / pageSize))
+		case opMemoryGrow:
+			vm.push32(vm.grow(vm.pop32()))
+		case opMemoryCopy:
+			n := uint64(vm.pop32())
+			src := uint64(vm.pop32())
+			dst := uint64(vm.pop32())
+			if src+n > uint64(len(vm.memory)) || dst+n > uint64(len(vm.memory)) {
+				vm.fail(xerrors.New("out of bounds memory access"))
+			}
+			vm.useGas(n * GasPerByte)
+			copy(vm.memory[dst:dst+n], vm.memory[src:src+n])
+		case opMemoryFill:
+			n := uint64(vm.pop32())
+			v := byte(vm.pop32())
+			dst := uint64(vm.pop32())
+			if dst+n > uint64(len(vm.memory)) {
+				vm.fail(xerrors.New("out of bounds memory access"))
+			}
+			vm.useGas(n * GasPerByte)
+			for i := dst; i < dst+n; i++ {
+				vm.memory[i] = v
+			}
+
+		case opI32Const, opI64Const:
+			vm.push(in.a)
+
+		case opI32Eqz:
+			vm.pushBool(vm.pop32() == 0)
+		case opI64Eqz:
+			vm.pushBool(vm.pop() == 0)
+		default:
+			if in.op >= opI32Eq && in.op <= opI32GeU {
+				b := vm.pop32()
+				a := vm.pop32()
+				vm.pushBool(compare32(in.op, a, b))
+			} else if in.op >= opI64Eq && in.op <= opI64GeU {
+				b := vm.pop()
+				a := vm.pop()
+				vm.pushBool(compare64(in.op, a, b))
+			} else {
+				vm.numeric(in.op)
+			}
+		}
+	}
+}
+
+// grow adds n pages to the memory and returns the previous number of pages,
+// or -1 if the memory cannot grow.
+func (vm *VM) grow(n uint32) uint32 {
+	pages := uint32(len(vm.memory) / pageSize)
+	if vm.mod.memory == nil || uint64(pages)+uint64(n) > uint64(vm.maxPages()) {
+		return math.MaxUint32
+	}
+	vm.useGas(uint64(n) * GasPerPage)
+	vm.memory = append(vm.memory, make([]byte, int(n)*pageSize)...)
+	return pages
+}
+
+func compare32(op uint16, a, b uint32) bool {
+	switch op {
+	case opI32Eq:
+		return a == b
+	case opI32Ne:
+		return a != b
+	case opI32LtS:
+		return int32(a) < int32(b)
+	case opI32LtU:
+		return a < b
+	case opI32GtS:
+		return int32(a) > int32(b)
+	case opI32GtU:
+		return a > b
+	case opI32LeS:
+		return int32(a) <= int32(b)
+	case opI32LeU:
+		return a <= b
+	case opI32GeS:
+		return int32(a) >= int32(b)
+	default:
+		return a >= b
+	}
+}
+
+func compare64(op uint16, a, b uint64) bool {
+	switch op {
+	case opI64Eq:
+		return a == b
+	case opI64Ne:
+		return a != b
+	case opI64LtS:
+		return int64(a) < int64(b)
+	case opI64LtU:
+		return a < b
+	case opI64GtS:
+		return int64(a) > int64(b)
+	case opI64GtU:
+		return a > b
+	case opI64LeS:
+		return int64(a) <= int64(b)
+	case opI64LeU:
+		return a <= b
+	case opI64GeS:
+		return int64(a) >= int64(b)
+	default:
+		return a >= b
+	}
+}
+
+// numeric executes the arithmetic and conversion instructions.
+func (vm *VM) numeric(op uint16) {
+	switch op {
+	case opI32Clz:
+		vm.push32(uint32(bits.LeadingZeros32(vm.pop32())))
+	case opI32Ctz:
+		vm.push32(uint32(bits.TrailingZeros32(vm.pop32())))
+	case opI32Popcnt:
+		vm.push32(uint32(bits.OnesCount32(vm.pop32())))
+	case opI64Clz:
+		vm.push(uint64(bits.LeadingZeros64(vm.pop())))
+	case opI64Ctz:
+		vm.push(uint64(bits.TrailingZeros64(vm.pop())))
+	case opI64Popcnt:
+		vm.push(uint64(bits.OnesCount64(vm.pop())))
+	case opI32WrapI64:
+		vm.push32(uint32(vm.pop()))
+	case opI64ExtendI32S:
+		vm.push(uint64(int32(vm.pop32())))
+	case opI64ExtendI32U:
+		vm.push(uint64(vm.pop32()))
+	case opI32Extend8S:
+		vm.push32(uint32(int8(vm.pop32())))
+	case opI32Extend16S:
+		vm.push32(uint32(int16(vm.pop32())))
+	case opI64Extend8S:
+		vm.push(uint64(int8(vm.pop())))
+	case opI64Extend16S:
+		vm.push(uint64(int16(vm.pop())))
+	case opI64Extend32S:
+		vm.push(uint64(int32(vm.pop())))
+	default:
+		if op >= opI32Add && op <= opI32Rotr {
+			b := vm.pop32()
+			a := vm.pop32()
+			vm.push32(vm.binary32(op, a, b))
+		} else if op >= opI64Add && op <= opI64Rotr {
+			b := vm.pop()
+			a := vm.pop()
+			vm.push(vm.binary64(op, a, b))
+		} else {
+			vm.fail(xerrors.Errorf("unsupported instruction 0x%x", op))
+		}
+	}
+}
+
+func (vm *VM) binary32(op uint16, a, b uint32) uint32 {
+	switch op {
+	case opI32Add:
+		return a + b
+	case opI32Sub:
+		return a - b
+	case opI32Mul:
+		return a * b
+	case opI32DivS, opI32DivU, opI32RemS, opI32RemU:
+		if b == 0 {
+			vm.fail(xerrors.New("integer divide by zero"))
+		}
+		switch op {
+		case opI32DivS:
+			if int32(a) == math.MinInt32 && int32(b) == -1 {
+				vm.fail(xerrors.New("integer overflow"))
+			}
+			return uint32(int32(a) / int32(b))
+		case opI32DivU:
+			return a / b
+		case opI32RemS:
+			if int32(b) == -1 {
+				return 0
+			}
+			return uint32(int32(a) % int32(b))
+		default:
+			return a % b
+		}
+	case opI32And:
+		return a & b
+	case opI32Or:
+		return a | b
+	case opI32Xor:
+		return a ^ b
+	case opI32Shl:
+		return a << (b & 31)
+	case opI32ShrS:
+		return uint32(int32(a) >> (b & 31))
+	case opI32ShrU:
+		return a >> (b & 31)
+	case opI32Rotl:
+		return bits.RotateLeft32(a, int(b&31))
+	default:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+}
+
+func (vm *VM) binary64(op uint16, a, b uint64) uint64 {
+	switch op {
+	case opI64Add:
+		return a + b
+	case opI64Sub:
+		return a - b
+	case opI64Mul:
+		return a * b
+	case opI64DivS, opI64DivU, opI64RemS, opI64RemU:
+		if b == 0 {
+			vm.fail(xerrors.New("integer divide by zero"))
+		}
+		switch op {
+		case opI64DivS:
+			if int64(a) == math.MinInt64 && int64(b) == -1 {
+				vm.fail(xerrors.New("integer overflow"))
+			}
+			return uint64(int64(a) / int64(b))
+		case opI64DivU:
+			return a / b
+		case opI64RemS:
+			if int64(b) == -1 {
+				return 0
+			}
+			return uint64(int64(a) % int64(b))
+		default:
+			return a % b
+		}
+	case opI64And:
+		return a & b
+	case opI64Or:
+		return a | b
+	case opI64Xor:
+		return a ^ b
+	case opI64Shl:
+		return a << (b & 63)
+	case opI64ShrS:
+		return uint64(int64(a) >> (b & 63))
+	case opI64ShrU:
+		return a >> (b & 63)
+	case opI64Rotl:
+		return bits.RotateLeft64(a, int(b&63))
+	default:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+}
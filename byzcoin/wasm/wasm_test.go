@@ -0,0 +1,333 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The helpers below assemble modules in the binary format.
+
+func leb(v uint64) []byte {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func cat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+func vec(items ...[]byte) []byte {
+	return cat(leb(uint64(len(items))), cat(items...))
+}
+
+func name(s string) []byte {
+	return cat(leb(uint64(len(s))), []byte(s))
+}
+
+func section(id byte, items ...[]byte) []byte {
+	content := vec(items...)
+	return cat([]byte{id}, leb(uint64(len(content))), content)
+}
+
+func module(sections ...[]byte) []byte {
+	return cat([]byte(magic), []byte{1, 0, 0, 0}, cat(sections...))
+}
+
+func fnType(params []ValueType, results ...ValueType) []byte {
+	return cat([]byte{funcTypeTag}, vec(types(params)...), vec(types(results)...))
+}
+
+func types(vts []ValueType) [][]byte {
+	var items [][]byte
+	for _, vt := range vts {
+		items = append(items, []byte{byte(vt)})
+	}
+	return items
+}
+
+func export(n string, idx uint32) []byte {
+	return cat(name(n), []byte{externFunc}, leb(uint64(idx)))
+}
+
+func body(locals [][]byte, code ...byte) []byte {
+	b := cat(vec(locals...), code)
+	return cat(leb(uint64(len(b))), b)
+}
+
+func u32(v uint32) []byte {
+	return leb(uint64(v))
+}
+
+func instantiate(t *testing.T, code []byte, imports Imports) *VM {
+	mod, err := Parse(code)
+	require.NoError(t, err)
+	vm, err := Instantiate(mod, imports, DefaultConfig())
+	require.NoError(t, err)
+	return vm
+}
+
+func TestParse(t *testing.T) {
+	_, err := Parse([]byte("not wasm"))
+	require.Error(t, err)
+
+	// An empty module is valid.
+	_, err = Parse(module())
+	require.NoError(t, err)
+
+	// The floating point types are refused.
+	_, err = Parse(module(section(sectionType, fnType([]ValueType{0x7d}))))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported value type")
+
+	// The floating point instructions are refused too.
+	_, err = Parse(module(
+		section(sectionType, fnType(nil)),
+		section(sectionFunction, u32(0)),
+		section(sectionCode, body(nil, 0x43, 0, 0, 0, 0, 0x1a, opEnd)),
+	))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported instruction")
+
+	// A call to an unknown function is refused.
+	_, err = Parse(module(
+		section(sectionType, fnType(nil)),
+		section(sectionFunction, u32(0)),
+		section(sectionCode, body(nil, opCall, 5, opEnd)),
+	))
+	require.Error(t, err)
+
+	// An unterminated function is refused.
+	_, err = Parse(module(
+		section(sectionType, fnType(nil)),
+		section(sectionFunction, u32(0)),
+		section(sectionCode, body(nil, opBlock, blockTypeEmpty, opEnd)),
+	))
+	require.Error(t, err)
+}
+
+func TestVM_Control(t *testing.T) {
+	i32, i64 := []ValueType{I32}, []ValueType{I64}
+	code := module(
+		section(sectionType, fnType(i64, I64), fnType(i32, I32)),
+		section(sectionFunction, u32(0), u32(1), u32(1)),
+		section(sectionExport, export("fac", 0), export("fib", 1),
+			export("switch", 2)),
+		section(sectionCode,
+			// The factorial with a loop.
+			body([][]byte{{1, byte(I64)}},
+				opI64Const, 1, opLocalSet, 1,
+				opBlock, blockTypeEmpty, opLoop, blockTypeEmpty,
+				opLocalGet, 0, opI64Eqz, opBrIf, 1,
+				opLocalGet, 1, opLocalGet, 0, opI64Mul, opLocalSet, 1,
+				opLocalGet, 0, opI64Const, 1, opI64Sub, opLocalSet, 0,
+				opBr, 0,
+				opEnd, opEnd,
+				opLocalGet, 1, opEnd),
+			// Fibonacci with recursive calls.
+			body(nil,
+				opLocalGet, 0, opI32Const, 2, opI32LtU,
+				opIf, byte(I32),
+				opLocalGet, 0,
+				opElse,
+				opLocalGet, 0, opI32Const, 1, opI32Sub, opCall, 1,
+				opLocalGet, 0, opI32Const, 2, opI32Sub, opCall, 1,
+				opI32Add,
+				opEnd, opEnd),
+			// A switch with a br_table.
+			body(nil,
+				opBlock, blockTypeEmpty, opBlock, blockTypeEmpty,
+				opBlock, blockTypeEmpty,
+				opLocalGet, 0, opBrTable, 2, 0, 1, 2,
+				opEnd, opI32Const, 10, opReturn,
+				opEnd, opI32Const, 20, opReturn,
+				opEnd, opI32Const, 0xe3, 0, opEnd),
+		),
+	)
+	vm := instantiate(t, code, nil)
+
+	res, err := vm.Call("fac", 10)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3628800}, res)
+
+	res, err = vm.Call("fib", 15)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{610}, res)
+
+	for in, out := range map[uint64]uint64{0: 10, 1: 20, 2: 99, 100: 99} {
+		res, err = vm.Call("switch", in)
+		require.NoError(t, err)
+		require.Equal(t, []uint64{out}, res)
+	}
+
+	_, err = vm.Call("fac")
+	require.Error(t, err)
+	_, err = vm.Call("unknown")
+	require.Error(t, err)
+}
+
+func TestVM_Numeric(t *testing.T) {
+	i32x2 := []ValueType{I32, I32}
+	i64x2 := []ValueType{I64, I64}
+	code := module(
+		section(sectionType, fnType(i32x2, I32), fnType(i64x2, I64)),
+		section(sectionFunction, u32(0), u32(0), u32(0), u32(1), u32(1)),
+		section(sectionExport, export("div_s", 0), export("rem_s", 1),
+			export("rotl", 2), export("shr_s", 3), export("mul", 4)),
+		section(sectionCode,
+			body(nil, opLocalGet, 0, opLocalGet, 1, opI32DivS, opEnd),
+			body(nil, opLocalGet, 0, opLocalGet, 1, opI32RemS, opEnd),
+			body(nil, opLocalGet, 0, opLocalGet, 1, opI32Rotl, opEnd),
+			body(nil, opLocalGet, 0, opLocalGet, 1, opI64ShrS, opEnd),
+			body(nil, opLocalGet, 0, opLocalGet, 1, opI64Mul, opEnd),
+		),
+	)
+	vm := instantiate(t, code, nil)
+	minus := func(v int32) uint64 {
+		return uint64(uint32(v))
+	}
+
+	res, err := vm.Call("div_s", minus(-7), 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{minus(-3)}, res)
+	res, err = vm.Call("rem_s", minus(-7), 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{minus(-1)}, res)
+	res, err = vm.Call("rem_s", 0x80000000, minus(-1))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, res)
+	res, err = vm.Call("rotl", 0x80000001, 33)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, res)
+	res, err = vm.Call("shr_s", 1<<63, 62)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{^uint64(1)}, res)
+	res, err = vm.Call("mul", 1<<32, 1<<32)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, res)
+
+	vm = instantiate(t, code, nil)
+	_, err = vm.Call("div_s", 1, 0)
+	require.EqualError(t, err, "integer divide by zero")
+	vm = instantiate(t, code, nil)
+	_, err = vm.Call("div_s", 0x80000000, minus(-1))
+	require.EqualError(t, err, "integer overflow")
+}
+
+func TestVM_Memory(t *testing.T) {
+	i32 := []ValueType{I32}
+	code := module(
+		section(sectionType, fnType(i32, I32), fnType([]ValueType{I32, I64}, I64)),
+		section(sectionFunction, u32(0), u32(0), u32(1)),
+		section(sectionMemory, []byte{1, 1, 2}),
+		section(sectionExport, export("load8", 0), export("grow", 1),
+			export("roundtrip", 2)),
+		section(sectionCode,
+			body(nil, opLocalGet, 0, opI32Load8U, 0, 0, opEnd),
+			body(nil, opLocalGet, 0, opMemoryGrow, 0, opEnd),
+			body(nil, opLocalGet, 0, opLocalGet, 1, opI64Store, 3, 0,
+				opLocalGet, 0, opI64Load, 3, 0, opEnd),
+		),
+		section(sectionData, cat([]byte{0, opI32Const, 8, opEnd}, name("hello"))),
+	)
+	vm := instantiate(t, code, nil)
+
+	res, err := vm.Call("load8", 9)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{'e'}, res)
+	buf, err := vm.Read(8, 5)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+
+	res, err = vm.Call("roundtrip", 100, 0x0102030405060708)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0x0102030405060708}, res)
+	res, err = vm.Call("load8", 100)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{8}, res)
+
+	// The memory cannot grow over its maximum.
+	res, err = vm.Call("grow", 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, res)
+	res, err = vm.Call("grow", 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0xffffffff}, res)
+
+	_, err = vm.Call("load8", 2*pageSize)
+	require.EqualError(t, err, "out of bounds memory access")
+}
+
+func TestVM_Limits(t *testing.T) {
+	code := module(
+		section(sectionType, fnType(nil)),
+		section(sectionFunction, u32(0), u32(0)),
+		section(sectionExport, export("loop", 0), export("recurse", 1)),
+		section(sectionCode,
+			body(nil, opLoop, blockTypeEmpty, opBr, 0, opEnd, opEnd),
+			body(nil, opCall, 1, opEnd),
+		),
+	)
+	mod, err := Parse(code)
+	require.NoError(t, err)
+	config := DefaultConfig()
+	config.Gas = 1000
+
+	vm, err := Instantiate(mod, nil, config)
+	require.NoError(t, err)
+	_, err = vm.Call("loop")
+	require.Equal(t, ErrOutOfGas, err)
+	require.Equal(t, config.Gas, vm.GasUsed())
+
+	vm, err = Instantiate(mod, nil, DefaultConfig())
+	require.NoError(t, err)
+	_, err = vm.Call("recurse")
+	require.EqualError(t, err, "call stack exhausted")
+}
+
+func TestVM_Host(t *testing.T) {
+	i32 := []ValueType{I32}
+	code := module(
+		section(sectionType, fnType(i32, I32)),
+		section(sectionImport, cat(name("env"), name("double"), []byte{externFunc}, u32(0))),
+		section(sectionFunction, u32(0)),
+		section(sectionTable, cat([]byte{funcRefType}, []byte{0, 2})),
+		section(sectionExport, export("call", 1)),
+		section(sectionElement, cat(u32(0), []byte{opI32Const, 0, opEnd}, vec(u32(0), u32(1)))),
+		section(sectionCode,
+			// Calls double through the table.
+			body(nil, opLocalGet, 0, opI32Const, 0, opCallIndirect, 0, 0, opEnd),
+		),
+	)
+	mod, err := Parse(code)
+	require.NoError(t, err)
+
+	double := HostFunc{
+		Type: FuncType{Params: i32, Results: i32},
+		Call: func(vm *VM, args []uint64) ([]uint64, error) {
+			return []uint64{2 * args[0]}, nil
+		},
+	}
+	_, err = Instantiate(mod, nil, DefaultConfig())
+	require.Error(t, err)
+	_, err = Instantiate(mod, Imports{"env": {"double": HostFunc{Type: FuncType{}}}},
+		DefaultConfig())
+	require.Error(t, err)
+
+	vm, err := Instantiate(mod, Imports{"env": {"double": double}}, DefaultConfig())
+	require.NoError(t, err)
+	res, err := vm.Call("call", 21)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{42}, res)
+}
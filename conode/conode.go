@@ -26,7 +26,7 @@ import (
 	cli "github.com/urfave/cli"
 	"go.dedis.ch/cothority/v3"
 	_ "go.dedis.ch/cothority/v3/authprox"
-	_ "go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin"
 	_ "go.dedis.ch/cothority/v3/byzcoin/contracts"
 	_ "go.dedis.ch/cothority/v3/calypso"
 	_ "go.dedis.ch/cothority/v3/eventlog"
@@ -112,6 +112,17 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "migrate-storage",
+			Usage:  "Copy the ByzCoin state tries and the skipblocks of a bbolt database to the storage of the configuration",
+			Action: migrateStorage,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "db",
+					Usage: "the bbolt database of the conode",
+				},
+			},
+		},
 	}
 	cliApp.Flags = []cli.Flag{
 		cli.IntFlag{
@@ -156,6 +167,20 @@ func runServer(ctx *cli.Context) error {
 	if raiseFdLimit != nil {
 		raiseFdLimit()
 	}
+	storage, err := readStorageConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := byzcoin.SetTrieStorage(storage); err != nil {
+		return err
+	}
+	nc, err := readNodeConfig(config)
+	if err != nil {
+		return err
+	}
+	if err := byzcoin.SetNodeConfig(nc); err != nil {
+		return err
+	}
 	app.RunServer(config)
 	return nil
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"go.dedis.ch/cothority/v3/byzcoin"
+)
+
+// nodeConfig is the part of the configuration of the conode that holds the
+// settings of its ByzCoin services, for example:
+//
+//  [ByzCoin]
+//    SnapshotInterval = 500
+//    PruneDepth = 1000
+//    StateRetention = 100
+//    StateTrieCacheSize = 33554432
+type nodeConfig struct {
+	ByzCoin byzcoin.NodeConfig
+}
+
+// readNodeConfig reads the settings of the ByzCoin services of the
+// configuration file.
+func readNodeConfig(file string) (byzcoin.NodeConfig, error) {
+	var c nodeConfig
+	if _, err := toml.DecodeFile(file, &c); err != nil {
+		return byzcoin.NodeConfig{}, fmt.Errorf("reading the byzcoin configuration: %v", err)
+	}
+	return c.ByzCoin, nil
+}
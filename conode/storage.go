@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	cli "github.com/urfave/cli"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/app"
+	"go.dedis.ch/onet/v3/log"
+	"go.etcd.io/bbolt"
+)
+
+// migrateBatchSize is the number of entries written at once to LevelDB.
+const migrateBatchSize = 10000
+
+// trieBucket matches the buckets holding the state tries in the bbolt
+// database of the conode.
+var trieBucket = regexp.MustCompile("^ByzCoin_([0-9a-f]{64})$")
+
+// skipBlockBucket is the bucket holding the skipblocks in the bbolt database
+// of the conode.
+var skipBlockBucket = []byte(skipchain.ServiceName + "_skipblocks")
+
+// storageConfig is the part of the configuration of the conode that selects
+// the storage of the state tries and of the skipblocks, for example:
+//
+//  [Storage]
+//    Backend = "leveldb"
+//    Path = "tries.ldb"
+type storageConfig struct {
+	Storage byzcoin.TrieStorage
+}
+
+// readStorageConfig reads the storage of the configuration file. A relative
+// path is relative to the directory of the configuration file.
+func readStorageConfig(file string) (byzcoin.TrieStorage, error) {
+	var c storageConfig
+	if _, err := toml.DecodeFile(file, &c); err != nil {
+		return byzcoin.TrieStorage{}, fmt.Errorf("reading the storage configuration: %v", err)
+	}
+	if c.Storage.Path != "" && !filepath.IsAbs(c.Storage.Path) {
+		c.Storage.Path = filepath.Join(filepath.Dir(file), c.Storage.Path)
+	}
+	return c.Storage, nil
+}
+
+// migrateStorage copies the state tries and the skipblocks of the bbolt
+// database of the conode to the LevelDB database of its configuration. The
+// conode must be stopped.
+func migrateStorage(c *cli.Context) error {
+	config := c.GlobalString("config")
+	if c.String("db") == "" {
+		return errors.New("missing the bbolt database")
+	}
+	storage, err := readStorageConfig(config)
+	if err != nil {
+		return err
+	}
+	if storage.Backend != byzcoin.TrieStorageLevelDB {
+		return errors.New("the configuration doesn't use the leveldb storage")
+	}
+	ccfg, err := app.LoadCothority(config)
+	if err != nil {
+		return err
+	}
+	si, err := ccfg.GetServerIdentity()
+	if err != nil {
+		return err
+	}
+
+	src, err := bbolt.Open(c.String("db"), 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("opening the bbolt database: %v", err)
+	}
+	defer src.Close()
+	dst, err := byzcoin.OpenTrieLevelDB(storage.Path)
+	if err != nil {
+		return err
+	}
+	defer byzcoin.ReleaseTrieLevelDB(storage.Path)
+
+	return src.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if bytes.Equal(name, skipBlockBucket) {
+				return copyBucket(dst, b, byzcoin.SkipBlockLevelDBPrefix(si.Public),
+					"the skipblocks", storage.Path)
+			}
+			m := trieBucket.FindSubmatch(name)
+			if m == nil {
+				return nil
+			}
+			return copyBucket(dst, b, byzcoin.TrieLevelDBPrefix(si.Public, string(m[1])),
+				fmt.Sprintf("the trie of %s", m[1]), storage.Path)
+		})
+	})
+}
+
+// copyBucket copies the entries of a bucket to the LevelDB database with the
+// given prefix, which must not be used yet.
+func copyBucket(dst *leveldb.DB, b *bbolt.Bucket, prefix []byte, what, path string) error {
+	it := dst.NewIterator(util.BytesPrefix(prefix), nil)
+	exists := it.Next()
+	it.Release()
+	if exists {
+		return fmt.Errorf("%s already exist in %s", what, path)
+	}
+
+	batch := new(leveldb.Batch)
+	var n int
+	err := b.ForEach(func(k, v []byte) error {
+		batch.Put(append(append([]byte{}, prefix...), k...), v)
+		n++
+		if batch.Len() < migrateBatchSize {
+			return nil
+		}
+		err := dst.Write(batch, nil)
+		batch.Reset()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := dst.Write(batch, nil); err != nil {
+		return err
+	}
+	log.Infof("Copied %d entries of %s", n, what)
+	return nil
+}
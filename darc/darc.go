@@ -33,19 +33,26 @@ package darc
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 
 	"golang.org/x/xerrors"
 
@@ -73,7 +80,9 @@ const sign = "_sign"
 // if latest is true, then given a darc base-ID (a darc of version 0), the
 // callback should return the latest one with that base-ID. If latest is false,
 // then the callback should return an exact match. The callback should return
-// nil if no match is found.
+// nil if no match is found. The callback is also given the delegation ids
+// (delegation:<instance id>), and should then return a darc whose "sign" rule
+// holds the delegated identity, as long as the delegation is valid.
 type GetDarc func(s string, latest bool) *Darc
 
 // AttrInterpreters is a map of callbacks for evaluating an attribute. An
@@ -303,6 +312,16 @@ func (r Rules) Get(a Action) expression.Expr {
 	return nil
 }
 
+// HasThreshold returns true if one of the rules holds a threshold expression.
+func (r Rules) HasThreshold() bool {
+	for _, rule := range r.List {
+		if expression.HasThreshold(rule.Expr) {
+			return true
+		}
+	}
+	return false
+}
+
 // Copy copies the rules.
 func (r Rules) Copy() Rules {
 	rCopy := NewRules()
@@ -649,6 +668,9 @@ func evalAttr(s string, attrFuncs AttrInterpreters) error {
 	return attrFunc(tokens[2])
 }
 
+// errNotSigner is the reason why an identity of an expression is false.
+var errNotSigner = errors.New("expression evaluated to false")
+
 // evalExprDarc takes an extra visited parameter to track the visited nodes and
 // avoid infinite recursion.
 func evalExprDarc(visited map[string]bool, expr expression.Expr, getDarc GetDarc,
@@ -656,68 +678,12 @@ func evalExprDarc(visited map[string]bool, expr expression.Expr, getDarc GetDarc
 
 	var issue error
 	Y := expression.InitParser(func(s string) bool {
-		if strings.HasPrefix(s, "attr") {
-			if err := evalAttr(s, attrFuncs); err != nil {
-				issue = err
-				return false
-			}
-			return true
-		}
-
-		found := false
-		for _, id := range ids {
-			if id == s {
-				found = true
-			}
-		}
-		if strings.HasPrefix(s, "darc") {
-			if acceptDarc && found {
-				return true
-			}
-
-			// prevent cycles by checking the visited map
-			if _, ok := visited[s]; ok {
-				issue = errors.New("cycle detected")
-				return false
-			}
-
-			// we make a copy so that diamond delegation will work,
-			// see TestDarc_DelegationDiamond
-			newVisited := make(map[string]bool)
-			for k, v := range visited {
-				newVisited[k] = v
-			}
-			newVisited[s] = true
-
-			// getDarc is responsible for returning the latest Darc
-			d := getDarc(s, true)
-			if d == nil {
-				issue = fmt.Errorf("unable to get the darc %s", s)
-				return false
-			}
-
-			// Evaluate the "sign" action only in the latest darc
-			// because it may have revoked some rules in earlier
-			// darcs. We do this recursively because there may be
-			// further delegations.
-			if !d.Rules.Contains(sign) {
-				issue = errors.New(sign + " rule does not exist")
-				return false
-			}
-			signExpr := d.Rules.GetSignExpr()
-
-			// Recursively evaluate the sign expression until we
-			// find the final signer.
-			if err := evalExprDarc(newVisited, signExpr, getDarc, attrFuncs, acceptDarc, ids...); err != nil {
-				issue = err
-				return false
-			}
-			return true
-		}
-		if !found {
-			issue = errors.New("expression evaluated to false")
+		_, err := evalID(visited, s, getDarc, attrFuncs, acceptDarc, false, ids)
+		if err != nil {
+			issue = err
+			return false
 		}
-		return found
+		return true
 	})
 
 	res, err := expression.Evaluate(Y, expr)
@@ -735,6 +701,127 @@ func evalExprDarc(visited map[string]bool, expr expression.Expr, getDarc GetDarc
 	return nil
 }
 
+// evalID evaluates one id of an expression and returns the reason why it is
+// false. If explain is true, it also returns the steps of the evaluation of
+// a delegated darc.
+func evalID(visited map[string]bool, s string, getDarc GetDarc,
+	attrFuncs AttrInterpreters, acceptDarc, explain bool, ids []string) ([]ExplainStep, error) {
+	if strings.HasPrefix(s, "attr") {
+		return nil, evalAttr(s, attrFuncs)
+	}
+
+	found := false
+	for _, id := range ids {
+		if id == s {
+			found = true
+		}
+	}
+	if !strings.HasPrefix(s, "darc") && !strings.HasPrefix(s, "delegation") {
+		if !found {
+			return nil, errNotSigner
+		}
+		return nil, nil
+	}
+	if acceptDarc && found {
+		return nil, nil
+	}
+
+	// prevent cycles by checking the visited map
+	if _, ok := visited[s]; ok {
+		return nil, errors.New("cycle detected")
+	}
+
+	// we make a copy so that diamond delegation will work,
+	// see TestDarc_DelegationDiamond
+	newVisited := make(map[string]bool)
+	for k, v := range visited {
+		newVisited[k] = v
+	}
+	newVisited[s] = true
+
+	// getDarc is responsible for returning the latest Darc
+	d := getDarc(s, true)
+	if d == nil {
+		if strings.HasPrefix(s, "delegation") {
+			return nil, fmt.Errorf("the delegation %s is unknown or not valid", s)
+		}
+		return nil, fmt.Errorf("unable to get the darc %s", s)
+	}
+
+	// Evaluate the "sign" action only in the latest darc
+	// because it may have revoked some rules in earlier
+	// darcs. We do this recursively because there may be
+	// further delegations.
+	if !d.Rules.Contains(sign) {
+		return nil, errors.New(sign + " rule does not exist")
+	}
+	signExpr := d.Rules.GetSignExpr()
+
+	// Recursively evaluate the sign expression until we
+	// find the final signer.
+	if explain {
+		steps, err := explainExprDarc(newVisited, signExpr, getDarc, attrFuncs, acceptDarc, ids...)
+		if err != nil {
+			return nil, err
+		}
+		if !steps[0].Satisfied {
+			return steps, errors.New(sign + " rule of the darc is not satisfied")
+		}
+		return steps, nil
+	}
+	return nil, evalExprDarc(newVisited, signExpr, getDarc, attrFuncs, acceptDarc, ids...)
+}
+
+// explainExprDarc works like evalExprDarc, but returns the steps of the
+// evaluation.
+func explainExprDarc(visited map[string]bool, expr expression.Expr, getDarc GetDarc,
+	attrFuncs AttrInterpreters, acceptDarc bool, ids ...string) ([]ExplainStep, error) {
+
+	type result struct {
+		steps []ExplainStep
+		err   error
+	}
+	results := make(map[string]result)
+	trace, err := expression.Explain(expr, func(s string) bool {
+		r, ok := results[s]
+		if !ok {
+			r.steps, r.err = evalID(visited, s, getDarc, attrFuncs, acceptDarc, true, ids)
+			results[s] = r
+		}
+		return r.err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []ExplainStep
+	var walk func(t *expression.Trace, depth int)
+	walk = func(t *expression.Trace, depth int) {
+		step := ExplainStep{Depth: depth, Expr: t.String(), Satisfied: t.Value}
+		if t.Op != expression.IDOp {
+			steps = append(steps, step)
+			for _, c := range t.Children {
+				walk(c, depth+1)
+			}
+			return
+		}
+		r := results[t.ID]
+		switch {
+		case r.err == errNotSigner:
+			step.Reason = "not one of the signers"
+		case r.err != nil:
+			step.Reason = r.err.Error()
+		}
+		steps = append(steps, step)
+		for _, nested := range r.steps {
+			nested.Depth += depth + 1
+			steps = append(steps, nested)
+		}
+	}
+	walk(trace, 0)
+	return steps, nil
+}
+
 // EvalExprDarc checks whether the expression evaluates to true given a list of
 // identities. It takes 'acceptDarc', and, if it is true, doesn't recurse into
 // darcs that fit one of the ids.
@@ -750,6 +837,15 @@ func EvalExprAttr(expr expression.Expr, getDarc GetDarc, attrFuncs AttrInterpret
 	return evalExprDarc(make(map[string]bool), expr, getDarc, attrFuncs, false, ids...)
 }
 
+// ExplainExpr evaluates the expression like EvalExprAttr, and returns the
+// steps of the evaluation, which tell which parts of the expression and of
+// the delegated darcs are satisfied, or why they are not. The first step is
+// the whole expression. An error is only returned if the expression is
+// invalid.
+func ExplainExpr(expr expression.Expr, getDarc GetDarc, attrFuncs AttrInterpreters, ids ...string) ([]ExplainStep, error) {
+	return explainExprDarc(make(map[string]bool), expr, getDarc, attrFuncs, false, ids...)
+}
+
 // Type returns an integer representing the type of key held in the signer. It
 // is compatible with Identity.Type. For an empty signer, -1 is returned.
 func (s Signer) Type() int {
@@ -762,6 +858,10 @@ func (s Signer) Type() int {
 		return 3
 	case s.EvmContract != nil:
 		return 4
+	case s.Secp256k1 != nil:
+		return 5
+	case s.WebAuthn != nil:
+		return 6
 	default:
 		return -1
 	}
@@ -779,6 +879,10 @@ func (s Signer) Identity() Identity {
 		return NewIdentityProxy(s.Proxy)
 	case 4:
 		return NewIdentityEvmContract(s.EvmContract)
+	case 5:
+		return NewIdentitySecp256k1(s.Secp256k1.Address)
+	case 6:
+		return NewIdentityWebAuthn(s.WebAuthn.RPID, s.WebAuthn.Public)
 	default:
 		return Identity{}
 	}
@@ -800,6 +904,10 @@ func (s Signer) Sign(msg []byte) ([]byte, error) {
 		return s.Proxy.Sign(msg)
 	case 4:
 		return s.EvmContract.Sign(msg)
+	case 5:
+		return s.Secp256k1.Sign(msg)
+	case 6:
+		return s.WebAuthn.Sign(msg)
 	default:
 		return nil, errors.New("unknown signer type")
 	}
@@ -810,8 +918,10 @@ func (s Signer) GetPrivate() (kyber.Scalar, error) {
 	switch s.Type() {
 	case 1:
 		return s.Ed25519.Secret, nil
-	case 0, 2, 3:
+	case 0, 2, 3, 6:
 		return nil, errors.New("signer lacks a private key")
+	case 5:
+		return nil, errors.New("secp256k1 key is not a kyber scalar")
 	default:
 		return nil, errors.New("signer is of unknown type")
 	}
@@ -834,6 +944,10 @@ func (id Identity) Equal(id2 *Identity) bool {
 		return id.Proxy.Equal(id2.Proxy)
 	case 4:
 		return id.EvmContract.Equal(id2.EvmContract)
+	case 5:
+		return id.Secp256k1.Equal(id2.Secp256k1)
+	case 6:
+		return id.WebAuthn.Equal(id2.WebAuthn)
 	}
 	return false
 }
@@ -852,6 +966,10 @@ func (id Identity) Type() int {
 		return 3
 	case id.EvmContract != nil:
 		return 4
+	case id.Secp256k1 != nil:
+		return 5
+	case id.WebAuthn != nil:
+		return 6
 	}
 	return -1
 }
@@ -870,6 +988,10 @@ func (id Identity) PrimaryIdentity() bool {
 		return true
 	case id.EvmContract != nil:
 		return true
+	case id.Secp256k1 != nil:
+		return true
+	case id.WebAuthn != nil:
+		return true
 	}
 	return false
 }
@@ -887,6 +1009,10 @@ func (id Identity) TypeString() string {
 		return "proxy"
 	case 4:
 		return "evm_contract"
+	case 5:
+		return "secp256k1"
+	case 6:
+		return "webauthn"
 	default:
 		return "No identity"
 	}
@@ -907,6 +1033,10 @@ func (id Identity) String() string {
 		bevmString := hex.EncodeToString(id.EvmContract.BEvmID)
 		addrString := id.EvmContract.Address.Hex()
 		return fmt.Sprintf("%s:%s:%s", id.TypeString(), bevmString, addrString)
+	case 5:
+		return fmt.Sprintf("%s:%x", id.TypeString(), id.Secp256k1.Address[:])
+	case 6:
+		return fmt.Sprintf("%s:%s:%x", id.TypeString(), id.WebAuthn.RPID, id.WebAuthn.Public)
 	default:
 		return "No identity"
 	}
@@ -926,6 +1056,10 @@ func (id Identity) Verify(msg, sig []byte) error {
 		return id.Proxy.Verify(msg, sig)
 	case 4:
 		return id.EvmContract.Verify(msg, sig)
+	case 5:
+		return id.Secp256k1.Verify(msg, sig)
+	case 6:
+		return id.WebAuthn.Verify(msg, sig)
 	default:
 		return errors.New("unknown identity")
 	}
@@ -953,6 +1087,10 @@ func (id Identity) GetPublicBytes() []byte {
 		return buf
 	case 4:
 		return id.EvmContract.Address[:]
+	case 5:
+		return id.Secp256k1.Address[:]
+	case 6:
+		return id.WebAuthn.Public
 	default:
 		return nil
 	}
@@ -1021,6 +1159,27 @@ func NewIdentityEvmContract(s *SignerEvmContract) Identity {
 	}
 }
 
+// NewIdentitySecp256k1 creates a new secp256k1 identity struct given the
+// Ethereum address of the key.
+func NewIdentitySecp256k1(address common.Address) Identity {
+	return Identity{
+		Secp256k1: &IdentitySecp256k1{
+			Address: address,
+		},
+	}
+}
+
+// NewIdentityWebAuthn creates a new WebAuthn identity struct given the ID of
+// the relying party and the DER-encoded public key of the credential.
+func NewIdentityWebAuthn(rpID string, public []byte) Identity {
+	return Identity{
+		WebAuthn: &IdentityWebAuthn{
+			Public: public,
+			RPID:   rpID,
+		},
+	}
+}
+
 // Equal returns true if both IdentityX509EC point to the same data.
 func (idkc IdentityX509EC) Equal(idkc2 *IdentityX509EC) bool {
 	return bytes.Compare(idkc.Public, idkc2.Public) == 0
@@ -1037,6 +1196,17 @@ func (id IdentityEvmContract) Equal(id2 *IdentityEvmContract) bool {
 		id.Address == id2.Address
 }
 
+// Equal returns true if both IdentitySecp256k1 have the same address.
+func (id IdentitySecp256k1) Equal(id2 *IdentitySecp256k1) bool {
+	return id.Address == id2.Address
+}
+
+// Equal returns true if both IdentityWebAuthn have the same public key and
+// relying party.
+func (id IdentityWebAuthn) Equal(id2 *IdentityWebAuthn) bool {
+	return bytes.Equal(id.Public, id2.Public) && id.RPID == id2.RPID
+}
+
 type sigRS struct {
 	R *big.Int
 	S *big.Int
@@ -1091,6 +1261,142 @@ func (id IdentityEvmContract) Verify(msg, s []byte) error {
 	return xerrors.Errorf("invalid EVM Contract signature")
 }
 
+// ethereumHash returns the digest that an Ethereum wallet signs for msg with
+// the personal_sign method.
+func ethereumHash(msg []byte) []byte {
+	prefix := fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(msg))
+	return crypto.Keccak256([]byte(prefix), msg)
+}
+
+// Verify returns nil if the signature is correct, or an error if something
+// fails. The signature is the 65 bytes [R || S || V] returned by an Ethereum
+// wallet for the personal_sign method, where V is 27 or 28. Like for the
+// Ethereum transactions, S must be in the lower half of the curve order, so
+// that a signature cannot be turned into another valid one.
+func (id IdentitySecp256k1) Verify(msg, s []byte) error {
+	if len(s) != 65 {
+		return xerrors.Errorf("signature must be 65 bytes long, got %d", len(s))
+	}
+	if s[64] != 27 && s[64] != 28 {
+		return xerrors.Errorf("recovery id must be 27 or 28, got %d", s[64])
+	}
+	sig := append([]byte{}, s...)
+	sig[64] -= 27
+	r, sv := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])
+	if !crypto.ValidateSignatureValues(sig[64], r, sv, true) {
+		return xerrors.New("invalid signature values, or S in the upper half")
+	}
+	public, err := crypto.SigToPub(ethereumHash(msg), sig)
+	if err != nil {
+		return xerrors.Errorf("recovering public key: %v", err)
+	}
+	if crypto.PubkeyToAddress(*public) != id.Address {
+		return errors.New("Wrong signature")
+	}
+	return nil
+}
+
+// webAuthnUserPresent is the flag of the authenticator data telling that the
+// user was present.
+const webAuthnUserPresent = 0x01
+
+// webAuthnRPID matches the ID of a relying party, which is a domain name.
+var webAuthnRPID = regexp.MustCompile(`^[0-9a-zA-Z.\-]+$`)
+
+// webAuthnDigest returns the digest signed by a WebAuthn authenticator.
+func webAuthnDigest(authData, clientDataJSON []byte) []byte {
+	clientHash := sha256.Sum256(clientDataJSON)
+	h := sha256.New()
+	h.Write(authData)
+	h.Write(clientHash[:])
+	return h.Sum(nil)
+}
+
+// webAuthnOrigin returns nil if the origin is the relying party, or one of its
+// subdomains, over https.
+func webAuthnOrigin(origin, rpID string) error {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return xerrors.Errorf("parsing origin: %v", err)
+	}
+	host := u.Hostname()
+	if u.Scheme != "https" || (host != rpID && !strings.HasSuffix(host, "."+rpID)) {
+		return xerrors.Errorf("origin %s is not for the relying party", origin)
+	}
+	return nil
+}
+
+// Verify returns nil if the signature is correct, or an error if something
+// fails. The signature is a protobuf-encoded WebAuthnAssertion, whose
+// challenge must be the message and whose origin and authenticator data must
+// be for the relying party of the identity. The key must be a P-256 key and
+// the signature must have a low S, so that it cannot be malleated.
+func (id IdentityWebAuthn) Verify(msg, s []byte) error {
+	var assertion WebAuthnAssertion
+	if err := protobuf.Decode(s, &assertion); err != nil {
+		return xerrors.Errorf("decoding assertion: %v", err)
+	}
+
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(assertion.ClientDataJSON, &clientData); err != nil {
+		return xerrors.Errorf("decoding client data: %v", err)
+	}
+	if clientData.Type != "webauthn.get" {
+		return xerrors.Errorf("wrong client data type %s", clientData.Type)
+	}
+	challenge := strings.TrimRight(clientData.Challenge, "=")
+	if challenge != base64.RawURLEncoding.EncodeToString(msg) {
+		return errors.New("the challenge is not the message")
+	}
+	if err := webAuthnOrigin(clientData.Origin, id.RPID); err != nil {
+		return err
+	}
+
+	// The authenticator data starts with the hash of the relying party ID,
+	// followed by the flags and the signature counter.
+	authData := assertion.AuthenticatorData
+	if len(authData) < 37 {
+		return errors.New("authenticator data is too short")
+	}
+	rpHash := sha256.Sum256([]byte(id.RPID))
+	if id.RPID == "" || !bytes.Equal(authData[:32], rpHash[:]) {
+		return errors.New("the authenticator data is for another relying party")
+	}
+	if authData[32]&webAuthnUserPresent == 0 {
+		return errors.New("the user was not present")
+	}
+
+	public, err := x509.ParsePKIXPublicKey(id.Public)
+	if err != nil {
+		return err
+	}
+	ecPublic, ok := public.(*ecdsa.PublicKey)
+	if !ok || ecPublic.Curve != elliptic.P256() {
+		return errors.New("public key is not a P-256 key")
+	}
+	sig := &sigRS{}
+	rest, err := asn1.Unmarshal(assertion.Signature, sig)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errors.New("trailing bytes after the signature")
+	}
+	halfOrder := new(big.Int).Rsh(ecPublic.Params().N, 1)
+	if sig.S.Cmp(halfOrder) > 0 {
+		return errors.New("the signature has a high S")
+	}
+	digest := webAuthnDigest(authData, assertion.ClientDataJSON)
+	if ecdsa.Verify(ecPublic, digest, sig.R, sig.S) {
+		return nil
+	}
+	return errors.New("Wrong signature")
+}
+
 // ParseIdentity returns an Identity structure that matches
 // the given string.
 func ParseIdentity(in string) (Identity, error) {
@@ -1109,6 +1415,10 @@ func ParseIdentity(in string) (Identity, error) {
 		return parseIDProxy(fields[1])
 	case "evm_contract":
 		return parseIDEvmContract(fields[1])
+	case "secp256k1":
+		return parseIDSecp256k1(fields[1])
+	case "webauthn":
+		return parseIDWebAuthn(fields[1])
 	default:
 		return Identity{}, fmt.Errorf("unknown identity type %v", fields[0])
 	}
@@ -1180,6 +1490,30 @@ func parseIDEvmContract(in string) (Identity, error) {
 	}, nil
 }
 
+func parseIDSecp256k1(in string) (Identity, error) {
+	address, err := hex.DecodeString(strings.TrimPrefix(in, "0x"))
+	if err != nil {
+		return Identity{}, err
+	}
+	if len(address) != common.AddressLength {
+		return Identity{}, xerrors.Errorf("address must be %d bytes long",
+			common.AddressLength)
+	}
+	return NewIdentitySecp256k1(common.BytesToAddress(address)), nil
+}
+
+func parseIDWebAuthn(in string) (Identity, error) {
+	fields := strings.Split(in, ":")
+	if len(fields) != 2 || !webAuthnRPID.MatchString(fields[0]) {
+		return Identity{}, errors.New("need a relying party ID and a public key")
+	}
+	public, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return Identity{}, err
+	}
+	return NewIdentityWebAuthn(fields[0], public), nil
+}
+
 // NewSignerEd25519 initializes a new SignerEd25519 signer given public and
 // private keys. If either of the given keys is nil, then a new key pair is
 // generated.
@@ -1351,3 +1685,95 @@ func copyBytes(a []byte) []byte {
 	copy(b, a)
 	return b
 }
+
+// NewSignerSecp256k1 creates a new SignerSecp256k1 given a private key. If
+// the key is nil, a new one is generated.
+func NewSignerSecp256k1(private *ecdsa.PrivateKey) (Signer, error) {
+	if private == nil {
+		var err error
+		private, err = crypto.GenerateKey()
+		if err != nil {
+			return Signer{}, xerrors.Errorf("generating key: %v", err)
+		}
+	}
+	return Signer{Secp256k1: &SignerSecp256k1{
+		Address: crypto.PubkeyToAddress(private.PublicKey),
+		Secret:  crypto.FromECDSA(private),
+	}}, nil
+}
+
+// Sign creates a signature on the message like an Ethereum wallet.
+func (s SignerSecp256k1) Sign(msg []byte) ([]byte, error) {
+	private, err := crypto.ToECDSA(s.Secret)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding private key: %v", err)
+	}
+	sig, err := crypto.Sign(ethereumHash(msg), private)
+	if err != nil {
+		return nil, xerrors.Errorf("signing: %v", err)
+	}
+	sig[64] += 27
+	return sig, nil
+}
+
+// NewSignerWebAuthn creates a new SignerWebAuthn for the relying party rpID,
+// which behaves like an authenticator - mostly for tests.
+func NewSignerWebAuthn(rpID string) (Signer, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Signer{}, xerrors.Errorf("generating key: %v", err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return Signer{}, xerrors.Errorf("encoding public key: %v", err)
+	}
+	secret, err := x509.MarshalECPrivateKey(private)
+	if err != nil {
+		return Signer{}, xerrors.Errorf("encoding private key: %v", err)
+	}
+	return Signer{WebAuthn: &SignerWebAuthn{
+		Public: public,
+		RPID:   rpID,
+		secret: secret,
+	}}, nil
+}
+
+// Sign creates a WebAuthn assertion with the message as challenge.
+func (s SignerWebAuthn) Sign(msg []byte) ([]byte, error) {
+	if s.secret == nil {
+		return nil, errors.New("signer lacks a private key")
+	}
+	private, err := x509.ParseECPrivateKey(s.secret)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding private key: %v", err)
+	}
+	clientData, err := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": base64.RawURLEncoding.EncodeToString(msg),
+		"origin":    "https://" + s.RPID,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("encoding client data: %v", err)
+	}
+	rpHash := sha256.Sum256([]byte(s.RPID))
+	authData := append(rpHash[:], webAuthnUserPresent, 0, 0, 0, 0)
+
+	r, ss, err := ecdsa.Sign(rand.Reader, private, webAuthnDigest(authData, clientData))
+	if err != nil {
+		return nil, xerrors.Errorf("signing: %v", err)
+	}
+	// The verifiers only accept the low S.
+	n := private.Params().N
+	if ss.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		ss.Sub(n, ss)
+	}
+	sig, err := asn1.Marshal(sigRS{R: r, S: ss})
+	if err != nil {
+		return nil, xerrors.Errorf("encoding signature: %v", err)
+	}
+	return protobuf.Encode(&WebAuthnAssertion{
+		AuthenticatorData: authData,
+		ClientDataJSON:    clientData,
+		Signature:         sig,
+	})
+}
@@ -1,14 +1,25 @@
 package darc
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"net/url"
 	"strings"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/protobuf"
 )
 
 func TestRules(t *testing.T) {
@@ -446,10 +457,269 @@ func TestDarc_DelegationDiamond(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestDarc_DelegationThreshold(t *testing.T) {
+	n := 3
+	darcs := make([]*Darc, n)
+	darcIDs := make([]string, n)
+	identityStrs := make([]string, n)
+	for i := 0; i < n; i++ {
+		// The sign rule is evaluated for the delegated darcs.
+		id := createIdentity()
+		darcs[i] = NewDarc(InitRules([]Identity{id}, []Identity{id}),
+			[]byte("test threshold"))
+		darcIDs[i] = darcs[i].GetIdentityString()
+		identityStrs[i] = id.String()
+	}
+	getDarc := DarcsToGetDarcs(darcs)
+	expr := expression.InitThresholdExpr(2, darcIDs...)
+
+	// two of the delegated darcs are enough
+	require.NoError(t, EvalExpr(expr, getDarc, identityStrs[0], identityStrs[2]))
+	require.Error(t, EvalExpr(expr, getDarc, identityStrs[1]))
+
+	// a missing darc counts as a false factor
+	getDarc = DarcsToGetDarcs(darcs[:2])
+	require.NoError(t, EvalExpr(expr, getDarc, identityStrs[0], identityStrs[1]))
+	require.Error(t, EvalExpr(expr, getDarc, identityStrs[0], identityStrs[2]))
+}
+
+func TestDarc_DelegationID(t *testing.T) {
+	owner := NewSignerEd25519(nil, nil)
+	delegate := NewSignerEd25519(nil, nil)
+	delegation := "delegation:0102"
+	getDarc := func(s string, latest bool) *Darc {
+		if s != delegation {
+			return nil
+		}
+		ids := []Identity{delegate.Identity()}
+		return NewDarc(InitRules(ids, ids), []byte("delegation"))
+	}
+	expr := expression.InitOrExpr(owner.Identity().String(), delegation)
+	require.NoError(t, EvalExpr(expr, getDarc, owner.Identity().String()))
+	require.NoError(t, EvalExpr(expr, getDarc, delegate.Identity().String()))
+	require.Error(t, EvalExpr(expr, getDarc, delegation))
+
+	// an invalid delegation is refused
+	expr = expression.InitOrExpr(owner.Identity().String(), "delegation:03")
+	err := EvalExpr(expr, getDarc, delegate.Identity().String())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not valid")
+}
+
+func TestDarc_Explain(t *testing.T) {
+	td := createDarc(1, "test explain")
+	evolved := td.darc.Copy()
+	d0 := td.darc.GetIdentityString()
+	id0 := td.ids[0].String()
+	require.NoError(t, evolved.Rules.UpdateSign([]byte(d0+" | "+id0)))
+	require.NoError(t, localEvolution(evolved, td.darc, td.owners[0]))
+	getDarc := DarcsToGetDarcs([]*Darc{evolved})
+
+	other := createIdentity().String()
+	steps, err := ExplainExpr(expression.Expr(d0+" & "+other), getDarc, nil, id0)
+	require.NoError(t, err)
+	require.Equal(t, []ExplainStep{
+		{Depth: 0, Expr: d0 + " & " + other},
+		{Depth: 1, Expr: d0, Satisfied: true},
+		{Depth: 2, Expr: d0 + " | " + id0, Satisfied: true},
+		{Depth: 3, Expr: d0, Reason: "cycle detected"},
+		{Depth: 3, Expr: id0, Satisfied: true},
+		{Depth: 1, Expr: other, Reason: "not one of the signers"},
+	}, steps)
+
+	// The explanation agrees with the evaluation.
+	require.Error(t, EvalExpr(expression.Expr(d0+" & "+other), getDarc, id0))
+	steps, err = ExplainExpr(expression.Expr(d0+" & "+other), getDarc, nil, id0, other)
+	require.NoError(t, err)
+	require.True(t, steps[0].Satisfied)
+	require.NoError(t, EvalExpr(expression.Expr(d0+" & "+other), getDarc, id0, other))
+
+	steps, err = ExplainExpr(expression.Expr("attr:abc:x"), getDarc, nil)
+	require.NoError(t, err)
+	require.Equal(t, "no such attr interpreter: abc", steps[0].Reason)
+
+	_, err = ExplainExpr(expression.Expr(d0+" &"), getDarc, nil, id0)
+	require.Error(t, err)
+}
+
 func TestDarc_X509(t *testing.T) {
 	// TODO
 }
 
+func TestDarc_Secp256k1(t *testing.T) {
+	signer, err := NewSignerSecp256k1(nil)
+	require.NoError(t, err)
+	id := signer.Identity()
+	require.Equal(t, 5, id.Type())
+	parsed, err := ParseIdentity(id.String())
+	require.NoError(t, err)
+	require.True(t, id.Equal(&parsed))
+
+	msg := []byte("darc")
+	sig, err := signer.Sign(msg)
+	require.NoError(t, err)
+	require.NoError(t, id.Verify(msg, sig))
+	require.Error(t, id.Verify([]byte("other"), sig))
+
+	// The recovery ID must be 27 or 28.
+	sig[64] -= 27
+	require.Error(t, id.Verify(msg, sig))
+	sig[64] += 27 + 4
+	require.Error(t, id.Verify(msg, sig))
+	sig[64] -= 4
+	require.NoError(t, id.Verify(msg, sig))
+	require.Error(t, id.Verify(msg, sig[:64]))
+
+	// The signature with S flipped to N - S and the other recovery ID
+	// recovers the same key, but must be refused.
+	n := crypto.S256().Params().N
+	flipped := append([]byte{}, sig...)
+	highS := new(big.Int).Sub(n, new(big.Int).SetBytes(sig[32:64]))
+	highS.FillBytes(flipped[32:64])
+	flipped[64] ^= 27 ^ 28
+	require.Error(t, id.Verify(msg, flipped))
+
+	other, err := NewSignerSecp256k1(nil)
+	require.NoError(t, err)
+	require.Error(t, other.Identity().Verify(msg, sig))
+
+	d := NewDarc(InitRules([]Identity{id}, []Identity{id}), []byte("secp256k1"))
+	d2 := d.Copy()
+	require.NoError(t, d2.EvolveFrom(d))
+	r, _, err := d2.MakeEvolveRequest(signer)
+	require.NoError(t, err)
+	require.NoError(t, r.Verify(d))
+}
+
+func TestDarc_WebAuthn(t *testing.T) {
+	signer, err := NewSignerWebAuthn("example.com")
+	require.NoError(t, err)
+	id := signer.Identity()
+	require.Equal(t, 6, id.Type())
+	parsed, err := ParseIdentity(id.String())
+	require.NoError(t, err)
+	require.True(t, id.Equal(&parsed))
+
+	msg := []byte("darc")
+	sig, err := signer.Sign(msg)
+	require.NoError(t, err)
+	require.NoError(t, id.Verify(msg, sig))
+	require.Error(t, id.Verify([]byte("other"), sig))
+
+	var assertion WebAuthnAssertion
+	require.NoError(t, protobuf.Decode(sig, &assertion))
+	sigAssertion := func() []byte {
+		buf, err := protobuf.Encode(&assertion)
+		require.NoError(t, err)
+		return buf
+	}
+	assertion.AuthenticatorData[32] = 0
+	require.Error(t, id.Verify(msg, sigAssertion()))
+	assertion.AuthenticatorData[32] = webAuthnUserPresent
+	require.NoError(t, id.Verify(msg, sigAssertion()))
+	assertion.AuthenticatorData = assertion.AuthenticatorData[:36]
+	require.Error(t, id.Verify(msg, sigAssertion()))
+
+	// The credential only signs for its relying party.
+	other := NewIdentityWebAuthn("example.org", id.WebAuthn.Public)
+	require.False(t, id.Equal(&other))
+	require.Error(t, other.Verify(msg, sig))
+
+	// Without its private key, the signer cannot sign anymore.
+	buf, err := protobuf.Encode(&signer)
+	require.NoError(t, err)
+	var loaded Signer
+	require.NoError(t, protobuf.Decode(buf, &loaded))
+	loadedID := loaded.Identity()
+	require.True(t, id.Equal(&loadedID))
+	_, err = loaded.Sign(msg)
+	require.Error(t, err)
+}
+
+// signWebAuthn returns the assertion of the message for the relying party,
+// with the given origin in the client data.
+func signWebAuthn(t *testing.T, private *ecdsa.PrivateKey, rpID, origin string,
+	msg []byte) []byte {
+	clientData, err := json.Marshal(map[string]string{
+		"type":      "webauthn.get",
+		"challenge": base64.RawURLEncoding.EncodeToString(msg),
+		"origin":    origin,
+	})
+	require.NoError(t, err)
+	rpHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpHash[:], webAuthnUserPresent, 0, 0, 0, 0)
+	r, s, err := ecdsa.Sign(rand.Reader, private, webAuthnDigest(authData, clientData))
+	require.NoError(t, err)
+	if s.Cmp(new(big.Int).Rsh(private.Params().N, 1)) > 0 {
+		s.Sub(private.Params().N, s)
+	}
+	sig, err := asn1.Marshal(sigRS{R: r, S: s})
+	require.NoError(t, err)
+	buf, err := protobuf.Encode(&WebAuthnAssertion{
+		AuthenticatorData: authData,
+		ClientDataJSON:    clientData,
+		Signature:         sig,
+	})
+	require.NoError(t, err)
+	return buf
+}
+
+func TestDarc_WebAuthnRejections(t *testing.T) {
+	signer, err := NewSignerWebAuthn("example.com")
+	require.NoError(t, err)
+	id := signer.Identity()
+	private, err := x509.ParseECPrivateKey(signer.WebAuthn.secret)
+	require.NoError(t, err)
+	msg := []byte("darc")
+	sig := signWebAuthn(t, private, "example.com", "https://example.com", msg)
+	require.NoError(t, id.Verify(msg, sig))
+
+	// Trailing bytes after the signature.
+	var assertion WebAuthnAssertion
+	require.NoError(t, protobuf.Decode(sig, &assertion))
+	rs := sigRS{}
+	_, err = asn1.Unmarshal(assertion.Signature, &rs)
+	require.NoError(t, err)
+	assertion.Signature = append(assertion.Signature, 0)
+	buf, err := protobuf.Encode(&assertion)
+	require.NoError(t, err)
+	err = id.Verify(msg, buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "trailing bytes")
+
+	// The high S of the same signature is valid for ECDSA, but malleable.
+	rs.S.Sub(private.Params().N, rs.S)
+	assertion.Signature, err = asn1.Marshal(rs)
+	require.NoError(t, err)
+	buf, err = protobuf.Encode(&assertion)
+	require.NoError(t, err)
+	err = id.Verify(msg, buf)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "high S")
+
+	// The origin must be the relying party or one of its subdomains, over
+	// https.
+	for _, origin := range []string{"https://example.org", "http://example.com",
+		"https://notexample.com", "example.com"} {
+		err = id.Verify(msg, signWebAuthn(t, private, "example.com", origin, msg))
+		require.Error(t, err, origin)
+		require.Contains(t, err.Error(), "origin", origin)
+	}
+	require.NoError(t, id.Verify(msg, signWebAuthn(t, private, "example.com",
+		"https://login.example.com:8443", msg)))
+
+	// The key must be a P-256 key.
+	private384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	public384, err := x509.MarshalPKIXPublicKey(&private384.PublicKey)
+	require.NoError(t, err)
+	id384 := NewIdentityWebAuthn("example.com", public384)
+	err = id384.Verify(msg, signWebAuthn(t, private384, "example.com",
+		"https://example.com", msg))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "P-256")
+}
+
 func TestDarc_IsSubset(t *testing.T) {
 	expr := []byte(createIdentity().String())
 	supersetRules := NewRules()
@@ -640,4 +910,28 @@ func TestParseIdentity(t *testing.T) {
 	require.NotNil(t, i.EvmContract)
 	// ToLower() because common.Address uses address checksum (EIP-55)
 	require.Equal(t, in, strings.ToLower(i.String()))
+
+	in = "secp256k1:0011"
+	i, err = ParseIdentity(in)
+	require.Error(t, err)
+
+	in = "secp256k1:00112233445566778899aabbccddeeff00112233"
+	i, err = ParseIdentity(in)
+	require.NoError(t, err)
+	require.NotNil(t, i.Secp256k1)
+	require.Equal(t, in, i.String())
+
+	in = "webauthn:xxx"
+	i, err = ParseIdentity(in)
+	require.Error(t, err)
+
+	in = "webauthn:010203"
+	i, err = ParseIdentity(in)
+	require.Error(t, err)
+
+	in = "webauthn:example.com:010203"
+	i, err = ParseIdentity(in)
+	require.NoError(t, err)
+	require.NotNil(t, i.WebAuthn)
+	require.Equal(t, in, i.String())
 }
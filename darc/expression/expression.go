@@ -5,8 +5,10 @@ the syntax we use is from: https://en.wikipedia.org/wiki/Extended_Backus%E2%80%9
 
 	expr = term, [ '&', term ]*
 	term = factor, [ '|', factor ]*
-	factor = '(', expr, ')' | id | openid
-	identity = (darc|ed25519|x509ec):[0-9a-fA-F]+
+	factor = '(', expr, ')' | id | openid | thexpr
+	thexpr = '[', factor, [ ',', factor ]*, ']', '/', digit+
+	identity = (darc|ed25519|x509ec|secp256k1|delegation):[0-9a-fA-F]+
+	webauthn = webauthn:[0-9a-zA-Z.\-]+:[0-9a-fA-F]+
 	proxy = proxy:[0-9a-fA-F]+:[^ \n\t]*
 	evm_identity = evm_contract:[0-9a-fA-F]+:0x[0-9a-fA-F]+
 	attr = attr:[0-9a-zA-Z\-\_]+:[^ \n\t]*
@@ -16,7 +18,9 @@ Examples:
 	ed25519:deadbeef // every id evaluates to a boolean
 	(ed25519:a & x509ec:b) | (darc:c & ed25519:d)
 	proxy:deadbeef:me@example.com // where deadbeef is a ed25519 public key
+	secp256k1:deadbeef | webauthn:example.com:deadbeef // an Ethereum address or a WebAuthn key
 	attr:time_interval:before=5pm&after=9am & ed25519:deadbeef
+	[ed25519:a, ed25519:b, (darc:c & ed25519:d)]/2
 
 In the simplest case, the evaluation of an expression is performed against a
 set of valid ids.  Suppose we have the expression (a:a & b:b) | (c:c & d:d),
@@ -25,15 +29,20 @@ true.  If the set of valid ids is [a:a, c:c], then the expression will evaluate
 to false. However, the user is able to provide a ValueCheckFn to customise how
 the expressions are evaluated.
 
-EXTENSION - NOT YET IMPLEMENTED:
-To support threshold signatures, we extend the syntax to include the following.
-	thexpr = '[', id, [ ',', id ]*, ']', '/', digit
+A threshold expression [f1, f2, ..., fn]/m evaluates to true if at least m of
+its n factors evaluate to true, where 1 <= m <= n. The same factor cannot be
+listed twice, even in parentheses or with its operands in another order. Like
+before the other operators, a proxy or attr id must be
+followed by a space in a threshold expression, because its data would
+include the separators.
 */
 package expression
 
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	parsec "github.com/prataprc/goparsec"
@@ -53,15 +62,33 @@ type Expr []byte
 
 // InitParser creates the root parser
 func InitParser(fn ValueCheckFn) parsec.Parser {
+	Y := initTraceParser(fn)
+	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
+		n, s := Y(s)
+		if t, ok := n.(*Trace); ok {
+			return t.Value, s
+		}
+		return n, s
+	}
+}
+
+// initTraceParser creates the root parser, which returns the Trace of the
+// expression.
+func initTraceParser(fn ValueCheckFn) parsec.Parser {
 	// Y is root Parser, usually called as `s` in CFG theory.
 	var Y parsec.Parser
-	var sum, value parsec.Parser // circular rats
+	var sum, value, threshold parsec.Parser // circular rats
 
 	// Terminal rats
 	var openparan = parsec.Token(`\(`, "OPENPARAN")
 	var closeparan = parsec.Token(`\)`, "CLOSEPARAN")
 	var andop = parsec.Token(`&`, "AND")
 	var orop = parsec.Token(`\|`, "OR")
+	var openbracket = parsec.Token(`\[`, "OPENBRACKET")
+	var closebracket = parsec.Token(`\]`, "CLOSEBRACKET")
+	var comma = parsec.Token(`,`, "COMMA")
+	var slash = parsec.Token(`/`, "SLASH")
+	var number = parsec.Token(`[0-9]+`, "NUMBER")
 
 	// NonTerminal rats
 	// sumOp -> "&" |  "|"
@@ -73,12 +100,18 @@ func InitParser(fn ValueCheckFn) parsec.Parser {
 	// (andop prod)*
 	var prodK = parsec.Kleene(nil, parsec.And(many2many, sumOp, &value), nil)
 
+	// ("," value)*
+	var valueK = parsec.Kleene(nil, parsec.And(many2many, comma, &value), nil)
+
 	// Circular rats come to life
 	// sum -> prod (andop prod)*
-	sum = parsec.And(sumNode(fn), &value, prodK)
-	// value -> id | "(" expr ")"
+	sum = parsec.And(sumNode, &value, prodK)
+	// value -> id | "(" expr ")" | threshold
 	value = parsec.OrdChoice(exprValueNode(fn), identity(), proxy(),
-		evmIdentity(), attr(), groupExpr)
+		evmIdentity(), webAuthnIdentity(), attr(), groupExpr, &threshold)
+	// threshold -> "[" value ("," value)* "]" "/" number
+	threshold = parsec.And(thresholdNode, openbracket, &value, valueK,
+		closebracket, slash, number)
 	// expr  -> sum
 	Y = parsec.OrdChoice(one2one, sum)
 	return Y
@@ -101,6 +134,136 @@ func Evaluate(parser parsec.Parser, expr Expr) (bool, error) {
 	return vv, nil
 }
 
+// TraceOp is the operator of a node of a Trace.
+type TraceOp int
+
+const (
+	// IDOp is an id, the leaf of a trace.
+	IDOp TraceOp = iota
+	// AndOp is true if all its children are true.
+	AndOp
+	// OrOp is true if one of its children is true.
+	OrOp
+	// ThresholdOp is true if at least Threshold of its children are true.
+	ThresholdOp
+)
+
+// Trace is the evaluation of an expression or of one of its parts. It tells
+// which parts of an expression were satisfied.
+type Trace struct {
+	Op TraceOp
+	// ID is the id of an IDOp.
+	ID string
+	// Threshold is the minimum number of true children of a ThresholdOp.
+	Threshold int
+	Value     bool
+	Children  []*Trace
+	// grouped is true if the part is in parentheses, so that it is not
+	// merged with the operator around it.
+	grouped bool
+}
+
+// String returns the part of the expression of the trace. Its compound parts
+// are always put in parentheses.
+func (t *Trace) String() string {
+	children := make([]string, len(t.Children))
+	for i, c := range t.Children {
+		children[i] = c.String()
+		if c.Op == AndOp || c.Op == OrOp {
+			children[i] = "(" + children[i] + ")"
+		}
+	}
+	switch t.Op {
+	case AndOp:
+		return strings.Join(children, " & ")
+	case OrOp:
+		return strings.Join(children, " | ")
+	case ThresholdOp:
+		return fmt.Sprintf("[%s]/%d", strings.Join(children, ", "), t.Threshold)
+	}
+	return t.ID
+}
+
+// canonical returns the same string for the equivalent parts of an
+// expression: the parentheses are dropped, and the operands of an operator
+// are merged with the ones of the same operator below it, sorted and kept
+// once.
+func (t *Trace) canonical() string {
+	switch t.Op {
+	case IDOp:
+		return t.ID
+	case ThresholdOp:
+		children := make([]string, len(t.Children))
+		for i, c := range t.Children {
+			children[i] = c.canonical()
+		}
+		sort.Strings(children)
+		return fmt.Sprintf("[%s]/%d", strings.Join(children, ","), t.Threshold)
+	}
+	operands := make(map[string]bool)
+	var merge func(*Trace)
+	merge = func(c *Trace) {
+		if c.Op == t.Op {
+			for _, cc := range c.Children {
+				merge(cc)
+			}
+			return
+		}
+		operands[c.canonical()] = true
+	}
+	merge(t)
+	children := make([]string, 0, len(operands))
+	for c := range operands {
+		children = append(children, c)
+	}
+	if len(children) == 1 {
+		return children[0]
+	}
+	sort.Strings(children)
+	if t.Op == AndOp {
+		return "(" + strings.Join(children, "&") + ")"
+	}
+	return "(" + strings.Join(children, "|") + ")"
+}
+
+// Explain evaluates the expression expr like Evaluate, and returns the trace
+// of its evaluation.
+func Explain(expr Expr, fn ValueCheckFn) (*Trace, error) {
+	v, s := initTraceParser(fn)(parsec.NewScanner(expr))
+	_, s = s.SkipWS()
+	if !s.Endof() {
+		rest, _ := s.Match(".*")
+		return nil, fmt.Errorf("%v: (rest = %v)", errScannerNotEmpty, string(rest))
+	}
+	t, ok := v.(*Trace)
+	if !ok {
+		return nil, errFailedToCast
+	}
+	return t, nil
+}
+
+// HasThreshold returns true if the expression expr holds a threshold
+// expression. An expression that cannot be parsed has none.
+func HasThreshold(expr Expr) bool {
+	t, err := Explain(expr, func(string) bool { return false })
+	if err != nil {
+		return false
+	}
+	var walk func(t *Trace) bool
+	walk = func(t *Trace) bool {
+		if t.Op == ThresholdOp {
+			return true
+		}
+		for _, c := range t.Children {
+			if walk(c) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(t)
+}
+
 // DefaultParser creates a parser and evaluates the expression expr, every id
 // in pks will evaluate to true.
 func DefaultParser(expr Expr, ids ...string) (bool, error) {
@@ -126,11 +289,17 @@ func InitOrExpr(ids ...string) Expr {
 	return Expr(strings.Join(ids, " | "))
 }
 
+// InitThresholdExpr creates an expression that is true if at least m of the
+// IDs are valid.
+func InitThresholdExpr(m int, ids ...string) Expr {
+	return Expr(fmt.Sprintf("[%s]/%d", strings.Join(ids, ", "), m))
+}
+
 // Accepts tokens of the form "identity_type:HEX"
 func identity() parsec.Parser {
 	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
 		_, s = s.SkipAny(`^[ \n\t]+`)
-		p := parsec.Token(`(darc|ed25519|x509ec):[0-9a-fA-F]+`, "HEX")
+		p := parsec.Token(`(darc|ed25519|x509ec|secp256k1|delegation):[0-9a-fA-F]+`, "HEX")
 		return p(s)
 	}
 }
@@ -152,6 +321,15 @@ func evmIdentity() parsec.Parser {
 	}
 }
 
+// Accepts tokens of the form "webauthn:rp_id:HEX"
+func webAuthnIdentity() parsec.Parser {
+	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
+		_, s = s.SkipAny(`^[ \n\t]+`)
+		p := parsec.Token(`webauthn:[0-9a-zA-Z.\-]+:[0-9a-fA-F]+`, "WEBAUTHN")
+		return p(s)
+	}
+}
+
 // Accepts tokens of the form that begins with "attr:"
 func attr() parsec.Parser {
 	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
@@ -161,24 +339,31 @@ func attr() parsec.Parser {
 	}
 }
 
-func sumNode(fn ValueCheckFn) func(ns []parsec.ParsecNode) parsec.ParsecNode {
-	return func(ns []parsec.ParsecNode) parsec.ParsecNode {
-		if len(ns) > 0 {
-			val := ns[0].(bool)
-			for _, x := range ns[1].([]parsec.ParsecNode) {
-				y := x.([]parsec.ParsecNode)
-				n := y[1].(bool)
-				switch y[0].(*parsec.Terminal).Name {
-				case "AND":
-					val = val && n
-				case "OR":
-					val = val || n
-				}
-			}
-			return val
-		}
+func sumNode(ns []parsec.ParsecNode) parsec.ParsecNode {
+	if len(ns) == 0 {
 		return nil
 	}
+	// The operators have the same precedence and are applied from left
+	// to right.
+	t := ns[0].(*Trace)
+	for _, x := range ns[1].([]parsec.ParsecNode) {
+		y := x.([]parsec.ParsecNode)
+		n := y[1].(*Trace)
+		op := AndOp
+		if y[0].(*parsec.Terminal).Name == "OR" {
+			op = OrOp
+		}
+		val := t.Value && n.Value
+		if op == OrOp {
+			val = t.Value || n.Value
+		}
+		if t.Op == op && !t.grouped {
+			t = &Trace{Op: op, Value: val, Children: append(t.Children[:len(t.Children):len(t.Children)], n)}
+		} else {
+			t = &Trace{Op: op, Value: val, Children: []*Trace{t, n}}
+		}
+	}
+	return t
 }
 
 func exprValueNode(fn ValueCheckFn) func(ns []parsec.ParsecNode) parsec.ParsecNode {
@@ -186,17 +371,50 @@ func exprValueNode(fn ValueCheckFn) func(ns []parsec.ParsecNode) parsec.ParsecNo
 		if len(ns) == 0 {
 			return nil
 		} else if term, ok := ns[0].(*parsec.Terminal); ok {
-			return fn(term.Value)
+			return &Trace{Op: IDOp, ID: term.Value, Value: fn(term.Value)}
 		}
 		return ns[0]
 	}
 }
 
+// thresholdNode counts the factors that are true. It fails if the threshold
+// is not between one and the number of factors, or if a factor is repeated.
+func thresholdNode(ns []parsec.ParsecNode) parsec.ParsecNode {
+	if len(ns) != 6 {
+		return nil
+	}
+	items := []*Trace{ns[1].(*Trace)}
+	for _, x := range ns[2].([]parsec.ParsecNode) {
+		items = append(items, x.([]parsec.ParsecNode)[1].(*Trace))
+	}
+	m, err := strconv.Atoi(ns[5].(*parsec.Terminal).Value)
+	if err != nil || m < 1 || m > len(items) {
+		return nil
+	}
+
+	factors := make(map[string]bool)
+	var count int
+	for _, item := range items {
+		c := item.canonical()
+		if factors[c] {
+			return nil
+		}
+		factors[c] = true
+		if item.Value {
+			count++
+		}
+	}
+	return &Trace{Op: ThresholdOp, Threshold: m, Value: count >= m, Children: items}
+}
+
 func exprNode(ns []parsec.ParsecNode) parsec.ParsecNode {
 	if len(ns) == 0 {
 		return nil
 	}
-	return ns[1]
+	// The group is kept as a child of the operators around it.
+	t := *ns[1].(*Trace)
+	t.grouped = true
+	return &t
 }
 
 func one2one(ns []parsec.ParsecNode) parsec.ParsecNode {
@@ -180,6 +180,16 @@ func TestParsing_RealIDs(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	expr = []byte("secp256k1:5764e85642c3bda8748c5cf3d7f14c6d5c18e193 | webauthn:example.com:3059301306072a8648ce3d0201")
+	_, err = Evaluate(InitParser(trueFn), expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr = []byte("delegation:5764e85642c3bda8748c5cf3d7f14c6d5c18e193228d70f4c58dd80ed4582748")
+	_, err = Evaluate(InitParser(trueFn), expr)
+	if err != nil {
+		t.Fatal(err)
+	}
 }
 
 func TestParsing_Attr(t *testing.T) {
@@ -262,3 +272,118 @@ func TestEval_DefaultParser(t *testing.T) {
 		t.Fatal("evaluation should return false")
 	}
 }
+
+func TestParsing_Threshold(t *testing.T) {
+	keys := []string{"ed25519:a", "ed25519:b", "darc:c"}
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"[ed25519:a, ed25519:b, x509ec:e]/2", true},
+		{"[ed25519:a, x509ec:e, x509ec:f]/2", false},
+		{"[ed25519:a,ed25519:b]/2", true},
+		{"[ed25519:a]/1", true},
+		{"[x509ec:e, (ed25519:a & darc:c)]/1", true},
+		{"[x509ec:e, (ed25519:a & x509ec:f)]/1", false},
+		{"[[ed25519:a, x509ec:e]/1, [x509ec:f, darc:c]/2]/1", true},
+		{"[ed25519:a, x509ec:e]/2 | darc:c", true},
+		{"x509ec:e | [ed25519:a, ed25519:b]/2 & darc:c", true},
+		{"[attr:abc:x=1 , ed25519:a]/1", true},
+		{"[ed25519:a, (ed25519:a & darc:c)]/2", true},
+		{"[(ed25519:a & darc:c), (ed25519:a | darc:c)]/2", true},
+	}
+	for _, test := range tests {
+		ok, err := DefaultParser(Expr(test.expr), keys...)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		if ok != test.ok {
+			t.Fatalf("%s: evaluation should return %v", test.expr, test.ok)
+		}
+	}
+
+	invalid := []string{
+		"[ed25519:a, ed25519:b]/3",
+		"[ed25519:a, ed25519:b]/0",
+		"[ed25519:a, ed25519:a]/2",
+		"[ed25519:a, (ed25519:a)]/2",
+		"[((ed25519:a)), ed25519:b]/1 & [ed25519:a, ((ed25519:a))]/1",
+		"[(ed25519:a & ed25519:b), (ed25519:b & ed25519:a)]/1",
+		"[ed25519:a, (ed25519:a | ed25519:a)]/1",
+		"[(ed25519:a & (ed25519:b & darc:c)), (darc:c & ed25519:a & ed25519:b)]/2",
+		"[[ed25519:a, ed25519:b]/1, [ed25519:b, ed25519:a]/1]/1",
+		"[ed25519:a, ed25519:b]",
+		"[ed25519:a, ed25519:b]/",
+		"[]/1",
+		"[ed25519:a ed25519:b]/1",
+	}
+	for _, expr := range invalid {
+		if _, err := DefaultParser(Expr(expr), keys...); err == nil {
+			t.Fatalf("%s: parsing should fail", expr)
+		}
+	}
+
+	expr := InitThresholdExpr(2, keys...)
+	if string(expr) != "[ed25519:a, ed25519:b, darc:c]/2" {
+		t.Fatalf("wrong threshold expression %s", expr)
+	}
+}
+
+func TestHasThreshold(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"ed25519:a & darc:c", false},
+		{"[ed25519:a, ed25519:b]/1", true},
+		{"ed25519:a | (darc:c & [ed25519:a, ed25519:b]/2)", true},
+		{"attr:abc:[x]/1", false},
+		{"[ed25519:a, ed25519:b]/3", false},
+	}
+	for _, test := range tests {
+		if HasThreshold(Expr(test.expr)) != test.ok {
+			t.Fatalf("%s: HasThreshold should return %v", test.expr, test.ok)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	fn := func(s string) bool {
+		return s == "ed25519:a" || s == "darc:c"
+	}
+	trace, err := Explain(Expr("ed25519:a & x509ec:b | [darc:c, (ed25519:d & ed25519:e)]/1"), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The operators are applied from left to right.
+	if trace.Op != OrOp || !trace.Value || len(trace.Children) != 2 {
+		t.Fatalf("wrong root %+v", trace)
+	}
+	and := trace.Children[0]
+	if and.Op != AndOp || and.Value || and.String() != "ed25519:a & x509ec:b" {
+		t.Fatalf("wrong and %+v", and)
+	}
+	if !and.Children[0].Value || and.Children[1].Value || and.Children[1].ID != "x509ec:b" {
+		t.Fatal("wrong ids")
+	}
+	th := trace.Children[1]
+	if th.Op != ThresholdOp || th.Threshold != 1 || !th.Value || len(th.Children) != 2 {
+		t.Fatalf("wrong threshold %+v", th)
+	}
+	if th.String() != "[darc:c, (ed25519:d & ed25519:e)]/1" {
+		t.Fatalf("wrong string %s", th.String())
+	}
+
+	// The same operators are merged, except in parentheses.
+	trace, err = Explain(Expr("ed25519:a & x509ec:b & (darc:c & ed25519:d)"), fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Children) != 3 || trace.String() != "ed25519:a & x509ec:b & (darc:c & ed25519:d)" {
+		t.Fatalf("wrong trace %s", trace)
+	}
+
+	if _, err := Explain(Expr("ed25519:a &"), fn); err == nil {
+		t.Fatal("invalid expression should fail")
+	}
+}
@@ -69,6 +69,10 @@ type Identity struct {
 	Proxy *IdentityProxy
 	// Address of an EVM contract
 	EvmContract *IdentityEvmContract
+	// Ethereum address of a secp256k1 key.
+	Secp256k1 *IdentitySecp256k1
+	// Public key of a WebAuthn authenticator.
+	WebAuthn *IdentityWebAuthn
 }
 
 // IdentityEd25519 holds a Ed25519 public key (Point)
@@ -101,6 +105,27 @@ type IdentityEvmContract struct {
 	Address common.Address
 }
 
+// IdentitySecp256k1 holds the Ethereum address of a secp256k1 public key.
+type IdentitySecp256k1 struct {
+	Address common.Address
+}
+
+// IdentityWebAuthn holds the public key of a WebAuthn credential, as an ECDSA
+// P-256 key in the DER-encoded PKIX format, and the ID of the relying party
+// the credential is scoped to.
+type IdentityWebAuthn struct {
+	Public []byte
+	RPID   string
+}
+
+// WebAuthnAssertion is the signature of a WebAuthn identity: the data
+// returned by the authenticator for a challenge equal to the signed message.
+type WebAuthnAssertion struct {
+	AuthenticatorData []byte
+	ClientDataJSON    []byte
+	Signature         []byte
+}
+
 // Signature is a signature on a Darc to accept a given decision.
 // can be verified using the appropriate identity.
 type Signature struct {
@@ -116,6 +141,8 @@ type Signer struct {
 	X509EC      *SignerX509EC
 	Proxy       *SignerProxy
 	EvmContract *SignerEvmContract
+	Secp256k1   *SignerSecp256k1
+	WebAuthn    *SignerWebAuthn
 }
 
 // SignerEd25519 holds a public and private keys necessary to sign Darcs
@@ -145,6 +172,21 @@ type SignerEvmContract struct {
 	Address common.Address
 }
 
+// SignerSecp256k1 holds a secp256k1 key pair, which signs messages like an
+// Ethereum wallet.
+type SignerSecp256k1 struct {
+	Address common.Address
+	Secret  []byte
+}
+
+// SignerWebAuthn holds the key pair of a software WebAuthn authenticator,
+// but the private key will not be given out.
+type SignerWebAuthn struct {
+	Public []byte
+	RPID   string
+	secret []byte
+}
+
 // Request is the structure that the client must provide to be verified
 type Request struct {
 	BaseID     ID
@@ -164,3 +206,17 @@ type Rule struct {
 	Action Action
 	Expr   expression.Expr
 }
+
+// ExplainStep is one step of the evaluation of an expression, as returned by
+// ExplainExpr.
+type ExplainStep struct {
+	// Depth is the nesting level of the step. The parent of a step is the
+	// previous one with a smaller depth.
+	Depth int
+	// Expr is the part of the expression, or the id, that is evaluated.
+	Expr string
+	// Satisfied is true if this part of the expression is true.
+	Satisfied bool
+	// Reason tells why an id is not satisfied.
+	Reason string `protobuf:"opt"`
+}
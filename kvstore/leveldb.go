@@ -0,0 +1,193 @@
+package kvstore
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/xerrors"
+)
+
+// levelDB is the DB implementation for LevelDB, a log-structured merge tree.
+// All the keys are stored with a prefix, so that many stores can share the
+// same database. The transactions are serialized like in boltdb, but a
+// transaction only writes a batch to the log when it is committed instead of
+// copying the modified pages of a B+tree.
+type levelDB struct {
+	db     *leveldb.DB
+	prefix []byte
+	// writer serializes the Update transactions, so that every transaction
+	// sees the writes of the previous ones.
+	writer sync.Mutex
+}
+
+// NewLevelDB creates a new LevelDB-backed database whose keys are stored with
+// the given prefix. The LevelDB database is not closed by Close, because it
+// can be shared.
+func NewLevelDB(db *leveldb.DB, prefix []byte) DB {
+	return &levelDB{
+		db:     db,
+		prefix: clone(prefix),
+	}
+}
+
+func (r *levelDB) Update(f func(Bucket) error) error {
+	r.writer.Lock()
+	defer r.writer.Unlock()
+	b, err := r.newBucket(true)
+	if err != nil {
+		return err
+	}
+	defer b.snap.Release()
+	if err := b.check(f(b)); err != nil {
+		return err
+	}
+	return r.db.Write(b.batch, nil)
+}
+
+func (r *levelDB) View(f func(Bucket) error) error {
+	b, err := r.newBucket(false)
+	if err != nil {
+		return err
+	}
+	defer b.snap.Release()
+	return b.check(f(b))
+}
+
+// UpdateDryRun executes the given transaction without writing its batch to
+// the database.
+func (r *levelDB) UpdateDryRun(f func(Bucket) error) error {
+	r.writer.Lock()
+	defer r.writer.Unlock()
+	b, err := r.newBucket(true)
+	if err != nil {
+		return err
+	}
+	defer b.snap.Release()
+	return b.check(f(b))
+}
+
+func (r *levelDB) Close() error {
+	return nil
+}
+
+func (r *levelDB) newBucket(writable bool) (*levelBucket, error) {
+	snap, err := r.db.GetSnapshot()
+	if err != nil {
+		return nil, xerrors.Errorf("getting snapshot: %v", err)
+	}
+	return &levelBucket{
+		snap:     snap,
+		prefix:   r.prefix,
+		writable: writable,
+		writes:   make(map[string][]byte),
+		batch:    new(leveldb.Batch),
+	}, nil
+}
+
+// levelBucket reads from a snapshot of the database and keeps the writes of
+// the transaction on top of it until they are committed.
+type levelBucket struct {
+	snap     *leveldb.Snapshot
+	prefix   []byte
+	writable bool
+	// writes holds the values written by the transaction, nil for the
+	// deleted keys.
+	writes map[string][]byte
+	batch  *leveldb.Batch
+	// err is the first error of Get, which cannot return it. It fails the
+	// transaction, because the caller took the key as missing.
+	err error
+}
+
+// check returns the error of the transaction, the one of Get first.
+func (r *levelBucket) check(err error) error {
+	if r.err != nil {
+		return xerrors.Errorf("reading: %v", r.err)
+	}
+	return err
+}
+
+func (r *levelBucket) key(k []byte) []byte {
+	return append(clone(r.prefix), k...)
+}
+
+func (r *levelBucket) Delete(k []byte) error {
+	if !r.writable {
+		return xerrors.New("transaction is read-only")
+	}
+	r.writes[string(k)] = nil
+	r.batch.Delete(r.key(k))
+	return nil
+}
+
+func (r *levelBucket) Put(k, v []byte) error {
+	if !r.writable {
+		return xerrors.New("transaction is read-only")
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	r.writes[string(k)] = clone(v)
+	r.batch.Put(r.key(k), v)
+	return nil
+}
+
+func (r *levelBucket) Get(k []byte) []byte {
+	if v, ok := r.writes[string(k)]; ok {
+		return v
+	}
+	v, err := r.snap.Get(r.key(k), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return nil
+	}
+	return v
+}
+
+// ForEach merges the keys of the snapshot with the ones written by the
+// transaction, so that they are visited in order like in boltdb.
+func (r *levelBucket) ForEach(f func(k, v []byte) error) error {
+	written := make([]string, 0, len(r.writes))
+	for k := range r.writes {
+		written = append(written, k)
+	}
+	sort.Strings(written)
+
+	it := r.snap.NewIterator(util.BytesPrefix(r.prefix), nil)
+	defer it.Release()
+	next := it.Next()
+	for next || len(written) > 0 {
+		var k, v []byte
+		if next {
+			k = it.Key()[len(r.prefix):]
+		}
+		if len(written) > 0 && (!next || bytes.Compare([]byte(written[0]), k) <= 0) {
+			if next && written[0] == string(k) {
+				next = it.Next()
+			}
+			k, v = []byte(written[0]), r.writes[written[0]]
+			written = written[1:]
+			if v == nil {
+				continue
+			}
+		} else {
+			k, v = clone(k), clone(it.Value())
+			next = it.Next()
+		}
+		if err := f(k, v); err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return xerrors.Errorf("iterating: %v", err)
+	}
+	return nil
+}
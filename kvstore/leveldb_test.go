@@ -0,0 +1,98 @@
+package kvstore
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newLevelDB(t *testing.T) (*leveldb.DB, DB) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	return ldb, NewLevelDB(ldb, []byte("prefix/"))
+}
+
+func TestLevelDB_ForEach(t *testing.T) {
+	ldb, db := newLevelDB(t)
+	defer ldb.Close()
+
+	require.NoError(t, db.Update(func(b Bucket) error {
+		for i := 0; i < 10; i += 2 {
+			if err := b.Put([]byte(fmt.Sprint(i)), []byte("stored")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// The keys written and deleted by a transaction are merged in order
+	// with the stored ones.
+	var keys, values []string
+	require.NoError(t, db.UpdateDryRun(func(b Bucket) error {
+		for i := 1; i < 10; i += 2 {
+			if err := b.Put([]byte(fmt.Sprint(i)), []byte("written")); err != nil {
+				return err
+			}
+		}
+		if err := b.Put([]byte("0"), []byte("written")); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte("4")); err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			values = append(values, string(v))
+			return nil
+		})
+	}))
+	require.Equal(t, []string{"0", "1", "2", "3", "5", "6", "7", "8", "9"}, keys)
+	require.Equal(t, []string{"written", "written", "stored", "written",
+		"written", "stored", "written", "stored", "written"}, values)
+
+	// The dry-run didn't write anything.
+	keys = nil
+	require.NoError(t, db.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	}))
+	require.Equal(t, []string{"0", "2", "4", "6", "8"}, keys)
+}
+
+func TestLevelDB_Prefix(t *testing.T) {
+	ldb, db1 := newLevelDB(t)
+	defer ldb.Close()
+	db2 := NewLevelDB(ldb, []byte("other/"))
+
+	require.NoError(t, db1.Update(func(b Bucket) error {
+		return b.Put([]byte("key"), []byte("value"))
+	}))
+	require.NoError(t, db2.View(func(b Bucket) error {
+		require.Nil(t, b.Get([]byte("key")))
+		return b.ForEach(func(k, v []byte) error {
+			return fmt.Errorf("unexpected key %x", k)
+		})
+	}))
+}
+
+func TestLevelDB_GetError(t *testing.T) {
+	ldb, db := newLevelDB(t)
+	require.NoError(t, db.Update(func(b Bucket) error {
+		return b.Put([]byte("key"), []byte("value"))
+	}))
+
+	// A key that cannot be read is not taken as missing: the transaction
+	// fails even if the function ignored it.
+	err := db.View(func(b Bucket) error {
+		require.NoError(t, ldb.Close())
+		require.Nil(t, b.Get([]byte("key")))
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "reading")
+}
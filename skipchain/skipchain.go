@@ -26,6 +26,7 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/blscosi/protocol"
 	"go.dedis.ch/cothority/v3/byzcoinx"
+	"go.dedis.ch/cothority/v3/kvstore"
 	"go.dedis.ch/cothority/v3/messaging"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/pairing"
@@ -54,6 +55,41 @@ func init() {
 	network.RegisterMessages(&Storage{})
 }
 
+// blockStore holds the function giving the store of the skipblocks of a
+// conode, see SetBlockStore.
+var blockStore struct {
+	sync.Mutex
+	open func(si *network.ServerIdentity) (kvstore.DB, error)
+}
+
+// SetBlockStore sets the function giving the store of the skipblocks of the
+// skipchain services created afterwards, for example a kvstore.NewLevelDB. The
+// store is closed with the service. If the function is nil, the skipblocks are
+// stored in the bbolt database of the conode. The other data of the service
+// always stays in the bbolt database.
+func SetBlockStore(open func(si *network.ServerIdentity) (kvstore.DB, error)) {
+	blockStore.Lock()
+	blockStore.open = open
+	blockStore.Unlock()
+}
+
+// newServiceDB returns the SkipBlockDB of a skipchain service, in the store
+// given to SetBlockStore.
+func newServiceDB(c *onet.Context) (*SkipBlockDB, error) {
+	blockStore.Lock()
+	open := blockStore.open
+	blockStore.Unlock()
+	if open == nil {
+		db, bucket := c.GetAdditionalBucket([]byte("skipblocks"))
+		return NewSkipBlockDB(db, bucket), nil
+	}
+	store, err := open(c.ServerIdentity())
+	if err != nil {
+		return nil, xerrors.Errorf("opening the store of the skipblocks: %v", err)
+	}
+	return NewSkipBlockDBWithStore(store), nil
+}
+
 // Service handles adding new SkipBlocks
 type Service struct {
 	*onet.ServiceProcessor
@@ -1060,6 +1096,9 @@ func (s *Service) TestClose() {
 		close(s.closing)
 		s.closedMutex.Unlock()
 		s.working.Wait()
+		if err := s.db.closeStore(); err != nil {
+			log.Error("closing the store of the skipblocks:", err)
+		}
 	} else {
 		s.closedMutex.Unlock()
 	}
@@ -1069,8 +1108,11 @@ func (s *Service) TestClose() {
 // structure.
 func (s *Service) TestRestart() error {
 	s.TestClose()
-	db, bucket := s.GetAdditionalBucket([]byte("skipblocks"))
-	s.db = NewSkipBlockDB(db, bucket)
+	db, err := newServiceDB(s.Context)
+	if err != nil {
+		return err
+	}
+	s.db = db
 	s.Storage = &Storage{}
 	// Don't reset the verifiers, keep them
 	//s.verifiers = map[VerifierID]SkipBlockVerifier{}
@@ -1876,11 +1918,19 @@ func sliceToArr(msg []byte) [32]byte {
 	return arr
 }
 
-func newSkipchainService(c *onet.Context) (onet.Service, error) {
-	db, bucket := c.GetAdditionalBucket([]byte("skipblocks"))
+func newSkipchainService(c *onet.Context) (_ onet.Service, err error) {
+	db, err := newServiceDB(c)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			db.closeStore()
+		}
+	}()
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
-		db:               NewSkipBlockDB(db, bucket),
+		db:               db,
 		Storage:          &Storage{},
 		verifiers:        map[VerifierID]SkipBlockVerifier{},
 		propTimeout:      defaultPropagateTimeout,
@@ -1904,7 +1954,6 @@ func newSkipchainService(c *onet.Context) (onet.Service, error) {
 		return nil, err
 	}
 
-	var err error
 	s.propagateGenesis, err = messaging.NewPropagationFunc(c, "SkipchainPropagate", s.propagateGenesisHandler, -1)
 	if err != nil {
 		return nil, err
@@ -15,6 +15,7 @@ import (
 	"go.dedis.ch/cothority/v3/blscosi/bdnproto"
 	"go.dedis.ch/cothority/v3/blscosi/protocol"
 	"go.dedis.ch/cothority/v3/byzcoinx"
+	"go.dedis.ch/cothority/v3/kvstore"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/onet/v3"
@@ -735,10 +736,19 @@ func (fl *ForwardLink) IsEmpty() bool {
 
 // SkipBlockDB holds the database to the skipblocks.
 // This is used for verification, so that all links can be followed.
-// It is a wrapper to embed bolt.DB.
+// It is a wrapper to embed bolt.DB. The embedded bolt.DB is nil if the
+// skipblocks are in another store, given to NewSkipBlockDBWithStore.
 type SkipBlockDB struct {
 	*bbolt.DB
 	bucketName []byte
+	store      kvstore.DB
+	// counted tells whether the number of skipblocks in the store and the
+	// bytes they use have been counted. They are then kept up to date by
+	// update, so that stats doesn't scan the store on each call.
+	counted    bool
+	blockCount int
+	blockSize  int
+	countMutex sync.Mutex
 	// latestBlocks is used as a simple caching mechanism
 	latestBlocks map[string]SkipBlockID
 	latestMutex  sync.Mutex
@@ -754,23 +764,130 @@ func NewSkipBlockDB(db *bbolt.DB, bn []byte) *SkipBlockDB {
 	}
 }
 
-// GetStatus is a function that returns the status report of the db.
-func (db *SkipBlockDB) GetStatus() *onet.Status {
-	out := make(map[string]string)
-	err := db.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		s := b.Stats()
-		out["Blocks"] = strconv.Itoa(s.KeyN)
-
-		total := s.BranchInuse + s.LeafInuse
-		out["Bytes"] = strconv.Itoa(total)
+// NewSkipBlockDBWithStore returns an initialized SkipBlockDB structure that
+// keeps the skipblocks in the given store, for example a kvstore.NewLevelDB.
+func NewSkipBlockDBWithStore(store kvstore.DB) *SkipBlockDB {
+	return &SkipBlockDB{
+		store:        store,
+		latestBlocks: map[string]SkipBlockID{},
+	}
+}
+
+// blocks returns the store of the skipblocks: the bucket of the bolt.DB,
+// unless another store has been given.
+func (db *SkipBlockDB) blocks() kvstore.DB {
+	if db.store != nil {
+		return db.store
+	}
+	return kvstore.NewBoltDB(db.DB, db.bucketName)
+}
+
+// closeStore closes the store given to NewSkipBlockDBWithStore, if any.
+func (db *SkipBlockDB) closeStore() error {
+	if db.store == nil {
 		return nil
+	}
+	return db.store.Close()
+}
+
+// update executes f in a read-write transaction of the store of the
+// skipblocks. The skipblocks it writes and deletes are counted once it is
+// committed.
+func (db *SkipBlockDB) update(f func(kvstore.Bucket) error) error {
+	if db.store == nil {
+		return db.blocks().Update(f)
+	}
+	db.countMutex.Lock()
+	defer db.countMutex.Unlock()
+	var c countingBucket
+	err := db.store.Update(func(b kvstore.Bucket) error {
+		c = countingBucket{Bucket: b}
+		return f(&c)
 	})
+	if err != nil {
+		return err
+	}
+	db.blockCount += c.blocks
+	db.blockSize += c.size
+	return nil
+}
+
+// stats returns the number of skipblocks and the number of bytes they use.
+func (db *SkipBlockDB) stats() (blocks int, size int, err error) {
+	if db.store == nil {
+		err = db.DB.View(func(tx *bbolt.Tx) error {
+			s := tx.Bucket(db.bucketName).Stats()
+			blocks = s.KeyN
+			size = s.BranchInuse + s.LeafInuse
+			return nil
+		})
+		return
+	}
+	db.countMutex.Lock()
+	defer db.countMutex.Unlock()
+	if !db.counted {
+		// The store is counted once, the writes being blocked by the
+		// mutex.
+		err = db.store.View(func(b kvstore.Bucket) error {
+			return b.ForEach(func(k, v []byte) error {
+				db.blockCount++
+				db.blockSize += len(k) + len(v)
+				return nil
+			})
+		})
+		if err != nil {
+			db.blockCount, db.blockSize = 0, 0
+			return
+		}
+		db.counted = true
+	}
+	return db.blockCount, db.blockSize, nil
+}
+
+// countingBucket counts the changes of the number of skipblocks and of the
+// bytes they use made by a transaction.
+type countingBucket struct {
+	kvstore.Bucket
+	blocks int
+	size   int
+}
+
+func (b *countingBucket) Put(k, v []byte) error {
+	old := b.Get(k)
+	if err := b.Bucket.Put(k, v); err != nil {
+		return err
+	}
+	if old == nil {
+		b.blocks++
+		b.size += len(k)
+	}
+	b.size += len(v) - len(old)
+	return nil
+}
+
+func (b *countingBucket) Delete(k []byte) error {
+	old := b.Get(k)
+	if err := b.Bucket.Delete(k); err != nil {
+		return err
+	}
+	if old != nil {
+		b.blocks--
+		b.size -= len(k) + len(old)
+	}
+	return nil
+}
+
+// GetStatus is a function that returns the status report of the db.
+func (db *SkipBlockDB) GetStatus() *onet.Status {
+	blocks, size, err := db.stats()
 	if err != nil {
 		log.Error(err)
 		return nil
 	}
-	return &onet.Status{Field: out}
+	return &onet.Status{Field: map[string]string{
+		"Blocks": strconv.Itoa(blocks),
+		"Bytes":  strconv.Itoa(size),
+	}}
 }
 
 // GetByID returns a new copy of the skip-block or nil if it doesn't exist
@@ -779,7 +896,7 @@ func (db *SkipBlockDB) GetByID(sbID SkipBlockID) *SkipBlock {
 	if sbID == nil {
 		return nil
 	}
-	err := db.View(func(tx *bbolt.Tx) error {
+	err := db.blocks().View(func(tx kvstore.Bucket) error {
 		sb, err := db.getFromTx(tx, sbID)
 		if err != nil {
 			return err
@@ -798,7 +915,7 @@ func (db *SkipBlockDB) GetByID(sbID SkipBlockID) *SkipBlock {
 // so that the db is consistent at every moment.
 func (db *SkipBlockDB) StoreBlocks(blocks []*SkipBlock) ([]SkipBlockID, error) {
 	var result []SkipBlockID
-	err := db.Update(func(tx *bbolt.Tx) error {
+	err := db.update(func(tx kvstore.Bucket) error {
 		for i, sb := range blocks {
 			log.Lvlf2("Storing skipblock %d / %x", sb.Index, sb.Hash)
 			sbOld, err := db.getFromTx(tx, sb.Hash)
@@ -965,12 +1082,7 @@ func (db *SkipBlockDB) latestUpdate(sb *SkipBlock) {
 
 // Length returns the actual length using mutexes
 func (db *SkipBlockDB) Length() int {
-	var i int
-	_ = db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		i = b.Stats().KeyN
-		return nil
-	})
+	i, _, _ := db.stats()
 	return i
 }
 
@@ -1107,29 +1219,31 @@ func (db *SkipBlockDB) GetFuzzy(id string) (*SkipBlock, error) {
 	}
 
 	var sb *SkipBlock
-	err = db.View(func(tx *bbolt.Tx) error {
-		c := tx.Bucket([]byte(db.bucketName)).Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if bytes.HasPrefix(k, match) {
-				_, msg, err := network.Unmarshal(v, suite)
-				if err != nil {
-					return errors.New("Unmarshal failed with error: " + err.Error())
-				}
-				sb = msg.(*SkipBlock).Copy()
+	// found stops the iteration over the blocks.
+	found := errors.New("found")
+	search := func(b kvstore.Bucket, matches func(k []byte) bool) error {
+		err := b.ForEach(func(k, v []byte) error {
+			if !matches(k) {
 				return nil
 			}
-		}
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if bytes.HasSuffix(k, match) {
-				_, msg, err := network.Unmarshal(v, suite)
-				if err != nil {
-					return errors.New("Unmarshal failed with error: " + err.Error())
-				}
-				sb = msg.(*SkipBlock).Copy()
-				return nil
+			_, msg, err := network.Unmarshal(v, suite)
+			if err != nil {
+				return errors.New("Unmarshal failed with error: " + err.Error())
 			}
+			sb = msg.(*SkipBlock).Copy()
+			return found
+		})
+		if err == found {
+			return nil
 		}
-		return nil
+		return err
+	}
+	err = db.blocks().View(func(b kvstore.Bucket) error {
+		err := search(b, func(k []byte) bool { return bytes.HasPrefix(k, match) })
+		if err != nil || sb != nil {
+			return err
+		}
+		return search(b, func(k []byte) bool { return bytes.HasSuffix(k, match) })
 	})
 	return sb, err
 }
@@ -1139,7 +1253,7 @@ func (db *SkipBlockDB) GetFuzzy(id string) (*SkipBlock, error) {
 func (db *SkipBlockDB) GetProof(sid SkipBlockID) (sbs []*SkipBlock, err error) {
 	sbs = make([]*SkipBlock, 0)
 
-	err = db.View(func(tx *bbolt.Tx) error {
+	err = db.blocks().View(func(tx kvstore.Bucket) error {
 		sb, err := db.getFromTx(tx, sid)
 		if err != nil {
 			return err
@@ -1192,7 +1306,7 @@ func (db *SkipBlockDB) GetProof(sid SkipBlockID) (sbs []*SkipBlock, err error) {
 // GetProofForID returns the shortest chain known from the genesis to the given
 // block using the heighest forward-links available in the local db.
 func (db *SkipBlockDB) GetProofForID(bid SkipBlockID) (sbs Proof, err error) {
-	err = db.View(func(tx *bbolt.Tx) error {
+	err = db.blocks().View(func(tx kvstore.Bucket) error {
 		target, err := db.getFromTx(tx, bid)
 		if err != nil {
 			return err
@@ -1252,14 +1366,13 @@ func (db *SkipBlockDB) GetSkipchains() (map[string]*SkipBlock, error) {
 // If the skipchain is only partial, it can skip missing blocks, as long as the
 // forwardlinks are present.
 func (db *SkipBlockDB) RemoveSkipchain(scid SkipBlockID) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
+	return db.update(func(tx kvstore.Bucket) error {
 		sb, err := db.getFromTx(tx, scid)
 		if err != nil {
 			return err
 		}
 		for {
-			err := b.Delete(sb.Hash)
+			err := tx.Delete(sb.Hash)
 			if err != nil {
 				return err
 			}
@@ -1286,34 +1399,52 @@ func (db *SkipBlockDB) RemoveSkipchain(scid SkipBlockID) error {
 
 // RemoveBlock removes the given block from the database.
 func (db *SkipBlockDB) RemoveBlock(blockID SkipBlockID) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		return b.Delete(blockID)
+	return db.update(func(tx kvstore.Bucket) error {
+		return tx.Delete(blockID)
+	})
+}
+
+// PrunePayload drops the payload of the given block. The payload is not part
+// of the hash of the block, so the block and its forward links stay valid.
+func (db *SkipBlockDB) PrunePayload(blockID SkipBlockID) error {
+	return db.update(func(tx kvstore.Bucket) error {
+		sb, err := db.getFromTx(tx, blockID)
+		if err != nil {
+			return err
+		}
+		if sb == nil {
+			return errors.New("unknown block")
+		}
+		if sb.Payload == nil {
+			return nil
+		}
+		sb.Payload = nil
+		return db.storeToTx(tx, sb)
 	})
 }
 
 // storeToTx stores the skipblock into the database.
 // An error is returned on failure.
 // The caller must ensure that this function is called from within a valid transaction.
-func (db *SkipBlockDB) storeToTx(tx *bbolt.Tx, sb *SkipBlock) error {
+func (db *SkipBlockDB) storeToTx(tx kvstore.Bucket, sb *SkipBlock) error {
 	key := sb.Hash
 	val, err := network.Marshal(sb)
 	if err != nil {
 		return err
 	}
-	return tx.Bucket([]byte(db.bucketName)).Put(key, val)
+	return tx.Put(key, val)
 }
 
 // getFromTx returns the skipblock identified by sbID.
 // nil is returned if the key does not exist.
 // An error is thrown if marshalling fails.
 // The caller must ensure that this function is called from within a valid transaction.
-func (db *SkipBlockDB) getFromTx(tx *bbolt.Tx, sbID SkipBlockID) (*SkipBlock, error) {
+func (db *SkipBlockDB) getFromTx(tx kvstore.Bucket, sbID SkipBlockID) (*SkipBlock, error) {
 	if sbID == nil {
 		return nil, xerrors.New("cannot look up skipblock with ID == nil")
 	}
 
-	val := tx.Bucket(db.bucketName).Get(sbID)
+	val := tx.Get(sbID)
 	if val == nil {
 		return nil, nil
 	}
@@ -1336,8 +1467,7 @@ func (db *SkipBlockDB) getFromTx(tx *bbolt.Tx, sbID SkipBlockID) (*SkipBlock, er
 // database that is consistent at the time of the function call.
 func (db *SkipBlockDB) getAll() (map[string]*SkipBlock, error) {
 	data := map[string]*SkipBlock{}
-	err := db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
+	err := db.blocks().View(func(b kvstore.Bucket) error {
 		return b.ForEach(func(k, v []byte) error {
 			_, sbMsg, err := network.Unmarshal(v, suite)
 			if err != nil {
@@ -1365,8 +1495,7 @@ func (db *SkipBlockDB) getAllSkipchains() (map[string]*SkipBlock, error) {
 	// Loop over all blocks. If we see a new genesis block we
 	// have not seen, remember it. If we see a higher Index than what
 	// we have, replace it.
-	err := db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
+	err := db.blocks().View(func(b kvstore.Bucket) error {
 		return b.ForEach(func(k, v []byte) error {
 			_, sbMsg, err := network.Unmarshal(v, suite)
 			if err != nil {
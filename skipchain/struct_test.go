@@ -10,8 +10,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoinx"
+	"go.dedis.ch/cothority/v3/kvstore"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/sign"
@@ -262,7 +265,16 @@ func TestSkipBlock_GetFuzzy(t *testing.T) {
 	db, fname := setupSkipBlockDB(t)
 	defer db.Close()
 	defer os.Remove(fname)
+	testGetFuzzy(t, db)
 
+	// The same lookups work on the skipblocks in LevelDB.
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	defer ldb.Close()
+	testGetFuzzy(t, NewSkipBlockDBWithStore(kvstore.NewLevelDB(ldb, []byte("skipblocks/"))))
+}
+
+func testGetFuzzy(t *testing.T, db *SkipBlockDB) {
 	sb0 := NewSkipBlock()
 	sb0.Data = []byte{0}
 	sb0.Hash = []byte{1, 2, 3, 6, 5}
@@ -271,7 +283,7 @@ func TestSkipBlock_GetFuzzy(t *testing.T) {
 	sb1.Data = []byte{1}
 	sb1.Hash = []byte{2, 3, 4, 1, 5}
 
-	db.Update(func(tx *bbolt.Tx) error {
+	db.update(func(tx kvstore.Bucket) error {
 		err := db.storeToTx(tx, sb0)
 		require.NoError(t, err)
 
@@ -317,6 +329,46 @@ func TestSkipBlock_GetFuzzy(t *testing.T) {
 	require.Equal(t, sb.Data[0], sb0.Data[0])
 }
 
+func TestSkipBlockDB_StoreStats(t *testing.T) {
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	defer ldb.Close()
+	store := kvstore.NewLevelDB(ldb, []byte("skipblocks/"))
+
+	sb0 := NewSkipBlock()
+	sb0.Data = []byte{0}
+	sb0.Hash = sb0.CalculateHash()
+	sb1 := NewSkipBlock()
+	sb1.Data = []byte{1}
+	sb1.Hash = sb1.CalculateHash()
+
+	// The blocks already in the store are counted once.
+	db := NewSkipBlockDBWithStore(store)
+	_, err = db.StoreBlocks([]*SkipBlock{sb0})
+	require.NoError(t, err)
+	db = NewSkipBlockDBWithStore(store)
+	require.Equal(t, 1, db.Length())
+
+	// The counter follows the writes of the transactions.
+	_, err = db.StoreBlocks([]*SkipBlock{sb0, sb1})
+	require.NoError(t, err)
+	require.Equal(t, 2, db.Length())
+	require.NoError(t, db.PrunePayload(sb0.Hash))
+	require.Equal(t, 2, db.Length())
+	require.NoError(t, db.RemoveBlock(sb0.Hash))
+	require.Equal(t, 1, db.Length())
+	require.NoError(t, db.RemoveBlock(sb0.Hash))
+	require.Equal(t, 1, db.Length())
+
+	// The bytes match the ones of a new count.
+	blocks, size, err := db.stats()
+	require.NoError(t, err)
+	blocksScan, sizeScan, err := NewSkipBlockDBWithStore(store).stats()
+	require.NoError(t, err)
+	require.Equal(t, blocksScan, blocks)
+	require.Equal(t, sizeScan, size)
+}
+
 func TestSkipBlock_Payload(t *testing.T) {
 	sb := NewSkipBlock()
 	h := sb.CalculateHash()